@@ -4,11 +4,13 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"os"
+	"io/fs"
 	"reflect"
+	"strconv"
 	"strings"
 
 	"github.com/anirudhraja/protolite/registry"
+	"github.com/anirudhraja/protolite/schema"
 	"github.com/anirudhraja/protolite/wire"
 )
 
@@ -17,101 +19,111 @@ type Protolite interface {
 	// Parse parses the given data into a map of string to interface. This is used when schema is not known.
 	Parse(data []byte) (map[string]interface{}, error)
 
-	// MarshalWithSchema marshals data using a specific message schema
+	// MarshalWithSchema marshals data using a specific message schema. A nil
+	// data map is an empty message and marshals to zero bytes.
 	MarshalWithSchema(data map[string]interface{}, messageName string) ([]byte, error)
 
-	// UnmarshalWithSchema unmarshals data using a specific message schema
+	// MarshalWithMessage marshals data using msg directly, e.g. one built at runtime
+	// without being registered; nested types are still resolved from the loaded schemas
+	MarshalWithMessage(data map[string]interface{}, msg *schema.Message) ([]byte, error)
+
+	// UnmarshalWithSchema unmarshals data using a specific message schema. A
+	// google.protobuf.Struct, or a Value holding one, decodes to a plain map
 	UnmarshalWithSchema(data []byte, messageName string) (map[string]interface{}, error)
 
-	// UnmarshalToStruct unmarshals protobuf data into a Go struct using reflection
+	// UnmarshalWithPresence unmarshals like UnmarshalWithSchema and also reports which
+	// fields of the message appeared on the wire, before defaults were filled in
+	UnmarshalWithPresence(data []byte, messageName string) (map[string]interface{}, map[string]bool, error)
+
+	// ParseWithSchema unmarshals like UnmarshalWithSchema but also keeps the fields the
+	// schema does not know, keyed "field_<number>" and described as Parse does
+	ParseWithSchema(data []byte, messageName string) (map[string]interface{}, error)
+
+	// UnmarshalFields unmarshals only the fields with the given numbers, skipping the
+	// rest without decoding them
+	UnmarshalFields(data []byte, messageName string, fieldNumbers []int32) (map[string]interface{}, error)
+
+	// UnmarshalOrdered unmarshals like UnmarshalWithSchema but returns the fields, and
+	// those of nested messages, in .proto declaration order
+	UnmarshalOrdered(data []byte, messageName string) (OrderedMessage, error)
+
+	// UnmarshalToJSONMap unmarshals data into a map that encoding/json can marshal directly:
+	// string map keys, 64-bit integers as strings (see JSONIntegers), well-known types
+	// flattened and enums as names
+	UnmarshalToJSONMap(data []byte, messageName string, opts ...JSONOption) (map[string]interface{}, error)
+
+	// Transcode converts data of the given message type between the protobuf wire
+	// format and the JSON form produced by UnmarshalToJSONMap, or renders the wire
+	// format as protobuf text. opts apply when the output is JSON.
+	Transcode(data []byte, messageName string, from, to Format, opts ...JSONOption) ([]byte, error)
+
+	// MarshalFields marshals only the fields of data selected by fieldMask, a list of
+	// dot-separated snake_case paths such as "address.city"
+	MarshalFields(data map[string]interface{}, messageName string, fieldMask []string) ([]byte, error)
+
+	// MarshalDelimited writes each item to w as a varint length-prefixed message,
+	// the standard protobuf delimited stream format. Every item is encoded before
+	// anything is written, so an item that fails to encode leaves w untouched.
+	MarshalDelimited(w io.Writer, items []map[string]interface{}, messageName string) error
+
+	// UnmarshalToStruct unmarshals protobuf data into a Go struct using reflection.
+	// v, or any of its struct fields, may implement ProtoliteUnmarshaler instead
 	UnmarshalToStruct(data []byte, messageName string, v interface{}) error
 
+	// RegisterFieldCodec routes a string, bytes or message field of a loaded message
+	// through codec on encode and decode, e.g. to compress its payload
+	RegisterFieldCodec(messageName, fieldName string, codec FieldCodec) error
+
+	// RegisterRawJSONField makes a message field of a loaded message decode to its
+	// JSON as a json.RawMessage, ready to store verbatim; encoding takes that JSON back
+	RegisterRawJSONField(messageName, fieldName string) error
+
 	// LoadSchemaFromFile loads schema definitions from a .proto file
 	LoadSchemaFromFile(protoPath string) error
 
 	// LoadSchemaFromReader loads schema definitions from an io.Reader with a unique identifier
 	// The identifier is used as a unique key for the schema, while dependent imports are still loaded from file paths
 	LoadSchemaFromReader(reader io.Reader, identifier string) error
+
+	// LoadSchemaFromFS loads schema definitions from the .proto file entry inside fsys,
+	// e.g. an embed.FS or a zip.Reader; its imports are read from fsys too, later
+	// loads read from the filesystem again
+	LoadSchemaFromFS(fsys fs.FS, entry string) error
+
+	// Reset unloads every loaded schema so changed .proto files can be loaded again
+	// without colliding with their old definitions
+	Reset()
 }
 
+// FieldCodec transforms a field payload on its way to and from the wire, see RegisterFieldCodec
+type FieldCodec = schema.FieldCodec
+
+// ProtoliteMarshaler lets a Go type, e.g. a Money struct, choose the message map
+// it is encoded as wherever a message value is expected
+type ProtoliteMarshaler = schema.ProtoliteMarshaler
+
+// ProtoliteUnmarshaler lets a Go type fill itself from the decoded message map of
+// a struct field in UnmarshalToStruct
+type ProtoliteUnmarshaler = schema.ProtoliteUnmarshaler
+
 type protolite struct {
 	registry *registry.Registry
 }
 
 // Parse implements Protolite - parses protobuf data without schema knowledge.
 func (p *protolite) Parse(data []byte) (map[string]interface{}, error) {
-	if len(data) == 0 {
-		return make(map[string]interface{}), nil
-	}
-
-	decoder := wire.NewDecoder(data)
-	result := make(map[string]interface{})
-
-	for {
-		field, err := decoder.DecodeField()
-		if err != nil {
-			return nil, fmt.Errorf("failed to decode field: %v", err)
-		}
-
-		if field == nil {
-			// End of data
-			break
-		}
-
-		// Use field number as key since we don't have schema
-		fieldKey := fmt.Sprintf("field_%d", field.FieldNumber)
-
-		// Convert wire type to more readable format
-		switch field.WireType {
-		case wire.WireVarint:
-			result[fieldKey] = map[string]interface{}{
-				"type":  "varint",
-				"value": field.Data,
-			}
-		case wire.WireFixed64:
-			result[fieldKey] = map[string]interface{}{
-				"type":  "fixed64",
-				"value": field.Data,
-			}
-		case wire.WireBytes:
-			result[fieldKey] = map[string]interface{}{
-				"type":  "bytes",
-				"value": field.Data,
-			}
-		case wire.WireFixed32:
-			result[fieldKey] = map[string]interface{}{
-				"type":  "fixed32",
-				"value": field.Data,
-			}
-		default:
-			result[fieldKey] = map[string]interface{}{
-				"type":  "unknown",
-				"value": field.Data,
-			}
-		}
-	}
+	return wire.Parse(data)
+}
 
-	return result, nil
+// RegisterFieldCodec routes a field of a loaded message through codec on encode and decode
+func (p *protolite) RegisterFieldCodec(messageName, fieldName string, codec FieldCodec) error {
+	return p.registry.RegisterFieldCodec(messageName, fieldName, codec)
 }
 
-// LoadSchemaFromFile loads schema definitions from a .proto file
-// It internally uses LoadSchemaFromReader by creating a reader from the file
+// LoadSchemaFromFile loads schema definitions from a .proto file, read from the
+// fs.FS given to NewProtolite if any
 func (p *protolite) LoadSchemaFromFile(protoPath string) error {
-	// Resolve the full path using registry's proto directories
-	fullPath, err := p.registry.FindProtoPath(protoPath)
-	if err != nil {
-		return err
-	}
-
-	// Open and read the file
-	file, err := os.Open(fullPath)
-	if err != nil {
-		return fmt.Errorf("failed to open proto file: %w", err)
-	}
-	defer file.Close()
-
-	// Use LoadSchema with the full path as identifier
-	return p.registry.LoadSchema(file, fullPath)
+	return p.registry.LoadSchemaFile(protoPath)
 }
 
 // LoadSchemaFromReader loads schema definitions from an io.Reader with a unique identifier
@@ -119,6 +131,16 @@ func (p *protolite) LoadSchemaFromReader(reader io.Reader, identifier string) er
 	return p.registry.LoadSchema(reader, identifier)
 }
 
+// LoadSchemaFromFS loads schema definitions from a .proto file inside fsys
+func (p *protolite) LoadSchemaFromFS(fsys fs.FS, entry string) error {
+	return p.registry.LoadSchemaFromFS(fsys, entry)
+}
+
+// Reset unloads every loaded schema
+func (p *protolite) Reset() {
+	p.registry.Reset()
+}
+
 // Additional helper methods that require schema
 
 // MarshalWithSchema marshals data using a specific message schema
@@ -135,6 +157,58 @@ func (p *protolite) MarshalWithSchema(data map[string]interface{}, messageName s
 	return protoBytes,err
 }
 
+// MarshalWithMessage marshals data using msg, resolving nested types from the registry
+func (p *protolite) MarshalWithMessage(data map[string]interface{}, msg *schema.Message) ([]byte, error) {
+	if msg == nil {
+		return nil, errors.New("message schema is nil")
+	}
+	if err := msg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid message schema: %w", err)
+	}
+
+	protoBytes, err := wire.EncodeMessage(data, msg, p.registry)
+	if err != nil {
+		return nil, fmt.Errorf("encoding failed: %w", err)
+	}
+	return protoBytes, nil
+}
+
+// MarshalFields marshals only the fields of data selected by the field mask paths
+func (p *protolite) MarshalFields(data map[string]interface{}, messageName string, fieldMask []string) ([]byte, error) {
+	message, err := p.registry.GetMessage(messageName)
+	if err != nil {
+		return nil, fmt.Errorf("message schema not found: %v", err)
+	}
+
+	protoBytes, err := wire.EncodeMessageFields(data, message, p.registry, fieldMask)
+	if err != nil {
+		return nil, fmt.Errorf("encoding failed: %w", err)
+	}
+	return protoBytes, nil
+}
+
+// MarshalDelimited writes each item to w as a varint length-prefixed message.
+// The frames are collected in one buffer and written with a single Write.
+func (p *protolite) MarshalDelimited(w io.Writer, items []map[string]interface{}, messageName string) error {
+	message, err := p.registry.GetMessage(messageName)
+	if err != nil {
+		return fmt.Errorf("message schema not found: %v", err)
+	}
+
+	frames := wire.NewEncoder()
+	for i, item := range items {
+		protoBytes, err := wire.EncodeMessage(item, message, p.registry)
+		if err != nil {
+			return fmt.Errorf("encoding item %d failed: %w", i, err)
+		}
+		frames.EncodeBytes(protoBytes)
+	}
+	if _, err := w.Write(frames.Bytes()); err != nil {
+		return fmt.Errorf("writing items failed: %w", err)
+	}
+	return nil
+}
+
 // UnmarshalWithSchema unmarshals data using a specific message schema
 func (p *protolite) UnmarshalWithSchema(data []byte, messageName string) (map[string]interface{}, error) {
 	message, err := p.registry.GetMessage(messageName)
@@ -150,6 +224,63 @@ func (p *protolite) UnmarshalWithSchema(data []byte, messageName string) (map[st
 	if !ok {
 		return nil, fmt.Errorf("expected type of map[string]interface{} got %T", decodedMessage)
 	}
+	if flat, ok := p.flattenStruct(result, message, newJSONOptions(nil)); ok {
+		return flat, nil
+	}
+	return result, nil
+}
+
+// UnmarshalWithPresence unmarshals data and reports the fields present on the wire
+func (p *protolite) UnmarshalWithPresence(data []byte, messageName string) (map[string]interface{}, map[string]bool, error) {
+	message, err := p.registry.GetMessage(messageName)
+	if err != nil {
+		return nil, nil, fmt.Errorf("message schema not found: %v", err)
+	}
+
+	decodedMessage, presence, err := wire.DecodeMessageWithPresence(data, message, p.registry)
+	if err != nil {
+		return nil, nil, fmt.Errorf("decoding failed: %w", err)
+	}
+	result, ok := decodedMessage.(map[string]interface{})
+	if !ok {
+		return nil, nil, fmt.Errorf("expected type of map[string]interface{} got %T", decodedMessage)
+	}
+	return result, presence, nil
+}
+
+// ParseWithSchema unmarshals known fields by name and keeps unknown ones under field_<number>
+func (p *protolite) ParseWithSchema(data []byte, messageName string) (map[string]interface{}, error) {
+	message, err := p.registry.GetMessage(messageName)
+	if err != nil {
+		return nil, fmt.Errorf("message schema not found: %v", err)
+	}
+
+	decodedMessage, err := wire.ParseWithSchema(data, message, p.registry)
+	if err != nil {
+		return nil, fmt.Errorf("decoding failed: %w", err)
+	}
+	result, ok := decodedMessage.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expected type of map[string]interface{} got %T", decodedMessage)
+	}
+	return result, nil
+}
+
+// UnmarshalFields unmarshals only the fields with the given numbers
+func (p *protolite) UnmarshalFields(data []byte, messageName string, fieldNumbers []int32) (map[string]interface{}, error) {
+	message, err := p.registry.GetMessage(messageName)
+	if err != nil {
+		return nil, fmt.Errorf("message schema not found: %v", err)
+	}
+
+	decodedMessage, err := wire.DecodeMessageFields(data, message, p.registry, fieldNumbers)
+	if err != nil {
+		return nil, fmt.Errorf("decoding failed: %w", err)
+	}
+	result, ok := decodedMessage.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expected type of map[string]interface{} got %T", decodedMessage)
+	}
 	return result, nil
 }
 
@@ -160,13 +291,21 @@ func (p *protolite) UnmarshalToStruct(data []byte, messageName string, v interfa
 	if err != nil {
 		return err
 	}
+	if u, ok := v.(ProtoliteUnmarshaler); ok {
+		return u.UnmarshalProtolite(result)
+	}
+	message, err := p.registry.GetMessage(messageName)
+	if err != nil {
+		return fmt.Errorf("message schema not found: %v", err)
+	}
 
 	// Use reflection to populate the struct
-	return p.mapToStruct(result, v)
+	return p.mapToStruct(result, message, v)
 }
 
-// mapToStruct uses reflection to copy map values to struct fields
-func (p *protolite) mapToStruct(data map[string]interface{}, v interface{}) error {
+// mapToStruct uses reflection to copy map values to struct fields. msg, when
+// known, describes data and lets enum names fill integer fields.
+func (p *protolite) mapToStruct(data map[string]interface{}, msg *schema.Message, v interface{}) error {
 	rv := reflect.ValueOf(v)
 	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Struct {
 		return errors.New("v must be a pointer to a struct")
@@ -186,11 +325,13 @@ func (p *protolite) mapToStruct(data map[string]interface{}, v interface{}) erro
 		// Try to find matching data by field name with multiple strategies
 		var value interface{}
 		var found bool
+		var key string
 
 		// Strategy 1: Check exact match
 		if val, ok := data[fieldType.Name]; ok {
 			value = val
 			found = true
+			key = fieldType.Name
 		}
 
 		// Strategy 2: Check lowercase version
@@ -199,6 +340,7 @@ func (p *protolite) mapToStruct(data map[string]interface{}, v interface{}) erro
 			if val, ok := data[lowerName]; ok {
 				value = val
 				found = true
+				key = lowerName
 			}
 		}
 
@@ -208,6 +350,7 @@ func (p *protolite) mapToStruct(data map[string]interface{}, v interface{}) erro
 			if val, ok := data[snakeName]; ok {
 				value = val
 				found = true
+				key = snakeName
 			}
 		}
 
@@ -215,8 +358,15 @@ func (p *protolite) mapToStruct(data map[string]interface{}, v interface{}) erro
 			continue
 		}
 
+		var valueType *schema.FieldType
+		if msg != nil {
+			if schemaField := fieldByDecodedName(msg, key); schemaField != nil {
+				valueType = &schemaField.Type
+			}
+		}
+
 		// Set the field value with type conversion
-		if err := p.setFieldValue(field, value); err != nil {
+		if err := p.setFieldValue(field, value, valueType); err != nil {
 			return fmt.Errorf("failed to set field %s: %v", fieldType.Name, err)
 		}
 	}
@@ -224,14 +374,53 @@ func (p *protolite) mapToStruct(data map[string]interface{}, v interface{}) erro
 	return nil
 }
 
-// setFieldValue sets a struct field value with appropriate type conversion
-func (p *protolite) setFieldValue(field reflect.Value, value interface{}) error {
+// setFieldValue sets a struct field value with appropriate type conversion.
+// valueType is the schema type of value when known, nil otherwise.
+func (p *protolite) setFieldValue(field reflect.Value, value interface{}, valueType *schema.FieldType) error {
+	if valueType != nil && valueType.Kind == schema.KindWrapper {
+		// wire.Config.DecodeWrappersAsMessages keeps the {"value": ...} shape
+		value = wrappedValue(value)
+	}
 	if value == nil {
 		return nil
 	}
 
 	rv := reflect.ValueOf(value)
 
+	// Nested messages decode to maps, fill struct and struct pointer fields from them
+	if nested, ok := value.(map[string]interface{}); ok {
+		if field.CanAddr() {
+			if u, ok := field.Addr().Interface().(ProtoliteUnmarshaler); ok {
+				return u.UnmarshalProtolite(nested)
+			}
+		}
+		if field.Kind() == reflect.Ptr {
+			target := reflect.New(field.Type().Elem())
+			if u, ok := target.Interface().(ProtoliteUnmarshaler); ok {
+				if err := u.UnmarshalProtolite(nested); err != nil {
+					return err
+				}
+				field.Set(target)
+				return nil
+			}
+		}
+		var nestedMsg *schema.Message
+		if valueType != nil && valueType.Kind == schema.KindMessage {
+			nestedMsg, _ = p.registry.GetMessage(valueType.MessageType)
+		}
+		switch {
+		case field.Kind() == reflect.Struct:
+			return p.mapToStruct(nested, nestedMsg, field.Addr().Interface())
+		case field.Kind() == reflect.Ptr && field.Type().Elem().Kind() == reflect.Struct:
+			target := reflect.New(field.Type().Elem())
+			if err := p.mapToStruct(nested, nestedMsg, target.Interface()); err != nil {
+				return err
+			}
+			field.Set(target)
+			return nil
+		}
+	}
+
 	// Handle type conversions
 	switch field.Kind() {
 	case reflect.String:
@@ -244,6 +433,13 @@ func (p *protolite) setFieldValue(field reflect.Value, value interface{}) error
 		switch rv.Kind() {
 		case reflect.Int, reflect.Int32, reflect.Int64:
 			field.SetInt(rv.Int())
+		case reflect.String:
+			// enums decode to their names
+			number, err := p.enumNumber(rv.String(), valueType)
+			if err != nil {
+				return err
+			}
+			field.SetInt(int64(number))
 		default:
 			return fmt.Errorf("cannot convert %T to int", value)
 		}
@@ -268,11 +464,45 @@ func (p *protolite) setFieldValue(field reflect.Value, value interface{}) error
 			return fmt.Errorf("cannot convert %T to bool", value)
 		}
 	case reflect.Slice:
-		if rv.Kind() == reflect.Slice {
-			field.Set(rv)
-		} else {
+		if rv.Kind() != reflect.Slice {
 			return fmt.Errorf("cannot convert %T to slice", value)
 		}
+		if rv.Type().AssignableTo(field.Type()) {
+			field.Set(rv)
+			break
+		}
+		// decoded repeated fields are []interface{}, so convert element by element
+		converted := reflect.MakeSlice(field.Type(), rv.Len(), rv.Len())
+		for i := 0; i < rv.Len(); i++ {
+			if err := p.setFieldValue(converted.Index(i), rv.Index(i).Interface(), valueType); err != nil {
+				return fmt.Errorf("element %d: %v", i, err)
+			}
+		}
+		field.Set(converted)
+	case reflect.Map:
+		if rv.Kind() != reflect.Map {
+			return fmt.Errorf("cannot convert %T to map", value)
+		}
+		// decoded maps hold interface{} values, so convert entry by entry
+		mapType := field.Type()
+		converted := reflect.MakeMapWithSize(mapType, rv.Len())
+		iter := rv.MapRange()
+		for iter.Next() {
+			key := reflect.New(mapType.Key()).Elem()
+			if err := p.setFieldValue(key, iter.Key().Interface(), nil); err != nil {
+				return fmt.Errorf("map key %v: %v", iter.Key().Interface(), err)
+			}
+			val := reflect.New(mapType.Elem()).Elem()
+			var mapValueType *schema.FieldType
+			if valueType != nil {
+				mapValueType = valueType.MapValue
+			}
+			if err := p.setFieldValue(val, iter.Value().Interface(), mapValueType); err != nil {
+				return fmt.Errorf("map value for key %v: %v", iter.Key().Interface(), err)
+			}
+			converted.SetMapIndex(key, val)
+		}
+		field.Set(converted)
 	default:
 		// Try direct assignment
 		if rv.Type().AssignableTo(field.Type()) {
@@ -285,6 +515,29 @@ func (p *protolite) setFieldValue(field reflect.Value, value interface{}) error
 	return nil
 }
 
+// enumNumber resolves a decoded enum name, or the json_name the decoder emits
+// in its place, to its number; unknown numbers decode to their decimal form
+// and are parsed back
+func (p *protolite) enumNumber(name string, valueType *schema.FieldType) (int32, error) {
+	if valueType == nil || valueType.Kind != schema.KindEnum {
+		return 0, fmt.Errorf("cannot convert string %q to int without an enum schema", name)
+	}
+	enum, err := p.registry.GetEnum(valueType.EnumType)
+	if err != nil {
+		return 0, err
+	}
+	for _, value := range enum.Values {
+		if value.Name == name || (value.JsonName != "" && value.JsonName == name) {
+			return value.Number, nil
+		}
+	}
+	number, err := strconv.ParseInt(name, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("unknown value %q for enum %s", name, valueType.EnumType)
+	}
+	return int32(number), nil
+}
+
 // toSnakeCase converts CamelCase to snake_case
 func toSnakeCase(s string) string {
 	if len(s) == 0 {
@@ -328,7 +581,15 @@ func toSnakeCase(s string) string {
 	return string(result)
 }
 
-func NewProtolite(ProtoDirectories []string) Protolite {
+// NewProtolite returns a Protolite that resolves imports against ProtoDirectories.
+// When fsys is given, e.g. an embed.FS, proto files are read from it instead of
+// the OS filesystem.
+func NewProtolite(ProtoDirectories []string, fsys ...fs.FS) Protolite {
+	if len(fsys) > 0 && fsys[0] != nil {
+		return &protolite{
+			registry: registry.NewRegistryFS(ProtoDirectories, fsys[0]),
+		}
+	}
 	return &protolite{
 		registry: registry.NewRegistry(ProtoDirectories),
 	}
@@ -1,8 +1,18 @@
 package protolite
 
 import (
+	"bytes"
+	"compress/gzip"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
 	"reflect"
 	"strings"
+	"sync"
 	"testing"
 
 	"github.com/anirudhraja/protolite/schema"
@@ -188,7 +198,7 @@ func TestProtolite_UnmarshalToStruct(t *testing.T) {
 
 	t.Run("map_to_struct", func(t *testing.T) {
 		var result TestStruct
-		err := proto.mapToStruct(testData, &result)
+		err := proto.mapToStruct(testData, nil, &result)
 		if err != nil {
 			t.Fatalf("mapToStruct failed: %v", err)
 		}
@@ -216,7 +226,7 @@ func TestProtolite_UnmarshalToStruct(t *testing.T) {
 		}
 
 		var result TestStruct2
-		err := proto.mapToStruct(testData2, &result)
+		err := proto.mapToStruct(testData2, nil, &result)
 		if err != nil {
 			t.Fatalf("mapToStruct failed: %v", err)
 		}
@@ -229,15 +239,87 @@ func TestProtolite_UnmarshalToStruct(t *testing.T) {
 		}
 	})
 
+	t.Run("map_fields", func(t *testing.T) {
+		type TestStruct3 struct {
+			Metadata   map[string]string
+			Statistics map[string]int64
+			Flags      map[int32]bool
+		}
+
+		testData3 := map[string]interface{}{
+			"metadata":   map[string]interface{}{"team": "core"},
+			"statistics": map[string]interface{}{"posts": int64(12)},
+			"flags":      map[int32]interface{}{int32(1): true},
+		}
+
+		var result TestStruct3
+		if err := proto.mapToStruct(testData3, nil, &result); err != nil {
+			t.Fatalf("mapToStruct failed: %v", err)
+		}
+
+		if !reflect.DeepEqual(result.Metadata, map[string]string{"team": "core"}) {
+			t.Errorf("Expected Metadata={team: core}, got %v", result.Metadata)
+		}
+		if !reflect.DeepEqual(result.Statistics, map[string]int64{"posts": 12}) {
+			t.Errorf("Expected Statistics={posts: 12}, got %v", result.Statistics)
+		}
+		if !reflect.DeepEqual(result.Flags, map[int32]bool{1: true}) {
+			t.Errorf("Expected Flags={1: true}, got %v", result.Flags)
+		}
+
+		bad := map[string]interface{}{"metadata": map[string]interface{}{"team": int32(1)}}
+		if err := proto.mapToStruct(bad, nil, &result); err == nil {
+			t.Error("Expected error converting int32 map value to string")
+		}
+	})
+
+	t.Run("nested_structs", func(t *testing.T) {
+		type Coordinates struct {
+			Lat float64
+		}
+		type Address struct {
+			City        string
+			Coordinates *Coordinates
+		}
+		type TestStruct4 struct {
+			Home   Address
+			Work   *Address
+			Places map[string]Address
+		}
+
+		testData4 := map[string]interface{}{
+			"home": map[string]interface{}{
+				"city":        "Springfield",
+				"coordinates": map[string]interface{}{"lat": 1.5},
+			},
+			"work":   map[string]interface{}{"city": "Shelbyville"},
+			"places": map[string]interface{}{"cabin": map[string]interface{}{"city": "Ogdenville"}},
+		}
+
+		var result TestStruct4
+		if err := proto.mapToStruct(testData4, nil, &result); err != nil {
+			t.Fatalf("mapToStruct failed: %v", err)
+		}
+
+		expected := TestStruct4{
+			Home:   Address{City: "Springfield", Coordinates: &Coordinates{Lat: 1.5}},
+			Work:   &Address{City: "Shelbyville"},
+			Places: map[string]Address{"cabin": {City: "Ogdenville"}},
+		}
+		if !reflect.DeepEqual(result, expected) {
+			t.Errorf("Expected %+v, got %+v", expected, result)
+		}
+	})
+
 	t.Run("invalid_target", func(t *testing.T) {
 		var notAPointer TestStruct
-		err := proto.mapToStruct(testData, notAPointer)
+		err := proto.mapToStruct(testData, nil, notAPointer)
 		if err == nil {
 			t.Error("Expected error for non-pointer target")
 		}
 
 		var notAStruct *string
-		err = proto.mapToStruct(testData, notAStruct)
+		err = proto.mapToStruct(testData, nil, notAStruct)
 		if err == nil {
 			t.Error("Expected error for non-struct target")
 		}
@@ -277,7 +359,7 @@ func TestProtolite_setFieldValue(t *testing.T) {
 		var s TestStruct
 		field := reflect.ValueOf(&s).Elem().Field(0)
 
-		err := proto.setFieldValue(field, "test value")
+		err := proto.setFieldValue(field, "test value", nil)
 		if err != nil {
 			t.Fatalf("setFieldValue failed: %v", err)
 		}
@@ -293,7 +375,7 @@ func TestProtolite_setFieldValue(t *testing.T) {
 		var s TestStruct
 		field := reflect.ValueOf(&s).Elem().Field(0)
 
-		err := proto.setFieldValue(field, int32(123))
+		err := proto.setFieldValue(field, int32(123), nil)
 		if err != nil {
 			t.Fatalf("setFieldValue failed: %v", err)
 		}
@@ -309,7 +391,7 @@ func TestProtolite_setFieldValue(t *testing.T) {
 		var s TestStruct
 		field := reflect.ValueOf(&s).Elem().Field(0)
 
-		err := proto.setFieldValue(field, true)
+		err := proto.setFieldValue(field, true, nil)
 		if err != nil {
 			t.Fatalf("setFieldValue failed: %v", err)
 		}
@@ -325,7 +407,7 @@ func TestProtolite_setFieldValue(t *testing.T) {
 		var s TestStruct
 		field := reflect.ValueOf(&s).Elem().Field(0)
 
-		err := proto.setFieldValue(field, 123)
+		err := proto.setFieldValue(field, 123, nil)
 		if err == nil {
 			t.Error("Expected error for type mismatch")
 		}
@@ -338,7 +420,7 @@ func TestProtolite_setFieldValue(t *testing.T) {
 		var s TestStruct
 		field := reflect.ValueOf(&s).Elem().Field(0)
 
-		err := proto.setFieldValue(field, nil)
+		err := proto.setFieldValue(field, nil, nil)
 		if err != nil {
 			t.Fatalf("setFieldValue failed for nil: %v", err)
 		}
@@ -1081,3 +1163,1277 @@ message Article {
 		t.Fatalf("Marshal Article failed: %v", err)
 	}
 }
+
+func TestMarshalDelimited(t *testing.T) {
+	protoContent := `
+syntax = "proto3";
+
+package example;
+
+message Row {
+    int32 id = 1;
+    string name = 2;
+}
+`
+	proto := NewProtolite([]string{""})
+	if err := proto.LoadSchemaFromReader(strings.NewReader(protoContent), "row.proto"); err != nil {
+		t.Fatalf("Failed to load schema from reader: %v", err)
+	}
+
+	items := []map[string]interface{}{
+		{"id": int32(1), "name": "first"},
+		{"id": int32(2), "name": strings.Repeat("x", 200)}, // forces a multi-byte length prefix
+		{},
+	}
+
+	var buf bytes.Buffer
+	if err := proto.MarshalDelimited(&buf, items, "Row"); err != nil {
+		t.Fatalf("MarshalDelimited failed: %v", err)
+	}
+
+	decoder := wire.NewDecoder(buf.Bytes())
+	for i, item := range items {
+		frame, err := decoder.DecodeBytes()
+		if err != nil {
+			t.Fatalf("Failed to read frame %d: %v", i, err)
+		}
+		expected, err := proto.MarshalWithSchema(item, "Row")
+		if err != nil {
+			t.Fatalf("Failed to marshal item %d: %v", i, err)
+		}
+		if !bytes.Equal(frame, expected) {
+			t.Errorf("Frame %d mismatch: expected %v, got %v", i, expected, frame)
+		}
+	}
+	if field, err := decoder.DecodeField(); err != nil || field != nil {
+		t.Errorf("Expected end of stream, got field=%v err=%v", field, err)
+	}
+
+	t.Run("unknown_message", func(t *testing.T) {
+		if err := proto.MarshalDelimited(&buf, items, "Missing"); err == nil {
+			t.Error("Expected error for unknown message")
+		}
+	})
+
+	t.Run("bad_item_writes_nothing", func(t *testing.T) {
+		var out bytes.Buffer
+		bad := []map[string]interface{}{
+			{"id": int32(1), "name": "first"},
+			{"id": "not a number"},
+		}
+		err := proto.MarshalDelimited(&out, bad, "Row")
+		if err == nil || !strings.Contains(err.Error(), "encoding item 1") {
+			t.Fatalf("Expected an error for item 1, got %v", err)
+		}
+		if out.Len() != 0 {
+			t.Errorf("Expected nothing written, got %d bytes", out.Len())
+		}
+	})
+}
+
+func TestMarshalFields(t *testing.T) {
+	protoContent := `
+syntax = "proto3";
+
+package example;
+
+message Address {
+    string street = 1;
+    string city = 2;
+}
+
+message Profile {
+    string user_name = 1;
+    int32 age = 2;
+    Address address = 3;
+}
+`
+	proto := NewProtolite([]string{""})
+	if err := proto.LoadSchemaFromReader(strings.NewReader(protoContent), "profile.proto"); err != nil {
+		t.Fatalf("Failed to load schema from reader: %v", err)
+	}
+
+	data := map[string]interface{}{
+		"userName": "alice",
+		"age":      int32(30),
+		"address": map[string]interface{}{
+			"street": "Main St",
+			"city":   "Springfield",
+		},
+	}
+
+	tests := []struct {
+		name     string
+		mask     []string
+		expected map[string]interface{}
+	}{
+		{
+			name:     "top_level",
+			mask:     []string{"user_name"},
+			expected: map[string]interface{}{"user_name": "alice"},
+		},
+		{
+			name: "nested",
+			mask: []string{"age", "address.city"},
+			expected: map[string]interface{}{
+				"age":     int32(30),
+				"address": map[string]interface{}{"city": "Springfield"},
+			},
+		},
+		{
+			name: "whole_message_wins_over_subpath",
+			mask: []string{"address.city", "address"},
+			expected: map[string]interface{}{
+				"address": map[string]interface{}{"street": "Main St", "city": "Springfield"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := proto.MarshalFields(data, "Profile", tt.mask)
+			if err != nil {
+				t.Fatalf("MarshalFields failed: %v", err)
+			}
+			expected, err := proto.MarshalWithSchema(tt.expected, "Profile")
+			if err != nil {
+				t.Fatalf("MarshalWithSchema failed: %v", err)
+			}
+			if !bytes.Equal(got, expected) {
+				t.Errorf("Expected %v, got %v", expected, got)
+			}
+		})
+	}
+
+	if address := data["address"].(map[string]interface{}); len(address) != 2 {
+		t.Errorf("MarshalFields must not modify the input, got address %v", address)
+	}
+
+	t.Run("unknown_path", func(t *testing.T) {
+		if _, err := proto.MarshalFields(data, "Profile", []string{"address.zip"}); err == nil {
+			t.Error("Expected error for unknown field mask path")
+		}
+	})
+
+	t.Run("path_through_scalar", func(t *testing.T) {
+		if _, err := proto.MarshalFields(data, "Profile", []string{"age.value"}); err == nil {
+			t.Error("Expected error for path through a scalar field")
+		}
+	})
+}
+
+// gzipCodec compresses string values into a bytes field
+type gzipCodec struct{}
+
+func (gzipCodec) Encode(value interface{}) ([]byte, error) {
+	s, ok := value.(string)
+	if !ok {
+		return nil, fmt.Errorf("expected string, got %T", value)
+	}
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write([]byte(s)); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gzipCodec) Decode(data []byte) (interface{}, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	out, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return string(out), nil
+}
+
+// upperCodec writes string values upper-cased
+type upperCodec struct{}
+
+func (upperCodec) Encode(value interface{}) ([]byte, error) {
+	s, ok := value.(string)
+	if !ok {
+		return nil, fmt.Errorf("expected string, got %T", value)
+	}
+	return []byte(strings.ToUpper(s)), nil
+}
+
+func (upperCodec) Decode(data []byte) (interface{}, error) {
+	return string(data), nil
+}
+
+func TestRegisterFieldCodec_Concurrent(t *testing.T) {
+	protoContent := `
+syntax = "proto3";
+
+package codec;
+
+message Tag {
+    string name = 1;
+}
+`
+	proto := NewProtolite([]string{""})
+	if err := proto.LoadSchemaFromReader(strings.NewReader(protoContent), "tag.proto"); err != nil {
+		t.Fatalf("Failed to load schema from reader: %v", err)
+	}
+
+	// codecs come and go while other goroutines encode and decode the field
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				encoded, err := proto.MarshalWithSchema(map[string]interface{}{"name": "go"}, "codec.Tag")
+				if err != nil {
+					t.Errorf("MarshalWithSchema failed: %v", err)
+					return
+				}
+				decoded, err := proto.UnmarshalWithSchema(encoded, "codec.Tag")
+				if err != nil {
+					t.Errorf("UnmarshalWithSchema failed: %v", err)
+					return
+				}
+				if name := decoded["name"]; name != "go" && name != "GO" {
+					t.Errorf("Unexpected name %v", name)
+					return
+				}
+			}
+		}()
+	}
+	for i := 0; i < 100; i++ {
+		var codec FieldCodec
+		if i%2 == 0 {
+			codec = upperCodec{}
+		}
+		if err := proto.RegisterFieldCodec("codec.Tag", "name", codec); err != nil {
+			t.Fatalf("RegisterFieldCodec failed: %v", err)
+		}
+	}
+	close(done)
+	wg.Wait()
+}
+
+func TestRegisterFieldCodec(t *testing.T) {
+	protoContent := `
+syntax = "proto3";
+
+package codec;
+
+message Log {
+    string id = 1;
+    bytes body = 2;
+    repeated bytes attachments = 3;
+    int32 level = 4;
+}
+`
+	proto := NewProtolite([]string{""})
+	if err := proto.LoadSchemaFromReader(strings.NewReader(protoContent), "codec.proto"); err != nil {
+		t.Fatalf("Failed to load schema from reader: %v", err)
+	}
+	for _, field := range []string{"body", "attachments"} {
+		if err := proto.RegisterFieldCodec("codec.Log", field, gzipCodec{}); err != nil {
+			t.Fatalf("RegisterFieldCodec(%s) failed: %v", field, err)
+		}
+	}
+
+	body := strings.Repeat("compress me ", 100)
+	data := map[string]interface{}{
+		"id":          "l1",
+		"body":        body,
+		"attachments": []interface{}{"first", "second"},
+	}
+	encoded, err := proto.MarshalWithSchema(data, "codec.Log")
+	if err != nil {
+		t.Fatalf("MarshalWithSchema failed: %v", err)
+	}
+	if len(encoded) >= len(body) {
+		t.Errorf("Expected the body to be compressed, got %d bytes", len(encoded))
+	}
+
+	// without the codec the payload is the gzip stream
+	parsed, err := proto.Parse(encoded)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	field2, _ := parsed["field_2"].(map[string]interface{})
+	if raw, ok := field2["value"].([]byte); !ok || !bytes.HasPrefix(raw, []byte{0x1f, 0x8b}) {
+		t.Errorf("Expected gzip payload on the wire, got %v", parsed["field_2"])
+	}
+
+	decoded, err := proto.UnmarshalWithSchema(encoded, "codec.Log")
+	if err != nil {
+		t.Fatalf("UnmarshalWithSchema failed: %v", err)
+	}
+	if decoded["body"] != body {
+		t.Errorf("Expected body to round trip, got %v", decoded["body"])
+	}
+	if !reflect.DeepEqual(decoded["attachments"], []interface{}{"first", "second"}) {
+		t.Errorf("Expected attachments to round trip, got %v", decoded["attachments"])
+	}
+
+	t.Run("errors", func(t *testing.T) {
+		if err := proto.RegisterFieldCodec("codec.Log", "level", gzipCodec{}); err == nil {
+			t.Error("Expected error for a varint field")
+		}
+		if err := proto.RegisterFieldCodec("codec.Log", "missing", gzipCodec{}); err == nil {
+			t.Error("Expected error for an unknown field")
+		}
+		_, err := proto.MarshalWithSchema(map[string]interface{}{"body": 7}, "codec.Log")
+		if err == nil || !strings.Contains(err.Error(), "field codec") {
+			t.Errorf("Expected codec error, got %v", err)
+		}
+	})
+}
+
+func TestUnmarshalFields(t *testing.T) {
+	protoContent := `
+syntax = "proto3";
+
+package example;
+
+message Address {
+    string street = 1;
+}
+
+message Event {
+    int64 id = 1;
+    int64 timestamp = 2;
+    Address address = 3;
+    repeated string tags = 4;
+    string note = 5;
+}
+`
+	proto := NewProtolite([]string{""})
+	if err := proto.LoadSchemaFromReader(strings.NewReader(protoContent), "event.proto"); err != nil {
+		t.Fatalf("Failed to load schema from reader: %v", err)
+	}
+
+	encoded, err := proto.MarshalWithSchema(map[string]interface{}{
+		"id":   int64(7),
+		"tags": []interface{}{"a", "b"},
+		"note": "hello",
+	}, "example.Event")
+	if err != nil {
+		t.Fatalf("MarshalWithSchema failed: %v", err)
+	}
+	// an address whose payload is not a valid message: unselected fields are
+	// skipped without being decoded, so it only breaks a full decode
+	encoded = append(encoded, 0x1a, 0x02, 0xff, 0xff)
+	if _, err := proto.UnmarshalWithSchema(encoded, "example.Event"); err == nil {
+		t.Fatal("Expected full decode of the malformed address to fail")
+	}
+
+	got, err := proto.UnmarshalFields(encoded, "example.Event", []int32{1, 2})
+	if err != nil {
+		t.Fatalf("UnmarshalFields failed: %v", err)
+	}
+	// the absent timestamp still gets its default like in a full decode
+	expected := map[string]interface{}{"id": int64(7), "timestamp": int64(0)}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+
+	got, err = proto.UnmarshalFields(encoded, "example.Event", []int32{4})
+	if err != nil {
+		t.Fatalf("UnmarshalFields failed: %v", err)
+	}
+	expected = map[string]interface{}{"tags": []interface{}{"a", "b"}}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+}
+
+func TestMarshalWithSchema_NestedStructs(t *testing.T) {
+	protoContent := `
+syntax = "proto3";
+
+package example;
+
+message Coordinates {
+    double lat = 1;
+    double lng = 2;
+}
+
+message Address {
+    string street_name = 1;
+    Coordinates coordinates = 2;
+}
+
+message Person {
+    string name = 1;
+    Address home = 2;
+    repeated Address previous = 3;
+    Address work = 4;
+}
+`
+	type Coordinates struct {
+		Lat, Lng float64
+	}
+	type Address struct {
+		StreetName  string
+		Coordinates *Coordinates
+		internal    string
+	}
+
+	proto := NewProtolite([]string{""})
+	if err := proto.LoadSchemaFromReader(strings.NewReader(protoContent), "person.proto"); err != nil {
+		t.Fatalf("Failed to load schema from reader: %v", err)
+	}
+
+	encoded, err := proto.MarshalWithSchema(map[string]interface{}{
+		"name": "alice",
+		"home": Address{StreetName: "Main St", Coordinates: &Coordinates{Lat: 1.5, Lng: -2.5}, internal: "x"},
+		"previous": []Address{
+			{StreetName: "Elm St"},
+			{StreetName: "Oak St"},
+		},
+		"work": (*Address)(nil),
+	}, "example.Person")
+	if err != nil {
+		t.Fatalf("MarshalWithSchema failed: %v", err)
+	}
+
+	decoded, err := proto.UnmarshalWithSchema(encoded, "example.Person")
+	if err != nil {
+		t.Fatalf("UnmarshalWithSchema failed: %v", err)
+	}
+	expected := map[string]interface{}{
+		"name": "alice",
+		"home": map[string]interface{}{
+			"street_name": "Main St",
+			"coordinates": map[string]interface{}{"lat": 1.5, "lng": -2.5},
+		},
+		"previous": []interface{}{
+			map[string]interface{}{"street_name": "Elm St"},
+			map[string]interface{}{"street_name": "Oak St"},
+		},
+		"work": map[string]interface{}{"street_name": ""},
+	}
+	if !reflect.DeepEqual(decoded, expected) {
+		t.Errorf("Expected %v, got %v", expected, decoded)
+	}
+
+	if _, err := proto.MarshalWithSchema(map[string]interface{}{"home": 42}, "example.Person"); err == nil {
+		t.Error("Expected error for a message field given a non-struct value")
+	}
+}
+
+func TestMarshalWithSchema_NestedStructTags(t *testing.T) {
+	protoContent := `
+syntax = "proto3";
+
+package example;
+
+message Account {
+    string user_id = 1;
+    string userid = 2;
+    string display_name = 3 [json_name = "nick"];
+    string note = 4;
+}
+
+message Holder {
+    Account account = 1;
+}
+`
+	proto := NewProtolite([]string{""})
+	if err := proto.LoadSchemaFromReader(strings.NewReader(protoContent), "holder.proto"); err != nil {
+		t.Fatalf("Failed to load schema from reader: %v", err)
+	}
+
+	type Tagged struct {
+		ID     string `protobuf:"bytes,1,opt,name=user_id,proto3"`
+		Legacy string `json:"userid"`
+		Name   string `json:"nick,omitempty"`
+		Note   string `json:"-"`
+	}
+	encoded, err := proto.MarshalWithSchema(map[string]interface{}{
+		"account": Tagged{ID: "u1", Legacy: "old", Name: "Al", Note: "secret"},
+	}, "example.Holder")
+	if err != nil {
+		t.Fatalf("MarshalWithSchema failed: %v", err)
+	}
+	decoded, err := proto.UnmarshalWithSchema(encoded, "example.Holder")
+	if err != nil {
+		t.Fatalf("UnmarshalWithSchema failed: %v", err)
+	}
+	expected := map[string]interface{}{
+		// note stays unset, so it decodes to its default
+		"account": map[string]interface{}{"user_id": "u1", "userid": "old", "nick": "Al", "note": ""},
+	}
+	if !reflect.DeepEqual(decoded, expected) {
+		t.Errorf("Expected %v, got %v", expected, decoded)
+	}
+
+	t.Run("ambiguous_name", func(t *testing.T) {
+		type Untagged struct {
+			UserID string
+		}
+		_, err := proto.MarshalWithSchema(map[string]interface{}{"account": Untagged{UserID: "u1"}}, "example.Holder")
+		if err == nil || !strings.Contains(err.Error(), "matches both user_id and userid") {
+			t.Errorf("Expected an ambiguous match error, got %v", err)
+		}
+	})
+
+	t.Run("same_field_twice", func(t *testing.T) {
+		type Twice struct {
+			DisplayName string
+			Nick        string `json:"nick"`
+		}
+		_, err := proto.MarshalWithSchema(map[string]interface{}{"account": Twice{DisplayName: "Al", Nick: "Bo"}}, "example.Holder")
+		if err == nil || !strings.Contains(err.Error(), "fields DisplayName and Nick both fill display_name") {
+			t.Errorf("Expected an error for two struct fields filling display_name, got %v", err)
+		}
+	})
+}
+
+func TestParseWithSchema(t *testing.T) {
+	protoContent := `
+syntax = "proto3";
+
+package example;
+
+message Inner {
+    string a = 1;
+    string b = 2;
+}
+
+message Full {
+    string name = 1;
+    int64 id = 2;
+    Inner inner = 3;
+    fixed32 code = 4;
+}
+
+// Partial knows only some of the fields of Full
+message PartialInner {
+    string a = 1;
+}
+
+message Partial {
+    string name = 1;
+    PartialInner inner = 3;
+}
+`
+	proto := NewProtolite([]string{""})
+	if err := proto.LoadSchemaFromReader(strings.NewReader(protoContent), "partial.proto"); err != nil {
+		t.Fatalf("Failed to load schema from reader: %v", err)
+	}
+
+	encoded, err := proto.MarshalWithSchema(map[string]interface{}{
+		"name":  "alice",
+		"id":    int64(5),
+		"inner": map[string]interface{}{"a": "x", "b": "y"},
+		"code":  uint32(9),
+	}, "example.Full")
+	if err != nil {
+		t.Fatalf("MarshalWithSchema failed: %v", err)
+	}
+
+	got, err := proto.ParseWithSchema(encoded, "example.Partial")
+	if err != nil {
+		t.Fatalf("ParseWithSchema failed: %v", err)
+	}
+	expected := map[string]interface{}{
+		"name": "alice",
+		"inner": map[string]interface{}{
+			"a":       "x",
+			"field_2": map[string]interface{}{"type": "bytes", "value": []byte("y")},
+		},
+		"field_2": map[string]interface{}{"type": "varint", "value": uint64(5)},
+		"field_4": map[string]interface{}{"type": "fixed32", "value": uint32(9)},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+
+	// UnmarshalWithSchema still drops what the schema does not know
+	plain, err := proto.UnmarshalWithSchema(encoded, "example.Partial")
+	if err != nil {
+		t.Fatalf("UnmarshalWithSchema failed: %v", err)
+	}
+	if _, ok := plain["field_2"]; ok {
+		t.Errorf("Expected UnmarshalWithSchema to drop unknown fields, got %v", plain)
+	}
+}
+
+func TestMarshalWithMessage(t *testing.T) {
+	protoContent := `
+syntax = "proto3";
+
+package example;
+
+message Address {
+    string city = 1;
+}
+
+message Request {
+    string id = 1;
+    Address address = 2;
+}
+`
+	proto := NewProtolite([]string{""})
+	if err := proto.LoadSchemaFromReader(strings.NewReader(protoContent), "request.proto"); err != nil {
+		t.Fatalf("Failed to load schema from reader: %v", err)
+	}
+
+	// synthesized per request, mirroring example.Request without being registered
+	msg := &schema.Message{
+		Name: "DynamicRequest",
+		Fields: []*schema.Field{
+			{Name: "id", Number: 1, Type: schema.FieldType{Kind: schema.KindPrimitive, PrimitiveType: schema.TypeString}},
+			{Name: "address", Number: 2, Type: schema.FieldType{Kind: schema.KindMessage, MessageType: "example.Address"}},
+		},
+	}
+	data := map[string]interface{}{
+		"id":      "r-1",
+		"address": map[string]interface{}{"city": "Springfield"},
+	}
+	encoded, err := proto.MarshalWithMessage(data, msg)
+	if err != nil {
+		t.Fatalf("MarshalWithMessage failed: %v", err)
+	}
+	expected, err := proto.MarshalWithSchema(data, "example.Request")
+	if err != nil {
+		t.Fatalf("MarshalWithSchema failed: %v", err)
+	}
+	if !bytes.Equal(encoded, expected) {
+		t.Errorf("Expected %x, got %x", expected, encoded)
+	}
+
+	if _, err := proto.MarshalWithMessage(data, nil); err == nil {
+		t.Error("Expected error for nil message")
+	}
+
+	// a oneof member may be neither repeated nor a map
+	for _, member := range []*schema.Field{
+		{Name: "tags", Number: 3, Label: schema.LabelRepeated, Type: schema.FieldType{Kind: schema.KindPrimitive, PrimitiveType: schema.TypeString}},
+		{Name: "attrs", Number: 3, Type: schema.FieldType{Kind: schema.KindMap, MapKey: &schema.FieldType{Kind: schema.KindPrimitive, PrimitiveType: schema.TypeString}, MapValue: &schema.FieldType{Kind: schema.KindPrimitive, PrimitiveType: schema.TypeString}}},
+	} {
+		invalid := &schema.Message{
+			Name:        "DynamicRequest",
+			Fields:      msg.Fields,
+			OneofGroups: []*schema.Oneof{{Name: "extra", Fields: []*schema.Field{member}}},
+		}
+		_, err := proto.MarshalWithMessage(data, &schema.Message{Name: "Outer", NestedTypes: []*schema.Message{invalid}})
+		if err == nil || !strings.Contains(err.Error(), "Outer.DynamicRequest: oneof extra cannot contain repeated or map field "+member.Name) {
+			t.Errorf("Expected oneof validation error for %s, got %v", member.Name, err)
+		}
+		if _, err := proto.MarshalWithMessage(data, invalid); err == nil {
+			t.Errorf("Expected oneof validation error for %s", member.Name)
+		}
+	}
+}
+
+func TestUnmarshalToStruct_Enums(t *testing.T) {
+	protoContent := `
+syntax = "proto3";
+
+package example;
+
+enum Status {
+    STATUS_UNKNOWN = 0;
+    STATUS_ACTIVE = 1;
+    STATUS_BANNED = 2;
+}
+
+message Owner {
+    Status status = 1;
+}
+
+message Account {
+    Status status = 1;
+    repeated Status history = 2;
+    map<string, Status> by_region = 3;
+    Owner owner = 4;
+    Status label = 5;
+}
+`
+	proto := NewProtolite([]string{""})
+	if err := proto.LoadSchemaFromReader(strings.NewReader(protoContent), "account.proto"); err != nil {
+		t.Fatalf("Failed to load schema from reader: %v", err)
+	}
+
+	type Status int32
+	type Owner struct {
+		Status Status
+	}
+	type Account struct {
+		Status   Status
+		History  []Status
+		ByRegion map[string]int32
+		Owner    *Owner
+		Label    string
+	}
+
+	encoded, err := proto.MarshalWithSchema(map[string]interface{}{
+		"status":    "STATUS_ACTIVE",
+		"history":   []interface{}{"STATUS_UNKNOWN", "STATUS_BANNED", int32(7)},
+		"by_region": map[string]interface{}{"eu": "STATUS_BANNED"},
+		"owner":     map[string]interface{}{"status": "STATUS_BANNED"},
+		"label":     "STATUS_ACTIVE",
+	}, "example.Account")
+	if err != nil {
+		t.Fatalf("MarshalWithSchema failed: %v", err)
+	}
+
+	var account Account
+	if err := proto.UnmarshalToStruct(encoded, "example.Account", &account); err != nil {
+		t.Fatalf("UnmarshalToStruct failed: %v", err)
+	}
+	expected := Account{
+		Status: 1,
+		// an unknown number survives as itself
+		History:  []Status{0, 2, 7},
+		ByRegion: map[string]int32{"eu": 2},
+		Owner:    &Owner{Status: 2},
+		// string fields keep the name
+		Label: "STATUS_ACTIVE",
+	}
+	if !reflect.DeepEqual(account, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, account)
+	}
+}
+
+func TestUnmarshalWithPresence(t *testing.T) {
+	protoContent := `
+syntax = "proto3";
+
+package example;
+
+message Address {
+    string street = 1;
+    string city = 2;
+}
+
+message Profile {
+    string name = 1;
+    int32 age = 2;
+    Address address = 3;
+    repeated string tags = 4;
+    map<string, string> labels = 5;
+    oneof contact {
+        string email = 6;
+        string phone = 7;
+    }
+}
+`
+	proto := NewProtolite([]string{""})
+	if err := proto.LoadSchemaFromReader(strings.NewReader(protoContent), "profile.proto"); err != nil {
+		t.Fatalf("Failed to load schema from reader: %v", err)
+	}
+
+	encoded, err := proto.MarshalWithSchema(map[string]interface{}{
+		"name":    "ann",
+		"address": map[string]interface{}{"street": "main"},
+		"labels":  map[string]interface{}{"team": "core"},
+		"email":   "ann@example.com",
+	}, "example.Profile")
+	if err != nil {
+		t.Fatalf("MarshalWithSchema failed: %v", err)
+	}
+
+	got, presence, err := proto.UnmarshalWithPresence(encoded, "example.Profile")
+	if err != nil {
+		t.Fatalf("UnmarshalWithPresence failed: %v", err)
+	}
+	// age is filled with its default but was not on the wire
+	if got["age"] != int32(0) {
+		t.Errorf("Expected default age 0, got %v", got["age"])
+	}
+	expected := map[string]bool{"name": true, "address": true, "labels": true, "email": true}
+	if !reflect.DeepEqual(presence, expected) {
+		t.Errorf("Expected presence %v, got %v", expected, presence)
+	}
+
+	decoded, err := proto.UnmarshalWithSchema(encoded, "example.Profile")
+	if err != nil {
+		t.Fatalf("UnmarshalWithSchema failed: %v", err)
+	}
+	if !reflect.DeepEqual(got, decoded) {
+		t.Errorf("Expected the same values as UnmarshalWithSchema:\nexpected %v\ngot      %v", decoded, got)
+	}
+}
+
+//go:embed conformance_test/protos/google/protobuf/*.proto
+var embeddedWKT embed.FS
+
+func TestNewProtolite_EmbedFS(t *testing.T) {
+	protos, err := fs.Sub(embeddedWKT, "conformance_test/protos")
+	if err != nil {
+		t.Fatalf("fs.Sub: %v", err)
+	}
+	// "google/protobuf/..." does not exist relative to the working directory,
+	// so both loads below can only succeed by reading from protos
+	proto := NewProtolite([]string{""}, protos)
+	if err := proto.LoadSchemaFromFile("google/protobuf/duration.proto"); err != nil {
+		t.Fatalf("LoadSchemaFromFile: %v", err)
+	}
+	eventProto := `
+syntax = "proto3";
+
+package example;
+
+import "google/protobuf/timestamp.proto";
+
+message Event {
+    string name = 1;
+    google.protobuf.Timestamp at = 2;
+}
+`
+	if err := proto.LoadSchemaFromReader(strings.NewReader(eventProto), "event.proto"); err != nil {
+		t.Fatalf("LoadSchemaFromReader: %v", err)
+	}
+
+	data := map[string]interface{}{
+		"name": "launch",
+		"at":   map[string]interface{}{"seconds": int64(1700000000)},
+	}
+	encoded, err := proto.MarshalWithSchema(data, "example.Event")
+	if err != nil {
+		t.Fatalf("MarshalWithSchema failed: %v", err)
+	}
+	decoded, err := proto.UnmarshalWithSchema(encoded, "example.Event")
+	if err != nil {
+		t.Fatalf("UnmarshalWithSchema failed: %v", err)
+	}
+	at, _ := decoded["at"].(map[string]interface{})
+	if decoded["name"] != "launch" || at["seconds"] != int64(1700000000) {
+		t.Errorf("Unexpected round trip result: %v", decoded)
+	}
+
+	if err := NewProtolite([]string{""}).LoadSchemaFromFile("google/protobuf/duration.proto"); err == nil {
+		t.Errorf("Expected the OS filesystem lookup to fail without an fs.FS")
+	}
+}
+
+func TestRoundTrip_AllScalarTypes(t *testing.T) {
+	proto := NewProtolite([]string{"./sampleapp/testdata"})
+	if err := proto.LoadSchemaFromFile("scalars.proto"); err != nil {
+		t.Fatalf("Loading scalars.proto failed: %v", err)
+	}
+
+	expected := map[string]interface{}{
+		"double_value":   -1.5,
+		"float_value":    float32(0.25),
+		"int32_value":    int32(math.MinInt32),
+		"int64_value":    int64(math.MinInt64),
+		"uint32_value":   uint32(math.MaxUint32),
+		"uint64_value":   uint64(math.MaxUint64),
+		"sint32_value":   int32(math.MinInt32),
+		"sint64_value":   int64(math.MinInt64),
+		"fixed32_value":  uint32(math.MaxUint32),
+		"fixed64_value":  uint64(math.MaxUint64),
+		"sfixed32_value": int32(math.MinInt32),
+		"sfixed64_value": int64(math.MinInt64),
+		"bool_value":     true,
+		"string_value":   "héllo",
+		"bytes_value":    []byte{0x00, 0xff},
+		"sint32_list":    []interface{}{int32(-1), int32(0), int32(math.MaxInt32)},
+		"sint64_list":    []interface{}{int64(-1), int64(math.MaxInt64)},
+		"fixed32_list":   []interface{}{uint32(0), uint32(math.MaxUint32)},
+		"fixed64_list":   []interface{}{uint64(0), uint64(math.MaxUint64)},
+		"sfixed32_list":  []interface{}{int32(math.MinInt32), int32(-1)},
+		"sfixed64_list":  []interface{}{int64(math.MinInt64), int64(-1)},
+	}
+
+	inputs := map[string]map[string]interface{}{
+		"go_types": {
+			"double_value":   -1.5,
+			"float_value":    float32(0.25),
+			"int32_value":    int32(math.MinInt32),
+			"int64_value":    int64(math.MinInt64),
+			"uint32_value":   uint32(math.MaxUint32),
+			"uint64_value":   uint64(math.MaxUint64),
+			"sint32_value":   int32(math.MinInt32),
+			"sint64_value":   int64(math.MinInt64),
+			"fixed32_value":  uint32(math.MaxUint32),
+			"fixed64_value":  uint64(math.MaxUint64),
+			"sfixed32_value": int32(math.MinInt32),
+			"sfixed64_value": int64(math.MinInt64),
+			"bool_value":     true,
+			"string_value":   "héllo",
+			"bytes_value":    []byte{0x00, 0xff},
+			"sint32_list":    []int32{-1, 0, math.MaxInt32},
+			"sint64_list":    []int64{-1, math.MaxInt64},
+			"fixed32_list":   []uint32{0, math.MaxUint32},
+			"fixed64_list":   []uint64{0, math.MaxUint64},
+			"sfixed32_list":  []int32{math.MinInt32, -1},
+			"sfixed64_list":  []int64{math.MinInt64, -1},
+		},
+		"json_numbers": {
+			"double_value":   json.Number("-1.5"),
+			"float_value":    json.Number("0.25"),
+			"int32_value":    json.Number("-2147483648"),
+			"int64_value":    json.Number("-9223372036854775808"),
+			"uint32_value":   json.Number("4294967295"),
+			"uint64_value":   json.Number("18446744073709551615"),
+			"sint32_value":   json.Number("-2147483648"),
+			"sint64_value":   json.Number("-9223372036854775808"),
+			"fixed32_value":  json.Number("4294967295"),
+			"fixed64_value":  json.Number("18446744073709551615"),
+			"sfixed32_value": json.Number("-2147483648"),
+			"sfixed64_value": json.Number("-9223372036854775808"),
+			"bool_value":     true,
+			"string_value":   "héllo",
+			"bytes_value":    "AP8=",
+			"sint32_list":    []interface{}{json.Number("-1"), json.Number("0"), json.Number("2147483647")},
+			"sint64_list":    []interface{}{json.Number("-1"), json.Number("9223372036854775807")},
+			"fixed32_list":   []interface{}{json.Number("0"), json.Number("4294967295")},
+			"fixed64_list":   []interface{}{json.Number("0"), json.Number("18446744073709551615")},
+			"sfixed32_list":  []interface{}{json.Number("-2147483648"), json.Number("-1")},
+			"sfixed64_list":  []interface{}{json.Number("-9223372036854775808"), json.Number("-1")},
+		},
+	}
+	for name, input := range inputs {
+		t.Run(name, func(t *testing.T) {
+			encoded, err := proto.MarshalWithSchema(input, "scalars.AllScalars")
+			if err != nil {
+				t.Fatalf("MarshalWithSchema failed: %v", err)
+			}
+			decoded, err := proto.UnmarshalWithSchema(encoded, "scalars.AllScalars")
+			if err != nil {
+				t.Fatalf("UnmarshalWithSchema failed: %v", err)
+			}
+			for field, want := range expected {
+				if !reflect.DeepEqual(decoded[field], want) {
+					t.Errorf("field %s: expected %#v, got %#v", field, want, decoded[field])
+				}
+			}
+		})
+	}
+
+	// values outside the field's range are rejected rather than truncated
+	for _, field := range []string{"int32_value", "sint32_value", "sfixed32_value", "uint32_value", "fixed32_value"} {
+		for _, number := range []json.Number{"4294967296", "-2147483649"} {
+			if _, err := proto.MarshalWithSchema(map[string]interface{}{field: number}, "scalars.AllScalars"); err == nil {
+				t.Errorf("field %s: expected an error for %s", field, number)
+			}
+		}
+	}
+}
+
+// testMoney keeps an amount in cents and maps itself to a Money message of units and nanos
+type testMoney struct {
+	Cents    int64
+	Currency string
+}
+
+func (m testMoney) MarshalProtolite() (map[string]interface{}, error) {
+	if m.Currency == "" {
+		return nil, errors.New("missing currency")
+	}
+	return map[string]interface{}{
+		"currency_code": m.Currency,
+		"units":         m.Cents / 100,
+		"nanos":         int32(m.Cents%100) * 10000000,
+	}, nil
+}
+
+func (m *testMoney) UnmarshalProtolite(data map[string]interface{}) error {
+	units, _ := data["units"].(int64)
+	nanos, _ := data["nanos"].(int32)
+	m.Currency, _ = data["currency_code"].(string)
+	m.Cents = units*100 + int64(nanos/10000000)
+	return nil
+}
+
+func TestProtoliteMarshaler(t *testing.T) {
+	protoContent := `
+syntax = "proto3";
+
+package example;
+
+message Money {
+    string currency_code = 1;
+    int64 units = 2;
+    int32 nanos = 3;
+}
+
+message Order {
+    string id = 1;
+    Money total = 2;
+    repeated Money refunds = 3;
+    Money tip = 4;
+}
+`
+	proto := NewProtolite([]string{""})
+	if err := proto.LoadSchemaFromReader(strings.NewReader(protoContent), "order.proto"); err != nil {
+		t.Fatalf("Failed to load schema from reader: %v", err)
+	}
+
+	type Order struct {
+		ID      string
+		Total   testMoney
+		Refunds []testMoney
+		Tip     *testMoney
+	}
+
+	encoded, err := proto.MarshalWithSchema(map[string]interface{}{
+		"id":      "o-1",
+		"total":   testMoney{Cents: 1234, Currency: "EUR"},
+		"refunds": []interface{}{testMoney{Cents: 5, Currency: "EUR"}},
+		"tip":     &testMoney{Cents: 100, Currency: "EUR"},
+	}, "example.Order")
+	if err != nil {
+		t.Fatalf("MarshalWithSchema failed: %v", err)
+	}
+	decoded, err := proto.UnmarshalWithSchema(encoded, "example.Order")
+	if err != nil {
+		t.Fatalf("UnmarshalWithSchema failed: %v", err)
+	}
+	total := decoded["total"].(map[string]interface{})
+	if total["currency_code"] != "EUR" || total["units"] != int64(12) || total["nanos"] != int32(340000000) {
+		t.Errorf("Unexpected total: %v", total)
+	}
+
+	var order Order
+	if err := proto.UnmarshalToStruct(encoded, "example.Order", &order); err != nil {
+		t.Fatalf("UnmarshalToStruct failed: %v", err)
+	}
+	expected := Order{
+		ID:      "o-1",
+		Total:   testMoney{Cents: 1234, Currency: "EUR"},
+		Refunds: []testMoney{{Cents: 5, Currency: "EUR"}},
+		Tip:     &testMoney{Cents: 100, Currency: "EUR"},
+	}
+	if !reflect.DeepEqual(order, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, order)
+	}
+
+	// the target of UnmarshalToStruct may implement the interface itself
+	moneyBytes, err := proto.MarshalWithSchema(map[string]interface{}{"currency_code": "USD", "units": int64(3), "nanos": int32(50000000)}, "example.Money")
+	if err != nil {
+		t.Fatalf("MarshalWithSchema failed: %v", err)
+	}
+	var money testMoney
+	if err := proto.UnmarshalToStruct(moneyBytes, "example.Money", &money); err != nil {
+		t.Fatalf("UnmarshalToStruct failed: %v", err)
+	}
+	if money != (testMoney{Cents: 305, Currency: "USD"}) {
+		t.Errorf("Unexpected money: %+v", money)
+	}
+
+	_, err = proto.MarshalWithSchema(map[string]interface{}{"total": testMoney{Cents: 1}}, "example.Order")
+	if err == nil || !strings.Contains(err.Error(), "missing currency") {
+		t.Errorf("expected the MarshalProtolite error, got %v", err)
+	}
+}
+
+func TestUnmarshalWithSchema_TopLevelStruct(t *testing.T) {
+	proto := NewProtolite([]string{"conformance_test/protos"})
+	if err := proto.LoadSchemaFromFile("google/protobuf/struct.proto"); err != nil {
+		t.Fatalf("LoadSchemaFromFile failed: %v", err)
+	}
+	fields := map[string]interface{}{
+		"name":  map[string]interface{}{"string_value": "x"},
+		"count": map[string]interface{}{"number_value": float64(2)},
+		"none":  map[string]interface{}{"null_value": "NULL_VALUE"},
+		"inner": map[string]interface{}{"struct_value": map[string]interface{}{
+			"fields": map[string]interface{}{"ok": map[string]interface{}{"bool_value": true}},
+		}},
+		"tags": map[string]interface{}{"list_value": map[string]interface{}{
+			"values": []interface{}{map[string]interface{}{"string_value": "a"}},
+		}},
+	}
+	expected := map[string]interface{}{
+		"name":  "x",
+		"count": float64(2),
+		"none":  nil,
+		"inner": map[string]interface{}{"ok": true},
+		"tags":  []interface{}{"a"},
+	}
+
+	structBytes, err := proto.MarshalWithSchema(map[string]interface{}{"fields": fields}, "google.protobuf.Struct")
+	if err != nil {
+		t.Fatalf("MarshalWithSchema failed: %v", err)
+	}
+	valueBytes, err := proto.MarshalWithSchema(map[string]interface{}{"struct_value": map[string]interface{}{"fields": fields}}, "google.protobuf.Value")
+	if err != nil {
+		t.Fatalf("MarshalWithSchema failed: %v", err)
+	}
+	for name, data := range map[string][]byte{"google.protobuf.Struct": structBytes, "google.protobuf.Value": valueBytes} {
+		decoded, err := proto.UnmarshalWithSchema(data, name)
+		if err != nil {
+			t.Fatalf("UnmarshalWithSchema(%s) failed: %v", name, err)
+		}
+		if !reflect.DeepEqual(decoded, expected) {
+			t.Errorf("%s: expected %v, got %v", name, expected, decoded)
+		}
+		jsonMap, err := proto.UnmarshalToJSONMap(data, name)
+		if err != nil {
+			t.Fatalf("UnmarshalToJSONMap(%s) failed: %v", name, err)
+		}
+		if !reflect.DeepEqual(jsonMap, expected) {
+			t.Errorf("%s JSON: expected %v, got %v", name, expected, jsonMap)
+		}
+	}
+
+	// a Value that is not an object keeps its oneof shape
+	stringBytes, err := proto.MarshalWithSchema(map[string]interface{}{"string_value": "x"}, "google.protobuf.Value")
+	if err != nil {
+		t.Fatalf("MarshalWithSchema failed: %v", err)
+	}
+	decoded, err := proto.UnmarshalWithSchema(stringBytes, "google.protobuf.Value")
+	if err != nil {
+		t.Fatalf("UnmarshalWithSchema failed: %v", err)
+	}
+	if decoded["string_value"] != "x" {
+		t.Errorf("Expected the string_value shape, got %v", decoded)
+	}
+}
+
+func TestUnmarshalWithSchema_ListOfLists(t *testing.T) {
+	protoContent := `
+syntax = "proto3";
+
+package lists;
+
+message Triangle {
+    repeated SecondListWrapper rows = 1;
+}
+
+message SecondListWrapper {
+    option wrapper = true;
+    repeated int32 items = 1;
+}
+`
+	proto := NewProtolite([]string{""})
+	if err := proto.LoadSchemaFromReader(strings.NewReader(protoContent), "lists.proto"); err != nil {
+		t.Fatalf("Failed to load schema from reader: %v", err)
+	}
+
+	triangle := map[string]interface{}{
+		"rows": []interface{}{
+			[]interface{}{int32(1)},
+			[]interface{}{int32(1), int32(1)},
+			[]interface{}{int32(1), int32(2), int32(1)},
+			[]interface{}{int32(1), int32(3), int32(3), int32(1)},
+			[]interface{}{int32(1), int32(4), int32(6), int32(4), int32(1)},
+		},
+	}
+	// each row is a SecondListWrapper message holding its packed items
+	golden := []byte{
+		0x0a, 0x03, 0x0a, 0x01, 0x01,
+		0x0a, 0x04, 0x0a, 0x02, 0x01, 0x01,
+		0x0a, 0x05, 0x0a, 0x03, 0x01, 0x02, 0x01,
+		0x0a, 0x06, 0x0a, 0x04, 0x01, 0x03, 0x03, 0x01,
+		0x0a, 0x07, 0x0a, 0x05, 0x01, 0x04, 0x06, 0x04, 0x01,
+	}
+
+	encoded, err := proto.MarshalWithSchema(triangle, "lists.Triangle")
+	if err != nil {
+		t.Fatalf("MarshalWithSchema failed: %v", err)
+	}
+	if !bytes.Equal(encoded, golden) {
+		t.Errorf("Expected bytes %x, got %x", golden, encoded)
+	}
+
+	decoded, err := proto.UnmarshalWithSchema(golden, "lists.Triangle")
+	if err != nil {
+		t.Fatalf("UnmarshalWithSchema failed: %v", err)
+	}
+	if !reflect.DeepEqual(decoded, triangle) {
+		t.Errorf("Expected %v, got %v", triangle, decoded)
+	}
+}
+
+func TestMarshalWithSchema_NilMap(t *testing.T) {
+	protoContent := `
+syntax = "proto3";
+
+package heartbeat;
+
+message Ping {
+    string id = 1;
+}
+
+message Tracked {
+    option track_null = true;
+    string id = 1;
+}
+
+message Items {
+    option wrapper = true;
+    repeated int32 items = 1;
+}
+`
+	proto := NewProtolite([]string{""})
+	if err := proto.LoadSchemaFromReader(strings.NewReader(protoContent), "heartbeat.proto"); err != nil {
+		t.Fatalf("Failed to load schema from reader: %v", err)
+	}
+
+	for _, name := range []string{"heartbeat.Ping", "heartbeat.Tracked", "heartbeat.Items"} {
+		encoded, err := proto.MarshalWithSchema(nil, name)
+		if err != nil {
+			t.Fatalf("%s: MarshalWithSchema failed: %v", name, err)
+		}
+		if encoded == nil || len(encoded) != 0 {
+			t.Errorf("%s: expected empty non-nil bytes, got %#v", name, encoded)
+		}
+	}
+
+	decoded, err := proto.UnmarshalWithSchema([]byte{}, "heartbeat.Ping")
+	if err != nil {
+		t.Fatalf("UnmarshalWithSchema failed: %v", err)
+	}
+	if expected := map[string]interface{}{"id": ""}; !reflect.DeepEqual(decoded, expected) {
+		t.Errorf("Expected %v, got %v", expected, decoded)
+	}
+}
+
+func TestUnmarshalToStruct_EnumJSONName(t *testing.T) {
+	protoContent := `
+syntax = "proto3";
+
+package example;
+
+enum Tier {
+    TIER_FREE = 0;
+    TIER_PRO = 1 [json_name = "pro"];
+}
+
+message Plan {
+    Tier tier = 1;
+}
+`
+	proto := NewProtolite([]string{""})
+	if err := proto.LoadSchemaFromReader(strings.NewReader(protoContent), "plan.proto"); err != nil {
+		t.Fatalf("Failed to load schema from reader: %v", err)
+	}
+
+	encoded, err := proto.MarshalWithSchema(map[string]interface{}{"tier": "TIER_PRO"}, "example.Plan")
+	if err != nil {
+		t.Fatalf("MarshalWithSchema failed: %v", err)
+	}
+	// the decoder emits the json_name of the value, which must map back to its number
+	decoded, err := proto.UnmarshalWithSchema(encoded, "example.Plan")
+	if err != nil {
+		t.Fatalf("UnmarshalWithSchema failed: %v", err)
+	}
+	if decoded["tier"] != "pro" {
+		t.Fatalf("Expected tier to decode as pro, got %v", decoded["tier"])
+	}
+
+	type Plan struct {
+		Tier int32
+	}
+	var plan Plan
+	if err := proto.UnmarshalToStruct(encoded, "example.Plan", &plan); err != nil {
+		t.Fatalf("UnmarshalToStruct failed: %v", err)
+	}
+	if plan.Tier != 1 {
+		t.Errorf("Expected tier 1, got %d", plan.Tier)
+	}
+}
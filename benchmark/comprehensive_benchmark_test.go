@@ -22,6 +22,9 @@ var (
 	simplePayload    []byte
 	simpleDescriptor protoreflect.MessageDescriptor
 
+	// Flat payload (a message of singular primitive fields only)
+	flatPayload []byte
+
 	// Complex payload (nested, maps, repeated fields)
 	complexPayload    []byte
 	complexDescriptor protoreflect.MessageDescriptor
@@ -66,6 +69,16 @@ func setupBenchmarkData() {
 		panic("Failed to create simple payload: " + err.Error())
 	}
 
+	// Create flat payload (singular primitive fields only)
+	flatPayload, err = proto.Marshal(&pb.SocialMedia{
+		Platform:   "twitter",
+		Username:   "johndoe",
+		ProfileUrl: "https://twitter.com/johndoe",
+	})
+	if err != nil {
+		panic("Failed to create flat payload: " + err.Error())
+	}
+
 	// Create complex payload (full featured)
 	complexUser := createComplexUser()
 	complexPayload, err = proto.Marshal(complexUser)
@@ -267,6 +280,35 @@ func BenchmarkSimple_DynamicPB_RuntimeDesc(b *testing.B) {
 	}
 }
 
+// ===== FLAT PAYLOAD BENCHMARKS =====
+
+func BenchmarkFlat_Protolite(b *testing.B) {
+	b.ReportMetric(float64(len(flatPayload)), "payload_bytes")
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		result, err := protoliteClient.UnmarshalWithSchema(flatPayload, "benchmark.SocialMedia")
+		if err != nil {
+			b.Fatal(err)
+		}
+		_ = result
+	}
+}
+
+func BenchmarkFlat_Protoc(b *testing.B) {
+	b.ReportMetric(float64(len(flatPayload)), "payload_bytes")
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		social := &pb.SocialMedia{}
+		err := proto.Unmarshal(flatPayload, social)
+		if err != nil {
+			b.Fatal(err)
+		}
+		_ = social
+	}
+}
+
 // ===== COMPLEX PAYLOAD BENCHMARKS =====
 
 func BenchmarkComplex_Protolite(b *testing.B) {
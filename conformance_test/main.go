@@ -354,6 +354,11 @@ func (h *Harness) normalizeForJSON(v interface{}, parentKey string) (interface{}
                         // Normalize inner and merge its fields
                         norm, err := h.normalizeForJSON(inner, "")
                         if err == nil {
+                            // An Any payload expands to its own {"@type": ...} object
+                            if typeName == "google.protobuf.Any" {
+                                at["value"] = norm
+                                return at, nil
+                            }
                             if mm, ok := norm.(map[string]interface{}); ok {
                                 for k, v := range mm { at[k] = v }
                                 return at, nil
@@ -0,0 +1,97 @@
+package protolite
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// DescribeValue renders a decoded value on a single line with its Go type, e.g.
+// int32(7), string("x"), []byte{0a 0b} or map[int32]{1: string("a")}.
+// Map entries are sorted by key so the output is stable.
+func DescribeValue(v interface{}) string {
+	var b strings.Builder
+	describe(&b, v, "", false)
+	return b.String()
+}
+
+// Dump renders a decoded message one field per line, sorted by field name, with
+// nested messages, maps and lists indented beneath their field.
+func Dump(m map[string]interface{}) string {
+	var b strings.Builder
+	describe(&b, m, "", true)
+	return b.String()
+}
+
+// describe writes v to b; with multiline set, each map entry and list element
+// goes on its own line, indented one level deeper than indent.
+func describe(b *strings.Builder, v interface{}, indent string, multiline bool) {
+	if v == nil {
+		b.WriteString("nil")
+		return
+	}
+	switch val := v.(type) {
+	case string:
+		fmt.Fprintf(b, "string(%q)", val)
+		return
+	case []byte:
+		fmt.Fprintf(b, "[]byte{% x}", val)
+		return
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Map:
+		keys := rv.MapKeys()
+		sort.Slice(keys, func(i, j int) bool {
+			return fmt.Sprint(keys[i].Interface()) < fmt.Sprint(keys[j].Interface())
+		})
+		if _, ok := v.(map[string]interface{}); !ok {
+			fmt.Fprintf(b, "map[%s]", rv.Type().Key())
+		}
+		entries := make([]string, len(keys))
+		for i, key := range keys {
+			entries[i] = fmt.Sprint(key.Interface())
+		}
+		writeEntries(b, indent, multiline, len(keys), func(i int, inner string) {
+			b.WriteString(entries[i])
+			b.WriteString(": ")
+			describe(b, rv.MapIndex(keys[i]).Interface(), inner, multiline)
+		})
+	case reflect.Slice, reflect.Array:
+		if _, ok := v.([]interface{}); !ok {
+			b.WriteString(rv.Type().String())
+		}
+		writeEntries(b, indent, multiline, rv.Len(), func(i int, inner string) {
+			describe(b, rv.Index(i).Interface(), inner, multiline)
+		})
+	default:
+		fmt.Fprintf(b, "%T(%v)", v, v)
+	}
+}
+
+// writeEntries wraps n entries written by entry in braces, either inline and
+// comma separated or one per line.
+func writeEntries(b *strings.Builder, indent string, multiline bool, n int, entry func(i int, inner string)) {
+	if n == 0 {
+		b.WriteString("{}")
+		return
+	}
+	inner := indent + "  "
+	b.WriteString("{")
+	for i := 0; i < n; i++ {
+		if multiline {
+			b.WriteString("\n")
+			b.WriteString(inner)
+		} else if i > 0 {
+			b.WriteString(", ")
+		}
+		entry(i, inner)
+	}
+	if multiline {
+		b.WriteString("\n")
+		b.WriteString(indent)
+	}
+	b.WriteString("}")
+}
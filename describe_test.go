@@ -0,0 +1,56 @@
+package protolite
+
+import "testing"
+
+func TestDescribeValue(t *testing.T) {
+	tests := []struct {
+		name  string
+		value interface{}
+		want  string
+	}{
+		{"nil", nil, "nil"},
+		{"int32", int32(7), "int32(7)"},
+		{"uint64", uint64(1) << 63, "uint64(9223372036854775808)"},
+		{"string", "a\"b", `string("a\"b")`},
+		{"bytes", []byte{0x0a, 0xff}, "[]byte{0a ff}"},
+		{"list", []interface{}{int64(1), "x"}, `{int64(1), string("x")}`},
+		{"typed_list", []int32{1, 2}, "[]int32{int32(1), int32(2)}"},
+		{"typed_map", map[int32]interface{}{2: "b", 1: "a"}, `map[int32]{1: string("a"), 2: string("b")}`},
+		{"empty_message", map[string]interface{}{}, "{}"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DescribeValue(tt.value); got != tt.want {
+				t.Errorf("DescribeValue(%#v) = %s, want %s", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDump(t *testing.T) {
+	got := Dump(map[string]interface{}{
+		"name":   "alice",
+		"id":     int32(1),
+		"avatar": []byte{1, 2},
+		"tags":   []interface{}{"a"},
+		"address": map[string]interface{}{
+			"city": "Paris",
+		},
+		"scores": map[string]interface{}{},
+	})
+	want := `{
+  address: {
+    city: string("Paris")
+  }
+  avatar: []byte{01 02}
+  id: int32(1)
+  name: string("alice")
+  scores: {}
+  tags: {
+    string("a")
+  }
+}`
+	if got != want {
+		t.Errorf("Dump mismatch\ngot:\n%s\nwant:\n%s", got, want)
+	}
+}
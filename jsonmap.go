@@ -0,0 +1,415 @@
+package protolite
+
+import (
+	"encoding/base64"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/anirudhraja/protolite/schema"
+	"github.com/anirudhraja/protolite/wire"
+)
+
+// Well-known types that have a dedicated JSON representation.
+const (
+	wktTimestamp = "google.protobuf.Timestamp"
+	wktDuration  = "google.protobuf.Duration"
+	wktFieldMask = "google.protobuf.FieldMask"
+	wktStruct    = "google.protobuf.Struct"
+	wktValue     = "google.protobuf.Value"
+	wktListValue = "google.protobuf.ListValue"
+	wktAny       = "google.protobuf.Any"
+)
+
+// maxAnyDepth bounds how many Any-within-Any layers are expanded, matching the
+// default recursion limit of the protobuf runtimes.
+const maxAnyDepth = 100
+
+// JSONOption adjusts a single UnmarshalToJSONMap or Transcode call, so callers
+// that need different JSON forms can share one Protolite.
+type JSONOption func(*jsonOptions)
+
+// jsonOptions holds the per-call settings of a JSON conversion
+type jsonOptions struct {
+	integers wire.JSONIntegerStyle
+}
+
+// JSONIntegers selects whether integers are written as JSON numbers or strings
+// for this call. It overrides wire.Config.JSONIntegers.
+func JSONIntegers(style wire.JSONIntegerStyle) JSONOption {
+	return func(o *jsonOptions) {
+		o.integers = style
+	}
+}
+
+// newJSONOptions applies opts to the defaults taken from the global wire.Config
+func newJSONOptions(opts []JSONOption) jsonOptions {
+	o := jsonOptions{integers: wire.GetConfig().JSONIntegers}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// UnmarshalToJSONMap unmarshals data into a map that can be handed to encoding/json as is
+func (p *protolite) UnmarshalToJSONMap(data []byte, messageName string, opts ...JSONOption) (map[string]interface{}, error) {
+	o := newJSONOptions(opts)
+	message, err := p.registry.GetMessage(messageName)
+	if err != nil {
+		return nil, fmt.Errorf("message schema not found: %v", err)
+	}
+
+	decodedMessage, err := wire.DecodeMessage(data, message, p.registry)
+	if err != nil {
+		return nil, fmt.Errorf("decoding failed: %w", err)
+	}
+	decoded, ok := decodedMessage.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("decoded message is not a map, got %T", decodedMessage)
+	}
+
+	if flat, ok := p.flattenStruct(decoded, message, o); ok {
+		return flat, nil
+	}
+	return p.jsonMessage(decoded, message, o)
+}
+
+// flattenStruct converts a decoded top-level google.protobuf.Struct, or a Value
+// holding a struct_value, into the plain map such a nested field converts to.
+// Any other Value is not a JSON object and is left alone.
+func (p *protolite) flattenStruct(decoded map[string]interface{}, msg *schema.Message, o jsonOptions) (map[string]interface{}, bool) {
+	for _, name := range []string{wktStruct, wktValue} {
+		// compare the short name first, the registry lookup of a missing
+		// well-known type builds an error on every decode
+		if !strings.HasSuffix(name, "."+msg.Name) {
+			continue
+		}
+		if wkt, err := p.registry.GetMessage(name); err != nil || wkt != msg {
+			continue
+		}
+		flat, ok := structToJSON(decoded, o).(map[string]interface{})
+		return flat, ok
+	}
+	return nil, false
+}
+
+// jsonMessage converts the fields of a decoded message to their JSON-safe form
+func (p *protolite) jsonMessage(decoded map[string]interface{}, msg *schema.Message, o jsonOptions) (map[string]interface{}, error) {
+	out := make(map[string]interface{}, len(decoded))
+	for key, value := range decoded {
+		field := fieldByDecodedName(msg, key)
+		if field == nil {
+			// e.g. __typename of union wrappers
+			out[key] = jsonScalar(value, o)
+			continue
+		}
+		converted, err := p.jsonField(value, field, o)
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %w", key, err)
+		}
+		out[key] = converted
+	}
+	return out, nil
+}
+
+// jsonField converts a decoded field value, descending into repeated and map values
+func (p *protolite) jsonField(value interface{}, field *schema.Field, o jsonOptions) (interface{}, error) {
+	if value == nil {
+		return nil, nil
+	}
+	rv := reflect.ValueOf(value)
+	switch {
+	case field.Type.Kind == schema.KindMap && rv.Kind() == reflect.Map:
+		out := make(map[string]interface{}, rv.Len())
+		iter := rv.MapRange()
+		for iter.Next() {
+			converted, err := p.jsonType(iter.Value().Interface(), field.Type.MapValue, o)
+			if err != nil {
+				return nil, err
+			}
+			out[fmt.Sprint(iter.Key().Interface())] = converted
+		}
+		return out, nil
+	case field.Label == schema.LabelRepeated && rv.Kind() == reflect.Slice && rv.Type().Elem().Kind() != reflect.Uint8:
+		out := make([]interface{}, rv.Len())
+		for i := range out {
+			converted, err := p.jsonType(rv.Index(i).Interface(), &field.Type, o)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = converted
+		}
+		return out, nil
+	default:
+		return p.jsonType(value, &field.Type, o)
+	}
+}
+
+// jsonType converts a single decoded value of the given type
+func (p *protolite) jsonType(value interface{}, t *schema.FieldType, o jsonOptions) (interface{}, error) {
+	if t.Kind == schema.KindEnum && t.EnumType == schema.NullValueEnumName {
+		return nil, nil
+	}
+	if t.Kind == schema.KindWrapper {
+		return jsonScalar(wrappedValue(value), o), nil
+	}
+	if t.Kind != schema.KindMessage {
+		return jsonScalar(value, o), nil
+	}
+	nested, ok := value.(map[string]interface{})
+	if !ok {
+		// raw bytes of an unregistered message type
+		return jsonScalar(value, o), nil
+	}
+	switch t.MessageType {
+	case wktTimestamp:
+		sec, ns := secondsNanos(nested)
+		return formatTimestamp(sec, ns), nil
+	case wktDuration:
+		sec, ns := secondsNanos(nested)
+		return formatDuration(sec, ns), nil
+	case wktFieldMask:
+		paths, _ := nested["paths"].([]interface{})
+		camel := make([]string, 0, len(paths))
+		for _, path := range paths {
+			camel = append(camel, lowerCamelPath(fmt.Sprint(path)))
+		}
+		return strings.Join(camel, ","), nil
+	case wktStruct, wktValue, wktListValue:
+		return structToJSON(nested, o), nil
+	case wktAny:
+		return p.jsonAny(nested, 0, o)
+	}
+	msg, err := p.registry.GetMessage(t.MessageType)
+	if err != nil {
+		return nil, err
+	}
+	return p.jsonMessage(nested, msg, o)
+}
+
+// jsonAny expands an Any whose payload type is registered, keeping base64 otherwise.
+// A payload that is itself an Any is expanded too, up to maxAnyDepth layers.
+func (p *protolite) jsonAny(any map[string]interface{}, depth int, o jsonOptions) (interface{}, error) {
+	if depth >= maxAnyDepth {
+		return nil, fmt.Errorf("Any nested more than %d levels deep", maxAnyDepth)
+	}
+	typeURL, _ := any["type_url"].(string)
+	out := map[string]interface{}{"@type": typeURL}
+	payload, _ := any["value"].([]byte)
+	typeName := typeURL[strings.LastIndex(typeURL, "/")+1:]
+	if typeName == "" {
+		out["value"] = base64.StdEncoding.EncodeToString(payload)
+		return out, nil
+	}
+	msg, err := p.registry.GetMessage(typeName)
+	if err != nil {
+		out["value"] = base64.StdEncoding.EncodeToString(payload)
+		return out, nil
+	}
+	decoded, err := wire.DecodeMessage(payload, msg, p.registry)
+	if err != nil {
+		return nil, err
+	}
+	var converted interface{}
+	if inner, ok := decoded.(map[string]interface{}); ok && typeName == wktAny {
+		converted, err = p.jsonAny(inner, depth+1, o)
+	} else {
+		converted, err = p.jsonType(decoded, &schema.FieldType{Kind: schema.KindMessage, MessageType: typeName}, o)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if fields, ok := converted.(map[string]interface{}); ok && !hasJSONMapping(typeName) {
+		for k, v := range fields {
+			out[k] = v
+		}
+	} else {
+		// well-known payloads are carried under "value"
+		out["value"] = converted
+	}
+	return out, nil
+}
+
+// hasJSONMapping reports whether typeName is a well-known type with its own JSON form
+func hasJSONMapping(typeName string) bool {
+	switch typeName {
+	case wktTimestamp, wktDuration, wktFieldMask, wktStruct, wktValue, wktListValue, wktAny:
+		return true
+	}
+	return false
+}
+
+// jsonScalar makes a scalar safe for encoding/json: 64-bit integers become
+// strings, bytes become base64 and non-finite floats become their names.
+// The JSONIntegers option can turn every integer into a number or a string.
+func jsonScalar(value interface{}, o jsonOptions) interface{} {
+	style := o.integers
+	switch v := value.(type) {
+	case int32:
+		if style == wire.JSONIntegersAsStrings {
+			return strconv.FormatInt(int64(v), 10)
+		}
+		return value
+	case uint32:
+		if style == wire.JSONIntegersAsStrings {
+			return strconv.FormatUint(uint64(v), 10)
+		}
+		return value
+	case int64:
+		if style == wire.JSONIntegersAsNumbers {
+			return value
+		}
+		return strconv.FormatInt(v, 10)
+	case uint64:
+		if style == wire.JSONIntegersAsNumbers {
+			return value
+		}
+		return strconv.FormatUint(v, 10)
+	case []byte:
+		return base64.StdEncoding.EncodeToString(v)
+	case float32:
+		return jsonFloat(float64(v), value)
+	case float64:
+		return jsonFloat(v, value)
+	default:
+		return value
+	}
+}
+
+func jsonFloat(f float64, original interface{}) interface{} {
+	switch {
+	case math.IsNaN(f):
+		return "NaN"
+	case math.IsInf(f, 1):
+		return "Infinity"
+	case math.IsInf(f, -1):
+		return "-Infinity"
+	default:
+		return original
+	}
+}
+
+// structToJSON flattens google.protobuf.Struct/Value/ListValue into plain JSON values
+func structToJSON(v interface{}, o jsonOptions) interface{} {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return jsonScalar(v, o)
+	}
+	if fields, ok := m["fields"].(map[string]interface{}); ok {
+		out := make(map[string]interface{}, len(fields))
+		for k, vv := range fields {
+			out[k] = structToJSON(vv, o)
+		}
+		return out
+	}
+	if values, ok := m["values"].([]interface{}); ok {
+		out := make([]interface{}, len(values))
+		for i := range values {
+			out[i] = structToJSON(values[i], o)
+		}
+		return out
+	}
+	if _, ok := m["null_value"]; ok {
+		return nil
+	}
+	for _, key := range []string{"number_value", "string_value", "bool_value"} {
+		if vv, ok := m[key]; ok {
+			return jsonScalar(vv, o)
+		}
+	}
+	for _, key := range []string{"struct_value", "list_value"} {
+		if vv, ok := m[key]; ok {
+			return structToJSON(vv, o)
+		}
+	}
+	// empty Struct
+	return map[string]interface{}{}
+}
+
+// fieldByDecodedName finds the field the decoder stored under key
+func fieldByDecodedName(msg *schema.Message, key string) *schema.Field {
+	// the key depends on wire.Config.DecodeFieldNames, so accept every spelling
+	match := func(f *schema.Field) bool {
+		return f.Name == key || f.JsonName == key || lowerCamelPath(f.Name) == key
+	}
+	for _, f := range msg.Fields {
+		if match(f) {
+			return f
+		}
+	}
+	for _, oneof := range msg.OneofGroups {
+		for _, f := range oneof.Fields {
+			if match(f) {
+				return f
+			}
+		}
+	}
+	for _, f := range msg.Extensions {
+		if match(f) {
+			return f
+		}
+	}
+	return nil
+}
+
+func secondsNanos(m map[string]interface{}) (int64, int32) {
+	sec, _ := m["seconds"].(int64)
+	ns, _ := m["nanos"].(int32)
+	return sec, ns
+}
+
+// formatTimestamp renders a Timestamp in RFC 3339 form, in UTC with the
+// fraction written as by formatNanos
+func formatTimestamp(sec int64, ns int32) string {
+	t := time.Unix(sec, int64(ns)).UTC()
+	return t.Format("2006-01-02T15:04:05") + formatNanos(int32(t.Nanosecond())) + "Z"
+}
+
+// formatDuration renders a Duration as "<seconds>[.<fraction>]s", the fraction
+// written as by formatNanos
+func formatDuration(sec int64, ns int32) string {
+	sign := ""
+	if sec < 0 || ns < 0 {
+		sign = "-"
+		if sec < 0 {
+			sec = -sec
+		}
+		if ns < 0 {
+			ns = -ns
+		}
+	}
+	return fmt.Sprintf("%s%d%ss", sign, sec, formatNanos(ns))
+}
+
+// formatNanos renders ns as a fraction of 3, 6 or 9 digits, the fewest that
+// hold it, as proto3 JSON does. Zero is left out.
+func formatNanos(ns int32) string {
+	switch {
+	case ns == 0:
+		return ""
+	case ns%1000000 == 0:
+		return fmt.Sprintf(".%03d", ns/1000000)
+	case ns%1000 == 0:
+		return fmt.Sprintf(".%06d", ns/1000)
+	default:
+		return fmt.Sprintf(".%09d", ns)
+	}
+}
+
+// lowerCamelPath converts each segment of a snake_case field path to lowerCamelCase
+func lowerCamelPath(path string) string {
+	segments := strings.Split(path, ".")
+	for i, segment := range segments {
+		parts := strings.Split(segment, "_")
+		for j := 1; j < len(parts); j++ {
+			if parts[j] != "" {
+				parts[j] = strings.ToUpper(parts[j][:1]) + parts[j][1:]
+			}
+		}
+		segments[i] = strings.Join(parts, "")
+	}
+	return strings.Join(segments, ".")
+}
@@ -0,0 +1,452 @@
+package protolite
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/anirudhraja/protolite/wire"
+)
+
+func TestUnmarshalToJSONMap(t *testing.T) {
+	protoContent := `
+syntax = "proto3";
+
+package example;
+
+import "google/protobuf/timestamp.proto";
+import "google/protobuf/duration.proto";
+import "google/protobuf/field_mask.proto";
+import "google/protobuf/struct.proto";
+
+enum State {
+    STATE_UNKNOWN = 0;
+    STATE_ACTIVE = 1;
+}
+
+message Item {
+    uint64 id = 1;
+}
+
+message Event {
+    int64 big = 1;
+    State state = 2;
+    google.protobuf.Timestamp created_at = 3;
+    google.protobuf.Duration ttl = 4;
+    google.protobuf.FieldMask mask = 5;
+    google.protobuf.Struct attrs = 6;
+    map<int32, Item> items = 7;
+    repeated int64 counts = 8;
+    bytes blob = 9;
+    double ratio = 10;
+}
+`
+	proto := NewProtolite([]string{"conformance_test/protos"})
+	if err := proto.LoadSchemaFromReader(strings.NewReader(protoContent), "event.proto"); err != nil {
+		t.Fatalf("Failed to load schema from reader: %v", err)
+	}
+
+	data := map[string]interface{}{
+		"big":        int64(9007199254740993),
+		"state":      "STATE_ACTIVE",
+		"created_at": map[string]interface{}{"seconds": int64(1700000000), "nanos": int32(500000000)},
+		"ttl":        map[string]interface{}{"seconds": int64(90), "nanos": int32(0)},
+		"mask":       map[string]interface{}{"paths": []interface{}{"user_name", "address.zip_code"}},
+		"attrs": map[string]interface{}{
+			"fields": map[string]interface{}{
+				"name": map[string]interface{}{"string_value": "x"},
+				"tags": map[string]interface{}{"list_value": map[string]interface{}{
+					"values": []interface{}{map[string]interface{}{"bool_value": true}},
+				}},
+			},
+		},
+		"items":  map[int32]interface{}{int32(7): map[string]interface{}{"id": uint64(42)}},
+		"counts": []interface{}{int64(1), int64(2)},
+		"blob":   []byte("hi"),
+		"ratio":  math.Inf(1),
+	}
+	encoded, err := proto.MarshalWithSchema(data, "Event")
+	if err != nil {
+		t.Fatalf("MarshalWithSchema failed: %v", err)
+	}
+
+	result, err := proto.UnmarshalToJSONMap(encoded, "Event")
+	if err != nil {
+		t.Fatalf("UnmarshalToJSONMap failed: %v", err)
+	}
+	expected := map[string]interface{}{
+		"big":        "9007199254740993",
+		"state":      "STATE_ACTIVE",
+		"created_at": "2023-11-14T22:13:20.500Z",
+		"ttl":        "90s",
+		"mask":       "userName,address.zipCode",
+		"attrs": map[string]interface{}{
+			"name": "x",
+			"tags": []interface{}{true},
+		},
+		"items":  map[string]interface{}{"7": map[string]interface{}{"id": "42"}},
+		"counts": []interface{}{"1", "2"},
+		"blob":   "aGk=",
+		"ratio":  "Infinity",
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Unexpected result:\nexpected %v\ngot      %v", expected, result)
+	}
+	if _, err := json.Marshal(result); err != nil {
+		t.Errorf("Result must be JSON-marshalable: %v", err)
+	}
+}
+
+func TestUnmarshalToJSONMap_NestedAny(t *testing.T) {
+	protoContent := `
+syntax = "proto3";
+
+package example;
+
+import "google/protobuf/any.proto";
+
+message Item {
+    uint64 id = 1;
+}
+
+message Envelope {
+    google.protobuf.Any payload = 1;
+}
+`
+	proto := NewProtolite([]string{"conformance_test/protos"})
+	if err := proto.LoadSchemaFromReader(strings.NewReader(protoContent), "envelope.proto"); err != nil {
+		t.Fatalf("Failed to load schema from reader: %v", err)
+	}
+
+	item, err := proto.MarshalWithSchema(map[string]interface{}{"id": uint64(42)}, "example.Item")
+	if err != nil {
+		t.Fatalf("MarshalWithSchema failed: %v", err)
+	}
+	inner, err := proto.MarshalWithSchema(map[string]interface{}{
+		"type_url": "type.googleapis.com/example.Item",
+		"value":    item,
+	}, "google.protobuf.Any")
+	if err != nil {
+		t.Fatalf("MarshalWithSchema failed: %v", err)
+	}
+	encoded, err := proto.MarshalWithSchema(map[string]interface{}{
+		"payload": map[string]interface{}{
+			"type_url": "type.googleapis.com/google.protobuf.Any",
+			"value":    inner,
+		},
+	}, "example.Envelope")
+	if err != nil {
+		t.Fatalf("MarshalWithSchema failed: %v", err)
+	}
+
+	result, err := proto.UnmarshalToJSONMap(encoded, "example.Envelope")
+	if err != nil {
+		t.Fatalf("UnmarshalToJSONMap failed: %v", err)
+	}
+	expected := map[string]interface{}{
+		"payload": map[string]interface{}{
+			"@type": "type.googleapis.com/google.protobuf.Any",
+			"value": map[string]interface{}{
+				"@type": "type.googleapis.com/example.Item",
+				"id":    "42",
+			},
+		},
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Nested Any mismatch\ngot:  %#v\nwant: %#v", result, expected)
+	}
+}
+
+func TestUnmarshalToJSONMap_NullValue(t *testing.T) {
+	protoContent := `
+syntax = "proto3";
+
+package example;
+
+message Slot {
+    repeated google.protobuf.NullValue nulls = 1;
+}
+`
+	proto := NewProtolite([]string{""})
+	if err := proto.LoadSchemaFromReader(strings.NewReader(protoContent), "slot.proto"); err != nil {
+		t.Fatalf("Failed to load schema from reader: %v", err)
+	}
+
+	encoded, err := proto.MarshalWithSchema(map[string]interface{}{"nulls": []interface{}{"NULL_VALUE"}}, "example.Slot")
+	if err != nil {
+		t.Fatalf("MarshalWithSchema failed: %v", err)
+	}
+	result, err := proto.UnmarshalToJSONMap(encoded, "example.Slot")
+	if err != nil {
+		t.Fatalf("UnmarshalToJSONMap failed: %v", err)
+	}
+	if !reflect.DeepEqual(result["nulls"], []interface{}{nil}) {
+		t.Errorf("Expected NullValue to map to JSON null, got %#v", result["nulls"])
+	}
+}
+
+func TestUnmarshalToJSONMap_MessageMapValues(t *testing.T) {
+	protoContent := `
+syntax = "proto3";
+
+package example;
+
+import "google/protobuf/timestamp.proto";
+
+message UserProfile {
+    string name = 1;
+    int64 id = 2;
+    map<string, UserProfile> friends = 3;
+}
+
+message Account {
+    message Setting {
+        string value = 1;
+    }
+    map<string, UserProfile> profiles = 1;
+    map<string, Setting> settings = 2;
+    map<string, google.protobuf.Timestamp> last_seen = 3;
+}
+`
+	proto := NewProtolite([]string{"conformance_test/protos"})
+	if err := proto.LoadSchemaFromReader(strings.NewReader(protoContent), "account.proto"); err != nil {
+		t.Fatalf("Failed to load schema from reader: %v", err)
+	}
+
+	encoded, err := proto.MarshalWithSchema(map[string]interface{}{
+		"profiles": map[string]interface{}{
+			"ann": map[string]interface{}{
+				"name": "Ann",
+				"id":   int64(5),
+				"friends": map[string]interface{}{
+					"bob": map[string]interface{}{"name": "Bob", "id": int64(6)},
+				},
+			},
+		},
+		"settings":  map[string]interface{}{"theme": map[string]interface{}{"value": "dark"}},
+		"last_seen": map[string]interface{}{"web": map[string]interface{}{"seconds": int64(1700000000)}},
+	}, "example.Account")
+	if err != nil {
+		t.Fatalf("MarshalWithSchema failed: %v", err)
+	}
+	result, err := proto.UnmarshalToJSONMap(encoded, "example.Account")
+	if err != nil {
+		t.Fatalf("UnmarshalToJSONMap failed: %v", err)
+	}
+
+	expected := map[string]interface{}{
+		"profiles": map[string]interface{}{
+			"ann": map[string]interface{}{
+				"name": "Ann",
+				"id":   "5",
+				"friends": map[string]interface{}{
+					"bob": map[string]interface{}{"name": "Bob", "id": "6"},
+				},
+			},
+		},
+		"settings":  map[string]interface{}{"theme": map[string]interface{}{"value": "dark"}},
+		"last_seen": map[string]interface{}{"web": "2023-11-14T22:13:20Z"},
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Unexpected result:\nexpected %v\ngot      %v", expected, result)
+	}
+	out, err := json.Marshal(result["profiles"])
+	if err != nil {
+		t.Fatalf("Result must be JSON-marshalable: %v", err)
+	}
+	if want := `{"ann":{"friends":{"bob":{"id":"6","name":"Bob"}},"id":"5","name":"Ann"}}`; string(out) != want {
+		t.Errorf("Expected profiles to render as nested objects %s, got %s", want, out)
+	}
+}
+
+func TestUnmarshalToJSONMap_IntegerStyle(t *testing.T) {
+	protoContent := `
+syntax = "proto3";
+
+package example;
+
+enum Level {
+    LEVEL_LOW = 0;
+}
+
+message Counter {
+    int32 small = 1;
+    uint32 usmall = 2;
+    int64 big = 3;
+    uint64 ubig = 4;
+    repeated sint32 deltas = 5;
+    Level level = 6;
+    double ratio = 7;
+}
+`
+	proto := NewProtolite([]string{""})
+	if err := proto.LoadSchemaFromReader(strings.NewReader(protoContent), "counter.proto"); err != nil {
+		t.Fatalf("Failed to load schema from reader: %v", err)
+	}
+	encoded, err := proto.MarshalWithSchema(map[string]interface{}{
+		"small":  int32(-7),
+		"usmall": uint32(7),
+		"big":    int64(9007199254740993),
+		"ubig":   uint64(math.MaxUint64),
+		"deltas": []int32{1, -1},
+		"level":  int32(3),
+		"ratio":  1.5,
+	}, "example.Counter")
+	if err != nil {
+		t.Fatalf("MarshalWithSchema failed: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		style    wire.JSONIntegerStyle
+		expected string
+	}{
+		{
+			name:     "proto3",
+			style:    wire.JSONIntegersProto3,
+			expected: `{"big":"9007199254740993","deltas":[1,-1],"level":"3","ratio":1.5,"small":-7,"ubig":"18446744073709551615","usmall":7}`,
+		},
+		{
+			name:     "numbers",
+			style:    wire.JSONIntegersAsNumbers,
+			expected: `{"big":9007199254740993,"deltas":[1,-1],"level":"3","ratio":1.5,"small":-7,"ubig":18446744073709551615,"usmall":7}`,
+		},
+		{
+			name:     "strings",
+			style:    wire.JSONIntegersAsStrings,
+			expected: `{"big":"9007199254740993","deltas":["1","-1"],"level":"3","ratio":1.5,"small":"-7","ubig":"18446744073709551615","usmall":"7"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the styles share one Protolite and run side by side
+			t.Parallel()
+
+			out, err := proto.Transcode(encoded, "example.Counter", FormatProtobuf, FormatJSON, JSONIntegers(tt.style))
+			if err != nil {
+				t.Fatalf("Transcode failed: %v", err)
+			}
+			if string(out) != tt.expected {
+				t.Errorf("Unexpected JSON:\nexpected %s\ngot      %s", tt.expected, out)
+			}
+			back, err := proto.Transcode(out, "example.Counter", FormatJSON, FormatProtobuf)
+			if err != nil {
+				t.Fatalf("Transcode back failed: %v", err)
+			}
+			if !bytes.Equal(back, encoded) {
+				t.Errorf("Expected the JSON to transcode back to the original bytes:\nexpected % x\ngot      % x", encoded, back)
+			}
+		})
+	}
+}
+
+func TestUnmarshalToJSONMap_IntegerStyleDefault(t *testing.T) {
+	protoContent := `
+syntax = "proto3";
+
+package example;
+
+message Counter {
+    int64 big = 1;
+}
+`
+	proto := NewProtolite([]string{""})
+	if err := proto.LoadSchemaFromReader(strings.NewReader(protoContent), "counter.proto"); err != nil {
+		t.Fatalf("Failed to load schema from reader: %v", err)
+	}
+	encoded, err := proto.MarshalWithSchema(map[string]interface{}{"big": int64(42)}, "example.Counter")
+	if err != nil {
+		t.Fatalf("MarshalWithSchema failed: %v", err)
+	}
+
+	prev := wire.GetConfig()
+	defer wire.SetConfig(prev)
+	cfg := prev
+	cfg.JSONIntegers = wire.JSONIntegersAsNumbers
+	wire.SetConfig(cfg)
+
+	result, err := proto.UnmarshalToJSONMap(encoded, "example.Counter")
+	if err != nil {
+		t.Fatalf("UnmarshalToJSONMap failed: %v", err)
+	}
+	if result["big"] != int64(42) {
+		t.Errorf("Expected the global style to apply without options, got %#v", result["big"])
+	}
+	result, err = proto.UnmarshalToJSONMap(encoded, "example.Counter", JSONIntegers(wire.JSONIntegersProto3))
+	if err != nil {
+		t.Fatalf("UnmarshalToJSONMap failed: %v", err)
+	}
+	if result["big"] != "42" {
+		t.Errorf("Expected the option to override the global style, got %#v", result["big"])
+	}
+}
+
+func TestUnmarshalToJSONMap_WrappersAsMessages(t *testing.T) {
+	protoContent := `
+syntax = "proto3";
+
+package example;
+
+import "google/protobuf/wrappers.proto";
+
+message Account {
+    google.protobuf.Int64Value balance = 1;
+    google.protobuf.StringValue owner = 2;
+}
+`
+	proto := NewProtolite([]string{""})
+	if err := proto.LoadSchemaFromReader(strings.NewReader(protoContent), "account.proto"); err != nil {
+		t.Fatalf("Failed to load schema from reader: %v", err)
+	}
+	encoded, err := proto.MarshalWithSchema(map[string]interface{}{
+		"balance": int64(42),
+		"owner":   "ann",
+	}, "example.Account")
+	if err != nil {
+		t.Fatalf("MarshalWithSchema failed: %v", err)
+	}
+
+	prev := wire.GetConfig()
+	defer wire.SetConfig(prev)
+	cfg := prev
+	cfg.DecodeWrappersAsMessages = true
+	wire.SetConfig(cfg)
+
+	decoded, err := proto.UnmarshalWithSchema(encoded, "example.Account")
+	if err != nil {
+		t.Fatalf("UnmarshalWithSchema failed: %v", err)
+	}
+	if expected := map[string]interface{}{"value": int64(42)}; !reflect.DeepEqual(decoded["balance"], expected) {
+		t.Errorf("Expected balance %v, got %v", expected, decoded["balance"])
+	}
+
+	var account struct {
+		Balance int64
+		Owner   string
+	}
+	if err := proto.UnmarshalToStruct(encoded, "example.Account", &account); err != nil {
+		t.Fatalf("UnmarshalToStruct failed: %v", err)
+	}
+	if account.Balance != 42 || account.Owner != "ann" {
+		t.Errorf("Unexpected struct: %+v", account)
+	}
+
+	// proto3 JSON and the text format keep their own wrapper shapes
+	jsonMap, err := proto.UnmarshalToJSONMap(encoded, "example.Account")
+	if err != nil {
+		t.Fatalf("UnmarshalToJSONMap failed: %v", err)
+	}
+	if expected := map[string]interface{}{"balance": "42", "owner": "ann"}; !reflect.DeepEqual(jsonMap, expected) {
+		t.Errorf("Expected %v, got %v", expected, jsonMap)
+	}
+	text, err := proto.Transcode(encoded, "example.Account", FormatProtobuf, FormatText)
+	if err != nil {
+		t.Fatalf("Transcode failed: %v", err)
+	}
+	if expected := "balance {\n  value: 42\n}\nowner {\n  value: \"ann\"\n}\n"; string(text) != expected {
+		t.Errorf("Unexpected text:\nexpected:\n%s\ngot:\n%s", expected, text)
+	}
+}
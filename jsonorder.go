@@ -0,0 +1,177 @@
+package protolite
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/anirudhraja/protolite/schema"
+)
+
+// marshalJSONOrdered encodes a map produced by UnmarshalToJSONMap with the keys
+// of each message in field number order. Keys that match no field, e.g.
+// __typename, come last; map entries and Struct fields keep the sorted key
+// order of encoding/json.
+func (p *protolite) marshalJSONOrdered(jsonMap map[string]interface{}, msg *schema.Message) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := p.writeJSONMessage(&buf, jsonMap, msg); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writeJSONMessage writes one JSON object holding the fields of msg
+func (p *protolite) writeJSONMessage(buf *bytes.Buffer, obj map[string]interface{}, msg *schema.Message) error {
+	type entry struct {
+		key   string
+		field *schema.Field
+	}
+	entries := make([]entry, 0, len(obj))
+	for key := range obj {
+		entries = append(entries, entry{key, fieldByDecodedName(msg, key)})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		fi, fj := entries[i].field, entries[j].field
+		switch {
+		case fi != nil && fj != nil && fi.Number != fj.Number:
+			return fi.Number < fj.Number
+		case (fi == nil) != (fj == nil):
+			return fi != nil
+		}
+		return entries[i].key < entries[j].key
+	})
+
+	buf.WriteByte('{')
+	for i, e := range entries {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		if err := writeJSONValue(buf, e.key); err != nil {
+			return err
+		}
+		buf.WriteByte(':')
+		var err error
+		if e.field == nil {
+			err = writeJSONValue(buf, obj[e.key])
+		} else {
+			err = p.writeJSONField(buf, obj[e.key], e.field)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	buf.WriteByte('}')
+	return nil
+}
+
+// writeJSONField writes a field value, descending into repeated and map values
+func (p *protolite) writeJSONField(buf *bytes.Buffer, value interface{}, field *schema.Field) error {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if field.Type.Kind != schema.KindMap {
+			break
+		}
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		buf.WriteByte('{')
+		for i, k := range keys {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := writeJSONValue(buf, k); err != nil {
+				return err
+			}
+			buf.WriteByte(':')
+			if err := p.writeJSONType(buf, v[k], field.Type.MapValue); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+		return nil
+	case []interface{}:
+		if field.Label != schema.LabelRepeated {
+			break
+		}
+		buf.WriteByte('[')
+		for i, element := range v {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := p.writeJSONType(buf, element, &field.Type); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+		return nil
+	}
+	return p.writeJSONType(buf, value, &field.Type)
+}
+
+// writeJSONType writes a single value of the given type; only registered
+// messages and expanded Any payloads have their keys reordered
+func (p *protolite) writeJSONType(buf *bytes.Buffer, value interface{}, t *schema.FieldType) error {
+	obj, ok := value.(map[string]interface{})
+	if !ok || t == nil || t.Kind != schema.KindMessage {
+		return writeJSONValue(buf, value)
+	}
+	if t.MessageType == wktAny {
+		return p.writeJSONAny(buf, obj)
+	}
+	if hasJSONMapping(t.MessageType) {
+		return writeJSONValue(buf, value)
+	}
+	msg, err := p.registry.GetMessage(t.MessageType)
+	if err != nil {
+		return writeJSONValue(buf, value)
+	}
+	return p.writeJSONMessage(buf, obj, msg)
+}
+
+// writeJSONAny writes an expanded Any with "@type" first, followed by the
+// payload fields in field number order
+func (p *protolite) writeJSONAny(buf *bytes.Buffer, obj map[string]interface{}) error {
+	typeURL, _ := obj["@type"].(string)
+	typeName := typeURL[strings.LastIndex(typeURL, "/")+1:]
+	payload := &schema.Message{}
+	if typeName == wktAny {
+		// a nested Any is carried under "value"
+		payload.Fields = []*schema.Field{{Name: "value", Number: 1, Type: schema.FieldType{Kind: schema.KindMessage, MessageType: wktAny}}}
+	} else if msg, err := p.registry.GetMessage(typeName); err == nil && !hasJSONMapping(typeName) {
+		payload = msg
+	}
+	rest := make(map[string]interface{}, len(obj))
+	for k, v := range obj {
+		if k != "@type" {
+			rest[k] = v
+		}
+	}
+	buf.WriteString(`{"@type":`)
+	if err := writeJSONValue(buf, typeURL); err != nil {
+		return err
+	}
+	if len(rest) == 0 {
+		buf.WriteByte('}')
+		return nil
+	}
+	var inner bytes.Buffer
+	if err := p.writeJSONMessage(&inner, rest, payload); err != nil {
+		return err
+	}
+	// splice the payload object after "@type"
+	buf.WriteByte(',')
+	buf.Write(inner.Bytes()[1:])
+	return nil
+}
+
+func writeJSONValue(buf *bytes.Buffer, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	buf.Write(data)
+	return nil
+}
@@ -0,0 +1,194 @@
+package protolite
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+)
+
+// AsStringMap converts a decoded map<string, string> value, e.g.
+// map[string]interface{} or map[interface{}]interface{}, into a map[string]string.
+// A nil value gives a nil map.
+func AsStringMap(v interface{}) (map[string]string, error) {
+	out := map[string]string{}
+	err := eachMapEntry(v, func(key, value interface{}) error {
+		k, err := mapStringKey(key)
+		if err != nil {
+			return err
+		}
+		s, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("value for key %q must be a string, got %T", k, value)
+		}
+		out[k] = s
+		return nil
+	})
+	if err != nil || v == nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// AsStringInt64Map converts a decoded map with string keys and integer values,
+// e.g. map<string, int32> or map<string, int64>, into a map[string]int64.
+func AsStringInt64Map(v interface{}) (map[string]int64, error) {
+	out := map[string]int64{}
+	err := eachMapEntry(v, func(key, value interface{}) error {
+		k, err := mapStringKey(key)
+		if err != nil {
+			return err
+		}
+		n, err := asInt64(value)
+		if err != nil {
+			return fmt.Errorf("value for key %q: %w", k, err)
+		}
+		out[k] = n
+		return nil
+	})
+	if err != nil || v == nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// AsStringFloat64Map converts a decoded map with string keys and numeric
+// values, e.g. map<string, double>, into a map[string]float64.
+func AsStringFloat64Map(v interface{}) (map[string]float64, error) {
+	out := map[string]float64{}
+	err := eachMapEntry(v, func(key, value interface{}) error {
+		k, err := mapStringKey(key)
+		if err != nil {
+			return err
+		}
+		f, err := asFloat64(value)
+		if err != nil {
+			return fmt.Errorf("value for key %q: %w", k, err)
+		}
+		out[k] = f
+		return nil
+	})
+	if err != nil || v == nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// AsStringBoolMap converts a decoded map<string, bool> value into a map[string]bool.
+func AsStringBoolMap(v interface{}) (map[string]bool, error) {
+	out := map[string]bool{}
+	err := eachMapEntry(v, func(key, value interface{}) error {
+		k, err := mapStringKey(key)
+		if err != nil {
+			return err
+		}
+		b, ok := value.(bool)
+		if !ok {
+			return fmt.Errorf("value for key %q must be a bool, got %T", k, value)
+		}
+		out[k] = b
+		return nil
+	})
+	if err != nil || v == nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// AsInt64StringMap converts a decoded map with integer keys and string values,
+// e.g. map<int32, string> or map<int64, string>, into a map[int64]string.
+func AsInt64StringMap(v interface{}) (map[int64]string, error) {
+	out := map[int64]string{}
+	err := eachMapEntry(v, func(key, value interface{}) error {
+		k, err := asInt64(key)
+		if err != nil {
+			return fmt.Errorf("map key: %w", err)
+		}
+		s, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("value for key %d must be a string, got %T", k, value)
+		}
+		out[k] = s
+		return nil
+	})
+	if err != nil || v == nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// eachMapEntry calls fn for every entry of the map v in key order, so the
+// first error reported is stable. A nil v has no entries.
+func eachMapEntry(v interface{}, fn func(key, value interface{}) error) error {
+	if v == nil {
+		return nil
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Map {
+		return fmt.Errorf("expected a map, got %T", v)
+	}
+	keys := rv.MapKeys()
+	sort.Slice(keys, func(i, j int) bool {
+		return fmt.Sprint(keys[i].Interface()) < fmt.Sprint(keys[j].Interface())
+	})
+	for _, key := range keys {
+		if err := fn(key.Interface(), rv.MapIndex(key).Interface()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mapStringKey returns a string map key, including json.Number keys
+func mapStringKey(key interface{}) (string, error) {
+	switch k := key.(type) {
+	case string:
+		return k, nil
+	case json.Number:
+		return k.String(), nil
+	}
+	return "", fmt.Errorf("map key must be a string, got %T", key)
+}
+
+// asInt64 converts any decoded integer to int64
+func asInt64(value interface{}) (int64, error) {
+	switch n := value.(type) {
+	case int32:
+		return int64(n), nil
+	case int64:
+		return n, nil
+	case int:
+		return int64(n), nil
+	case uint32:
+		return int64(n), nil
+	case uint64:
+		if n > math.MaxInt64 {
+			return 0, fmt.Errorf("value %d overflows int64", n)
+		}
+		return int64(n), nil
+	case json.Number:
+		return n.Int64()
+	case string:
+		return strconv.ParseInt(n, 10, 64)
+	}
+	return 0, fmt.Errorf("expected an integer, got %T", value)
+}
+
+// asFloat64 converts any decoded number to float64
+func asFloat64(value interface{}) (float64, error) {
+	switch n := value.(type) {
+	case float64:
+		return n, nil
+	case float32:
+		return float64(n), nil
+	case json.Number:
+		return n.Float64()
+	}
+	i, err := asInt64(value)
+	if err != nil {
+		return 0, fmt.Errorf("expected a number, got %T", value)
+	}
+	return float64(i), nil
+}
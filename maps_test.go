@@ -0,0 +1,110 @@
+package protolite
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTypedMapHelpers_DecodedMaps(t *testing.T) {
+	p := NewProtolite([]string{""})
+	err := p.LoadSchemaFromReader(strings.NewReader(`syntax = "proto3";
+package maps;
+
+message Stats {
+  map<string, string> labels = 1;
+  map<string, int32> counts = 2;
+  map<string, double> ratios = 3;
+  map<string, bool> flags = 4;
+  map<int64, string> names = 5;
+}
+`), "maps.proto")
+	if err != nil {
+		t.Fatalf("LoadSchemaFromReader: %v", err)
+	}
+	data, err := p.MarshalWithSchema(map[string]interface{}{
+		"labels": map[string]interface{}{"team": "core", "tier": "gold"},
+		"counts": map[string]interface{}{"views": int32(12)},
+		"ratios": map[string]interface{}{"ctr": 0.25},
+		"flags":  map[string]interface{}{"beta": true},
+		"names":  map[int64]interface{}{7: "seven"},
+	}, "maps.Stats")
+	if err != nil {
+		t.Fatalf("MarshalWithSchema: %v", err)
+	}
+	decoded, err := p.UnmarshalWithSchema(data, "maps.Stats")
+	if err != nil {
+		t.Fatalf("UnmarshalWithSchema: %v", err)
+	}
+
+	labels, err := AsStringMap(decoded["labels"])
+	if err != nil || !reflect.DeepEqual(labels, map[string]string{"team": "core", "tier": "gold"}) {
+		t.Errorf("AsStringMap = %v, %v", labels, err)
+	}
+	counts, err := AsStringInt64Map(decoded["counts"])
+	if err != nil || !reflect.DeepEqual(counts, map[string]int64{"views": 12}) {
+		t.Errorf("AsStringInt64Map = %v, %v", counts, err)
+	}
+	ratios, err := AsStringFloat64Map(decoded["ratios"])
+	if err != nil || !reflect.DeepEqual(ratios, map[string]float64{"ctr": 0.25}) {
+		t.Errorf("AsStringFloat64Map = %v, %v", ratios, err)
+	}
+	flags, err := AsStringBoolMap(decoded["flags"])
+	if err != nil || !reflect.DeepEqual(flags, map[string]bool{"beta": true}) {
+		t.Errorf("AsStringBoolMap = %v, %v", flags, err)
+	}
+	names, err := AsInt64StringMap(decoded["names"])
+	if err != nil || !reflect.DeepEqual(names, map[int64]string{7: "seven"}) {
+		t.Errorf("AsInt64StringMap = %v, %v", names, err)
+	}
+}
+
+func TestTypedMapHelpers_Conversions(t *testing.T) {
+	t.Run("interface_keys", func(t *testing.T) {
+		got, err := AsStringMap(map[interface{}]interface{}{"a": "x"})
+		if err != nil || !reflect.DeepEqual(got, map[string]string{"a": "x"}) {
+			t.Errorf("AsStringMap = %v, %v", got, err)
+		}
+	})
+	t.Run("json_numbers", func(t *testing.T) {
+		got, err := AsStringInt64Map(map[string]interface{}{"n": json.Number("9007199254740993")})
+		if err != nil || got["n"] != 9007199254740993 {
+			t.Errorf("AsStringInt64Map = %v, %v", got, err)
+		}
+	})
+	t.Run("nil_gives_nil", func(t *testing.T) {
+		got, err := AsStringMap(nil)
+		if err != nil || got != nil {
+			t.Errorf("AsStringMap(nil) = %v, %v", got, err)
+		}
+	})
+
+	errTests := []struct {
+		name    string
+		convert func() error
+		want    string
+	}{
+		{"not_a_map", func() error { _, err := AsStringMap("x"); return err }, "expected a map, got string"},
+		{"wrong_value", func() error {
+			_, err := AsStringMap(map[string]interface{}{"a": "x", "b": int32(1)})
+			return err
+		}, `value for key "b" must be a string, got int32`},
+		{"wrong_key", func() error {
+			_, err := AsStringInt64Map(map[int32]interface{}{1: int32(1)})
+			return err
+		}, "map key must be a string, got int32"},
+		{"uint64_overflow", func() error {
+			_, err := AsStringInt64Map(map[string]interface{}{"big": uint64(1) << 63})
+			return err
+		}, `value for key "big": value 9223372036854775808 overflows int64`},
+	}
+	for _, tt := range errTests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.convert()
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("expected error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
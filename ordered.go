@@ -0,0 +1,139 @@
+package protolite
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/anirudhraja/protolite/schema"
+	"github.com/anirudhraja/protolite/wire"
+)
+
+// OrderedField is one decoded field of an OrderedMessage
+type OrderedField struct {
+	Name  string      // the key UnmarshalWithSchema would use
+	Value interface{} // decoded value; nested messages are OrderedMessage too
+}
+
+// OrderedMessage is a decoded message whose fields follow the .proto
+// declaration order: regular and map fields first, then the members of each
+// oneof, then extensions. Keys that match no field come last, sorted by name.
+type OrderedMessage []OrderedField
+
+// Get returns the value of the field stored under name
+func (m OrderedMessage) Get(name string) (interface{}, bool) {
+	for _, f := range m {
+		if f.Name == name {
+			return f.Value, true
+		}
+	}
+	return nil, false
+}
+
+// UnmarshalOrdered unmarshals data like UnmarshalWithSchema but returns the fields in declaration order
+func (p *protolite) UnmarshalOrdered(data []byte, messageName string) (OrderedMessage, error) {
+	message, err := p.registry.GetMessage(messageName)
+	if err != nil {
+		return nil, fmt.Errorf("message schema not found: %v", err)
+	}
+
+	decodedMessage, err := wire.DecodeMessage(data, message, p.registry)
+	if err != nil {
+		return nil, fmt.Errorf("decoding failed: %w", err)
+	}
+	decoded, ok := decodedMessage.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("decoded message is not a map, got %T", decodedMessage)
+	}
+
+	return p.orderedMessage(decoded, message), nil
+}
+
+// orderedMessage sorts the fields of a decoded message by declaration
+func (p *protolite) orderedMessage(decoded map[string]interface{}, msg *schema.Message) OrderedMessage {
+	rank := make(map[*schema.Field]int)
+	for _, f := range msg.Fields {
+		rank[f] = len(rank)
+	}
+	for _, oneof := range msg.OneofGroups {
+		for _, f := range oneof.Fields {
+			rank[f] = len(rank)
+		}
+	}
+	for _, f := range msg.Extensions {
+		rank[f] = len(rank)
+	}
+
+	type entry struct {
+		OrderedField
+		rank int
+	}
+	entries := make([]entry, 0, len(decoded))
+	for key, value := range decoded {
+		field := fieldByDecodedName(msg, key)
+		if field == nil {
+			// e.g. __typename of union wrappers
+			entries = append(entries, entry{OrderedField{key, value}, len(rank)})
+			continue
+		}
+		entries = append(entries, entry{OrderedField{key, p.orderedField(value, field)}, rank[field]})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].rank != entries[j].rank {
+			return entries[i].rank < entries[j].rank
+		}
+		return entries[i].Name < entries[j].Name
+	})
+
+	out := make(OrderedMessage, len(entries))
+	for i, e := range entries {
+		out[i] = e.OrderedField
+	}
+	return out
+}
+
+// orderedField orders the messages held by a decoded field value, descending into repeated and map values
+func (p *protolite) orderedField(value interface{}, field *schema.Field) interface{} {
+	rv := reflect.ValueOf(value)
+	switch {
+	case field.Type.Kind == schema.KindMap && rv.Kind() == reflect.Map:
+		if field.Type.MapValue == nil || field.Type.MapValue.Kind != schema.KindMessage {
+			return value
+		}
+		out := reflect.MakeMapWithSize(rv.Type(), rv.Len())
+		iter := rv.MapRange()
+		for iter.Next() {
+			v := reflect.ValueOf(p.orderedType(iter.Value().Interface(), field.Type.MapValue))
+			if !v.IsValid() {
+				v = reflect.Zero(rv.Type().Elem())
+			}
+			out.SetMapIndex(iter.Key(), v)
+		}
+		return out.Interface()
+	case field.Label == schema.LabelRepeated && field.Type.Kind == schema.KindMessage:
+		elements, ok := value.([]interface{})
+		if !ok {
+			return value
+		}
+		out := make([]interface{}, len(elements))
+		for i, element := range elements {
+			out[i] = p.orderedType(element, &field.Type)
+		}
+		return out
+	default:
+		return p.orderedType(value, &field.Type)
+	}
+}
+
+// orderedType orders a single decoded message value; anything else is returned as is
+func (p *protolite) orderedType(value interface{}, t *schema.FieldType) interface{} {
+	nested, ok := value.(map[string]interface{})
+	if !ok || t.Kind != schema.KindMessage {
+		return value
+	}
+	msg, err := p.registry.GetMessage(t.MessageType)
+	if err != nil {
+		return value
+	}
+	return p.orderedMessage(nested, msg)
+}
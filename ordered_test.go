@@ -0,0 +1,68 @@
+package protolite
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestUnmarshalOrdered(t *testing.T) {
+	p := NewProtolite([]string{""})
+	err := p.LoadSchemaFromReader(strings.NewReader(`syntax = "proto3";
+package ops;
+
+message Host {
+  string name = 2;
+  int32 port = 1;
+}
+
+message Incident {
+  string title = 5;
+  int32 severity = 1;
+  oneof owner {
+    string team = 3;
+    string person = 4;
+  }
+  repeated Host hosts = 2;
+  map<string, Host> by_region = 6;
+}
+`), "ops.proto")
+	if err != nil {
+		t.Fatalf("LoadSchemaFromReader: %v", err)
+	}
+	data, err := p.MarshalWithSchema(map[string]interface{}{
+		"title":     "db down",
+		"severity":  int32(2),
+		"team":      "storage",
+		"hosts":     []interface{}{map[string]interface{}{"name": "db1", "port": int32(5432)}},
+		"by_region": map[string]interface{}{"eu": map[string]interface{}{"name": "db2", "port": int32(5433)}},
+	}, "ops.Incident")
+	if err != nil {
+		t.Fatalf("MarshalWithSchema: %v", err)
+	}
+
+	got, err := p.UnmarshalOrdered(data, "ops.Incident")
+	if err != nil {
+		t.Fatalf("UnmarshalOrdered: %v", err)
+	}
+	host := func(name string, port int32) OrderedMessage {
+		return OrderedMessage{{"name", name}, {"port", port}}
+	}
+	expected := OrderedMessage{
+		{"title", "db down"},
+		{"severity", int32(2)},
+		{"hosts", []interface{}{host("db1", 5432)}},
+		{"by_region", map[string]interface{}{"eu": host("db2", 5433)}},
+		{"team", "storage"},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Unexpected order:\nexpected %v\ngot      %v", expected, got)
+	}
+
+	if v, ok := got.Get("team"); !ok || v != "storage" {
+		t.Errorf("Get(team) = %v, %v", v, ok)
+	}
+	if _, ok := got.Get("person"); ok {
+		t.Errorf("Expected unset oneof member to be absent")
+	}
+}
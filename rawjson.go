@@ -0,0 +1,94 @@
+package protolite
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/anirudhraja/protolite/schema"
+	"github.com/anirudhraja/protolite/wire"
+)
+
+// RegisterRawJSONField makes a message field decode to its JSON, the form
+// UnmarshalToJSONMap gives, as a json.RawMessage instead of a nested map
+func (p *protolite) RegisterRawJSONField(messageName, fieldName string) error {
+	msg, err := p.registry.GetMessage(messageName)
+	if err != nil {
+		return fmt.Errorf("message schema not found: %v", err)
+	}
+	field := fieldByDecodedName(msg, fieldName)
+	if field == nil {
+		return fmt.Errorf("field %s not found in message %s", fieldName, messageName)
+	}
+	if field.Type.Kind != schema.KindMessage {
+		return fmt.Errorf("raw JSON needs a message field, %s is not one", fieldName)
+	}
+	return p.registry.RegisterFieldCodec(messageName, field.Name, &rawJSONCodec{p: p, fieldType: field.Type})
+}
+
+// rawJSONCodec carries a message field as JSON. Encode also takes the map a
+// regular decode would give, so values read elsewhere can still be written.
+type rawJSONCodec struct {
+	p         *protolite
+	fieldType schema.FieldType
+}
+
+func (c *rawJSONCodec) Encode(value interface{}) ([]byte, error) {
+	var raw []byte
+	switch v := value.(type) {
+	case json.RawMessage:
+		raw = v
+	case []byte:
+		raw = v
+	case string:
+		raw = []byte(v)
+	case map[string]interface{}:
+		return c.encodeMessage(v)
+	default:
+		return nil, fmt.Errorf("raw JSON field expects json.RawMessage, []byte, string or a message map, got %T", value)
+	}
+
+	var jsonValue interface{}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	if err := dec.Decode(&jsonValue); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+	converted, err := c.p.protoType(jsonValue, &c.fieldType)
+	if err != nil {
+		return nil, err
+	}
+	protoMap, ok := converted.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("message %s expects a JSON object, got %T", c.fieldType.MessageType, jsonValue)
+	}
+	return c.encodeMessage(protoMap)
+}
+
+func (c *rawJSONCodec) encodeMessage(data map[string]interface{}) ([]byte, error) {
+	msg, err := c.p.registry.GetMessage(c.fieldType.MessageType)
+	if err != nil {
+		return nil, err
+	}
+	return wire.EncodeMessage(data, msg, c.p.registry)
+}
+
+func (c *rawJSONCodec) Decode(data []byte) (interface{}, error) {
+	msg, err := c.p.registry.GetMessage(c.fieldType.MessageType)
+	if err != nil {
+		return nil, err
+	}
+	decoded, err := wire.DecodeMessage(data, msg, c.p.registry)
+	if err != nil {
+		return nil, err
+	}
+	jsonValue, err := c.p.jsonType(decoded, &c.fieldType, newJSONOptions(nil))
+	if err != nil {
+		return nil, err
+	}
+	raw, err := json.Marshal(jsonValue)
+	if err != nil {
+		return nil, err
+	}
+	return json.RawMessage(raw), nil
+}
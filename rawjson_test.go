@@ -0,0 +1,114 @@
+package protolite
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRegisterRawJSONField(t *testing.T) {
+	protoContent := `
+syntax = "proto3";
+
+package example;
+
+import "google/protobuf/timestamp.proto";
+
+message Profile {
+    string display_name = 1;
+    int64 followers = 2;
+    google.protobuf.Timestamp updated_at = 3;
+}
+
+message User {
+    string id = 1;
+    Profile profile = 2;
+    repeated Profile history = 3;
+}
+`
+	proto := NewProtolite([]string{"conformance_test/protos"})
+	if err := proto.LoadSchemaFromReader(strings.NewReader(protoContent), "user.proto"); err != nil {
+		t.Fatalf("Failed to load schema from reader: %v", err)
+	}
+	profile := map[string]interface{}{
+		"display_name": "Ada",
+		"followers":    int64(42),
+		"updated_at":   map[string]interface{}{"seconds": int64(1700000000)},
+	}
+	data := map[string]interface{}{
+		"id":      "u1",
+		"profile": profile,
+		"history": []interface{}{profile},
+	}
+	encoded, err := proto.MarshalWithSchema(data, "example.User")
+	if err != nil {
+		t.Fatalf("MarshalWithSchema failed: %v", err)
+	}
+
+	for _, field := range []string{"profile", "history"} {
+		if err := proto.RegisterRawJSONField("example.User", field); err != nil {
+			t.Fatalf("RegisterRawJSONField(%s) failed: %v", field, err)
+		}
+	}
+	decoded, err := proto.UnmarshalWithSchema(encoded, "example.User")
+	if err != nil {
+		t.Fatalf("UnmarshalWithSchema failed: %v", err)
+	}
+	const expected = `{"display_name":"Ada","followers":"42","updated_at":"2023-11-14T22:13:20Z"}`
+	if raw, ok := decoded["profile"].(json.RawMessage); !ok || string(raw) != expected {
+		t.Errorf("Expected profile as raw JSON %s, got %v (%T)", expected, decoded["profile"], decoded["profile"])
+	}
+	if history, ok := decoded["history"].([]interface{}); !ok || len(history) != 1 || string(history[0].(json.RawMessage)) != expected {
+		t.Errorf("Expected history of raw JSON, got %v", decoded["history"])
+	}
+
+	// the raw JSON encodes back to the same message
+	reencoded, err := proto.MarshalWithSchema(decoded, "example.User")
+	if err != nil {
+		t.Fatalf("MarshalWithSchema failed: %v", err)
+	}
+	again, err := proto.UnmarshalWithSchema(reencoded, "example.User")
+	if err != nil {
+		t.Fatalf("UnmarshalWithSchema failed: %v", err)
+	}
+	if !reflect.DeepEqual(again, decoded) {
+		t.Errorf("Round trip mismatch: expected %v, got %v", decoded, again)
+	}
+
+	// decoded message maps are still accepted
+	if _, err := proto.MarshalWithSchema(data, "example.User"); err != nil {
+		t.Errorf("MarshalWithSchema with a message map failed: %v", err)
+	}
+
+	// the whole message still converts to JSON with the field embedded
+	jsonMap, err := proto.UnmarshalToJSONMap(encoded, "example.User")
+	if err != nil {
+		t.Fatalf("UnmarshalToJSONMap failed: %v", err)
+	}
+	out, err := json.Marshal(jsonMap)
+	if err != nil {
+		t.Fatalf("json.Marshal failed: %v", err)
+	}
+	var got, want interface{}
+	if err := json.Unmarshal(out, &got); err != nil {
+		t.Fatalf("json.Unmarshal failed: %v", err)
+	}
+	_ = json.Unmarshal([]byte(`{"id":"u1","profile":`+expected+`,"history":[`+expected+`]}`), &want)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Unexpected JSON: %s", out)
+	}
+
+	t.Run("errors", func(t *testing.T) {
+		if err := proto.RegisterRawJSONField("example.User", "id"); err == nil {
+			t.Error("Expected error for a non-message field")
+		}
+		if err := proto.RegisterRawJSONField("example.User", "missing"); err == nil {
+			t.Error("Expected error for an unknown field")
+		}
+		_, err := proto.MarshalWithSchema(map[string]interface{}{"profile": json.RawMessage(`{"followers":`)}, "example.User")
+		if err == nil || !strings.Contains(err.Error(), "invalid JSON") {
+			t.Errorf("Expected invalid JSON error, got %v", err)
+		}
+	})
+}
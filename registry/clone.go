@@ -0,0 +1,219 @@
+package registry
+
+import (
+	"github.com/anirudhraja/protolite/schema"
+	protoparserparser "github.com/yoheimuta/go-protoparser/v4/parser"
+)
+
+// Clone returns an independent copy of the registry. Every loaded message,
+// field, enum and service is copied, so the clone can be changed, e.g. by
+// adding a field or loading more files, without affecting r. Field codecs
+// and parsed .proto files are shared, as neither is modified in place.
+func (r *Registry) Clone() *Registry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c := &cloner{
+		messages: make(map[*schema.Message]*schema.Message),
+		fields:   make(map[*schema.Field]*schema.Field),
+		enums:    make(map[*schema.Enum]*schema.Enum),
+		services: make(map[*schema.Service]*schema.Service),
+	}
+	out := &Registry{
+		ProtoDirectories: append([]string(nil), r.ProtoDirectories...),
+		warnings:         append([]string(nil), r.warnings...),
+		fsys:             r.fsys,
+	}
+	if r.repo != nil {
+		out.repo = &schema.ProtoRepo{ProtoFiles: make(map[string]*schema.ProtoFile, len(r.repo.ProtoFiles))}
+		for name, file := range r.repo.ProtoFiles {
+			out.repo.ProtoFiles[name] = c.protoFile(file)
+		}
+	}
+	if r.messages != nil {
+		out.messages = make(map[string]*schema.Message, len(r.messages))
+		for name, msg := range r.messages {
+			out.messages[name] = c.message(msg)
+		}
+	}
+	if r.mapEntries != nil {
+		out.mapEntries = make(map[string]*schema.Message, len(r.mapEntries))
+		for name, msg := range r.mapEntries {
+			out.mapEntries[name] = c.message(msg)
+		}
+	}
+	if r.enums != nil {
+		out.enums = make(map[string]*schema.Enum, len(r.enums))
+		for name, enum := range r.enums {
+			out.enums[name] = c.enum(enum)
+		}
+	}
+	if r.services != nil {
+		out.services = make(map[string]*schema.Service, len(r.services))
+		for name, service := range r.services {
+			out.services[name] = c.service(service)
+		}
+	}
+	if r.protoEntities != nil {
+		out.protoEntities = make(map[string]*protoFileEntity, len(r.protoEntities))
+		for name, entity := range r.protoEntities {
+			out.protoEntities[name] = &protoFileEntity{
+				entities: append([]string(nil), entity.entities...),
+				imports:  append([]string(nil), entity.imports...),
+			}
+		}
+	}
+	if r.parsedProtoBody != nil {
+		out.parsedProtoBody = make(map[string]*protoparserparser.Proto, len(r.parsedProtoBody))
+		for name, parsed := range r.parsedProtoBody {
+			out.parsedProtoBody[name] = parsed
+		}
+	}
+	if r.publicImports != nil {
+		out.publicImports = make(map[string][]string, len(r.publicImports))
+		for name, imports := range r.publicImports {
+			out.publicImports[name] = append([]string(nil), imports...)
+		}
+	}
+	return out
+}
+
+// cloner deep-copies schema definitions, copying each one once so a message
+// reachable from both the symbol table and its file stays a single value
+type cloner struct {
+	messages map[*schema.Message]*schema.Message
+	fields   map[*schema.Field]*schema.Field
+	enums    map[*schema.Enum]*schema.Enum
+	services map[*schema.Service]*schema.Service
+}
+
+func (c *cloner) protoFile(file *schema.ProtoFile) *schema.ProtoFile {
+	out := *file
+	out.Imports = make([]*schema.Import, len(file.Imports))
+	for i, imp := range file.Imports {
+		copied := *imp
+		out.Imports[i] = &copied
+	}
+	out.Messages = c.messageList(file.Messages)
+	out.Enums = c.enumList(file.Enums)
+	out.Services = make([]*schema.Service, len(file.Services))
+	for i, service := range file.Services {
+		out.Services[i] = c.service(service)
+	}
+	out.Extensions = make([]*schema.Extension, len(file.Extensions))
+	for i, extension := range file.Extensions {
+		out.Extensions[i] = &schema.Extension{Extendee: extension.Extendee, Fields: c.fieldList(extension.Fields)}
+	}
+	return &out
+}
+
+func (c *cloner) message(msg *schema.Message) *schema.Message {
+	if msg == nil {
+		return nil
+	}
+	if copied, ok := c.messages[msg]; ok {
+		return copied
+	}
+	out := *msg
+	c.messages[msg] = &out
+	out.Fields = c.fieldList(msg.Fields)
+	out.NestedTypes = c.messageList(msg.NestedTypes)
+	out.NestedEnums = c.enumList(msg.NestedEnums)
+	out.Extensions = c.fieldList(msg.Extensions)
+	out.ReservedRanges = append([]schema.ReservedRange(nil), msg.ReservedRanges...)
+	out.ReservedNames = append([]string(nil), msg.ReservedNames...)
+	if msg.OneofGroups != nil {
+		out.OneofGroups = make([]*schema.Oneof, len(msg.OneofGroups))
+		for i, oneof := range msg.OneofGroups {
+			out.OneofGroups[i] = &schema.Oneof{Name: oneof.Name, Fields: c.fieldList(oneof.Fields)}
+		}
+	}
+	return &out
+}
+
+func (c *cloner) messageList(msgs []*schema.Message) []*schema.Message {
+	if msgs == nil {
+		return nil
+	}
+	out := make([]*schema.Message, len(msgs))
+	for i, msg := range msgs {
+		out[i] = c.message(msg)
+	}
+	return out
+}
+
+func (c *cloner) field(field *schema.Field) *schema.Field {
+	if copied, ok := c.fields[field]; ok {
+		return copied
+	}
+	out := *field
+	out.Type = *cloneFieldType(&field.Type)
+	if field.Options != nil {
+		out.Options = make(map[string]string, len(field.Options))
+		for k, v := range field.Options {
+			out.Options[k] = v
+		}
+	}
+	c.fields[field] = &out
+	return &out
+}
+
+func (c *cloner) fieldList(fields []*schema.Field) []*schema.Field {
+	if fields == nil {
+		return nil
+	}
+	out := make([]*schema.Field, len(fields))
+	for i, field := range fields {
+		out[i] = c.field(field)
+	}
+	return out
+}
+
+func (c *cloner) enum(enum *schema.Enum) *schema.Enum {
+	if copied, ok := c.enums[enum]; ok {
+		return copied
+	}
+	out := *enum
+	out.Values = make([]*schema.EnumValue, len(enum.Values))
+	for i, value := range enum.Values {
+		copied := *value
+		out.Values[i] = &copied
+	}
+	c.enums[enum] = &out
+	return &out
+}
+
+func (c *cloner) enumList(enums []*schema.Enum) []*schema.Enum {
+	if enums == nil {
+		return nil
+	}
+	out := make([]*schema.Enum, len(enums))
+	for i, enum := range enums {
+		out[i] = c.enum(enum)
+	}
+	return out
+}
+
+func cloneFieldType(t *schema.FieldType) *schema.FieldType {
+	if t == nil {
+		return nil
+	}
+	out := *t
+	out.MapKey = cloneFieldType(t.MapKey)
+	out.MapValue = cloneFieldType(t.MapValue)
+	out.ElementType = cloneFieldType(t.ElementType)
+	return &out
+}
+
+func (c *cloner) service(service *schema.Service) *schema.Service {
+	if copied, ok := c.services[service]; ok {
+		return copied
+	}
+	out := *service
+	out.Methods = make([]*schema.Method, len(service.Methods))
+	for i, method := range service.Methods {
+		copied := *method
+		out.Methods[i] = &copied
+	}
+	c.services[service] = &out
+	return &out
+}
@@ -0,0 +1,244 @@
+package registry
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/anirudhraja/protolite/schema"
+)
+
+// LintRule names the check that produced a LintIssue
+type LintRule string
+
+const (
+	// LintLargeFieldNumber flags a repeated field whose tag is written once per
+	// element and takes two bytes, while a one byte number (1-15) is still free.
+	LintLargeFieldNumber LintRule = "large_field_number"
+	// LintMissingZeroEnumValue flags a proto3 enum whose first value is not 0.
+	LintMissingZeroEnumValue LintRule = "missing_zero_enum_value"
+	// LintInvalidMapKey flags a map whose key is not an integral, bool or string type.
+	LintInvalidMapKey LintRule = "invalid_map_key"
+	// LintUnusedImport flags an import no definition of the file refers to.
+	LintUnusedImport LintRule = "unused_import"
+)
+
+// LintIssue is a problem found in the loaded schema by Lint
+type LintIssue struct {
+	Rule    LintRule `json:"rule"`
+	File    string   `json:"file"`              // proto file path as loaded
+	Message string   `json:"message,omitempty"` // fully qualified message or enum name, empty for file level issues
+	Field   string   `json:"field,omitempty"`   // field name, empty for message level issues
+	Detail  string   `json:"detail"`            // human readable description
+}
+
+// String formats the issue as "file: message.field: detail [rule]"
+func (i LintIssue) String() string {
+	where := i.File
+	if i.Message != "" {
+		where += ": " + i.Message
+		if i.Field != "" {
+			where += "." + i.Field
+		}
+	}
+	return fmt.Sprintf("%s: %s [%s]", where, i.Detail, i.Rule)
+}
+
+// Lint reports common problems in the loaded files that protolite itself
+// tolerates: large field numbers on repeated fields, proto3 enums without a
+// zero first value, map keys of an illegal type and unused imports. Issues are
+// ordered by file, then by declaration. Public and weak imports, and imports of
+// files declaring extensions, e.g. for custom options, are never reported as
+// unused. Loading already rejects illegal map keys and proto3 enums without a
+// zero first value, so those checks only fire for definitions changed in code.
+func (r *Registry) Lint() []LintIssue {
+	if r.repo == nil {
+		return nil
+	}
+	paths := r.ListProtoFiles()
+	sort.Strings(paths)
+
+	var issues []LintIssue
+	for _, path := range paths {
+		file := r.repo.ProtoFiles[path]
+		l := &linter{file: file, path: path, refs: map[string]struct{}{}}
+		for _, enum := range file.Enums {
+			l.lintEnum(enum, r.getFullName(file.Package, enum.Name))
+		}
+		for _, msg := range file.Messages {
+			// every file carries its own copy of the null tracker messages
+			if msg.Name == schema.NullTrackerWrapperMessageName || msg.Name == schema.NullTrackerWrapperInternalMessageName {
+				continue
+			}
+			l.lintMessage(msg, r.getFullName(file.Package, msg.Name))
+		}
+		for _, ext := range file.Extensions {
+			l.refs[ext.Extendee] = struct{}{}
+			for _, f := range ext.Fields {
+				l.addFieldRefs(&f.Type)
+			}
+		}
+		for _, service := range file.Services {
+			for _, method := range service.Methods {
+				for _, name := range []string{method.InputType, method.OutputType} {
+					l.refs[name] = struct{}{}
+					l.refs[r.getFullName(file.Package, name)] = struct{}{}
+				}
+			}
+		}
+		issues = append(issues, l.issues...)
+		issues = append(issues, r.lintImports(path, file, l.refs)...)
+	}
+	return issues
+}
+
+// linter collects the issues of a single file along with the type names it refers to
+type linter struct {
+	file   *schema.ProtoFile
+	path   string
+	refs   map[string]struct{}
+	issues []LintIssue
+}
+
+func (l *linter) report(rule LintRule, message, field, format string, args ...interface{}) {
+	l.issues = append(l.issues, LintIssue{
+		Rule:    rule,
+		File:    l.path,
+		Message: message,
+		Field:   field,
+		Detail:  fmt.Sprintf(format, args...),
+	})
+}
+
+func (l *linter) lintEnum(enum *schema.Enum, fullName string) {
+	if l.file.Syntax != "proto3" || len(enum.Values) == 0 {
+		return
+	}
+	if first := enum.Values[0]; first.Number != 0 {
+		l.report(LintMissingZeroEnumValue, fullName, "", "first value %s is %d, proto3 enums must start with a 0 value", first.Name, first.Number)
+	}
+}
+
+func (l *linter) lintMessage(msg *schema.Message, fullName string) {
+	used := make(map[int32]struct{})
+	for _, f := range allFields(msg) {
+		used[f.Number] = struct{}{}
+	}
+	freeSmallNumber := false
+	for n := int32(1); n <= 15; n++ {
+		if _, ok := used[n]; !ok && !msg.IsReserved(n) {
+			freeSmallNumber = true
+			break
+		}
+	}
+
+	for _, f := range allFields(msg) {
+		if schema.IsNullTrackerField(f) {
+			continue
+		}
+		l.addFieldRefs(&f.Type)
+		if f.Type.Kind == schema.KindMap && !isValidMapKey(f.Type.MapKey) {
+			l.report(LintInvalidMapKey, fullName, f.Name, "map key type %s is not allowed, use an integral, bool or string type", newTypeInfo(f.Type.MapKey).TypeName)
+		}
+		if freeSmallNumber && f.Number > 15 && f.Label == schema.LabelRepeated && !l.isPacked(f) {
+			l.report(LintLargeFieldNumber, fullName, f.Name, "repeated field number %d needs a two byte tag per element while numbers 1-15 are free", f.Number)
+		}
+	}
+	for _, enum := range msg.NestedEnums {
+		l.lintEnum(enum, fullName+"."+enum.Name)
+	}
+	for _, nested := range msg.NestedTypes {
+		if nested.MapEntry {
+			continue
+		}
+		l.lintMessage(nested, fullName+"."+nested.Name)
+	}
+}
+
+// isPacked reports whether a repeated field is written as a single packed
+// record, so its tag size does not grow with the element count
+func (l *linter) isPacked(f *schema.Field) bool {
+	packable := f.Type.Kind == schema.KindEnum || (f.Type.Kind == schema.KindPrimitive && schema.IsPackedType(f.Type.PrimitiveType))
+	if !packable {
+		return false
+	}
+	if packed, ok := f.Options[optionPacked]; ok {
+		return packed == "true"
+	}
+	return l.file.Syntax == "proto3"
+}
+
+func (l *linter) addFieldRefs(t *schema.FieldType) {
+	if t == nil {
+		return
+	}
+	switch t.Kind {
+	case schema.KindMessage:
+		l.refs[t.MessageType] = struct{}{}
+	case schema.KindEnum:
+		l.refs[t.EnumType] = struct{}{}
+	case schema.KindWrapper:
+		l.refs[string(t.WrapperType)] = struct{}{}
+	case schema.KindMap:
+		l.addFieldRefs(t.MapValue)
+	}
+	l.addFieldRefs(t.ElementType)
+}
+
+func isValidMapKey(t *schema.FieldType) bool {
+	if t == nil || t.Kind != schema.KindPrimitive {
+		return false
+	}
+	switch t.PrimitiveType {
+	case schema.TypeFloat, schema.TypeDouble, schema.TypeBytes:
+		return false
+	}
+	return true
+}
+
+// lintImports reports the imports of a file that define none of the referenced types
+func (r *Registry) lintImports(path string, file *schema.ProtoFile, refs map[string]struct{}) []LintIssue {
+	var issues []LintIssue
+	for _, imp := range file.Imports {
+		if imp.Public || imp.Weak {
+			continue
+		}
+		importPath, err := r.findIfProtoExists(imp.Path)
+		if err != nil {
+			continue // built in, e.g. wrappers.proto
+		}
+		if imported, ok := r.repo.ProtoFiles[importPath]; !ok || len(imported.Extensions) > 0 {
+			continue
+		}
+		if !r.importDefinesAny(importPath, refs, map[string]bool{}) {
+			issues = append(issues, LintIssue{
+				Rule:   LintUnusedImport,
+				File:   path,
+				Detail: fmt.Sprintf("import %s is not used", strings.Trim(imp.Path, `"`)),
+			})
+		}
+	}
+	return issues
+}
+
+// importDefinesAny reports whether the file, or a file it publicly imports,
+// defines one of the names
+func (r *Registry) importDefinesAny(path string, names map[string]struct{}, visited map[string]bool) bool {
+	if visited[path] {
+		return false
+	}
+	visited[path] = true
+	if entity, ok := r.protoEntities[path]; ok {
+		for _, name := range entity.entities {
+			if _, ok := names[name]; ok {
+				return true
+			}
+		}
+	}
+	for _, public := range r.publicImports[path] {
+		if r.importDefinesAny(public, names, visited) {
+			return true
+		}
+	}
+	return false
+}
@@ -1,11 +1,15 @@
 package registry
 
 import (
+	"bytes"
 	"fmt"
 	"io"
+	"io/fs"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/anirudhraja/protolite/schema"
 	protoparserparser "github.com/yoheimuta/go-protoparser/v4/parser"
@@ -15,12 +19,16 @@ import (
 type Registry struct {
 	repo             *schema.ProtoRepo
 	messages         map[string]*schema.Message          // fully qualified name -> message
+	mapEntries       map[string]*schema.Message          // containing message + "." + map field + "Entry" -> synthetic entry
 	enums            map[string]*schema.Enum             // fully qualified name -> enum
 	services         map[string]*schema.Service          // fully qualified name -> service
 	protoEntities    map[string]*protoFileEntity         // for each proto store the entities so its easy to refer
 	parsedProtoBody  map[string]*protoparserparser.Proto // just a cache to avoid parsing proto body
 	ProtoDirectories []string                            // list of directories to search for the imported protos
 	publicImports    map[string][]string                 // for each proto store the public imports
+	warnings         []string                            // non-fatal problems found while loading, e.g. missing weak imports
+	fsys             fs.FS                               // when set, proto files are read from it instead of the OS filesystem
+	mu               sync.Mutex                          // serializes field codec registration with Clone
 }
 
 // preprocessing the proto file to store the proto entities
@@ -35,11 +43,26 @@ func NewRegistry(ProtoDirectories []string) *Registry {
 	}
 }
 
+// NewRegistryFS returns a registry that resolves ProtoDirectories against fsys,
+// e.g. an embed.FS, and never touches the OS filesystem
+func NewRegistryFS(ProtoDirectories []string, fsys fs.FS) *Registry {
+	return &Registry{
+		ProtoDirectories: ProtoDirectories,
+		fsys:             fsys,
+	}
+}
+
 // FindProtoPath resolves a proto file path using the configured proto directories
 func (r *Registry) FindProtoPath(protoPath string) (string, error) {
 	return r.findIfProtoExists(protoPath)
 }
 
+// Warnings returns the non-fatal problems found by LoadSchema so far, such as
+// weak imports that could not be found and were skipped.
+func (r *Registry) Warnings() []string {
+	return r.warnings
+}
+
 // LoadSchema loads schema from an io.Reader with a unique identifier, while dependent protos are loaded from file paths
 func (r *Registry) LoadSchema(reader io.Reader, identifier string) error {
 	// Initialize the registry
@@ -53,13 +76,66 @@ func (r *Registry) LoadSchema(reader io.Reader, identifier string) error {
 	return r.processProtoFiles(allProtoFiles)
 }
 
+// LoadSchemaFromFS loads the proto file entry, and the files it imports, from
+// fsys instead of the OS filesystem, e.g. from an embed.FS or a zip.Reader.
+// entry and imports are resolved against ProtoDirectories inside fsys, so with
+// a single "" or "." directory paths are relative to the root of fsys. fsys is
+// only used for this call: later loads, ParsedFile and ReloadFile read from the
+// registry's own fs.FS or the OS filesystem again, use NewRegistryFS to read
+// every file from fsys.
+func (r *Registry) LoadSchemaFromFS(fsys fs.FS, entry string) error {
+	prev := r.fsys
+	r.fsys = fsys
+	defer func() { r.fsys = prev }()
+	return r.LoadSchemaFile(entry)
+}
+
+// LoadSchemaFile loads the proto file at protoPath, resolved against
+// ProtoDirectories, from the registry's fs.FS or else the OS filesystem. The
+// resolved path is its identifier.
+func (r *Registry) LoadSchemaFile(protoPath string) error {
+	fullPath, err := r.findIfProtoExists(protoPath)
+	if err != nil {
+		return err
+	}
+	protoBytes, err := r.readProtoFile(fullPath)
+	if err != nil {
+		return fmt.Errorf("failed to read proto file: %w", err)
+	}
+	return r.LoadSchema(bytes.NewReader(protoBytes), fullPath)
+}
+
+// Reset unloads every schema, leaving the registry as NewRegistry returned it
+// apart from ProtoDirectories and its fs.FS. Messages obtained before the reset, and any field
+// codecs registered on them, are not touched but no longer reachable through it.
+func (r *Registry) Reset() {
+	r.repo = nil
+	r.messages = nil
+	r.mapEntries = nil
+	r.enums = nil
+	r.services = nil
+	r.protoEntities = nil
+	r.parsedProtoBody = nil
+	r.publicImports = nil
+	r.warnings = nil
+}
+
 // initializeRegistry initializes all registry maps and repo if not already done
 func (r *Registry) initializeRegistry() {
 	if r.messages == nil {
 		r.messages = make(map[string]*schema.Message)
 	}
+	if r.mapEntries == nil {
+		r.mapEntries = make(map[string]*schema.Message)
+	}
 	if r.enums == nil {
 		r.enums = make(map[string]*schema.Enum)
+		// built in so fields can use it without importing struct.proto,
+		// which redefines it identically when loaded
+		r.enums[schema.NullValueEnumName] = &schema.Enum{
+			Name:   "NullValue",
+			Values: []*schema.EnumValue{{Name: "NULL_VALUE", Number: 0}},
+		}
 	}
 	if r.services == nil {
 		r.services = make(map[string]*schema.Service)
@@ -176,6 +252,9 @@ func (r *Registry) loadSingleProtoFile(filePath string) (*schema.ProtoFile, erro
 		Enums:    []*schema.Enum{},
 		Services: []*schema.Service{},
 	}
+	if parsedProtoBody.Syntax != nil && parsedProtoBody.Syntax.ProtobufVersion != "" {
+		protoFile.Syntax = parsedProtoBody.Syntax.ProtobufVersion
+	}
 	// preprocess the imports first and add package name to each entity
 	for _, body := range parsedProtoBody.ProtoBody {
 		switch b := body.(type) {
@@ -213,7 +292,17 @@ func (r *Registry) loadSingleProtoFile(filePath string) (*schema.ProtoFile, erro
 				return nil, fmt.Errorf("Service %s processing failed with err: %v", b.ServiceName, err)
 			}
 			protoFile.Services = append(protoFile.Services, service)
-
+		case *protoparserparser.Extend:
+			extension, err := r.processExtend(b, allResolvedEntities, protoFile.Package)
+			if err != nil {
+				return nil, fmt.Errorf("Extend %s processing failed with err: %v", b.MessageType, err)
+			}
+			protoFile.Extensions = append(protoFile.Extensions, extension)
+		}
+	}
+	if protoFile.Syntax == "proto3" {
+		if err := r.validateProto3Enums(protoFile); err != nil {
+			return nil, err
 		}
 	}
 	// Store in the ProtoRepo
@@ -248,8 +337,14 @@ func getNullTrackerMessages() []protoparserparser.Visitee {
 
 // parseMessage parses a message definition starting from the given line index
 func (r *Registry) processMessage(message *protoparserparser.Message, allResolvedEntities map[string]struct{}, prefix string) (*schema.Message, error) {
+	trailing := message.InlineComment
+	if trailing == nil {
+		trailing = message.InlineCommentBehindLeftCurly
+	}
 	msg := &schema.Message{
-		Name: message.MessageName,
+		Name:            message.MessageName,
+		Comment:         commentText(message.Comments...),
+		TrailingComment: commentText(trailing),
 	}
 	prefix = prefix + "." + message.MessageName
 	nestedEnums := make([]*schema.Enum, 0)
@@ -276,6 +371,8 @@ func (r *Registry) processMessage(message *protoparserparser.Message, allResolve
 				msg.IsWrapper = b.Constant == "true"
 			case optionShowNull:
 				msg.ShowNull = b.Constant == "true"
+			case optionMessageSetWireFormat:
+				msg.MessageSetWireFormat = b.Constant == "true"
 			case optionTrackNull:
 				msg.TrackNull = b.Constant == "true"
 				if field, err := r.getNullTrackerField(allResolvedEntities, prefix); err != nil {
@@ -284,6 +381,17 @@ func (r *Registry) processMessage(message *protoparserparser.Message, allResolve
 					fields = append(fields, field)
 				}
 			}
+		case *protoparserparser.Reserved:
+			for _, rng := range b.Ranges {
+				reserved, err := parseReservedRange(rng)
+				if err != nil {
+					return nil, fmt.Errorf("message %s: %w", msg.Name, err)
+				}
+				msg.ReservedRanges = append(msg.ReservedRanges, reserved)
+			}
+			for _, name := range b.FieldNames {
+				msg.ReservedNames = append(msg.ReservedNames, unquoteConstant(name))
+			}
 		case *protoparserparser.Field:
 			field, err := r.processField(b, allResolvedEntities, prefix)
 			if err != nil {
@@ -299,7 +407,7 @@ func (r *Registry) processMessage(message *protoparserparser.Message, allResolve
 		case *protoparserparser.Oneof:
 			oneOfFields := make([]*schema.Field, 0)
 			for _, field := range b.OneofFields {
-				fieldNumber, err := strconv.ParseInt(field.FieldNumber, 10, 32)
+				fieldNumber, err := parseFieldNumber(field.FieldNumber, field.FieldName)
 				if err != nil {
 					return nil, err
 				}
@@ -310,12 +418,19 @@ func (r *Registry) processMessage(message *protoparserparser.Message, allResolve
 				fieldLabel := schema.LabelOptional
 				f := &schema.Field{
 					Name:       field.FieldName,
-					Number:     int32(fieldNumber),
+					Number:     fieldNumber,
 					Label:      fieldLabel,
 					Type:       *fieldType,
 					JsonName:   findJSONName(field.FieldOptions),
 					JSONString: isJSONString(field.FieldOptions),
 					JSONBytes: isJSONBytes(field.FieldOptions),
+					Options:    fieldOptions(field.FieldOptions),
+
+					Comment:         commentText(field.Comments...),
+					TrailingComment: commentText(field.InlineComment),
+				}
+				if _, packed := f.Options[optionPacked]; packed {
+					return nil, fmt.Errorf("%s option is only allowed on repeated fields, got it on oneof field %s", optionPacked, f.Name)
 				}
 				if f.JSONString && (f.Type.Kind != schema.KindWrapper || f.Type.WrapperType != schema.WrapperStringValue) {
 					return nil, fmt.Errorf("expected %s type at %s for json_string, got %+v", schema.WrapperStringValue, f.Name, f.Type)
@@ -341,6 +456,9 @@ func (r *Registry) processMessage(message *protoparserparser.Message, allResolve
 	msg.Fields = fields
 	msg.NestedEnums = nestedEnums
 	msg.OneofGroups = oneOfGroups
+	if err := msg.Validate(); err != nil {
+		return nil, err
+	}
 
 	return msg, nil
 }
@@ -359,7 +477,7 @@ func (r *Registry) getNullTrackerField(resolvedEntities map[string]struct{}, pre
 }
 
 func (r *Registry) processField(field *protoparserparser.Field, resolvedEntities map[string]struct{}, prefix string) (*schema.Field, error) {
-	fieldNumber, err := strconv.ParseInt(field.FieldNumber, 10, 32)
+	fieldNumber, err := parseFieldNumber(field.FieldNumber, field.FieldName)
 	if err != nil {
 		return nil, err
 	}
@@ -374,13 +492,29 @@ func (r *Registry) processField(field *protoparserparser.Field, resolvedEntities
 		return nil, err
 	}
 	f := &schema.Field{
-		Name:       field.FieldName,
-		Number:     int32(fieldNumber),
-		Label:      fieldLabel,
-		Type:       *fieldType,
-		JsonName:   findJSONName(field.FieldOptions),
-		JSONString: isJSONString(field.FieldOptions),
-		JSONBytes: isJSONBytes(field.FieldOptions),
+		Name:         field.FieldName,
+		Number:       fieldNumber,
+		Label:        fieldLabel,
+		Type:         *fieldType,
+		JsonName:     findJSONName(field.FieldOptions),
+		JSONString:   isJSONString(field.FieldOptions),
+		JSONBytes:    isJSONBytes(field.FieldOptions),
+		DefaultValue: findDefaultValue(field.FieldOptions),
+		Set:          isSet(field.FieldOptions),
+		Options:      fieldOptions(field.FieldOptions),
+
+		Comment:         commentText(field.Comments...),
+		TrailingComment: commentText(field.InlineComment),
+	}
+	if f.Set && f.Label != schema.LabelRepeated {
+		return nil, fmt.Errorf("%s option is only allowed on repeated fields, got it on %s", optionSet, f.Name)
+	}
+	if _, packed := f.Options[optionPacked]; packed && f.Label != schema.LabelRepeated {
+		return nil, fmt.Errorf("%s option is only allowed on repeated fields, got it on %s", optionPacked, f.Name)
+	}
+	// enum references are still KindMessage here, they get resolved in buildDefinitions
+	if hasDefault(f) && (f.Label == schema.LabelRepeated || f.Type.Kind == schema.KindWrapper) {
+		return nil, fmt.Errorf("default value is only allowed on singular scalar or enum fields, got it on %s", f.Name)
 	}
 	if f.JSONString && (f.Type.Kind != schema.KindWrapper || f.Type.WrapperType != schema.WrapperStringValue) {
 		return nil, fmt.Errorf("expected %s type at %s for json_string, got %+v", schema.WrapperStringValue, f.Name, f.Type)
@@ -392,7 +526,7 @@ func (r *Registry) processField(field *protoparserparser.Field, resolvedEntities
 }
 
 func (r *Registry) processMapField(field *protoparserparser.MapField, resolvedEntities map[string]struct{}, prefix string) (*schema.Field, error) {
-	fieldNumber, err := strconv.ParseInt(field.FieldNumber, 10, 32)
+	fieldNumber, err := parseFieldNumber(field.FieldNumber, field.MapName)
 	if err != nil {
 		return nil, err
 	}
@@ -406,7 +540,7 @@ func (r *Registry) processMapField(field *protoparserparser.MapField, resolvedEn
 	}
 	f := &schema.Field{
 		Name:   field.MapName,
-		Number: int32(fieldNumber),
+		Number: fieldNumber,
 		Label:  schema.LabelOptional,
 		Type: schema.FieldType{
 			Kind:     schema.KindMap,
@@ -414,6 +548,16 @@ func (r *Registry) processMapField(field *protoparserparser.MapField, resolvedEn
 			MapValue: mapValueType,
 		},
 		JsonName: findJSONName(field.FieldOptions),
+		Options:  fieldOptions(field.FieldOptions),
+
+		Comment:         commentText(field.Comments...),
+		TrailingComment: commentText(field.InlineComment),
+	}
+	if _, packed := f.Options[optionPacked]; packed {
+		return nil, fmt.Errorf("%s option is only allowed on repeated fields, got it on map field %s", optionPacked, f.Name)
+	}
+	if hasDefault(f) {
+		return nil, fmt.Errorf("default value is only allowed on singular scalar or enum fields, got it on map field %s", f.Name)
 	}
 	return f, nil
 }
@@ -438,6 +582,40 @@ func isJSONBytes(opts []*protoparserparser.FieldOption) bool {
 	return false
 }
 
+// isSet reports whether a repeated field carries the set option set to true,
+// asking the encoder to drop duplicate elements.
+func isSet(opts []*protoparserparser.FieldOption) bool {
+	for _, opt := range opts {
+		if getOptionName(opt.OptionName) == optionSet {
+			return opt.Constant == "true"
+		}
+	}
+	return false
+}
+
+// processExtend parses a top-level extend block. The extension fields are
+// attached to the extended message once all definitions are registered.
+func (r *Registry) processExtend(extend *protoparserparser.Extend, resolvedEntities map[string]struct{}, prefix string) (*schema.Extension, error) {
+	extendee, err := getReferencedType(extend.MessageType, prefix, resolvedEntities)
+	if err != nil {
+		return nil, err
+	}
+	fields := make([]*schema.Field, 0)
+	for _, body := range extend.ExtendBody {
+		if field, ok := body.(*protoparserparser.Field); ok {
+			f, err := r.processField(field, resolvedEntities, prefix)
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, f)
+		}
+	}
+	return &schema.Extension{
+		Extendee: extendee,
+		Fields:   fields,
+	}, nil
+}
+
 func (r *Registry) processService(service *protoparserparser.Service) (*schema.Service, error) {
 	methods := make([]*schema.Method, 0)
 	for _, rpc := range service.ServiceBody {
@@ -474,6 +652,9 @@ func (r *Registry) processEnum(enum *protoparserparser.Enum) (*schema.Enum, erro
 				Name:     b.Ident,
 				Number:   int32(num),
 				JsonName: findJSONNameForEnumValue(b.EnumValueOptions),
+
+				Comment:         commentText(b.Comments...),
+				TrailingComment: commentText(b.InlineComment),
 			})
 		}
 	}
@@ -483,6 +664,47 @@ func (r *Registry) processEnum(enum *protoparserparser.Enum) (*schema.Enum, erro
 	}, nil
 }
 
+// validateProto3Enums checks that every enum of a proto3 file, nested ones
+// included, starts with a 0 value, which is the default of its fields
+func (r *Registry) validateProto3Enums(protoFile *schema.ProtoFile) error {
+	for _, enum := range protoFile.Enums {
+		if err := validateProto3Enum(enum, r.getFullName(protoFile.Package, enum.Name)); err != nil {
+			return err
+		}
+	}
+	var walk func(msg *schema.Message, prefix string) error
+	walk = func(msg *schema.Message, prefix string) error {
+		prefix = r.getFullName(prefix, msg.Name)
+		for _, enum := range msg.NestedEnums {
+			if err := validateProto3Enum(enum, prefix+"."+enum.Name); err != nil {
+				return err
+			}
+		}
+		for _, nested := range msg.NestedTypes {
+			if err := walk(nested, prefix); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, msg := range protoFile.Messages {
+		if err := walk(msg, protoFile.Package); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateProto3Enum(enum *schema.Enum, fullName string) error {
+	if len(enum.Values) == 0 {
+		return fmt.Errorf("enum %s has no values, proto3 enums must start with a 0 value", fullName)
+	}
+	if first := enum.Values[0]; first.Number != 0 {
+		return fmt.Errorf("enum %s starts with %s = %d, proto3 enums must start with a 0 value", fullName, first.Name, first.Number)
+	}
+	return nil
+}
+
 // convertProtoType converts a protobuf type string to a FieldType
 func (r *Registry) convertProtoType(protoType string, allResolvedEntities map[string]struct{}, prefix string) (*schema.FieldType, error) {
 	switch protoType {
@@ -535,14 +757,24 @@ func (r *Registry) convertProtoType(protoType string, allResolvedEntities map[st
 		return &schema.FieldType{Kind: schema.KindWrapper, WrapperType: schema.WrapperStringValue}, nil
 	case "google.protobuf.BytesValue":
 		return &schema.FieldType{Kind: schema.KindWrapper, WrapperType: schema.WrapperBytesValue}, nil
+	case schema.NullValueEnumName:
+		return &schema.FieldType{Kind: schema.KindEnum, EnumType: schema.NullValueEnumName}, nil
 	default:
 		// For non-primitive types, we need to determine if it's an enum or message
 		// This will be resolved later in buildDefinitions after all types are registered
 		// TODO handle error
 		fullResolvedType, err := getReferencedType(protoType, prefix, allResolvedEntities)
 		if err != nil {
+			// wrappers.proto is built in rather than loaded, so a wrapper named with a
+			// leading dot or relative to an enclosing google package resolves here
+			if wrapperName, ok := resolveWrapperName(protoType, prefix); ok {
+				return r.convertProtoType(wrapperName, allResolvedEntities, prefix)
+			}
 			return nil, err
 		}
+		if _, ok := wrapperTypeNames[fullResolvedType]; ok {
+			return r.convertProtoType(fullResolvedType, allResolvedEntities, prefix)
+		}
 		return &schema.FieldType{Kind: schema.KindMessage, MessageType: fullResolvedType}, nil
 	}
 }
@@ -631,10 +863,100 @@ func (r *Registry) buildDefinitions(protoFile *schema.ProtoFile) error {
 		if err := r.resolveMessageFields(message, protoFile.Package); err != nil {
 			return fmt.Errorf("failed to resolve fields in message %s: %w", message.Name, err)
 		}
+		if err := r.createMapEntries(message, r.getFullName(protoFile.Package, message.Name)); err != nil {
+			return fmt.Errorf("failed to create map entries of message %s: %w", message.Name, err)
+		}
+	}
+	for _, extension := range protoFile.Extensions {
+		if err := r.attachExtension(extension, protoFile.Package); err != nil {
+			return fmt.Errorf("failed to extend message %s: %w", extension.Extendee, err)
+		}
+	}
+	return nil
+}
+
+// attachExtension resolves the extension fields and adds them to the extended message
+func (r *Registry) attachExtension(extension *schema.Extension, packageName string) error {
+	msg, err := r.GetMessage(extension.Extendee)
+	if err != nil {
+		return err
+	}
+	for _, field := range extension.Fields {
+		if err := r.resolveFieldType(&field.Type, packageName); err != nil {
+			return fmt.Errorf("failed to resolve extension field %s: %v", field.Name, err)
+		}
+		if existing := findFieldByNumber(msg, field.Number); existing != nil {
+			if existing.Name == field.Name {
+				// already attached by an earlier load of the same file
+				continue
+			}
+			return fmt.Errorf("extension field %s uses number %d already taken by %s", field.Name, field.Number, existing.Name)
+		}
+		msg.Extensions = append(msg.Extensions, field)
+	}
+	return nil
+}
+
+// findFieldByNumber looks up a regular, oneof or extension field by number
+func findFieldByNumber(msg *schema.Message, number int32) *schema.Field {
+	for _, field := range msg.Fields {
+		if field.Number == number {
+			return field
+		}
+	}
+	for _, oneof := range msg.OneofGroups {
+		for _, field := range oneof.Fields {
+			if field.Number == number {
+				return field
+			}
+		}
+	}
+	for _, field := range msg.Extensions {
+		if field.Number == number {
+			return field
+		}
 	}
 	return nil
 }
 
+// RegisterFieldCodec routes the values of a field through codec when encoding
+// and decoding. The field must be a string, bytes or message field, so that
+// its payload is length-delimited on the wire. A nil codec removes it. A codec
+// may be registered while the message is being encoded or decoded elsewhere.
+func (r *Registry) RegisterFieldCodec(messageName, fieldName string, codec schema.FieldCodec) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	msg, err := r.GetMessage(messageName)
+	if err != nil {
+		return err
+	}
+	var field *schema.Field
+	for _, f := range allFields(msg) {
+		if f.Name == fieldName {
+			field = f
+			break
+		}
+	}
+	if field == nil {
+		return fmt.Errorf("field %s not found in message %s", fieldName, messageName)
+	}
+	if field.Type.Kind == schema.KindMap || field.Type.Kind == schema.KindWrapper || field.Type.WireType() != 2 {
+		return fmt.Errorf("field codec needs a string, bytes or message field, %s is %s", fieldName, newTypeInfo(&field.Type).TypeName)
+	}
+	field.SetCodec(codec)
+	return nil
+}
+
+// allFields returns the regular, oneof and extension fields of a message
+func allFields(msg *schema.Message) []*schema.Field {
+	fields := make([]*schema.Field, 0, len(msg.Fields)+len(msg.Extensions))
+	fields = append(fields, msg.Fields...)
+	for _, oneof := range msg.OneofGroups {
+		fields = append(fields, oneof.Fields...)
+	}
+	return append(fields, msg.Extensions...)
+}
+
 // buildServices builds service definitions (placeholder for now)
 func (r *Registry) buildServices(protoFile *schema.ProtoFile) error {
 	// Validate service method input/output types
@@ -674,6 +996,28 @@ func (r *Registry) resolveMessageFields(message *schema.Message, packageName str
 		if err := r.resolveFieldType(&field.Type, packageName); err != nil {
 			return fmt.Errorf("failed to resolve field %s: %v", field.Name, err)
 		}
+		if err := checkDefaultKind(field); err != nil {
+			return err
+		}
+		if field.Set && field.Type.Kind != schema.KindPrimitive && field.Type.Kind != schema.KindEnum {
+			return fmt.Errorf("%s option on field %s requires scalar or enum elements, got %s", optionSet, field.Name, field.Type.Kind)
+		}
+		if _, packed := field.Options[optionPacked]; packed && field.Type.Kind != schema.KindEnum &&
+			(field.Type.Kind != schema.KindPrimitive || !schema.IsPackedType(field.Type.PrimitiveType)) {
+			return fmt.Errorf("%s option on field %s requires numeric, bool or enum elements, got %s", optionPacked, field.Name, newTypeInfo(&field.Type).TypeName)
+		}
+	}
+
+	// oneof members may reference enums too, e.g. Value.null_value
+	for _, oneof := range message.OneofGroups {
+		for _, field := range oneof.Fields {
+			if err := r.resolveFieldType(&field.Type, packageName); err != nil {
+				return fmt.Errorf("failed to resolve field %s: %v", field.Name, err)
+			}
+			if err := checkDefaultKind(field); err != nil {
+				return err
+			}
+		}
 	}
 
 	// Recursively process nested messages
@@ -686,6 +1030,35 @@ func (r *Registry) resolveMessageFields(message *schema.Message, packageName str
 	return nil
 }
 
+// createMapEntries creates the entry messages of the map fields of message and
+// its nested messages, fullName being the fully qualified name of message
+func (r *Registry) createMapEntries(message *schema.Message, fullName string) error {
+	for _, field := range message.Fields {
+		if field.Type.Kind != schema.KindMap {
+			continue
+		}
+		if _, err := r.GetOrCreateNestedMapEntryMessage(fullName, field.Name, field.Type.MapKey, field.Type.MapValue); err != nil {
+			return fmt.Errorf("failed to create map entry of field %s: %v", field.Name, err)
+		}
+	}
+	for _, nestedMsg := range message.NestedTypes {
+		if err := r.createMapEntries(nestedMsg, fullName+"."+nestedMsg.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkDefaultKind rejects a default value on a resolved field that is not a
+// scalar or enum. Enum references only tell apart from messages once resolved,
+// so processField cannot check this.
+func checkDefaultKind(field *schema.Field) error {
+	if hasDefault(field) && field.Type.Kind != schema.KindPrimitive && field.Type.Kind != schema.KindEnum {
+		return fmt.Errorf("default value is only allowed on singular scalar or enum fields, got it on %s field %s", field.Type.Kind, field.Name)
+	}
+	return nil
+}
+
 // resolveFieldType resolves a single field type, determining if it's an enum or message
 func (r *Registry) resolveFieldType(fieldType *schema.FieldType, packageName string) error {
 	// Skip primitive types and wrapper types
@@ -856,12 +1229,163 @@ func (r *Registry) ListServices() []string {
 	return names
 }
 
-// GetOrCreateMapEntryMessage creates a synthetic message type for map entries
+// TypeInfo describes the type of a field, map key or map value
+type TypeInfo struct {
+	Kind     schema.TypeKind `json:"kind"`                // primitive, message, enum, map, wrapper
+	TypeName string          `json:"type_name,omitempty"` // primitive type, or message/enum/wrapper name; empty for maps
+}
+
+// FieldInfo describes a message field for schema introspection
+type FieldInfo struct {
+	TypeInfo
+	Name      string            `json:"name"`                // "user_name"
+	JsonName  string            `json:"json_name,omitempty"` // JSON field name if set
+	Number    int32             `json:"number"`              // 1
+	Label     schema.FieldLabel `json:"label"`               // optional, required, repeated
+	Oneof     string            `json:"oneof,omitempty"`     // containing oneof group, empty if none
+	Extension bool              `json:"extension,omitempty"` // declared in an extend block
+	MapKey    *TypeInfo         `json:"map_key,omitempty"`   // for map fields
+	MapValue  *TypeInfo         `json:"map_value,omitempty"` // for map fields
+	Options   map[string]string `json:"options,omitempty"`   // all declared field options, see schema.Field.Options
+}
+
+// ListFields returns metadata for each field of a message, ordered by field number.
+// The internal null tracker field is not listed.
+func (r *Registry) ListFields(messageName string) ([]FieldInfo, error) {
+	msg, err := r.GetMessage(messageName)
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]FieldInfo, 0, len(msg.Fields)+len(msg.Extensions))
+	for _, field := range msg.Fields {
+		if schema.IsNullTrackerField(field) {
+			continue
+		}
+		infos = append(infos, newFieldInfo(field))
+	}
+	for _, oneof := range msg.OneofGroups {
+		for _, field := range oneof.Fields {
+			info := newFieldInfo(field)
+			info.Oneof = oneof.Name
+			infos = append(infos, info)
+		}
+	}
+	for _, field := range msg.Extensions {
+		info := newFieldInfo(field)
+		info.Extension = true
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Number < infos[j].Number })
+	return infos, nil
+}
+
+// WireFieldInfo describes how a field appears on the wire
+type WireFieldInfo struct {
+	Number   int32  `json:"number"`           // field number in the tag
+	WireType int32  `json:"wire_type"`        // wire type the encoder writes in the tag
+	Name     string `json:"name"`             // proto field name
+	Packed   bool   `json:"packed,omitempty"` // repeated scalars written as one length-delimited record
+}
+
+// FieldWireInfo returns the tag layout of each field of a message, ordered by
+// field number. Packed repeated fields report wire type 2; decoders must still
+// accept their elements unpacked.
+func (r *Registry) FieldWireInfo(messageName string) ([]WireFieldInfo, error) {
+	msg, err := r.GetMessage(messageName)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := allFields(msg)
+	infos := make([]WireFieldInfo, 0, len(fields))
+	for _, field := range fields {
+		info := WireFieldInfo{
+			Number:   field.Number,
+			WireType: field.Type.WireType(),
+			Name:     field.Name,
+		}
+		if field.Label == schema.LabelRepeated {
+			switch field.Type.Kind {
+			case schema.KindPrimitive:
+				info.Packed = schema.IsPackedType(field.Type.PrimitiveType)
+			case schema.KindEnum:
+				info.Packed = true
+			}
+			if info.Packed {
+				info.WireType = 2
+			}
+		}
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Number < infos[j].Number })
+	return infos, nil
+}
+
+func newFieldInfo(field *schema.Field) FieldInfo {
+	info := FieldInfo{
+		TypeInfo: newTypeInfo(&field.Type),
+		Name:     field.Name,
+		JsonName: field.JsonName,
+		Number:   field.Number,
+		Label:    field.Label,
+		Options:  field.Options,
+	}
+	if field.Type.Kind == schema.KindMap {
+		key, value := newTypeInfo(field.Type.MapKey), newTypeInfo(field.Type.MapValue)
+		info.MapKey, info.MapValue = &key, &value
+	}
+	return info
+}
+
+func newTypeInfo(t *schema.FieldType) TypeInfo {
+	info := TypeInfo{Kind: t.Kind}
+	switch t.Kind {
+	case schema.KindPrimitive:
+		info.TypeName = string(t.PrimitiveType)
+	case schema.KindMessage:
+		info.TypeName = t.MessageType
+	case schema.KindEnum:
+		info.TypeName = t.EnumType
+	case schema.KindWrapper:
+		info.TypeName = string(t.WrapperType)
+	}
+	return info
+}
+
+// GetOrCreateMapEntryMessage creates a synthetic message type for map entries,
+// registered under the bare "<mapFieldName>Entry" name.
+//
+// Deprecated: same-named map fields of different messages share the bare name,
+// use GetOrCreateNestedMapEntryMessage instead.
 func (r *Registry) GetOrCreateMapEntryMessage(mapFieldName string, keyType, valueType *schema.FieldType) (*schema.Message, error) {
-	entryTypeName := mapFieldName + "Entry"
+	return r.GetOrCreateNestedMapEntryMessage("", mapFieldName, keyType, valueType)
+}
+
+// GetOrCreateNestedMapEntryMessage creates a synthetic message type for map
+// entries. Entries are namespaced by the fully qualified name of the containing
+// message (e.g. "pkg.User.metadataEntry") so that same-named map fields on
+// different messages do not share a registration. They are kept apart from the
+// declared messages, so GetMessage and ListMessages never see them and a
+// nested message the .proto itself names metadataEntry does not clash; look
+// them up with GetMapEntryMessage. An empty containingMessage registers the
+// entry as a message under the bare "<mapFieldName>Entry" name. Loading a file
+// creates the entries of all its map fields.
+func (r *Registry) GetOrCreateNestedMapEntryMessage(containingMessage, mapFieldName string, keyType, valueType *schema.FieldType) (*schema.Message, error) {
+	entryTypeName := mapEntryName(containingMessage, mapFieldName)
+	table := r.messages
+	if containingMessage != "" {
+		if r.mapEntries == nil {
+			r.mapEntries = make(map[string]*schema.Message)
+		}
+		table = r.mapEntries
+	}
 
 	// Check if already exists
-	if msg, exists := r.messages[entryTypeName]; exists {
+	if msg, exists := table[entryTypeName]; exists {
+		if len(msg.Fields) == 2 && (!sameFieldType(&msg.Fields[0].Type, keyType) || !sameFieldType(&msg.Fields[1].Type, valueType)) {
+			return nil, fmt.Errorf("map entry %s already registered with different key/value types", entryTypeName)
+		}
 		return msg, nil
 	}
 
@@ -886,10 +1410,52 @@ func (r *Registry) GetOrCreateMapEntryMessage(mapFieldName string, keyType, valu
 	}
 
 	// Register it
-	r.messages[entryTypeName] = mapEntryMessage
+	table[entryTypeName] = mapEntryMessage
 	return mapEntryMessage, nil
 }
 
+// GetMapEntryMessage returns the synthetic entry message of the map field
+// mapFieldName of the message with the fully qualified name containingMessage
+func (r *Registry) GetMapEntryMessage(containingMessage, mapFieldName string) (*schema.Message, error) {
+	entryTypeName := mapEntryName(containingMessage, mapFieldName)
+	if msg, exists := r.mapEntries[entryTypeName]; exists {
+		return msg, nil
+	}
+	return nil, fmt.Errorf("map entry not found: %s", entryTypeName)
+}
+
+// mapEntryName returns the name the entry message of a map field is registered under
+func mapEntryName(containingMessage, mapFieldName string) string {
+	if containingMessage == "" {
+		return mapFieldName + "Entry"
+	}
+	return containingMessage + "." + mapFieldName + "Entry"
+}
+
+// sameFieldType reports whether two field types describe the same type
+func sameFieldType(a, b *schema.FieldType) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	if a.Kind != b.Kind {
+		return false
+	}
+	switch a.Kind {
+	case schema.KindPrimitive:
+		return a.PrimitiveType == b.PrimitiveType
+	case schema.KindMessage:
+		return a.MessageType == b.MessageType
+	case schema.KindEnum:
+		return a.EnumType == b.EnumType
+	case schema.KindWrapper:
+		return a.WrapperType == b.WrapperType
+	case schema.KindMap:
+		return sameFieldType(a.MapKey, b.MapKey) && sameFieldType(a.MapValue, b.MapValue)
+	default:
+		return true
+	}
+}
+
 // ListProtoFiles returns all loaded proto file paths
 func (r *Registry) ListProtoFiles() []string {
 	if r.repo == nil {
@@ -902,3 +1468,172 @@ func (r *Registry) ListProtoFiles() []string {
 	}
 	return paths
 }
+
+// ParsedFile returns the syntax tree of a proto file, including the comments,
+// options and source positions that the schema types drop. Files already loaded
+// are served from the parse cache under their load identifier or resolved path;
+// the cached tree is shared and must not be modified. Other files are resolved
+// against ProtoDirectories and parsed without being loaded into the registry.
+func (r *Registry) ParsedFile(path string) (*protoparserparser.Proto, error) {
+	if parsed, ok := r.parsedProtoBody[path]; ok {
+		return parsed, nil
+	}
+	fullPath, err := r.findIfProtoExists(path)
+	if err != nil {
+		return nil, err
+	}
+	if parsed, ok := r.parsedProtoBody[fullPath]; ok {
+		return parsed, nil
+	}
+	return r.parseProtoFile(fullPath)
+}
+
+// ReloadFile re-reads one loaded proto file from disk and rebuilds its
+// definitions together with those of the loaded files importing it, so their
+// references into the changed file are resolved again. Other files are kept as
+// they are. path is the load identifier or a path resolved against
+// ProtoDirectories. The rebuilt messages are new values: field codecs must be
+// registered on them again. If the reload fails the registry may be left
+// partially rebuilt and should be Reset and loaded again.
+func (r *Registry) ReloadFile(path string) error {
+	fullPath := path
+	if _, ok := r.parsedProtoBody[fullPath]; !ok {
+		resolved, err := r.findIfProtoExists(path)
+		if err != nil {
+			return err
+		}
+		fullPath = resolved
+	}
+	if _, ok := r.parsedProtoBody[fullPath]; !ok {
+		return fmt.Errorf("proto file %s is not loaded", path)
+	}
+	protoBytes, err := r.readProtoFile(fullPath)
+	if err != nil {
+		return fmt.Errorf("failed to read file: %w", err)
+	}
+
+	dependents := r.importingFiles(fullPath)
+	rebuilt := map[string]struct{}{fullPath: {}}
+	r.unloadFile(fullPath)
+	for _, file := range dependents {
+		rebuilt[file] = struct{}{}
+		r.unloadFile(file)
+	}
+
+	// parse the new content, plus any import it adds that is not loaded yet
+	delete(r.parsedProtoBody, fullPath)
+	newFiles, err := r.traverseProtoWithDFS(fullPath, protoBytes)
+	if err != nil {
+		return err
+	}
+	for _, protoPath := range newFiles {
+		entities, err := r.resolveProtoFile(protoPath)
+		if err != nil {
+			return fmt.Errorf("failed to load proto file: %w", err)
+		}
+		r.protoEntities[protoPath].entities = entities
+		rebuilt[protoPath] = struct{}{}
+	}
+
+	loadedFiles := make([]*schema.ProtoFile, 0, len(newFiles)+len(dependents))
+	for _, protoPath := range append(newFiles, dependents...) {
+		protofile, err := r.loadSingleProtoFile(protoPath)
+		if err != nil {
+			return fmt.Errorf("failed to load proto file: %w", err)
+		}
+		loadedFiles = append(loadedFiles, protofile)
+	}
+	if err := r.buildSymbolTable(loadedFiles); err != nil {
+		return err
+	}
+
+	// extensions declared elsewhere onto the rebuilt messages are attached again
+	for filePath, protoFile := range r.repo.ProtoFiles {
+		if _, ok := rebuilt[filePath]; ok {
+			continue
+		}
+		for _, extension := range protoFile.Extensions {
+			if err := r.attachExtension(extension, protoFile.Package); err != nil {
+				return fmt.Errorf("failed to extend message %s: %w", extension.Extendee, err)
+			}
+		}
+	}
+	return nil
+}
+
+// importingFiles returns the loaded files that import filePath, directly or
+// through a public import
+func (r *Registry) importingFiles(filePath string) []string {
+	var files []string
+	for file, entity := range r.protoEntities {
+		if file == filePath {
+			continue
+		}
+		for _, imported := range entity.imports {
+			if imported == filePath {
+				files = append(files, file)
+				break
+			}
+		}
+	}
+	sort.Strings(files)
+	return files
+}
+
+// unloadFile removes the messages, enums, services and extension fields that
+// the loaded file filePath registered
+func (r *Registry) unloadFile(filePath string) {
+	protoFile, ok := r.repo.ProtoFiles[filePath]
+	if !ok {
+		return
+	}
+	for _, extension := range protoFile.Extensions {
+		msg, err := r.GetMessage(extension.Extendee)
+		if err != nil {
+			continue
+		}
+		kept := msg.Extensions[:0]
+		for _, field := range msg.Extensions {
+			if !containsField(extension.Fields, field) {
+				kept = append(kept, field)
+			}
+		}
+		msg.Extensions = kept
+	}
+	var unregister func(prefix string, msg *schema.Message)
+	unregister = func(prefix string, msg *schema.Message) {
+		name := r.getFullName(prefix, msg.Name)
+		delete(r.messages, name)
+		for _, field := range msg.Fields {
+			if field.Type.Kind == schema.KindMap {
+				delete(r.mapEntries, mapEntryName(name, field.Name))
+			}
+		}
+		for _, enum := range msg.NestedEnums {
+			delete(r.enums, name+"."+enum.Name)
+		}
+		for _, nested := range msg.NestedTypes {
+			unregister(name, nested)
+		}
+	}
+	for _, msg := range protoFile.Messages {
+		unregister(protoFile.Package, msg)
+	}
+	for _, enum := range protoFile.Enums {
+		delete(r.enums, r.getFullName(protoFile.Package, enum.Name))
+	}
+	for _, service := range protoFile.Services {
+		delete(r.services, r.getFullName(protoFile.Package, service.Name))
+	}
+	delete(r.repo.ProtoFiles, filePath)
+}
+
+// containsField reports whether field is one of fields
+func containsField(fields []*schema.Field, field *schema.Field) bool {
+	for _, f := range fields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
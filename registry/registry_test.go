@@ -1,12 +1,17 @@
 package registry
 
 import (
+	"archive/zip"
+	"bytes"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
+	"testing/fstest"
 
 	"github.com/anirudhraja/protolite/schema"
+	protoparserparser "github.com/yoheimuta/go-protoparser/v4/parser"
 )
 
 func TestNewRegistry(t *testing.T) {
@@ -587,6 +592,131 @@ func TestGetOrCreateMapEntryMessage_Existing(t *testing.T) {
 	}
 }
 
+func TestGetOrCreateNestedMapEntryMessage(t *testing.T) {
+	registry := NewRegistry([]string{""})
+	registry.messages = make(map[string]*schema.Message)
+
+	stringType := &schema.FieldType{Kind: schema.KindPrimitive, PrimitiveType: schema.TypeString}
+	int64Type := &schema.FieldType{Kind: schema.KindPrimitive, PrimitiveType: schema.TypeInt64}
+
+	userEntry, err := registry.GetOrCreateNestedMapEntryMessage("pkg.User", "metadata", stringType, stringType)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	postEntry, err := registry.GetOrCreateNestedMapEntryMessage("pkg.Post", "metadata", stringType, int64Type)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if userEntry == postEntry {
+		t.Fatal("Expected distinct map entry messages for User.metadata and Post.metadata")
+	}
+	if userEntry.Name != "pkg.User.metadataEntry" || postEntry.Name != "pkg.Post.metadataEntry" {
+		t.Errorf("Unexpected entry names %q and %q", userEntry.Name, postEntry.Name)
+	}
+	if postEntry.Fields[1].Type.PrimitiveType != schema.TypeInt64 {
+		t.Errorf("Expected Post.metadata value type int64, got %s", postEntry.Fields[1].Type.PrimitiveType)
+	}
+
+	// Re-registering with conflicting types must not silently reuse the entry.
+	if _, err := registry.GetOrCreateNestedMapEntryMessage("pkg.User", "metadata", stringType, int64Type); err == nil {
+		t.Error("Expected error for conflicting map entry types")
+	}
+}
+
+func TestLoadSchema_MapEntriesPerMessage(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, body string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(body), 0644); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+	}
+	write("blog.proto", `syntax = "proto3";
+package blog;
+
+message User {
+  map<string, string> metadata = 1;
+  map<string, string> labels = 2;
+  message labelsEntry {
+    int32 id = 1;
+  }
+}
+
+message Post {
+  map<string, int64> metadata = 1;
+  message Draft {
+    map<int32, bool> metadata = 1;
+  }
+}
+`)
+	r := NewRegistry([]string{dir})
+	if err := r.LoadSchemaFile("blog.proto"); err != nil {
+		t.Fatalf("LoadSchemaFile: %v", err)
+	}
+
+	valueTypes := map[string]schema.PrimitiveType{
+		"blog.User":       schema.TypeString,
+		"blog.Post":       schema.TypeInt64,
+		"blog.Post.Draft": schema.TypeBool,
+	}
+	for name, want := range valueTypes {
+		entry, err := r.GetMapEntryMessage(name, "metadata")
+		if err != nil {
+			t.Fatalf("GetMapEntryMessage(%s): %v", name, err)
+		}
+		if !entry.MapEntry || len(entry.Fields) != 2 || entry.Fields[1].Type.PrimitiveType != want {
+			t.Errorf("Expected %s.metadata entry with %s values, got %+v", name, want, entry)
+		}
+	}
+
+	// entries stay out of the message table and leave declared messages alone
+	if _, err := r.GetMessage("blog.User.metadataEntry"); err == nil {
+		t.Error("Expected GetMessage not to find the synthetic metadata entry")
+	}
+	for _, name := range r.ListMessages() {
+		if strings.HasSuffix(name, ".metadataEntry") {
+			t.Errorf("Expected ListMessages to leave out map entries, got %s", name)
+		}
+	}
+	declared, err := r.GetMessage("blog.User.labelsEntry")
+	if err != nil {
+		t.Fatalf("GetMessage: %v", err)
+	}
+	if declared.MapEntry || len(declared.Fields) != 1 || declared.Fields[0].Name != "id" {
+		t.Errorf("Expected the declared labelsEntry message, got %+v", declared)
+	}
+	labels, err := r.GetMapEntryMessage("blog.User", "labels")
+	if err != nil {
+		t.Fatalf("GetMapEntryMessage: %v", err)
+	}
+	if labels == declared || !labels.MapEntry {
+		t.Errorf("Expected a synthetic labels entry, got %+v", labels)
+	}
+
+	// reloading with a changed value type replaces the entry rather than conflicting with it
+	write("blog.proto", `syntax = "proto3";
+package blog;
+
+message User {
+  map<string, double> metadata = 1;
+}
+`)
+	if err := r.ReloadFile("blog.proto"); err != nil {
+		t.Fatalf("ReloadFile: %v", err)
+	}
+	entry, err := r.GetMapEntryMessage("blog.User", "metadata")
+	if err != nil {
+		t.Fatalf("GetMapEntryMessage: %v", err)
+	}
+	if got := entry.Fields[1].Type.PrimitiveType; got != schema.TypeDouble {
+		t.Errorf("Expected reloaded entry with double values, got %s", got)
+	}
+	if _, err := r.GetMapEntryMessage("blog.Post", "metadata"); err == nil {
+		t.Error("Expected the Post.metadata entry to be unloaded with Post")
+	}
+}
+
 func TestRegisterNames(t *testing.T) {
 	registry := NewRegistry([]string{""})
 	registry.messages = make(map[string]*schema.Message)
@@ -1307,3 +1437,1049 @@ message Bad {
 		t.Errorf("error should mention json_bytes, got: %v", err)
 	}
 }
+
+func TestSetOption_RejectedOnNonRepeatedField(t *testing.T) {
+	content := `syntax = "proto3";
+package test.set;
+
+message Bad {
+  string tag = 1 [set = true];
+}
+`
+	r, protoPath := loadProto(t, content)
+	file, err := os.Open(protoPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	err = r.LoadSchema(file, protoPath)
+	if err == nil {
+		t.Fatalf("expected error for set on non-repeated field, got nil")
+	}
+	if !strings.Contains(err.Error(), "set option") {
+		t.Errorf("error should mention the set option, got: %v", err)
+	}
+}
+
+func TestPackedOption_OnlyOnRepeatedScalars(t *testing.T) {
+	tests := []struct {
+		name  string
+		field string
+	}{
+		{"singular scalar", "int32 count = 1 [packed = true];"},
+		{"repeated string", "repeated string tags = 1 [packed = true];"},
+		{"repeated message", "repeated Item items = 1 [packed = false];"},
+		{"map", "map<string, int32> counts = 1 [packed = true];"},
+		{"oneof member", "oneof choice { int32 id = 1 [packed = true]; }"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content := "syntax = \"proto3\";\npackage test.packed;\n\nmessage Item {}\n\nmessage Bad {\n  " + tt.field + "\n}\n"
+			r := NewRegistry([]string{""})
+			err := r.LoadSchema(strings.NewReader(content), "packed.proto")
+			if err == nil || !strings.Contains(err.Error(), "packed option") {
+				t.Errorf("expected a packed option error, got %v", err)
+			}
+		})
+	}
+
+	valid := `syntax = "proto3";
+package test.packed;
+
+enum Kind {
+  KIND_UNKNOWN = 0;
+}
+
+message Good {
+  repeated int32 ids = 1 [packed = false];
+  repeated Kind kinds = 2 [packed = true];
+  repeated double scores = 3 [packed = true];
+}
+`
+	if err := NewRegistry([]string{""}).LoadSchema(strings.NewReader(valid), "packed.proto"); err != nil {
+		t.Errorf("expected packed on repeated scalars and enums to load, got %v", err)
+	}
+}
+
+func TestDefaultOption_OnlyOnSingularScalarsAndEnums(t *testing.T) {
+	tests := []struct {
+		name  string
+		field string
+	}{
+		{"message", "optional Item item = 1 [default = 1];"},
+		{"map", "map<string, int32> counts = 1 [default = 1];"},
+		{"repeated", "repeated int32 ids = 1 [default = 1];"},
+		{"wrapper", "optional google.protobuf.Int32Value count = 1 [default = 1];"},
+		{"empty string on repeated", "repeated string tags = 1 [default = \"\"];"},
+		{"oneof message member", "oneof choice { Item item = 1 [default = 1]; }"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content := "syntax = \"proto2\";\npackage test.defaults;\n\nimport \"google/protobuf/wrappers.proto\";\n\nmessage Item {}\n\nmessage Bad {\n  " + tt.field + "\n}\n"
+			r := NewRegistry([]string{""})
+			err := r.LoadSchema(strings.NewReader(content), "defaults.proto")
+			if err == nil || !strings.Contains(err.Error(), "default value") {
+				t.Errorf("expected a default value error, got %v", err)
+			}
+		})
+	}
+
+	valid := `syntax = "proto2";
+package test.defaults;
+
+enum Mode {
+  MODE_SLOW = 0;
+  MODE_FAST = 1;
+}
+
+message Good {
+  optional Mode mode = 1 [default = MODE_FAST];
+  optional string region = 2 [default = 'us-east'];
+  optional string zone = 3 [default = "eu-west"];
+}
+`
+	r := NewRegistry([]string{""})
+	if err := r.LoadSchema(strings.NewReader(valid), "defaults.proto"); err != nil {
+		t.Fatalf("expected defaults on scalars and enums to load, got %v", err)
+	}
+	good, err := r.GetMessage("test.defaults.Good")
+	if err != nil {
+		t.Fatalf("GetMessage: %v", err)
+	}
+	for i, want := range []string{"MODE_FAST", "us-east", "eu-west"} {
+		if got := good.Fields[i].DefaultValue; got != want {
+			t.Errorf("%s: expected default %q, got %q", good.Fields[i].Name, want, got)
+		}
+	}
+}
+
+func TestListFields(t *testing.T) {
+	content := `syntax = "proto3";
+package test.fields;
+
+enum Role {
+  ROLE_UNKNOWN = 0;
+  ROLE_ADMIN = 1;
+}
+
+message Address {
+  string city = 1;
+}
+
+message User {
+  string user_name = 1 [json_name = "login"];
+  repeated Role roles = 2;
+  map<string, Address> addresses = 3;
+  oneof contact {
+    string email = 4;
+    int64 phone = 5;
+  }
+}
+`
+	r := NewRegistry([]string{""})
+	if err := r.LoadSchema(strings.NewReader(content), "test.proto"); err != nil {
+		t.Fatalf("LoadSchema: %v", err)
+	}
+
+	fields, err := r.ListFields("test.fields.User")
+	if err != nil {
+		t.Fatalf("ListFields: %v", err)
+	}
+	expected := []FieldInfo{
+		{TypeInfo: TypeInfo{Kind: schema.KindPrimitive, TypeName: "string"}, Name: "user_name", JsonName: "login", Number: 1, Label: schema.LabelOptional, Options: map[string]string{"json_name": "login"}},
+		{TypeInfo: TypeInfo{Kind: schema.KindEnum, TypeName: "test.fields.Role"}, Name: "roles", Number: 2, Label: schema.LabelRepeated},
+		{
+			TypeInfo: TypeInfo{Kind: schema.KindMap}, Name: "addresses", Number: 3, Label: schema.LabelOptional,
+			MapKey:   &TypeInfo{Kind: schema.KindPrimitive, TypeName: "string"},
+			MapValue: &TypeInfo{Kind: schema.KindMessage, TypeName: "test.fields.Address"},
+		},
+		{TypeInfo: TypeInfo{Kind: schema.KindPrimitive, TypeName: "string"}, Name: "email", Number: 4, Label: schema.LabelOptional, Oneof: "contact"},
+		{TypeInfo: TypeInfo{Kind: schema.KindPrimitive, TypeName: "int64"}, Name: "phone", Number: 5, Label: schema.LabelOptional, Oneof: "contact"},
+	}
+	if !reflect.DeepEqual(fields, expected) {
+		t.Errorf("ListFields mismatch:\nexpected %+v\ngot      %+v", expected, fields)
+	}
+
+	if _, err := r.ListFields("test.fields.Missing"); err == nil {
+		t.Error("Expected error for unknown message")
+	}
+}
+
+func TestFieldNumber_RejectedOutOfRange(t *testing.T) {
+	tests := []struct {
+		name   string
+		field  string
+		errMsg string
+	}{
+		{"zero", "string name = 0;", "out of range"},
+		{"too_large", "string name = 536870912;", "out of range"},
+		{"reserved_range", "string name = 19500;", "reserved range"},
+		{"map_field", "map<string, string> labels = 19000;", "reserved range"},
+		{"oneof_field", "oneof choice { string a = 1; int32 b = 999999999; }", "out of range"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content := "syntax = \"proto3\";\npackage test.numbers;\n\nmessage Bad {\n  " + tt.field + "\n}\n"
+			r, protoPath := loadProto(t, content)
+			file, err := os.Open(protoPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer file.Close()
+
+			err = r.LoadSchema(file, protoPath)
+			if err == nil {
+				t.Fatalf("expected error for %s, got nil", tt.field)
+			}
+			if !strings.Contains(err.Error(), tt.errMsg) {
+				t.Errorf("error should mention %q, got: %v", tt.errMsg, err)
+			}
+		})
+	}
+
+	r, protoPath := loadProto(t, "syntax = \"proto3\";\npackage test.numbers;\n\nmessage Good {\n  string name = 536870911;\n  int32 id = 18999;\n  int32 next = 20000;\n}\n")
+	file, err := os.Open(protoPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if err := r.LoadSchema(file, protoPath); err != nil {
+		t.Errorf("expected boundary field numbers to load, got: %v", err)
+	}
+}
+
+func TestWeakImport_MissingFileSkipped(t *testing.T) {
+	content := `syntax = "proto3";
+package test.weak;
+
+import weak "instrumentation/trace.proto";
+
+message Event {
+  string name = 1;
+}
+`
+	r, protoPath := loadProto(t, content)
+	file, err := os.Open(protoPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	if err := r.LoadSchema(file, protoPath); err != nil {
+		t.Fatalf("expected missing weak import to be skipped, got: %v", err)
+	}
+	if _, err := r.GetMessage("test.weak.Event"); err != nil {
+		t.Errorf("expected Event to be registered: %v", err)
+	}
+	warnings := r.Warnings()
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "instrumentation/trace.proto") {
+		t.Errorf("expected one warning about the weak import, got %v", warnings)
+	}
+
+	// a regular import of a missing file still fails the load
+	r, protoPath = loadProto(t, strings.Replace(content, "import weak", "import", 1))
+	strict, err := os.Open(protoPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer strict.Close()
+	if err := r.LoadSchema(strict, protoPath); err == nil {
+		t.Errorf("expected missing non-weak import to fail the load")
+	}
+}
+
+func TestFieldWireInfo(t *testing.T) {
+	content := `syntax = "proto3";
+package test.wire;
+
+import "google/protobuf/wrappers.proto";
+
+enum Role {
+  ROLE_UNKNOWN = 0;
+  ROLE_ADMIN = 1;
+}
+
+message Address {
+  string city = 1;
+}
+
+message User {
+  double score = 9;
+  string user_name = 1;
+  repeated Role roles = 2;
+  repeated string tags = 3;
+  map<string, Address> addresses = 4;
+  google.protobuf.Int32Value age = 5;
+  fixed32 flags = 6;
+  repeated sfixed64 ids = 7;
+  oneof contact {
+    Address home = 8;
+  }
+}
+`
+	r := NewRegistry([]string{""})
+	if err := r.LoadSchema(strings.NewReader(content), "test.proto"); err != nil {
+		t.Fatalf("LoadSchema: %v", err)
+	}
+
+	infos, err := r.FieldWireInfo("test.wire.User")
+	if err != nil {
+		t.Fatalf("FieldWireInfo: %v", err)
+	}
+	expected := []WireFieldInfo{
+		{Number: 1, WireType: 2, Name: "user_name"},
+		{Number: 2, WireType: 2, Name: "roles", Packed: true},
+		{Number: 3, WireType: 2, Name: "tags"},
+		{Number: 4, WireType: 2, Name: "addresses"},
+		{Number: 5, WireType: 2, Name: "age"},
+		{Number: 6, WireType: 5, Name: "flags"},
+		{Number: 7, WireType: 2, Name: "ids", Packed: true},
+		{Number: 8, WireType: 2, Name: "home"},
+		{Number: 9, WireType: 1, Name: "score"},
+	}
+	if !reflect.DeepEqual(infos, expected) {
+		t.Errorf("FieldWireInfo mismatch:\nexpected %+v\ngot      %+v", expected, infos)
+	}
+
+	if _, err := r.FieldWireInfo("test.wire.Missing"); err == nil {
+		t.Error("Expected error for unknown message")
+	}
+}
+
+func TestParsedFile(t *testing.T) {
+	dir := t.TempDir()
+	onDisk := `syntax = "proto3";
+package test.docs;
+
+// Account is a user account.
+message Account {
+  string id = 1;
+}
+`
+	if err := os.WriteFile(filepath.Join(dir, "account.proto"), []byte(onDisk), 0644); err != nil {
+		t.Fatal(err)
+	}
+	r := NewRegistry([]string{dir})
+
+	// a file that has not been loaded is parsed from the proto directories
+	parsed, err := r.ParsedFile("account.proto")
+	if err != nil {
+		t.Fatalf("ParsedFile: %v", err)
+	}
+	var account *protoparserparser.Message
+	for _, body := range parsed.ProtoBody {
+		if m, ok := body.(*protoparserparser.Message); ok && m.MessageName == "Account" {
+			account = m
+		}
+	}
+	if account == nil {
+		t.Fatal("Expected Account message in parsed file")
+	}
+	if len(account.Comments) != 1 || account.Comments[0].Raw != "// Account is a user account." {
+		t.Errorf("Expected leading comment on Account, got %+v", account.Comments)
+	}
+	if _, err := r.GetMessage("test.docs.Account"); err == nil {
+		t.Error("Expected ParsedFile not to load the file into the registry")
+	}
+
+	// a loaded file is served from the parse cache under its identifier
+	loaded := `syntax = "proto3";
+package test.docs;
+
+message Session {
+  string token = 1; // opaque
+}
+`
+	if err := r.LoadSchema(strings.NewReader(loaded), "session.proto"); err != nil {
+		t.Fatalf("LoadSchema: %v", err)
+	}
+	first, err := r.ParsedFile("session.proto")
+	if err != nil {
+		t.Fatalf("ParsedFile: %v", err)
+	}
+	second, err := r.ParsedFile("session.proto")
+	if err != nil {
+		t.Fatalf("ParsedFile: %v", err)
+	}
+	if first != second {
+		t.Error("Expected loaded file to be served from the parse cache")
+	}
+
+	if _, err := r.ParsedFile("missing.proto"); err == nil {
+		t.Error("Expected error for missing file")
+	}
+}
+
+func TestComments_CarriedIntoSchema(t *testing.T) {
+	content := `syntax = "proto3";
+package test.comments;
+
+// Account is a user account.
+// It is keyed by id.
+message Account { // accounts table
+  // id is the primary key.
+  string id = 1; // immutable
+  /*
+   * tags label the account.
+   */
+  map<string, string> tags = 2;
+  oneof contact {
+    // email is the preferred contact.
+    string email = 3;
+  }
+  string plain = 4;
+}
+
+enum Status {
+  // the status is not known
+  STATUS_UNKNOWN = 0;
+  STATUS_ACTIVE = 1; // in use
+}
+`
+	r := NewRegistry([]string{""})
+	if err := r.LoadSchema(strings.NewReader(content), "test.proto"); err != nil {
+		t.Fatalf("LoadSchema: %v", err)
+	}
+
+	msg, err := r.GetMessage("test.comments.Account")
+	if err != nil {
+		t.Fatalf("GetMessage: %v", err)
+	}
+	if msg.Comment != "Account is a user account.\nIt is keyed by id." {
+		t.Errorf("unexpected message comment %q", msg.Comment)
+	}
+	if msg.TrailingComment != "accounts table" {
+		t.Errorf("unexpected message trailing comment %q", msg.TrailingComment)
+	}
+
+	fields := map[string]*schema.Field{}
+	for _, f := range allFields(msg) {
+		fields[f.Name] = f
+	}
+	expected := map[string][2]string{
+		"id":    {"id is the primary key.", "immutable"},
+		"tags":  {"tags label the account.", ""},
+		"email": {"email is the preferred contact.", ""},
+		"plain": {"", ""},
+	}
+	for name, want := range expected {
+		f := fields[name]
+		if f == nil {
+			t.Fatalf("field %s not found", name)
+		}
+		if f.Comment != want[0] || f.TrailingComment != want[1] {
+			t.Errorf("field %s: expected comments %q/%q, got %q/%q", name, want[0], want[1], f.Comment, f.TrailingComment)
+		}
+	}
+
+	enum, err := r.GetEnum("test.comments.Status")
+	if err != nil {
+		t.Fatalf("GetEnum: %v", err)
+	}
+	if got := enum.Values[0].Comment; got != "the status is not known" {
+		t.Errorf("unexpected enum value comment %q", got)
+	}
+	if got := enum.Values[1].TrailingComment; got != "in use" {
+		t.Errorf("unexpected enum value trailing comment %q", got)
+	}
+}
+
+func TestFieldOptions_Passthrough(t *testing.T) {
+	content := `syntax = "proto3";
+package test.options;
+
+message Signup {
+  string email = 1 [(validate.rules).string.email = true, deprecated = true];
+  string name = 2 [(validate.rules) = {string: {min_len: 1, max_len: 64}}, json_name = "displayName"];
+  map<string, string> labels = 3 [(custom.label) = "meta"];
+  oneof contact {
+    string phone = 4 [(validate.rules).string.pattern = "^[0-9]+$"];
+  }
+  int32 age = 5;
+}
+`
+	r := NewRegistry([]string{""})
+	if err := r.LoadSchema(strings.NewReader(content), "test.proto"); err != nil {
+		t.Fatalf("LoadSchema: %v", err)
+	}
+	msg, err := r.GetMessage("test.options.Signup")
+	if err != nil {
+		t.Fatalf("GetMessage: %v", err)
+	}
+
+	expected := map[string]map[string]string{
+		"email":  {"(validate.rules).string.email": "true", "deprecated": "true"},
+		"name":   {"(validate.rules)": "{string:{min_len:1,max_len:64}}", "json_name": "displayName"},
+		"labels": {"(custom.label)": "meta"},
+		"phone":  {"(validate.rules).string.pattern": "^[0-9]+$"},
+		"age":    nil,
+	}
+	for _, field := range allFields(msg) {
+		want, ok := expected[field.Name]
+		if !ok {
+			continue
+		}
+		if !reflect.DeepEqual(field.Options, want) {
+			t.Errorf("field %s: expected options %v, got %v", field.Name, want, field.Options)
+		}
+	}
+
+	infos, err := r.ListFields("test.options.Signup")
+	if err != nil {
+		t.Fatalf("ListFields: %v", err)
+	}
+	if got := infos[0].Options["(validate.rules).string.email"]; got != "true" {
+		t.Errorf("Expected ListFields to expose options, got %v", infos[0].Options)
+	}
+}
+
+func TestReset(t *testing.T) {
+	r := NewRegistry([]string{""})
+	v1 := `syntax = "proto3";
+package reload;
+
+message User {
+  string name = 1;
+}
+
+message Legacy {
+  int32 id = 1;
+}
+`
+	if err := r.LoadSchema(strings.NewReader(v1), "reload.proto"); err != nil {
+		t.Fatalf("LoadSchema v1: %v", err)
+	}
+
+	r.Reset()
+	if _, err := r.GetMessage("reload.User"); err == nil {
+		t.Errorf("Expected no messages after Reset")
+	}
+
+	v2 := `syntax = "proto3";
+package reload;
+
+message User {
+  string name = 1;
+  string email = 2;
+}
+`
+	if err := r.LoadSchema(strings.NewReader(v2), "reload.proto"); err != nil {
+		t.Fatalf("LoadSchema v2: %v", err)
+	}
+	user, err := r.GetMessage("reload.User")
+	if err != nil {
+		t.Fatalf("GetMessage: %v", err)
+	}
+	if len(user.Fields) != 2 || user.Fields[1].Name != "email" {
+		t.Errorf("Expected the reloaded User definition, got %+v", user.Fields)
+	}
+	if _, err := r.GetMessage("reload.Legacy"); err == nil {
+		t.Errorf("Expected Legacy to be unloaded")
+	}
+	if _, err := r.GetEnum(schema.NullValueEnumName); err != nil {
+		t.Errorf("Expected built-in enums to be restored on reload: %v", err)
+	}
+}
+
+func TestReloadFile(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, body string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(body), 0644); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+	}
+	write("common.proto", `syntax = "proto2";
+package shop;
+
+message Address {
+  optional string city = 1;
+}
+
+message Tier {
+  optional int32 level = 1;
+}
+`)
+	write("user.proto", `syntax = "proto2";
+package shop;
+
+import "common.proto";
+
+message User {
+  optional Address address = 1;
+  optional Tier tier = 2;
+  extensions 100 to 200;
+}
+`)
+	write("ext.proto", `syntax = "proto2";
+package shop;
+
+import "user.proto";
+
+extend User {
+  optional string nickname = 100;
+}
+`)
+
+	r := NewRegistry([]string{dir})
+	root := filepath.Join(dir, "ext.proto")
+	content, err := os.ReadFile(root)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if err := r.LoadSchema(strings.NewReader(string(content)), root); err != nil {
+		t.Fatalf("LoadSchema: %v", err)
+	}
+
+	// Tier turns into an enum and Address gains a field
+	write("common.proto", `syntax = "proto2";
+package shop;
+
+message Address {
+  optional string city = 1;
+  optional string zip = 2;
+}
+
+enum Tier {
+  TIER_FREE = 0;
+  TIER_PRO = 1;
+}
+`)
+	if err := r.ReloadFile("common.proto"); err != nil {
+		t.Fatalf("ReloadFile: %v", err)
+	}
+
+	address, err := r.GetMessage("shop.Address")
+	if err != nil {
+		t.Fatalf("GetMessage: %v", err)
+	}
+	if len(address.Fields) != 2 || address.Fields[1].Name != "zip" {
+		t.Errorf("Expected reloaded Address fields, got %+v", address.Fields)
+	}
+	if _, err := r.GetMessage("shop.Tier"); err == nil {
+		t.Errorf("Expected the old Tier message to be unloaded")
+	}
+	if _, err := r.GetEnum("shop.Tier"); err != nil {
+		t.Errorf("Expected Tier enum: %v", err)
+	}
+
+	user, err := r.GetMessage("shop.User")
+	if err != nil {
+		t.Fatalf("GetMessage: %v", err)
+	}
+	if tier := user.Fields[1].Type; tier.Kind != schema.KindEnum || tier.EnumType != "shop.Tier" {
+		t.Errorf("Expected User.tier to be re-resolved to the enum, got %+v", tier)
+	}
+	if len(user.Extensions) != 1 || user.Extensions[0].Name != "nickname" {
+		t.Errorf("Expected the nickname extension to stay attached, got %+v", user.Extensions)
+	}
+
+	// a reference left dangling by the change is reported
+	write("common.proto", `syntax = "proto2";
+package shop;
+
+message Address {
+  optional string city = 1;
+}
+`)
+	if err := r.ReloadFile("common.proto"); err == nil || !strings.Contains(err.Error(), "Tier") {
+		t.Errorf("Expected an error for the removed Tier type, got %v", err)
+	}
+
+	if err := r.ReloadFile("missing.proto"); err == nil {
+		t.Errorf("Expected an error for a file that was never loaded")
+	}
+}
+
+func TestLoadSchemaFromFS_Zip(t *testing.T) {
+	files := map[string]string{
+		"schemas/common.proto": `syntax = "proto3";
+package shop;
+
+message Money {
+  int64 units = 1;
+}
+`,
+		"schemas/order.proto": `syntax = "proto3";
+package shop;
+
+import "common.proto";
+
+message Order {
+  string id = 1;
+  Money total = 2;
+}
+`,
+	}
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, body := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatalf("zip Create: %v", err)
+		}
+		if _, err := w.Write([]byte(body)); err != nil {
+			t.Fatalf("zip Write: %v", err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("zip Close: %v", err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("zip NewReader: %v", err)
+	}
+
+	r := NewRegistry([]string{"schemas"})
+	if err := r.LoadSchemaFromFS(zr, "order.proto"); err != nil {
+		t.Fatalf("LoadSchemaFromFS: %v", err)
+	}
+	order, err := r.GetMessage("shop.Order")
+	if err != nil {
+		t.Fatalf("GetMessage: %v", err)
+	}
+	if got := order.Fields[1].Type.MessageType; got != "shop.Money" {
+		t.Errorf("Expected total to resolve to shop.Money, got %q", got)
+	}
+	if _, err := r.GetMessage("shop.Money"); err != nil {
+		t.Errorf("Expected the import to be loaded from the archive: %v", err)
+	}
+	// the archive is not kept, loaded files are served from the parse cache
+	if _, err := r.ParsedFile("schemas/common.proto"); err != nil {
+		t.Errorf("ParsedFile: %v", err)
+	}
+
+	if err := r.LoadSchemaFromFS(zr, "missing.proto"); err == nil {
+		t.Errorf("Expected an error for a file missing from the archive")
+	}
+}
+
+func TestLoadSchemaFromFS_DoesNotReplaceDiskLoading(t *testing.T) {
+	dir := t.TempDir()
+	write := func(body string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, "account.proto"), []byte(body), 0644); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+	}
+	write(`syntax = "proto3";
+package disk;
+
+message Account {
+  string id = 1;
+}
+`)
+	fsys := fstest.MapFS{
+		"event.proto": {Data: []byte(`syntax = "proto3";
+package embedded;
+
+message Event {
+  string name = 1;
+}
+`)},
+	}
+
+	// the absolute temp dir is not a valid fs.FS path, so the FS load resolves through "."
+	r := NewRegistry([]string{dir, "."})
+	if err := r.LoadSchemaFromFS(fsys, "event.proto"); err != nil {
+		t.Fatalf("LoadSchemaFromFS: %v", err)
+	}
+	if err := r.LoadSchemaFile("account.proto"); err != nil {
+		t.Fatalf("Expected a disk load after the FS load to succeed: %v", err)
+	}
+
+	write(`syntax = "proto3";
+package disk;
+
+message Account {
+  string id = 1;
+  string email = 2;
+}
+`)
+	if err := r.ReloadFile("account.proto"); err != nil {
+		t.Fatalf("Expected a disk reload after the FS load to succeed: %v", err)
+	}
+	account, err := r.GetMessage("disk.Account")
+	if err != nil {
+		t.Fatalf("GetMessage: %v", err)
+	}
+	if len(account.Fields) != 2 {
+		t.Errorf("Expected the reloaded Account fields, got %+v", account.Fields)
+	}
+	if _, err := r.GetMessage("embedded.Event"); err != nil {
+		t.Errorf("Expected the FS loaded message to stay registered: %v", err)
+	}
+}
+
+func TestJSONName_KeywordAndOptionForms(t *testing.T) {
+	r := NewRegistry([]string{""})
+	content := `syntax = "proto3";
+package names;
+
+message Profile {
+  string user_name = 1 [json_name = "userName"];
+  string nick_name = 2 [json_name = 'nick'];
+  string home_city = 3 [(field).json_name = "homeCity"];
+  string both = 4 [(field).json_name = "ignored", json_name = "bothKeyword"];
+  map<string, string> extra_info = 5 [json_name = "extraInfo"];
+  string plain_name = 6;
+  oneof contact {
+    string email_address = 7 [(field).json_name = "email"];
+  }
+}
+`
+	if err := r.LoadSchema(strings.NewReader(content), "names.proto"); err != nil {
+		t.Fatalf("LoadSchema: %v", err)
+	}
+	msg, err := r.GetMessage("names.Profile")
+	if err != nil {
+		t.Fatalf("GetMessage: %v", err)
+	}
+	expected := map[string]string{
+		"user_name":     "userName",
+		"nick_name":     "nick",
+		"home_city":     "homeCity",
+		"both":          "bothKeyword",
+		"extra_info":    "extraInfo",
+		"plain_name":    "",
+		"email_address": "email",
+	}
+	for _, field := range allFields(msg) {
+		if want, ok := expected[field.Name]; ok && field.JsonName != want {
+			t.Errorf("field %s: expected json name %q, got %q", field.Name, want, field.JsonName)
+		}
+	}
+}
+
+func TestClone(t *testing.T) {
+	r := NewRegistry([]string{""})
+	content := `syntax = "proto3";
+package clone;
+
+enum Status {
+  STATUS_UNKNOWN = 0;
+  STATUS_ACTIVE = 1;
+}
+
+message User {
+  message Address {
+    string city = 1;
+  }
+  string name = 1;
+  Address address = 2;
+  map<string, Status> roles = 3;
+  oneof contact {
+    string email = 4;
+  }
+}
+
+service Users {
+  rpc Get(User) returns (User);
+}
+`
+	if err := r.LoadSchema(strings.NewReader(content), "clone.proto"); err != nil {
+		t.Fatalf("LoadSchema: %v", err)
+	}
+	c := r.Clone()
+
+	user, err := c.GetMessage("clone.User")
+	if err != nil {
+		t.Fatalf("GetMessage on clone: %v", err)
+	}
+	original, _ := r.GetMessage("clone.User")
+	if user == original || !reflect.DeepEqual(user, original) {
+		t.Fatalf("Expected an equal copy of User, not the same value")
+	}
+	address, _ := c.GetMessage("clone.User.Address")
+	if user.NestedTypes[0] != address {
+		t.Errorf("Expected the nested Address of the clone to be its symbol table entry")
+	}
+	if c.repo.ProtoFiles["clone.proto"].Messages[0] != user {
+		t.Errorf("Expected the file of the clone to hold its User")
+	}
+
+	// changes to the clone stay in the clone
+	user.Fields = append(user.Fields, &schema.Field{Name: "age", Number: 5, Type: schema.FieldType{Kind: schema.KindPrimitive, PrimitiveType: schema.TypeInt32}})
+	user.Fields[2].Type.MapValue.EnumType = "clone.Other"
+	user.OneofGroups[0].Fields[0].Name = "mail"
+	status, _ := c.GetEnum("clone.Status")
+	status.Values[1].Name = "STATUS_ENABLED"
+	users, _ := c.GetService("clone.Users")
+	users.Methods[0].Name = "Fetch"
+	extra := "syntax = \"proto3\";\npackage clone;\n\nmessage Extra {\n  string id = 1;\n}\n"
+	if err := c.LoadSchema(strings.NewReader(extra), "extra.proto"); err != nil {
+		t.Fatalf("LoadSchema on clone: %v", err)
+	}
+
+	if len(original.Fields) != 3 || original.Fields[2].Type.MapValue.EnumType != "clone.Status" || original.OneofGroups[0].Fields[0].Name != "email" {
+		t.Errorf("Expected the original User to be unchanged, got %+v", original)
+	}
+	if enum, _ := r.GetEnum("clone.Status"); enum.Values[1].Name != "STATUS_ACTIVE" {
+		t.Errorf("Expected the original enum to be unchanged, got %+v", enum.Values)
+	}
+	if service, _ := r.GetService("clone.Users"); service.Methods[0].Name != "Get" {
+		t.Errorf("Expected the original service to be unchanged, got %+v", service.Methods)
+	}
+	if _, err := r.GetMessage("clone.Extra"); err == nil {
+		t.Errorf("Expected files loaded into the clone to stay out of the original")
+	}
+	if _, err := c.GetMessage("clone.Extra"); err != nil {
+		t.Errorf("Expected Extra in the clone: %v", err)
+	}
+}
+
+func TestLint(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, body string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(body), 0644); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+	}
+	write("common.proto", `syntax = "proto3";
+package shop;
+
+message Address {
+  string city = 1;
+}
+`)
+	write("legacy.proto", `syntax = "proto2";
+package shop;
+
+enum Grade {
+  GRADE_A = 1;
+}
+`)
+	write("order.proto", `syntax = "proto3";
+package shop;
+
+import "common.proto";
+import "legacy.proto";
+
+enum Status {
+  STATUS_UNKNOWN = 0;
+  STATUS_ACTIVE = 1;
+}
+
+message Order {
+  Address address = 1;
+  repeated string tags = 16;
+  repeated int32 quantities = 17;
+  map<string, string> notes = 3;
+  map<string, int32> counts = 4;
+
+  message Line {
+    enum Kind {
+      KIND_ITEM = 0;
+    }
+    Kind kind = 1;
+  }
+}
+`)
+	reg := NewRegistry([]string{dir})
+	if err := reg.LoadSchemaFile("order.proto"); err != nil {
+		t.Fatalf("LoadSchemaFile: %v", err)
+	}
+	// loading rejects these problems, but definitions changed in code can still have them
+	status, err := reg.GetEnum("shop.Status")
+	if err != nil {
+		t.Fatalf("GetEnum: %v", err)
+	}
+	status.Values = status.Values[1:]
+	order, err := reg.GetMessage("shop.Order")
+	if err != nil {
+		t.Fatalf("GetMessage: %v", err)
+	}
+	for _, f := range order.Fields {
+		if f.Name == "notes" {
+			f.Type.MapKey = &schema.FieldType{Kind: schema.KindPrimitive, PrimitiveType: schema.TypeDouble}
+		}
+	}
+
+	orderPath := filepath.Join(dir, "order.proto")
+	expected := []LintIssue{
+		{Rule: LintMissingZeroEnumValue, File: orderPath, Message: "shop.Status"},
+		{Rule: LintLargeFieldNumber, File: orderPath, Message: "shop.Order", Field: "tags"},
+		{Rule: LintInvalidMapKey, File: orderPath, Message: "shop.Order", Field: "notes"},
+		{Rule: LintUnusedImport, File: orderPath},
+	}
+	issues := reg.Lint()
+	if len(issues) != len(expected) {
+		t.Fatalf("expected %d issues, got %d: %v", len(expected), len(issues), issues)
+	}
+	for i, want := range expected {
+		got := issues[i]
+		if got.Rule != want.Rule || got.File != want.File || got.Message != want.Message || got.Field != want.Field {
+			t.Errorf("issue %d: expected %+v, got %+v", i, want, got)
+		}
+		if got.Detail == "" {
+			t.Errorf("issue %d: expected a detail message", i)
+		}
+	}
+	if !strings.Contains(issues[3].Detail, "legacy.proto") {
+		t.Errorf("expected the unused import to be legacy.proto, got %q", issues[3].Detail)
+	}
+}
+
+func TestProto3EnumRequiresZeroValue(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{
+			name: "top level",
+			content: `syntax = "proto3";
+package zero;
+
+enum Status {
+  STATUS_ACTIVE = 1;
+  STATUS_UNKNOWN = 0;
+}
+`,
+			wantErr: "enum zero.Status starts with STATUS_ACTIVE = 1, proto3 enums must start with a 0 value",
+		},
+		{
+			name: "nested",
+			content: `syntax = "proto3";
+package zero;
+
+message Order {
+  message Line {
+    enum Kind {
+      KIND_ITEM = 1;
+    }
+    Kind kind = 1;
+  }
+}
+`,
+			wantErr: "enum zero.Order.Line.Kind starts with KIND_ITEM = 1",
+		},
+		{
+			name: "proto2 allows any first value",
+			content: `syntax = "proto2";
+package zero;
+
+enum Status {
+  STATUS_ACTIVE = 1;
+}
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reg := NewRegistry([]string{""})
+			err := reg.LoadSchema(strings.NewReader(tt.content), "zero.proto")
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
@@ -4,10 +4,13 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path"
+	"strconv"
 	"strings"
 
+	"github.com/anirudhraja/protolite/schema"
 	protoparser "github.com/yoheimuta/go-protoparser/v4"
 	protoparserparser "github.com/yoheimuta/go-protoparser/v4/parser"
 )
@@ -19,6 +22,18 @@ const (
 	optionShowNull       = "show_null"
 	optionTrackNull      = "track_null"
 	optionJSONBytes      = "json_bytes"
+	optionDefault        = "default"
+	optionSet            = "set"
+	optionPacked         = "packed"
+
+	optionMessageSetWireFormat = "message_set_wire_format"
+)
+
+// Field number limits from the protobuf language spec
+const (
+	maxFieldNumber           = 1<<29 - 1
+	firstReservedFieldNumber = 19000
+	lastReservedFieldNumber  = 19999
 )
 
 // getAllProtoInfoFromReader uses DFS to fetch proto info starting from a reader, with dependent protos loaded from files
@@ -59,7 +74,7 @@ func (r *Registry) traverseProtoWithDFS(initialIdentifier string, initialProtoBy
 		result = append(result, protoFile)
 
 		// Read proto bytes from file
-		protoBytes, err := os.ReadFile(protoFile)
+		protoBytes, err := r.readProtoFile(protoFile)
 		if err != nil {
 			return nil, fmt.Errorf("failed to read file: %w", err)
 		}
@@ -88,6 +103,58 @@ func (r *Registry) traverseProtoWithDFS(initialIdentifier string, initialProtoBy
 	return result, nil
 }
 
+// commentText joins comments into plain text, one line per comment line. The
+// comment markers, the leading "*" of block comment lines and one leading space
+// per line are dropped, as are blank lines around the text.
+func commentText(comments ...*protoparserparser.Comment) string {
+	var lines []string
+	for _, comment := range comments {
+		if comment == nil {
+			continue
+		}
+		for _, line := range comment.Lines() {
+			line = strings.TrimRight(line, " \t\r")
+			if comment.IsCStyle() {
+				if trimmed := strings.TrimLeft(line, " \t"); strings.HasPrefix(trimmed, "*") {
+					line = trimmed[1:]
+				}
+			}
+			lines = append(lines, strings.TrimPrefix(line, " "))
+		}
+	}
+	return strings.Trim(strings.Join(lines, "\n"), "\n")
+}
+
+// readProtoFile reads a resolved proto file from the registry's fs.FS, or from
+// the OS filesystem when none is set
+func (r *Registry) readProtoFile(fullPath string) ([]byte, error) {
+	if r.fsys != nil {
+		return fs.ReadFile(r.fsys, fullPath)
+	}
+	return os.ReadFile(fullPath)
+}
+
+// statProtoFile is the os.Stat counterpart of readProtoFile
+func (r *Registry) statProtoFile(fullPath string) (fs.FileInfo, error) {
+	if r.fsys != nil {
+		return fs.Stat(r.fsys, fullPath)
+	}
+	return os.Stat(fullPath)
+}
+
+// parseProtoFile parses the proto file at fullPath without loading it
+func (r *Registry) parseProtoFile(fullPath string) (*protoparserparser.Proto, error) {
+	protoBytes, err := r.readProtoFile(fullPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	parsedBody, err := protoparser.Parse(bytes.NewBuffer(protoBytes))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse proto: %w", err)
+	}
+	return parsedBody, nil
+}
+
 // processProtoBytes is a common method to parse proto bytes and handle imports.
 // Returns (public import paths from this file, error).
 func (r *Registry) processProtoBytes(identifier string, protoBytes []byte, dfs func(string) ([]string, error)) ([]string, error) {
@@ -116,6 +183,11 @@ func (r *Registry) processProtoBytes(identifier string, protoBytes []byte, dfs f
 			}
 			fullImportPath, err := r.findIfProtoExists(importPath)
 			if err != nil {
+				// weak imports are optional dependencies, so a missing one is only reported
+				if b.Modifier == protoparserparser.ImportModifierWeak {
+					r.warnings = append(r.warnings, fmt.Sprintf("%s: skipping missing weak import %s: %v", identifier, importPath, err))
+					continue
+				}
 				return nil, err
 			}
 			protoFileEntity.imports = append(protoFileEntity.imports, fullImportPath)
@@ -144,7 +216,7 @@ func (r *Registry) findIfProtoExists(protoPath string) (string, error) {
 	for _, dir := range r.ProtoDirectories {
 		fullPath = path.Join(dir, protoPath)
 		// Check if the path exists
-		_, err = os.Stat(fullPath)
+		_, err = r.statProtoFile(fullPath)
 		if err == nil {
 			fullProtoPath = fullPath
 			break
@@ -201,6 +273,30 @@ func splitNameAndCheck(typeName, prefix string, allResolvedEntities map[string]s
 	return "", false
 }
 
+// wrapperTypeNames holds the fully qualified names of the built-in wrapper types
+var wrapperTypeNames = map[string]struct{}{
+	string(schema.WrapperDoubleValue): {},
+	string(schema.WrapperFloatValue):  {},
+	string(schema.WrapperInt64Value):  {},
+	string(schema.WrapperUInt64Value): {},
+	string(schema.WrapperInt32Value):  {},
+	string(schema.WrapperUInt32Value): {},
+	string(schema.WrapperBoolValue):   {},
+	string(schema.WrapperStringValue): {},
+	string(schema.WrapperBytesValue):  {},
+}
+
+// resolveWrapperName resolves typeName, as referenced from prefix, to the fully
+// qualified name of a built-in wrapper type
+func resolveWrapperName(typeName, prefix string) (string, bool) {
+	if strings.HasPrefix(typeName, ".") {
+		typeName = strings.TrimPrefix(typeName, ".")
+		_, ok := wrapperTypeNames[typeName]
+		return typeName, ok
+	}
+	return splitNameAndCheck(typeName, prefix, wrapperTypeNames)
+}
+
 func getFullyQualifiedType(typeName string, allResolvedEntities map[string]struct{}) (string, error) {
 
 	typeName = strings.TrimPrefix(typeName, ".")
@@ -220,20 +316,115 @@ func getOptionName(name string) string {
 	return strings.TrimSpace(name)
 }
 
+// findJSONName returns the json_name of a field. The built-in form
+// [json_name = "x"] wins over a custom option ending in json_name, such as
+// [(field).json_name = "x"]; either may use single or double quotes.
 func findJSONName(options []*protoparserparser.FieldOption) string {
+	custom := ""
 	for _, opt := range options {
-		if strings.Trim(opt.OptionName, `"`) == optionJSONNameKey {
-			return strings.Trim(opt.Constant, `"`)
+		name := strings.Trim(opt.OptionName, `"`)
+		if name == optionJSONNameKey {
+			return unquoteConstant(opt.Constant)
+		}
+		if strings.HasPrefix(name, "(") && getOptionName(name) == optionJSONNameKey && custom == "" {
+			custom = unquoteConstant(opt.Constant)
 		}
 	}
+	return custom
+}
+
+// unquoteConstant strips the single or double quotes around a string constant
+func unquoteConstant(constant string) string {
+	if n := len(constant); n >= 2 && (constant[0] == '"' || constant[0] == '\'') && constant[n-1] == constant[0] {
+		return constant[1 : n-1]
+	}
+	return constant
+}
+
+// fieldOptions collects all options of a field by name, with string literals
+// unquoted. A repeated option name keeps its last value. It returns nil when the
+// field declares no options.
+func fieldOptions(options []*protoparserparser.FieldOption) map[string]string {
+	if len(options) == 0 {
+		return nil
+	}
+	result := make(map[string]string, len(options))
+	for _, opt := range options {
+		value := opt.Constant
+		if unquoted, err := strconv.Unquote(value); err == nil {
+			value = unquoted
+		}
+		result[strings.TrimSpace(opt.OptionName)] = value
+	}
+	return result
+}
+
+// findDefaultValue returns the proto2 `[default = ...]` constant of a field,
+// with string literals unquoted. It returns "" when no default is declared.
+func findDefaultValue(options []*protoparserparser.FieldOption) string {
+	for _, opt := range options {
+		if strings.TrimSpace(opt.OptionName) != optionDefault {
+			continue
+		}
+		if unquoted, err := strconv.Unquote(opt.Constant); err == nil {
+			return unquoted
+		}
+		return unquoteConstant(opt.Constant)
+	}
 	return ""
 }
 
+// hasDefault reports whether a field declares a `[default = ...]` option,
+// including an empty string default
+func hasDefault(field *schema.Field) bool {
+	_, ok := field.Options[optionDefault]
+	return ok
+}
+
 func findJSONNameForEnumValue(options []*protoparserparser.EnumValueOption) string {
 	for _, opt := range options {
 		if strings.Trim(opt.OptionName, `"`) == optionJSONNameKey {
-			return strings.Trim(opt.Constant, `"`)
+			return unquoteConstant(opt.Constant)
 		}
 	}
 	return ""
 }
+
+// parseFieldNumber parses the number of field name and checks it is a valid
+// protobuf field number outside the range reserved for the implementation.
+func parseFieldNumber(number, name string) (int32, error) {
+	n, err := strconv.ParseInt(number, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid field number %q for %s: %w", number, name, err)
+	}
+	if n < 1 || n > maxFieldNumber {
+		return 0, fmt.Errorf("field number %d for %s is out of range 1 to %d", n, name, maxFieldNumber)
+	}
+	if n >= firstReservedFieldNumber && n <= lastReservedFieldNumber {
+		return 0, fmt.Errorf("field number %d for %s is in the reserved range %d to %d", n, name, firstReservedFieldNumber, lastReservedFieldNumber)
+	}
+	return int32(n), nil
+}
+
+// parseReservedRange converts a reserved range such as "5", "9 to 11" or
+// "100 to max" into its inclusive bounds
+func parseReservedRange(rng *protoparserparser.Range) (schema.ReservedRange, error) {
+	start, err := strconv.ParseInt(rng.Begin, 10, 32)
+	if err != nil {
+		return schema.ReservedRange{}, fmt.Errorf("invalid reserved field number %q", rng.Begin)
+	}
+	end := start
+	switch rng.End {
+	case "":
+	case "max":
+		end = maxFieldNumber
+	default:
+		if end, err = strconv.ParseInt(rng.End, 10, 32); err != nil {
+			return schema.ReservedRange{}, fmt.Errorf("invalid reserved field number %q", rng.End)
+		}
+	}
+	if start < 1 || end < start {
+		return schema.ReservedRange{}, fmt.Errorf("invalid reserved range %s to %s", rng.Begin, rng.End)
+	}
+	return schema.ReservedRange{Start: int32(start), End: int32(end)}, nil
+}
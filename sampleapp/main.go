@@ -476,12 +476,7 @@ func showWrapperResults(result map[string]interface{}) {
 			if value == nil {
 				fmt.Printf("   %s: <nil> (not set)\n", field)
 			} else {
-				switch v := value.(type) {
-				case []byte:
-					fmt.Printf("   %s: %v (bytes, length: %d)\n", field, v, len(v))
-				default:
-					fmt.Printf("   %s: %v (%T)\n", field, value, value)
-				}
+				fmt.Printf("   %s: %s\n", field, protolite.DescribeValue(value))
 			}
 		} else {
 			fmt.Printf("   %s: <not present>\n", field)
@@ -498,7 +493,7 @@ func showWrapperResults(result map[string]interface{}) {
 				if value == nil {
 					fmt.Printf("   %s: <nil> (not set)\n", field)
 				} else {
-					fmt.Printf("   %s: %v (%T)\n", field, value, value)
+					fmt.Printf("   %s: %s\n", field, protolite.DescribeValue(value))
 				}
 			} else {
 				fmt.Printf("   %s: <not present>\n", field)
@@ -1,5 +1,10 @@
 package schema
 
+import (
+	"fmt"
+	"sync/atomic"
+)
+
 // ProtoRepo represents a collection of .proto files and their definitions.
 type ProtoRepo struct {
 	ProtoFiles map[string]*ProtoFile `json:"proto_files"`
@@ -14,6 +19,14 @@ type ProtoFile struct {
 	Messages []*Message `json:"messages"` // message definitions
 	Enums    []*Enum    `json:"enums"`    // enum definitions
 	Services []*Service `json:"services"` // service definitions
+
+	Extensions []*Extension `json:"extensions"` // top-level proto2 extend blocks
+}
+
+// Extension represents a proto2 `extend` block adding fields to another message
+type Extension struct {
+	Extendee string   `json:"extendee"` // fully qualified name of the extended message
+	Fields   []*Field `json:"fields"`   // extension fields
 }
 
 // Import represents an import statement
@@ -35,6 +48,18 @@ type Message struct {
 	IsWrapper   bool       `json:"is_wrapper"`   // is this a wrapper?
 	ShowNull    bool       `json:"show_null"`    // should show null in decode
 	TrackNull   bool       `json:"track_null"`   // should track null in decode
+
+	// ReservedRanges and ReservedNames hold the field numbers and names that
+	// reserved statements retire, usually those of removed fields.
+	ReservedRanges []ReservedRange `json:"reserved_ranges,omitempty"`
+	ReservedNames  []string        `json:"reserved_names,omitempty"`
+
+	// MessageSetWireFormat is set by the proto2 message_set_wire_format option:
+	// extensions arrive as MessageSet item groups rather than regular fields.
+	MessageSetWireFormat bool `json:"message_set_wire_format,omitempty"`
+
+	Comment         string `json:"comment,omitempty"`          // leading comment text, without comment markers
+	TrailingComment string `json:"trailing_comment,omitempty"` // comment after the opening or closing brace
 }
 
 // Field represents a message field
@@ -48,6 +73,57 @@ type Field struct {
 	OneofIndex   int32      `json:"oneof_index"`   // oneof group index (-1 if not in oneof)
 	JSONString   bool       `json:"json_string"`   // when set raw json string is used to transport gql scalars on wire.
 	JSONBytes    bool       `json:"json_bytes"`    // when set (via the json_bytes field option) a bytes field carries a JSON-encoded value: json.Marshal on encode, json.Unmarshal on decode.
+	Set          bool       `json:"set"`           // when set (via the set field option) a repeated scalar/enum field is deduplicated on encode, keeping first occurrences.
+
+	// Options holds every option declared on the field, including custom ones
+	// such as "(validate.rules).string.min_len", keyed by the option name as
+	// written. String literals are unquoted and aggregate values are kept as
+	// their compact text form, e.g. "{string:{max_len:5}}".
+	Options map[string]string `json:"options,omitempty"`
+
+	Comment         string `json:"comment,omitempty"`          // leading comment text, without comment markers
+	TrailingComment string `json:"trailing_comment,omitempty"` // comment at the end of the field line
+
+	codec atomic.Value // custom transform of the field payload as a codecHolder, see SetCodec
+}
+
+// codecHolder gives every value stored in Field.codec the same concrete type,
+// as atomic.Value requires
+type codecHolder struct {
+	codec FieldCodec
+}
+
+// Codec returns the codec the field's payload is routed through, or nil
+func (f *Field) Codec() FieldCodec {
+	holder, _ := f.codec.Load().(codecHolder)
+	return holder.codec
+}
+
+// SetCodec routes the field's payload through codec, or back to the plain
+// encoding when codec is nil. It is safe to call while the field is being
+// encoded or decoded; each value uses the codec set when it is reached.
+func (f *Field) SetCodec(codec FieldCodec) {
+	f.codec.Store(codecHolder{codec: codec})
+}
+
+// FieldCodec transforms the value of a length-delimited field on its way to
+// and from the wire, e.g. to compress a bytes payload. Encode returns the
+// payload written for a value; Decode turns a payload back into a value.
+type FieldCodec interface {
+	Encode(value interface{}) ([]byte, error)
+	Decode(data []byte) (interface{}, error)
+}
+
+// ProtoliteMarshaler is implemented by Go types that encode themselves as a
+// message: MarshalProtolite returns the message map written in their place.
+type ProtoliteMarshaler interface {
+	MarshalProtolite() (map[string]interface{}, error)
+}
+
+// ProtoliteUnmarshaler is implemented by Go types that fill themselves from a
+// decoded message map instead of having it copied field by field.
+type ProtoliteUnmarshaler interface {
+	UnmarshalProtolite(data map[string]interface{}) error
 }
 
 // Oneof represents a oneof group
@@ -56,6 +132,48 @@ type Oneof struct {
 	Fields []*Field `json:"fields"` // fields in this oneof
 }
 
+// IsSynthetic reports whether the oneof is the single-field group that
+// descriptors generate for a proto3 optional field, named "_" plus the field
+// name. It is a plain optional field, never a union.
+func (o *Oneof) IsSynthetic() bool {
+	return len(o.Fields) == 1 && o.Name == "_"+o.Fields[0].Name
+}
+
+// ReservedRange is an inclusive range of reserved field numbers
+type ReservedRange struct {
+	Start int32 `json:"start"`
+	End   int32 `json:"end"`
+}
+
+// IsReserved reports whether the field number is retired by a reserved statement
+func (m *Message) IsReserved(number int32) bool {
+	for _, r := range m.ReservedRanges {
+		if number >= r.Start && number <= r.End {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks the rules a parsed .proto file already guarantees but a
+// message built in code may break: no oneof member is repeated or a map.
+// Nested messages are checked too.
+func (m *Message) Validate() error {
+	for _, oneof := range m.OneofGroups {
+		for _, f := range oneof.Fields {
+			if f.Label == LabelRepeated || f.Type.Kind == KindMap {
+				return fmt.Errorf("%s: oneof %s cannot contain repeated or map field %s", m.Name, oneof.Name, f.Name)
+			}
+		}
+	}
+	for _, nested := range m.NestedTypes {
+		if err := nested.Validate(); err != nil {
+			return fmt.Errorf("%s.%w", m.Name, err)
+		}
+	}
+	return nil
+}
+
 // FieldLabel represents field labels
 type FieldLabel string
 
@@ -74,6 +192,12 @@ const (
 	NullTrackerNullFieldsFieldName        string = "null_fields"
 )
 
+// IsNullTrackerField reports whether the field is the synthetic null tracker
+// field added to messages with the track_null option.
+func IsNullTrackerField(f *Field) bool {
+	return f != nil && f.Number == NullTrackerFieldNumber && f.Name == NullTrackerFieldName
+}
+
 // FieldType represents field type information
 type FieldType struct {
 	Kind          TypeKind      `json:"kind"`                     // primitive, message, enum, map, wrapper
@@ -86,6 +210,28 @@ type FieldType struct {
 	ElementType   *FieldType    `json:"element_type,omitempty"`   // for repeated element type
 }
 
+// WireType returns the protobuf wire type a single value of this type is
+// encoded with: 0 for varint, 1 for 64-bit, 2 for length-delimited and 5 for 32-bit.
+func (t *FieldType) WireType() int32 {
+	switch t.Kind {
+	case KindPrimitive:
+		switch t.PrimitiveType {
+		case TypeString, TypeBytes:
+			return 2
+		case TypeFloat, TypeFixed32, TypeSfixed32:
+			return 5
+		case TypeDouble, TypeFixed64, TypeSfixed64:
+			return 1
+		default:
+			return 0
+		}
+	case KindMessage, KindMap, KindWrapper:
+		return 2
+	default:
+		return 0
+	}
+}
+
 // TypeKind represents the kind of field type
 type TypeKind string
 
@@ -155,6 +301,9 @@ const (
 	WrapperBytesValue  WrapperType = "google.protobuf.BytesValue"
 )
 
+// NullValueEnumName is the built-in google.protobuf.NullValue enum, whose only value is NULL_VALUE = 0
+const NullValueEnumName = "google.protobuf.NullValue"
+
 // Enum represents an enum definition
 type Enum struct {
 	Name       string       `json:"name"`        // "Status"
@@ -167,6 +316,9 @@ type EnumValue struct {
 	Name     string `json:"name"`      // "ACTIVE"
 	Number   int32  `json:"number"`    // 1
 	JsonName string `json:"json_name"` // JSON field name
+
+	Comment         string `json:"comment,omitempty"`          // leading comment text, without comment markers
+	TrailingComment string `json:"trailing_comment,omitempty"` // comment at the end of the value line
 }
 
 // Service represents a service definition
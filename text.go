@@ -0,0 +1,247 @@
+package protolite
+
+import (
+	"encoding/base64"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/anirudhraja/protolite/schema"
+	"github.com/anirudhraja/protolite/wire"
+)
+
+// marshalText renders binary protobuf data in the protobuf text format: one
+// field per line in field number order, nested messages in braces and
+// indented by two spaces. As in protoc, singular scalar fields holding their
+// zero value are left out unless they belong to a oneof.
+func (p *protolite) marshalText(data []byte, messageName string) ([]byte, error) {
+	message, err := p.registry.GetMessage(messageName)
+	if err != nil {
+		return nil, fmt.Errorf("message schema not found: %v", err)
+	}
+	decodedMessage, err := wire.DecodeMessage(data, message, p.registry)
+	if err != nil {
+		return nil, fmt.Errorf("decoding failed: %w", err)
+	}
+	decoded, ok := decodedMessage.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("decoded message is not a map, got %T", decodedMessage)
+	}
+	var b strings.Builder
+	if err := p.textMessage(&b, decoded, message, ""); err != nil {
+		return nil, err
+	}
+	return []byte(b.String()), nil
+}
+
+// textMessage writes the fields of a decoded message, each line prefixed by indent
+func (p *protolite) textMessage(b *strings.Builder, decoded map[string]interface{}, msg *schema.Message, indent string) error {
+	type entry struct {
+		field *schema.Field
+		value interface{}
+	}
+	entries := make([]entry, 0, len(decoded))
+	for key, value := range decoded {
+		field := fieldByDecodedName(msg, key)
+		if field == nil || value == nil || schema.IsNullTrackerField(field) {
+			// e.g. __typename of union wrappers
+			continue
+		}
+		entries = append(entries, entry{field, value})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].field.Number < entries[j].field.Number
+	})
+	oneofMembers := make(map[*schema.Field]struct{})
+	for _, oneof := range msg.OneofGroups {
+		for _, f := range oneof.Fields {
+			oneofMembers[f] = struct{}{}
+		}
+	}
+
+	for _, e := range entries {
+		_, inOneof := oneofMembers[e.field]
+		if err := p.textField(b, e.value, e.field, inOneof, indent); err != nil {
+			return fmt.Errorf("field %s: %w", e.field.Name, err)
+		}
+	}
+	return nil
+}
+
+// textField writes one decoded field, one line or block per repeated element or
+// map entry. Zero values are skipped unless the field is a oneof member.
+func (p *protolite) textField(b *strings.Builder, value interface{}, field *schema.Field, inOneof bool, indent string) error {
+	rv := reflect.ValueOf(value)
+	switch {
+	case field.Type.Kind == schema.KindMap && rv.Kind() == reflect.Map:
+		keys := rv.MapKeys()
+		sort.Slice(keys, func(i, j int) bool {
+			return fmt.Sprint(keys[i].Interface()) < fmt.Sprint(keys[j].Interface())
+		})
+		inner := indent + "  "
+		for _, key := range keys {
+			fmt.Fprintf(b, "%s%s {\n", indent, field.Name)
+			if err := p.textValue(b, "key", key.Interface(), field.Type.MapKey, inner); err != nil {
+				return err
+			}
+			if err := p.textValue(b, "value", rv.MapIndex(key).Interface(), field.Type.MapValue, inner); err != nil {
+				return err
+			}
+			fmt.Fprintf(b, "%s}\n", indent)
+		}
+		return nil
+	case field.Label == schema.LabelRepeated && rv.Kind() == reflect.Slice && rv.Type().Elem().Kind() != reflect.Uint8:
+		for i := 0; i < rv.Len(); i++ {
+			if err := p.textValue(b, field.Name, rv.Index(i).Interface(), &field.Type, indent); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if !inOneof {
+		if zero, err := p.isZeroText(value, &field.Type); err != nil || zero {
+			return err
+		}
+	}
+	return p.textValue(b, field.Name, value, &field.Type, indent)
+}
+
+// textValue writes a single "name: value" line, or a "name { ... }" block for messages
+func (p *protolite) textValue(b *strings.Builder, name string, value interface{}, t *schema.FieldType, indent string) error {
+	switch t.Kind {
+	case schema.KindMessage:
+		nested, ok := value.(map[string]interface{})
+		if !ok {
+			// raw bytes of an unregistered message type
+			break
+		}
+		msg, err := p.registry.GetMessage(t.MessageType)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(b, "%s%s {\n", indent, name)
+		if err := p.textMessage(b, nested, msg, indent+"  "); err != nil {
+			return err
+		}
+		fmt.Fprintf(b, "%s}\n", indent)
+		return nil
+	case schema.KindWrapper:
+		fmt.Fprintf(b, "%s%s {\n", indent, name)
+		fmt.Fprintf(b, "%s  value: %s\n", indent, textScalar(wrappedValue(value), wrappedPrimitive(t.WrapperType)))
+		fmt.Fprintf(b, "%s}\n", indent)
+		return nil
+	case schema.KindEnum:
+		// names and unknown numbers are both written bare
+		fmt.Fprintf(b, "%s%s: %v\n", indent, name, value)
+		return nil
+	}
+	fmt.Fprintf(b, "%s%s: %s\n", indent, name, textScalar(value, t.PrimitiveType))
+	return nil
+}
+
+// isZeroText reports whether a singular scalar or enum value is its type's zero
+// value, which the text format leaves out
+func (p *protolite) isZeroText(value interface{}, t *schema.FieldType) (bool, error) {
+	switch t.Kind {
+	case schema.KindPrimitive:
+		switch v := value.(type) {
+		case []byte:
+			return len(v) == 0, nil
+		case string:
+			return v == "", nil
+		case bool:
+			return !v, nil
+		}
+		rv := reflect.ValueOf(value)
+		return rv.IsValid() && rv.IsZero(), nil
+	case schema.KindEnum:
+		enum, err := p.registry.GetEnum(t.EnumType)
+		if err != nil {
+			return false, err
+		}
+		for _, v := range enum.Values {
+			if v.Number == 0 {
+				return value == v.Name || value == int32(0), nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// textScalar formats a scalar the way the protobuf text format spells it
+func textScalar(value interface{}, pt schema.PrimitiveType) string {
+	switch v := value.(type) {
+	case string:
+		if pt == schema.TypeBytes {
+			// bytes decoded with wire.Config.DecodeBytesAsBase64
+			if raw, err := base64.StdEncoding.DecodeString(v); err == nil {
+				return quoteText(raw, true)
+			}
+		}
+		return quoteText([]byte(v), false)
+	case []byte:
+		return quoteText(v, true)
+	case float64:
+		return textFloat(v, 64)
+	case float32:
+		return textFloat(float64(v), 32)
+	}
+	return fmt.Sprint(value)
+}
+
+func textFloat(f float64, bitSize int) string {
+	switch {
+	case math.IsNaN(f):
+		return "nan"
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, bitSize)
+}
+
+// quoteText quotes s with the C-style escapes of the protobuf text format.
+// Control characters and, for bytes, every non-ASCII byte are written as
+// three-digit octal escapes; strings keep printable UTF-8 as is.
+func quoteText(s []byte, isBytes bool) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch c {
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '"':
+			b.WriteString(`\"`)
+		case '\'':
+			b.WriteString(`\'`)
+		case '\\':
+			b.WriteString(`\\`)
+		default:
+			if c >= 0x20 && c < 0x7f {
+				b.WriteByte(c)
+				break
+			}
+			if !isBytes && c >= utf8.RuneSelf {
+				if r, size := utf8.DecodeRune(s[i:]); r != utf8.RuneError && strconv.IsPrint(r) {
+					b.Write(s[i : i+size])
+					i += size
+					continue
+				}
+			}
+			fmt.Fprintf(&b, `\%03o`, c)
+		}
+		i++
+	}
+	b.WriteByte('"')
+	return b.String()
+}
@@ -0,0 +1,121 @@
+package protolite
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestTranscode_Text(t *testing.T) {
+	protoContent := `
+syntax = "proto3";
+
+package example;
+
+import "google/protobuf/wrappers.proto";
+
+enum Level {
+    LEVEL_UNSET = 0;
+    LEVEL_HIGH = 1;
+}
+
+message Note {
+    string body = 1;
+    bytes blob = 2;
+    Level level = 3;
+    int32 count = 4;
+    repeated string tags = 5;
+    map<string, int32> votes = 6;
+    Note reply = 7;
+    google.protobuf.StringValue title = 8;
+    double score = 9;
+    oneof target {
+        int32 line = 10;
+        string file = 11;
+    }
+}
+`
+	proto := NewProtolite([]string{""})
+	if err := proto.LoadSchemaFromReader(strings.NewReader(protoContent), "note.proto"); err != nil {
+		t.Fatalf("Failed to load schema from reader: %v", err)
+	}
+	encoded, err := proto.MarshalWithSchema(map[string]interface{}{
+		"body":  "say \"hi\"\n\tthen 'leave' \\ done\x01 – ok",
+		"blob":  []byte{0x00, 'a', 0xff, '\n'},
+		"level": "LEVEL_HIGH",
+		"tags":  []interface{}{"a", "b"},
+		"votes": map[string]interface{}{"y": int32(2), "n": int32(1)},
+		"reply": map[string]interface{}{"body": "ok"},
+		"title": "T",
+		"score": 0.5,
+		"line":  int32(0),
+	}, "example.Note")
+	if err != nil {
+		t.Fatalf("MarshalWithSchema failed: %v", err)
+	}
+
+	got, err := proto.Transcode(encoded, "example.Note", FormatProtobuf, FormatText)
+	if err != nil {
+		t.Fatalf("Transcode failed: %v", err)
+	}
+	expected := `body: "say \"hi\"\n\tthen \'leave\' \\ done\001 – ok"
+blob: "\000a\377\n"
+level: LEVEL_HIGH
+tags: "a"
+tags: "b"
+votes {
+  key: "n"
+  value: 1
+}
+votes {
+  key: "y"
+  value: 2
+}
+reply {
+  body: "ok"
+}
+title {
+  value: "T"
+}
+score: 0.5
+line: 0
+`
+	if string(got) != expected {
+		t.Errorf("Unexpected text:\nexpected:\n%s\ngot:\n%s", expected, got)
+	}
+
+	if _, err := proto.Transcode([]byte(expected), "example.Note", FormatText, FormatProtobuf); err == nil {
+		t.Errorf("Expected text input to be rejected")
+	}
+}
+
+func TestTranscode_JSONEscapesControlCharacters(t *testing.T) {
+	proto := NewProtolite([]string{""})
+	if err := proto.LoadSchemaFromReader(strings.NewReader(`syntax = "proto3";
+package example;
+message Line {
+    string text = 1;
+}
+`), "line.proto"); err != nil {
+		t.Fatalf("Failed to load schema from reader: %v", err)
+	}
+	text := "quote \" backslash \\ newline \n tab \t bell \x07 <tag>"
+	encoded, err := proto.MarshalWithSchema(map[string]interface{}{"text": text}, "example.Line")
+	if err != nil {
+		t.Fatalf("MarshalWithSchema failed: %v", err)
+	}
+	out, err := proto.Transcode(encoded, "example.Line", FormatProtobuf, FormatJSON)
+	if err != nil {
+		t.Fatalf("Transcode failed: %v", err)
+	}
+	if strings.ContainsAny(string(out), "\n\t\x07") {
+		t.Errorf("Expected control characters to be escaped, got %q", out)
+	}
+	var back map[string]string
+	if err := json.Unmarshal(out, &back); err != nil {
+		t.Fatalf("Output is not valid JSON: %v", err)
+	}
+	if back["text"] != text {
+		t.Errorf("Expected %q, got %q", text, back["text"])
+	}
+}
@@ -0,0 +1,410 @@
+package protolite
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/anirudhraja/protolite/schema"
+	"github.com/anirudhraja/protolite/wire"
+)
+
+// Format is a serialization format understood by Transcode
+type Format int
+
+const (
+	// FormatProtobuf is the binary protobuf wire format
+	FormatProtobuf Format = iota
+	// FormatJSON is the JSON produced by UnmarshalToJSONMap
+	FormatJSON
+	// FormatText is the protobuf text format, supported as an output only
+	FormatText
+)
+
+func (f Format) String() string {
+	switch f {
+	case FormatProtobuf:
+		return "protobuf"
+	case FormatJSON:
+		return "json"
+	case FormatText:
+		return "text"
+	default:
+		return fmt.Sprintf("Format(%d)", int(f))
+	}
+}
+
+// Transcode converts data of the given message type from one format to another
+func (p *protolite) Transcode(data []byte, messageName string, from, to Format, opts ...JSONOption) ([]byte, error) {
+	switch {
+	case from == to && (from == FormatProtobuf || from == FormatJSON):
+		return data, nil
+	case from == FormatProtobuf && to == FormatJSON:
+		jsonMap, err := p.UnmarshalToJSONMap(data, messageName, opts...)
+		if err != nil {
+			return nil, err
+		}
+		if wire.GetConfig().OrderJSONByFieldNumber {
+			message, err := p.registry.GetMessage(messageName)
+			if err != nil {
+				return nil, fmt.Errorf("message schema not found: %v", err)
+			}
+			return p.marshalJSONOrdered(jsonMap, message)
+		}
+		return json.Marshal(jsonMap)
+	case from == FormatProtobuf && to == FormatText:
+		return p.marshalText(data, messageName)
+	case from == FormatJSON && to == FormatProtobuf:
+		message, err := p.registry.GetMessage(messageName)
+		if err != nil {
+			return nil, fmt.Errorf("message schema not found: %v", err)
+		}
+		var jsonMap map[string]interface{}
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		if err := dec.Decode(&jsonMap); err != nil {
+			return nil, fmt.Errorf("invalid JSON: %w", err)
+		}
+		protoMap, err := p.protoMessage(jsonMap, message)
+		if err != nil {
+			return nil, err
+		}
+		return wire.EncodeMessage(protoMap, message, p.registry)
+	default:
+		return nil, fmt.Errorf("cannot transcode from %s to %s", from, to)
+	}
+}
+
+// protoMessage converts a JSON object into the map the encoder expects,
+// undoing the conversions of jsonMessage
+func (p *protolite) protoMessage(jsonMap map[string]interface{}, msg *schema.Message) (map[string]interface{}, error) {
+	out := make(map[string]interface{}, len(jsonMap))
+	for key, value := range jsonMap {
+		field := fieldByDecodedName(msg, key)
+		if field == nil {
+			return nil, fmt.Errorf("unknown field %q in %s", key, msg.Name)
+		}
+		if value == nil && !(field.Type.Kind == schema.KindMessage && field.Type.MessageType == wktValue) {
+			// null means absent, except for google.protobuf.Value
+			continue
+		}
+		converted, err := p.protoField(value, field)
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %w", key, err)
+		}
+		out[field.Name] = converted
+	}
+	return out, nil
+}
+
+// protoField converts a JSON field value, descending into repeated and map values
+func (p *protolite) protoField(value interface{}, field *schema.Field) (interface{}, error) {
+	switch {
+	case field.Type.Kind == schema.KindMap:
+		entries, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("map field expects a JSON object, got %T", value)
+		}
+		out := make(map[interface{}]interface{}, len(entries))
+		for k, v := range entries {
+			key, err := protoMapKey(k, field.Type.MapKey)
+			if err != nil {
+				return nil, err
+			}
+			converted, err := p.protoType(v, field.Type.MapValue)
+			if err != nil {
+				return nil, err
+			}
+			out[key] = converted
+		}
+		return out, nil
+	case field.Label == schema.LabelRepeated:
+		list, ok := value.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("repeated field expects a JSON array, got %T", value)
+		}
+		out := make([]interface{}, len(list))
+		for i := range list {
+			converted, err := p.protoType(list[i], &field.Type)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = converted
+		}
+		return out, nil
+	default:
+		return p.protoType(value, &field.Type)
+	}
+}
+
+// protoType converts a single JSON value of the given type
+func (p *protolite) protoType(value interface{}, t *schema.FieldType) (interface{}, error) {
+	switch t.Kind {
+	case schema.KindPrimitive:
+		return protoScalar(value, t.PrimitiveType)
+	case schema.KindWrapper:
+		return protoScalar(value, wrappedPrimitive(t.WrapperType))
+	case schema.KindEnum:
+		// names and numbers are both accepted by the encoder
+		return value, nil
+	}
+
+	switch t.MessageType {
+	case wktTimestamp:
+		s, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("timestamp expects an RFC 3339 string, got %T", value)
+		}
+		ts, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"seconds": ts.Unix(), "nanos": int32(ts.Nanosecond())}, nil
+	case wktDuration:
+		s, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("duration expects a string such as \"1.5s\", got %T", value)
+		}
+		sec, ns, err := parseDuration(s)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"seconds": sec, "nanos": ns}, nil
+	case wktFieldMask:
+		s, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("field mask expects a comma separated string, got %T", value)
+		}
+		paths := make([]interface{}, 0)
+		if s != "" {
+			for _, path := range strings.Split(s, ",") {
+				paths = append(paths, snakePath(path))
+			}
+		}
+		return map[string]interface{}{"paths": paths}, nil
+	case wktStruct:
+		fields, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("struct expects a JSON object, got %T", value)
+		}
+		return jsonToStruct(fields), nil
+	case wktValue:
+		return jsonToValue(value), nil
+	case wktListValue:
+		values, ok := value.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("list value expects a JSON array, got %T", value)
+		}
+		return jsonToListValue(values), nil
+	case wktAny:
+		any, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("any expects a JSON object, got %T", value)
+		}
+		return p.protoAny(any)
+	}
+
+	nested, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("message %s expects a JSON object, got %T", t.MessageType, value)
+	}
+	msg, err := p.registry.GetMessage(t.MessageType)
+	if err != nil {
+		return nil, err
+	}
+	return p.protoMessage(nested, msg)
+}
+
+// protoAny packs an {"@type": ...} object back into type_url and payload bytes
+func (p *protolite) protoAny(any map[string]interface{}) (interface{}, error) {
+	typeURL, _ := any["@type"].(string)
+	typeName := typeURL[strings.LastIndex(typeURL, "/")+1:]
+	msg, err := p.registry.GetMessage(typeName)
+	if typeName == "" || err != nil {
+		// unregistered payloads are kept as base64 under "value"
+		encoded, _ := any["value"].(string)
+		payload, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("any of unknown type %q: %w", typeURL, err)
+		}
+		return map[string]interface{}{"type_url": typeURL, "value": payload}, nil
+	}
+
+	var payload map[string]interface{}
+	if hasJSONMapping(typeName) {
+		// well-known payloads are carried under "value" and always convert to a map
+		var converted interface{}
+		converted, err = p.protoType(any["value"], &schema.FieldType{Kind: schema.KindMessage, MessageType: typeName})
+		payload, _ = converted.(map[string]interface{})
+	} else {
+		fields := make(map[string]interface{}, len(any))
+		for k, v := range any {
+			if k != "@type" {
+				fields[k] = v
+			}
+		}
+		payload, err = p.protoMessage(fields, msg)
+	}
+	if err != nil {
+		return nil, err
+	}
+	encoded, err := wire.EncodeMessage(payload, msg, p.registry)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"type_url": typeURL, "value": encoded}, nil
+}
+
+// protoScalar undoes jsonScalar: quoted 64-bit integers and the names of
+// non-finite floats become numbers again. Base64 strings are left for the
+// encoder, which accepts them for bytes fields.
+func protoScalar(value interface{}, pt schema.PrimitiveType) (interface{}, error) {
+	s, ok := value.(string)
+	if !ok {
+		return value, nil
+	}
+	switch pt {
+	case schema.TypeString, schema.TypeBytes:
+		return s, nil
+	case schema.TypeBool:
+		return strconv.ParseBool(s)
+	case schema.TypeDouble, schema.TypeFloat:
+		var f float64
+		switch s {
+		case "NaN":
+			f = math.NaN()
+		case "Infinity":
+			f = math.Inf(1)
+		case "-Infinity":
+			f = math.Inf(-1)
+		default:
+			return json.Number(s), nil
+		}
+		if pt == schema.TypeFloat {
+			return float32(f), nil
+		}
+		return f, nil
+	default:
+		return json.Number(s), nil
+	}
+}
+
+// protoMapKey converts a JSON object key to the map's key type
+func protoMapKey(key string, t *schema.FieldType) (interface{}, error) {
+	switch t.PrimitiveType {
+	case schema.TypeString:
+		return key, nil
+	case schema.TypeBool:
+		return strconv.ParseBool(key)
+	default:
+		return json.Number(key), nil
+	}
+}
+
+// wrappedValue returns the scalar of a decoded wrapper field, given bare or,
+// with wire.Config.DecodeWrappersAsMessages, as a {"value": ...} map
+func wrappedValue(value interface{}) interface{} {
+	if m, ok := value.(map[string]interface{}); ok {
+		return m["value"]
+	}
+	return value
+}
+
+// wrappedPrimitive returns the primitive type a wrapper message carries
+func wrappedPrimitive(wt schema.WrapperType) schema.PrimitiveType {
+	switch wt {
+	case schema.WrapperDoubleValue:
+		return schema.TypeDouble
+	case schema.WrapperFloatValue:
+		return schema.TypeFloat
+	case schema.WrapperInt64Value:
+		return schema.TypeInt64
+	case schema.WrapperUInt64Value:
+		return schema.TypeUint64
+	case schema.WrapperInt32Value:
+		return schema.TypeInt32
+	case schema.WrapperUInt32Value:
+		return schema.TypeUint32
+	case schema.WrapperBoolValue:
+		return schema.TypeBool
+	case schema.WrapperBytesValue:
+		return schema.TypeBytes
+	default:
+		return schema.TypeString
+	}
+}
+
+// jsonToStruct, jsonToValue and jsonToListValue undo structToJSON
+func jsonToStruct(fields map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(fields))
+	for k, v := range fields {
+		out[k] = jsonToValue(v)
+	}
+	return map[string]interface{}{"fields": out}
+}
+
+func jsonToValue(v interface{}) map[string]interface{} {
+	switch val := v.(type) {
+	case nil:
+		return map[string]interface{}{"null_value": "NULL_VALUE"}
+	case bool:
+		return map[string]interface{}{"bool_value": val}
+	case string:
+		return map[string]interface{}{"string_value": val}
+	case json.Number:
+		return map[string]interface{}{"number_value": val}
+	case map[string]interface{}:
+		return map[string]interface{}{"struct_value": jsonToStruct(val)}
+	case []interface{}:
+		return map[string]interface{}{"list_value": jsonToListValue(val)}
+	default:
+		return map[string]interface{}{"string_value": fmt.Sprint(val)}
+	}
+}
+
+func jsonToListValue(values []interface{}) map[string]interface{} {
+	out := make([]interface{}, len(values))
+	for i := range values {
+		out[i] = jsonToValue(values[i])
+	}
+	return map[string]interface{}{"values": out}
+}
+
+// parseDuration parses the "<seconds>[.<fraction>]s" form written by formatDuration
+func parseDuration(s string) (int64, int32, error) {
+	if !strings.HasSuffix(s, "s") {
+		return 0, 0, fmt.Errorf("invalid duration %q", s)
+	}
+	s = strings.TrimSuffix(s, "s")
+	negative := strings.HasPrefix(s, "-")
+	whole, frac, _ := strings.Cut(strings.TrimPrefix(s, "-"), ".")
+	sec, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil || len(frac) > 9 {
+		return 0, 0, fmt.Errorf("invalid duration %q", s+"s")
+	}
+	var ns int64
+	if frac != "" {
+		ns, err = strconv.ParseInt(frac+strings.Repeat("0", 9-len(frac)), 10, 32)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid duration %q", s+"s")
+		}
+	}
+	if negative {
+		sec, ns = -sec, -ns
+	}
+	return sec, int32(ns), nil
+}
+
+// snakePath converts each segment of a lowerCamelCase field path to snake_case
+func snakePath(path string) string {
+	segments := strings.Split(path, ".")
+	for i, segment := range segments {
+		segments[i] = toSnakeCase(segment)
+	}
+	return strings.Join(segments, ".")
+}
@@ -0,0 +1,267 @@
+package protolite
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/anirudhraja/protolite/wire"
+)
+
+func TestTranscode(t *testing.T) {
+	protoContent := `
+syntax = "proto3";
+
+package example;
+
+import "google/protobuf/any.proto";
+import "google/protobuf/timestamp.proto";
+import "google/protobuf/duration.proto";
+import "google/protobuf/field_mask.proto";
+import "google/protobuf/struct.proto";
+import "google/protobuf/wrappers.proto";
+
+enum State {
+    STATE_UNKNOWN = 0;
+    STATE_ACTIVE = 1;
+}
+
+message Item {
+    uint64 id = 1;
+}
+
+message Event {
+    int64 big = 1;
+    State state = 2;
+    google.protobuf.Timestamp created_at = 3;
+    google.protobuf.Duration ttl = 4;
+    google.protobuf.FieldMask mask = 5;
+    google.protobuf.Struct attrs = 6;
+    map<int32, Item> items = 7;
+    repeated int64 counts = 8;
+    bytes blob = 9;
+    double ratio = 10;
+    google.protobuf.Any payload = 11;
+    google.protobuf.Int64Value limit = 12;
+    map<bool, string> flags = 13;
+}
+`
+	proto := NewProtolite([]string{"conformance_test/protos"})
+	if err := proto.LoadSchemaFromReader(strings.NewReader(protoContent), "transcode.proto"); err != nil {
+		t.Fatalf("Failed to load schema from reader: %v", err)
+	}
+
+	item, err := proto.MarshalWithSchema(map[string]interface{}{"id": uint64(42)}, "example.Item")
+	if err != nil {
+		t.Fatalf("MarshalWithSchema failed: %v", err)
+	}
+	data := map[string]interface{}{
+		"big":        int64(9007199254740993),
+		"state":      "STATE_ACTIVE",
+		"created_at": map[string]interface{}{"seconds": int64(1700000000), "nanos": int32(500000000)},
+		"ttl":        map[string]interface{}{"seconds": int64(-90), "nanos": int32(-250000000)},
+		"mask":       map[string]interface{}{"paths": []interface{}{"user_name", "address.zip_code"}},
+		"attrs": map[string]interface{}{
+			"fields": map[string]interface{}{
+				"name": map[string]interface{}{"string_value": "x"},
+				"tags": map[string]interface{}{"list_value": map[string]interface{}{
+					"values": []interface{}{map[string]interface{}{"bool_value": true}},
+				}},
+			},
+		},
+		"items":   map[int32]interface{}{int32(7): map[string]interface{}{"id": uint64(42)}},
+		"counts":  []interface{}{int64(1), int64(-2)},
+		"blob":    []byte("hi"),
+		"ratio":   math.Inf(-1),
+		"payload": map[string]interface{}{"type_url": "type.googleapis.com/example.Item", "value": item},
+		"limit":   int64(-5),
+		"flags":   map[bool]interface{}{true: "on"},
+	}
+	encoded, err := proto.MarshalWithSchema(data, "example.Event")
+	if err != nil {
+		t.Fatalf("MarshalWithSchema failed: %v", err)
+	}
+
+	jsonData, err := proto.Transcode(encoded, "example.Event", FormatProtobuf, FormatJSON)
+	if err != nil {
+		t.Fatalf("Transcode to JSON failed: %v", err)
+	}
+	if !json.Valid(jsonData) || !bytes.Contains(jsonData, []byte(`"created_at":"2023-11-14T22:13:20.500Z"`)) {
+		t.Errorf("Unexpected JSON: %s", jsonData)
+	}
+
+	back, err := proto.Transcode(jsonData, "example.Event", FormatJSON, FormatProtobuf)
+	if err != nil {
+		t.Fatalf("Transcode to protobuf failed: %v", err)
+	}
+	original, err := proto.UnmarshalToJSONMap(encoded, "example.Event")
+	if err != nil {
+		t.Fatalf("UnmarshalToJSONMap failed: %v", err)
+	}
+	roundTripped, err := proto.UnmarshalToJSONMap(back, "example.Event")
+	if err != nil {
+		t.Fatalf("UnmarshalToJSONMap failed: %v", err)
+	}
+	if !reflect.DeepEqual(original, roundTripped) {
+		t.Errorf("Round trip mismatch\noriginal: %#v\nround trip: %#v", original, roundTripped)
+	}
+
+	t.Run("camel_case_input", func(t *testing.T) {
+		encoded, err := proto.Transcode([]byte(`{"createdAt": "1970-01-01T00:00:01Z", "big": 3, "state": 1}`), "example.Event", FormatJSON, FormatProtobuf)
+		if err != nil {
+			t.Fatalf("Transcode failed: %v", err)
+		}
+		decoded, err := proto.UnmarshalWithSchema(encoded, "example.Event")
+		if err != nil {
+			t.Fatalf("UnmarshalWithSchema failed: %v", err)
+		}
+		if decoded["big"] != int64(3) || decoded["state"] != "STATE_ACTIVE" {
+			t.Errorf("Unexpected decode: %v", decoded)
+		}
+		if ts, ok := decoded["created_at"].(map[string]interface{}); !ok || ts["seconds"] != int64(1) {
+			t.Errorf("Unexpected created_at: %v", decoded["created_at"])
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		if _, err := proto.Transcode([]byte(`{"nope": 1}`), "example.Event", FormatJSON, FormatProtobuf); err == nil {
+			t.Error("Expected error for unknown JSON field")
+		}
+		if _, err := proto.Transcode(encoded, "example.Event", FormatProtobuf, Format(9)); err == nil {
+			t.Error("Expected error for unknown format")
+		}
+	})
+}
+
+func TestTranscode_TimeFractions(t *testing.T) {
+	protoContent := `
+syntax = "proto3";
+
+package example;
+
+import "google/protobuf/timestamp.proto";
+import "google/protobuf/duration.proto";
+
+message Span {
+    google.protobuf.Timestamp start = 1;
+    google.protobuf.Duration length = 2;
+}
+`
+	proto := NewProtolite([]string{"conformance_test/protos"})
+	if err := proto.LoadSchemaFromReader(strings.NewReader(protoContent), "span.proto"); err != nil {
+		t.Fatalf("Failed to load schema from reader: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		nanos    int32
+		expected string
+	}{
+		{name: "whole", nanos: 0, expected: `{"length":"-3s","start":"1970-01-01T00:00:03Z"}`},
+		{name: "millisecond", nanos: 1000000, expected: `{"length":"-3.001s","start":"1970-01-01T00:00:03.001Z"}`},
+		{name: "microsecond", nanos: 1000, expected: `{"length":"-3.000001s","start":"1970-01-01T00:00:03.000001Z"}`},
+		{name: "100ns", nanos: 100, expected: `{"length":"-3.000000100s","start":"1970-01-01T00:00:03.000000100Z"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoded, err := proto.MarshalWithSchema(map[string]interface{}{
+				"start":  map[string]interface{}{"seconds": int64(3), "nanos": tt.nanos},
+				"length": map[string]interface{}{"seconds": int64(-3), "nanos": -tt.nanos},
+			}, "example.Span")
+			if err != nil {
+				t.Fatalf("MarshalWithSchema failed: %v", err)
+			}
+			out, err := proto.Transcode(encoded, "example.Span", FormatProtobuf, FormatJSON)
+			if err != nil {
+				t.Fatalf("Transcode failed: %v", err)
+			}
+			if string(out) != tt.expected {
+				t.Errorf("Unexpected JSON:\nexpected %s\ngot      %s", tt.expected, out)
+			}
+			back, err := proto.Transcode(out, "example.Span", FormatJSON, FormatProtobuf)
+			if err != nil {
+				t.Fatalf("Transcode back failed: %v", err)
+			}
+			if !bytes.Equal(back, encoded) {
+				t.Errorf("Expected the JSON to transcode back to the original bytes:\nexpected % x\ngot      % x", encoded, back)
+			}
+		})
+	}
+}
+
+func TestTranscode_OrderJSONByFieldNumber(t *testing.T) {
+	protoContent := `
+syntax = "proto3";
+
+package example;
+
+import "google/protobuf/any.proto";
+
+message Inner {
+    string zeta = 1;
+    string alpha = 2;
+}
+
+message Outer {
+    string zulu = 1;
+    Inner inner = 2;
+    repeated Inner list = 3;
+    map<string, Inner> by_name = 4;
+    google.protobuf.Any extra = 5;
+    string apple = 6;
+}
+`
+	proto := NewProtolite([]string{"conformance_test/protos"})
+	if err := proto.LoadSchemaFromReader(strings.NewReader(protoContent), "order.proto"); err != nil {
+		t.Fatalf("Failed to load schema from reader: %v", err)
+	}
+	inner := map[string]interface{}{"zeta": "z", "alpha": "a"}
+	innerBytes, err := proto.MarshalWithSchema(inner, "example.Inner")
+	if err != nil {
+		t.Fatalf("MarshalWithSchema failed: %v", err)
+	}
+	encoded, err := proto.MarshalWithSchema(map[string]interface{}{
+		"zulu":    "z",
+		"inner":   inner,
+		"list":    []interface{}{inner},
+		"by_name": map[string]interface{}{"b": inner, "a": inner},
+		"extra":   map[string]interface{}{"type_url": "type.googleapis.com/example.Inner", "value": innerBytes},
+		"apple":   "a",
+	}, "example.Outer")
+	if err != nil {
+		t.Fatalf("MarshalWithSchema failed: %v", err)
+	}
+
+	prev := wire.GetConfig()
+	defer wire.SetConfig(prev)
+	cfg := prev
+	cfg.OrderJSONByFieldNumber = true
+	wire.SetConfig(cfg)
+
+	out, err := proto.Transcode(encoded, "example.Outer", FormatProtobuf, FormatJSON)
+	if err != nil {
+		t.Fatalf("Transcode to JSON failed: %v", err)
+	}
+	const in = `{"zeta":"z","alpha":"a"}`
+	expected := `{"zulu":"z","inner":` + in + `,"list":[` + in + `],"by_name":{"a":` + in + `,"b":` + in + `},` +
+		`"extra":{"@type":"type.googleapis.com/example.Inner","zeta":"z","alpha":"a"},"apple":"a"}`
+	if string(out) != expected {
+		t.Errorf("Unexpected JSON\nexpected: %s\ngot:      %s", expected, out)
+	}
+
+	// the ordered output is the same document
+	back, err := proto.Transcode(out, "example.Outer", FormatJSON, FormatProtobuf)
+	if err != nil {
+		t.Fatalf("Transcode to protobuf failed: %v", err)
+	}
+	again, err := proto.Transcode(back, "example.Outer", FormatProtobuf, FormatJSON)
+	if err != nil {
+		t.Fatalf("Transcode to JSON failed: %v", err)
+	}
+	if !bytes.Equal(again, out) {
+		t.Errorf("Round trip mismatch\nexpected: %s\ngot:      %s", out, again)
+	}
+}
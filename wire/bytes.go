@@ -2,8 +2,14 @@ package wire
 
 import (
 	"fmt"
+	"io"
+	"os"
 )
 
+// readerChunkSize is the amount of spare buffer capacity reserved per read
+// when copying an io.Reader of unknown length into the encoder.
+const readerChunkSize = 32 * 1024
+
 // BytesDecoder handles length-delimited bytes decoding operations
 type BytesDecoder struct {
 	decoder *Decoder
@@ -112,6 +118,76 @@ func (be *BytesEncoder) EncodeString(s string) {
 	be.EncodeBytes([]byte(s))
 }
 
+// EncodeReader encodes the contents of r as length-delimited bytes. The data is
+// copied straight into the output buffer in chunks, so a large payload is never
+// held in memory twice. When the length of r is known up front (a *os.File, or
+// a reader with a Len method such as bytes.Reader) the buffer is grown once;
+// otherwise the payload is read first and the length prefix inserted in place.
+//
+// Only a field of the message being encoded streams this way. A reader inside a
+// nested message, map value or wrapper lands in the encoder of that message,
+// which is copied once more into its parent, so such a payload is held twice.
+func (be *BytesEncoder) EncodeReader(r io.Reader) error {
+	e := be.encoder
+	// a failed read cuts the buffer back here, dropping the length prefix and
+	// whatever part of the payload was read
+	start := len(e.buf)
+	if size, ok := readerSize(r); ok {
+		NewVarintEncoder(e).EncodeVarint(uint64(size))
+		payloadStart := len(e.buf)
+		e.grow(int(size))
+		e.buf = e.buf[:payloadStart+int(size)]
+		if _, err := io.ReadFull(r, e.buf[payloadStart:]); err != nil {
+			e.buf = e.buf[:start]
+			return fmt.Errorf("failed to read bytes value: %w", err)
+		}
+		return nil
+	}
+
+	for {
+		e.grow(readerChunkSize)
+		n, err := r.Read(e.buf[len(e.buf):cap(e.buf)])
+		e.buf = e.buf[:len(e.buf)+n]
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			e.buf = e.buf[:start]
+			return fmt.Errorf("failed to read bytes value: %w", err)
+		}
+	}
+
+	// shift the payload right to make room for its length prefix
+	length := len(e.buf) - start
+	prefix := NewEncoder()
+	prefix.EncodeVarint(uint64(length))
+	e.grow(len(prefix.buf))
+	e.buf = e.buf[:len(e.buf)+len(prefix.buf)]
+	copy(e.buf[start+len(prefix.buf):], e.buf[start:start+length])
+	copy(e.buf[start:], prefix.buf)
+	return nil
+}
+
+// readerSize reports the number of bytes left in r when it can be determined
+// without consuming the reader.
+func readerSize(r io.Reader) (int64, bool) {
+	switch v := r.(type) {
+	case interface{ Len() int }:
+		return int64(v.Len()), true
+	case *os.File:
+		info, err := v.Stat()
+		if err != nil || !info.Mode().IsRegular() {
+			return 0, false
+		}
+		offset, err := v.Seek(0, io.SeekCurrent)
+		if err != nil || offset > info.Size() {
+			return 0, false
+		}
+		return info.Size() - offset, true
+	}
+	return 0, false
+}
+
 // UTILITY FUNCTIONS
 
 // BytesSize returns the size needed to encode the given bytes
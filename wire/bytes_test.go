@@ -0,0 +1,328 @@
+package wire
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/anirudhraja/protolite/schema"
+)
+
+// opaqueReader hides the Len method of the wrapped reader so the encoder
+// has to take the unknown-length path.
+type opaqueReader struct {
+	r io.Reader
+}
+
+func (o *opaqueReader) Read(p []byte) (int, error) {
+	return o.r.Read(p)
+}
+
+func TestBytesEncoder_EncodeReader(t *testing.T) {
+	payload := bytes.Repeat([]byte("protolite"), 20000) // larger than one read chunk
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "blob.bin")
+	if err := os.WriteFile(path, append([]byte("skip"), payload...), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		reader func(t *testing.T) io.Reader
+	}{
+		{
+			name: "known_length",
+			reader: func(t *testing.T) io.Reader {
+				return bytes.NewReader(payload)
+			},
+		},
+		{
+			name: "unknown_length",
+			reader: func(t *testing.T) io.Reader {
+				return &opaqueReader{r: bytes.NewReader(payload)}
+			},
+		},
+		{
+			name: "file_with_offset",
+			reader: func(t *testing.T) io.Reader {
+				f, err := os.Open(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				t.Cleanup(func() { f.Close() })
+				if _, err := f.Seek(int64(len("skip")), io.SeekStart); err != nil {
+					t.Fatal(err)
+				}
+				return f
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoder := NewEncoder()
+			encoder.EncodeString("prefix")
+			if err := NewBytesEncoder(encoder).EncodeReader(tt.reader(t)); err != nil {
+				t.Fatalf("EncodeReader failed: %v", err)
+			}
+
+			decoder := NewDecoder(encoder.Bytes())
+			if s, err := NewBytesDecoder(decoder).DecodeString(); err != nil || s != "prefix" {
+				t.Fatalf("Preceding data corrupted: %q, %v", s, err)
+			}
+			got, err := decoder.DecodeBytes()
+			if err != nil {
+				t.Fatalf("DecodeBytes failed: %v", err)
+			}
+			if !bytes.Equal(got, payload) {
+				t.Errorf("Payload mismatch: got %d bytes, want %d", len(got), len(payload))
+			}
+		})
+	}
+}
+
+// failingReader returns its data and then err.
+type failingReader struct {
+	data []byte
+	err  error
+}
+
+func (f *failingReader) Read(p []byte) (int, error) {
+	if len(f.data) == 0 {
+		return 0, f.err
+	}
+	n := copy(p, f.data)
+	f.data = f.data[n:]
+	return n, nil
+}
+
+// sizedFailingReader reports size through Len, so the encoder takes the
+// known-length path and the read falls short of it.
+type sizedFailingReader struct {
+	failingReader
+	size int
+}
+
+func (s *sizedFailingReader) Len() int {
+	return s.size
+}
+
+func TestBytesEncoder_EncodeReaderErrorLeavesNoPartialOutput(t *testing.T) {
+	readErr := errors.New("disk gone")
+	tests := []struct {
+		name   string
+		reader io.Reader
+	}{
+		{
+			name:   "known_length",
+			reader: &sizedFailingReader{failingReader: failingReader{data: []byte("partial"), err: readErr}, size: 100},
+		},
+		{
+			name:   "unknown_length",
+			reader: &failingReader{data: bytes.Repeat([]byte("partial"), 5000), err: readErr},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoder := NewEncoder()
+			encoder.EncodeString("prefix")
+			prefix := append([]byte(nil), encoder.Bytes()...)
+
+			if err := NewBytesEncoder(encoder).EncodeReader(tt.reader); err == nil {
+				t.Fatal("expected a read error")
+			}
+			if !bytes.Equal(encoder.Bytes(), prefix) {
+				t.Errorf("expected the buffer to be cut back to %x, got %d bytes", prefix, len(encoder.Bytes()))
+			}
+		})
+	}
+}
+
+func TestBytesField_ReaderValue(t *testing.T) {
+	message := &schema.Message{
+		Name: "Upload",
+		Fields: []*schema.Field{
+			{
+				Name:   "name",
+				Number: 1,
+				Type:   schema.FieldType{Kind: schema.KindPrimitive, PrimitiveType: schema.TypeString},
+			},
+			{
+				Name:   "contents",
+				Number: 2,
+				Type:   schema.FieldType{Kind: schema.KindPrimitive, PrimitiveType: schema.TypeBytes},
+			},
+		},
+	}
+	payload := []byte{0x00, 0x01, 0xFE, 0xFF}
+
+	encoded, err := EncodeMessage(map[string]interface{}{
+		"name":     "blob",
+		"contents": &opaqueReader{r: bytes.NewReader(payload)},
+	}, message, nil)
+	if err != nil {
+		t.Fatalf("Failed to encode: %v", err)
+	}
+	expected, err := EncodeMessage(map[string]interface{}{
+		"name":     "blob",
+		"contents": payload,
+	}, message, nil)
+	if err != nil {
+		t.Fatalf("Failed to encode: %v", err)
+	}
+	if !bytes.Equal(encoded, expected) {
+		t.Errorf("Reader value encoded differently from []byte: %v vs %v", encoded, expected)
+	}
+}
+
+func TestBytesField_ReaderValueInNestedMessage(t *testing.T) {
+	reg := loadTestRegistry(t, `syntax = "proto3";
+package uploads;
+
+message Blob {
+  bytes contents = 1;
+}
+
+message Upload {
+  string name = 1;
+  Blob blob = 2;
+  map<string, Blob> parts = 3;
+}
+`)
+	msg, err := reg.GetMessage("uploads.Upload")
+	if err != nil {
+		t.Fatalf("GetMessage: %v", err)
+	}
+	payload := bytes.Repeat([]byte("protolite"), 20000)
+
+	// nested readers are buffered in the nested message's encoder rather than
+	// streamed, but must still encode exactly like the []byte value
+	encoded, err := EncodeMessage(map[string]interface{}{
+		"name":  "blob",
+		"blob":  map[string]interface{}{"contents": &opaqueReader{r: bytes.NewReader(payload)}},
+		"parts": map[string]interface{}{"a": map[string]interface{}{"contents": bytes.NewReader(payload)}},
+	}, msg, reg)
+	if err != nil {
+		t.Fatalf("Failed to encode: %v", err)
+	}
+	expected, err := EncodeMessage(map[string]interface{}{
+		"name":  "blob",
+		"blob":  map[string]interface{}{"contents": payload},
+		"parts": map[string]interface{}{"a": map[string]interface{}{"contents": payload}},
+	}, msg, reg)
+	if err != nil {
+		t.Fatalf("Failed to encode: %v", err)
+	}
+	if !bytes.Equal(encoded, expected) {
+		t.Errorf("Nested reader values encoded differently from []byte: %d vs %d bytes", len(encoded), len(expected))
+	}
+}
+
+func TestRepeatedBytesField(t *testing.T) {
+	message := &schema.Message{
+		Name: "Upload",
+		Fields: []*schema.Field{
+			{
+				Name:   "chunks",
+				Number: 1,
+				Label:  schema.LabelRepeated,
+				Type:   schema.FieldType{Kind: schema.KindPrimitive, PrimitiveType: schema.TypeBytes},
+			},
+		},
+	}
+	chunks := [][]byte{{0x01, 0x02}, {}, {0xFF}}
+	// each chunk is its own length-delimited field 1
+	expected := []byte{0x0a, 0x02, 0x01, 0x02, 0x0a, 0x00, 0x0a, 0x01, 0xFF}
+
+	for name, value := range map[string]interface{}{
+		"byte_slices":    chunks,
+		"interface_list": []interface{}{chunks[0], chunks[1], chunks[2]},
+	} {
+		t.Run(name, func(t *testing.T) {
+			encoded, err := EncodeMessage(map[string]interface{}{"chunks": value}, message, nil)
+			if err != nil {
+				t.Fatalf("Failed to encode: %v", err)
+			}
+			if !bytes.Equal(encoded, expected) {
+				t.Errorf("Expected %x, got %x", expected, encoded)
+			}
+
+			decodedI, err := DecodeMessage(encoded, message, nil)
+			if err != nil {
+				t.Fatalf("Failed to decode: %v", err)
+			}
+			got, ok := decodedI.(map[string]interface{})["chunks"].([]interface{})
+			if !ok || len(got) != len(chunks) {
+				t.Fatalf("Expected %d chunks, got %#v", len(chunks), decodedI.(map[string]interface{})["chunks"])
+			}
+			for i := range chunks {
+				if b, ok := got[i].([]byte); !ok || !bytes.Equal(b, chunks[i]) {
+					t.Errorf("chunk %d: expected %x, got %#v", i, chunks[i], got[i])
+				}
+			}
+		})
+	}
+}
+
+func TestBytesField_IntListInput(t *testing.T) {
+	reg := loadTestRegistry(t, `syntax = "proto3";
+package blobs;
+
+import "google/protobuf/wrappers.proto";
+
+message Blob {
+  bytes data = 1;
+  google.protobuf.BytesValue wrapped = 2;
+}
+`)
+	msg, err := reg.GetMessage("blobs.Blob")
+	if err != nil {
+		t.Fatalf("GetMessage: %v", err)
+	}
+	want, err := EncodeMessage(map[string]interface{}{
+		"data":    []byte{1, 2, 255},
+		"wrapped": []byte{1, 2, 255},
+	}, msg, reg)
+	if err != nil {
+		t.Fatalf("Failed to encode []byte: %v", err)
+	}
+
+	inputs := []interface{}{
+		[]int{1, 2, 255},
+		[]int32{1, 2, 255},
+		[]uint64{1, 2, 255},
+		[]interface{}{1, int32(2), json.Number("255")},
+		// encoding/json without UseNumber
+		[]interface{}{float64(1), float64(2), float64(255)},
+		json.RawMessage{1, 2, 255},
+	}
+	for _, input := range inputs {
+		got, err := EncodeMessage(map[string]interface{}{"data": input, "wrapped": input}, msg, reg)
+		if err != nil {
+			t.Errorf("%#v: failed to encode: %v", input, err)
+			continue
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%#v: expected % x, got % x", input, want, got)
+		}
+	}
+
+	for _, bad := range []interface{}{
+		[]int{256},
+		[]int{-1},
+		[]interface{}{1.5},
+		[]interface{}{"1"},
+	} {
+		for _, field := range []string{"data", "wrapped"} {
+			if _, err := EncodeMessage(map[string]interface{}{field: bad}, msg, reg); err == nil {
+				t.Errorf("%s: expected %#v to be rejected", field, bad)
+			}
+		}
+	}
+}
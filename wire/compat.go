@@ -5,10 +5,120 @@ package wire
 type Config struct {
     // FillMissingScalarDefaultsOnDecode: when true, populate absent non-repeated
     // scalar and enum fields with their proto3 defaults during decode.
+    // Message, wrapper, map and oneof fields keep presence and stay absent.
+    // Repeated fields are never filled either: an absent one stays missing,
+    // while an empty packed run on the wire decodes to an empty list.
     // Defaults to false to preserve field presence semantics.
     FillMissingScalarDefaultsOnDecode bool
+
+    // DecodeUnknownToParse: when true, nested messages whose type is not
+    // registered are decoded with the schema-less Parse into "field_N" maps
+    // instead of being returned as raw bytes.
+    DecodeUnknownToParse bool
+
+    // WrapSingleRepeatedElementOnEncode: when true, a single non-slice value
+    // given for a repeated field is encoded as a one-element list instead of
+    // failing.
+    WrapSingleRepeatedElementOnEncode bool
+
+    // RejectUnknownFieldsOnEncode: when true, encoding a message map fails
+    // with an error listing every key that matches no field, instead of
+    // silently dropping those keys. The GraphQL __typename key is still
+    // accepted.
+    RejectUnknownFieldsOnEncode bool
+
+    // RejectDuplicateFieldsOnEncode: when true, encoding a message map fails
+    // if one field is given under more than one key, e.g. user_id and
+    // userId. Otherwise the proto name wins over json_name, which wins over
+    // the lowerCamel form, and the other keys are dropped.
+    RejectDuplicateFieldsOnEncode bool
+
+    // DecodeFieldNames selects the keys used for decoded fields. The default
+    // uses json_name where it is set and the proto field name otherwise.
+    DecodeFieldNames FieldNameStyle
+
+    // DecodeBytesAsBase64: when true, bytes fields and BytesValue wrappers
+    // decode to standard base64 strings instead of []byte, mirroring the
+    // base64 strings the encoder already accepts. json_bytes fields are
+    // unaffected.
+    DecodeBytesAsBase64 bool
+
+    // DecodeIntegersAsInt: when true, integer fields, their repeated
+    // elements and map values, and integer wrappers decode to Go int
+    // wherever the value fits, instead of int32, int64, uint32 or uint64.
+    // Map keys keep their width. Leave it off where the width matters,
+    // e.g. when the decoded map is encoded again or converted to JSON.
+    DecodeIntegersAsInt bool
+
+    // RejectWrapperOverflowOnDecode: when true, an Int32Value or UInt32Value
+    // wrapper whose varint does not fit its 32-bit type fails to decode
+    // instead of being truncated like a plain int32 or uint32 field.
+    RejectWrapperOverflowOnDecode bool
+
+    // DecodeWrappersAsMessages: when true, wrapper fields, their repeated
+    // elements and map values decode to {"value": scalar} maps instead of
+    // the bare scalar, so they can be told apart from plain scalar fields.
+    // The encoder accepts both shapes. json_string fields still decode to
+    // their JSON value. The UnwrapWrappers option overrides it per call.
+    DecodeWrappersAsMessages bool
+
+    // MaxFields: when positive, a single message may hold at most this many
+    // field values. Every field occurrence on the wire counts, a packed run
+    // included even when it is empty, and so does every element of a packed
+    // run, so a repeated field with more elements is rejected. Nested
+    // messages are counted on their own.
+    MaxFields int
+
+    // MaxFieldSize: when positive, the payload of a length-delimited field
+    // (string, bytes, message, map entry or packed run) may be at most this
+    // many bytes.
+    MaxFieldSize int
+
+    // JSONIntegers selects whether UnmarshalToJSONMap and Transcode write
+    // integers as JSON numbers or strings. The default follows proto3 JSON.
+    // The JSONIntegers option of those calls overrides it per call.
+    JSONIntegers JSONIntegerStyle
+
+    // OrderJSONByFieldNumber makes Transcode write the keys of every JSON
+    // object holding a message in field number order instead of the
+    // alphabetical order of encoding/json, so the output diffs cleanly.
+    OrderJSONByFieldNumber bool
+
+    // Trace: when set, is called with every decision that changes what ends
+    // up in the output: unknown fields skipped and defaults filled while
+    // decoding, unknown keys dropped and values coerced while encoding. It
+    // is meant for diagnosing interop mismatches; while it is nil each call
+    // site costs a single nil check.
+    Trace func(TraceEvent)
 }
 
+// FieldNameStyle selects how decoded field names are spelled.
+type FieldNameStyle int
+
+const (
+    // FieldNamesDeclared uses json_name when set, else the proto field name.
+    FieldNamesDeclared FieldNameStyle = iota
+    // FieldNamesProto always uses the proto field name, ignoring json_name.
+    FieldNamesProto
+    // FieldNamesCamel always uses the lowerCamelCase form of the proto field
+    // name, ignoring json_name.
+    FieldNamesCamel
+)
+
+// JSONIntegerStyle selects how integers are written in JSON output.
+type JSONIntegerStyle int
+
+const (
+    // JSONIntegersProto3 writes 64-bit integers as strings and 32-bit ones
+    // as numbers, as proto3 JSON does.
+    JSONIntegersProto3 JSONIntegerStyle = iota
+    // JSONIntegersAsNumbers writes every integer as a number. JavaScript
+    // readers lose precision on 64-bit values beyond 2^53.
+    JSONIntegersAsNumbers
+    // JSONIntegersAsStrings writes every integer as a string.
+    JSONIntegersAsStrings
+)
+
 var config = Config{
     FillMissingScalarDefaultsOnDecode: true,
 }
@@ -16,3 +126,6 @@ var config = Config{
 // SetConfig sets the global wire configuration. Defaults remain zero-valued
 // unless explicitly changed by the caller.
 func SetConfig(c Config) { config = c }
+
+// GetConfig returns the global wire configuration.
+func GetConfig() Config { return config }
@@ -2,8 +2,12 @@ package wire
 
 import (
 	"bytes"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"math"
+	"reflect"
+	"strconv"
 
 	"github.com/anirudhraja/protolite/registry"
 	"github.com/anirudhraja/protolite/schema"
@@ -16,13 +20,19 @@ type Decoder struct {
 	buf      []byte
 	pos      int
 	registry *registry.Registry
+	keepRaw     bool               // populate Value.Raw in DecodeField
+	only        map[int32]struct{} // when set, DecodeWithSchema decodes just these field numbers
+	keepUnknown bool               // keep unknown fields under field_<number>, see ParseWithSchema
+	presence    map[string]bool    // when set, records the fields seen on the wire, see DecodeMessageWithPresence
+	opts        decodeOptions      // per-call settings, see DecodeOption
 }
 
 // NewDecoder creates a new wire format decoder
 func NewDecoder(data []byte) *Decoder {
 	return &Decoder{
-		buf: data,
-		pos: 0,
+		buf:  data,
+		pos:  0,
+		opts: newDecodeOptions(nil),
 	}
 }
 
@@ -32,95 +42,245 @@ func NewDecoderWithRegistry(data []byte, registry *registry.Registry) *Decoder {
 		buf:      data,
 		pos:      0,
 		registry: registry,
+		opts:     newDecodeOptions(nil),
 	}
 }
 
-// DecodeMessage decodes protobuf bytes using schema - main entry point
-func DecodeMessage(data []byte, msg *schema.Message, registry *registry.Registry) (interface{}, error) {
+// SetKeepRawBytes makes DecodeField also return the verbatim encoding of each
+// field (tag through end of value) in Value.Raw, so it can be re-emitted untouched.
+func (d *Decoder) SetKeepRawBytes(keep bool) {
+	d.keepRaw = keep
+}
+
+// DecodeMessage decodes protobuf bytes using schema - main entry point. opts
+// apply to this call only, see DecodeOption.
+func DecodeMessage(data []byte, msg *schema.Message, registry *registry.Registry, opts ...DecodeOption) (interface{}, error) {
 	decoder := NewDecoderWithRegistry(data, registry)
+	decoder.opts = newDecodeOptions(opts)
 	return decoder.DecodeWithSchema(msg)
 }
 
+// DecodeMessageFields decodes only the fields of msg with the given numbers.
+// The other fields are skipped on the wire without being decoded, while a
+// selected message field is decoded in full.
+func DecodeMessageFields(data []byte, msg *schema.Message, registry *registry.Registry, fieldNumbers []int32) (interface{}, error) {
+	decoder := NewDecoderWithRegistry(data, registry)
+	decoder.only = make(map[int32]struct{}, len(fieldNumbers))
+	for _, number := range fieldNumbers {
+		decoder.only[number] = struct{}{}
+	}
+	return decoder.DecodeWithSchema(msg)
+}
+
+// ParseWithSchema decodes like DecodeMessage but keeps the fields msg does not
+// know, in nested messages too, keyed and described as Parse does: each unknown
+// field becomes "field_<number>" with its wire type and raw decoded value.
+func ParseWithSchema(data []byte, msg *schema.Message, registry *registry.Registry) (interface{}, error) {
+	decoder := NewDecoderWithRegistry(data, registry)
+	decoder.keepUnknown = true
+	return decoder.DecodeWithSchema(msg)
+}
+
+// DecodeMessageWithPresence decodes like DecodeMessage and also reports, by
+// decoded field name, which fields of msg actually appeared on the wire, before
+// absent fields are filled with defaults or set to null by the null tracker.
+// Presence is reported for the fields of msg itself, not of nested messages.
+func DecodeMessageWithPresence(data []byte, msg *schema.Message, registry *registry.Registry) (interface{}, map[string]bool, error) {
+	decoder := NewDecoderWithRegistry(data, registry)
+	decoder.presence = make(map[string]bool)
+	decoded, err := decoder.DecodeWithSchema(msg)
+	return decoded, decoder.presence, err
+}
+
+// selected reports whether field is decoded, see DecodeMessageFields. The null
+// tracker is always decoded since it decides which selected fields are null.
+func (d *Decoder) selected(field *schema.Field) bool {
+	if d.only == nil || schema.IsNullTrackerField(field) {
+		return true
+	}
+	_, ok := d.only[field.Number]
+	return ok
+}
+
 // Main decoding methods that orchestrate the individual decoders
 func (d *Decoder) DecodeWithSchema(msg *schema.Message) (interface{}, error) {
 	result := make(map[string]interface{})
-	mapCollector := make(map[string]map[interface{}]interface{})
-	repeatedCollector := make(map[string][]interface{})
+	// The collectors are only allocated once a map or repeated field shows up,
+	// so flat messages of singular fields write straight into result.
+	var mapCollector map[string]map[interface{}]interface{}
+	var repeatedCollector map[string][]interface{}
 
 	initNull(result, msg)
 
+	// With the CollectErrors option, malformed fields are skipped and their
+	// errors gathered here instead of failing the whole message.
+	var fieldErrs []error
+	// field values seen so far, checked against config.MaxFields
+	fieldCount := 0
+
+fields:
 	for d.pos < len(d.buf) {
 		// Read field tag using varint decoder
 		tag, err := d.DecodeVarint()
 		if err != nil {
+			if d.opts.collectErrors {
+				fieldErrs = append(fieldErrs, wrapWithField(err, msg.Name))
+				break
+			}
 			return nil, wrapWithField(err, msg.Name)
 		}
+		valueStart := d.pos
 
 		fieldNumber, wireType := ParseTag(Tag(tag))
 
         // Field number 0 is illegal in protobuf
         if fieldNumber == 0 {
+            if d.opts.collectErrors {
+                fieldErrs = append(fieldErrs, fmt.Errorf("illegal field number 0"))
+                break
+            }
             return nil, fmt.Errorf("illegal field number 0")
         }
 		switch wireType {
-		case WireVarint, WireFixed64, WireBytes, WireFixed32:
+		case WireVarint, WireFixed64, WireBytes, WireFixed32, WireStartGroup:
 			// do nothing for known/allowed types
 		default:
+			if d.opts.collectErrors {
+				fieldErrs = append(fieldErrs, fmt.Errorf("unknown wire type: %d", wireType))
+				break fields
+			}
 			return nil, fmt.Errorf("unknown wire type: %d", wireType)
 		}
-		// Find field in schema
-		var field *schema.Field
-		for _, f := range msg.Fields {
-			if f.Number == int32(fieldNumber) {
-				field = f
-				break
+		// Structural limits reject the message even when collecting errors
+		fieldCount++
+		if err := checkFieldCount(fieldCount, msg); err != nil {
+			return nil, err
+		}
+		if err := d.checkFieldSize(fieldNumber, wireType); err != nil {
+			return nil, wrapWithField(err, msg.Name)
+		}
+		if isMessageSetItem(msg, fieldNumber, wireType) {
+			if err := d.decodeMessageSetItem(result, msg); err != nil {
+				err = wrapWithField(err, msg.Name)
+				if !d.skipBadField(&fieldErrs, err, valueStart, fieldNumber, wireType) {
+					return nil, err
+				}
 			}
+			continue
 		}
-		// attempt to find it in oneof fields
-		if field == nil {
-			for _, f := range msg.OneofGroups {
-				for _, oneOfField := range f.Fields {
-					if oneOfField.Number == int32(fieldNumber) {
-						field = oneOfField
-						break
-					}
+		// Find field in schema
+		// regular, oneof and extension fields are all looked up by number
+		field := getFieldByNumber(msg, int32(fieldNumber))
+		reserved := field == nil && config.Trace != nil && msg.IsReserved(int32(fieldNumber))
+		if reserved {
+			config.Trace(TraceEvent{Kind: TraceReservedFieldSeen, Message: msg.Name, Field: fmt.Sprintf("field_%d", fieldNumber), Detail: fmt.Sprintf("wire type %d", wireType)})
+		}
+		if field == nil && d.keepUnknown {
+			err := d.decodeUnknownField(result, fieldNumber, wireType)
+			if err != nil {
+				if d.opts.collectErrors {
+					fieldErrs = append(fieldErrs, wrapWithField(err, msg.Name))
+					break
 				}
+				return nil, wrapWithField(err, msg.Name)
 			}
+			continue
 		}
-		// Unknown field - skip it
-		if field == nil {
-			err := d.skipField(wireType)
+		// Unknown or unselected field - skip it
+		if field == nil && config.Trace != nil && !reserved {
+			config.Trace(TraceEvent{Kind: TraceUnknownFieldSkipped, Message: msg.Name, Field: fmt.Sprintf("field_%d", fieldNumber), Detail: fmt.Sprintf("wire type %d", wireType)})
+		}
+		if field == nil || !d.selected(field) {
+			err := d.skipField(fieldNumber, wireType)
 			if err != nil {
+				if d.opts.collectErrors {
+					fieldErrs = append(fieldErrs, wrapWithField(err, msg.Name))
+					break
+				}
 				return nil, wrapWithField(err, msg.Name)
 			}
 			continue
 		}
-		fieldName := getFieldName(field)
-		// Decode using appropriate decoder
-		value, isPackedType, err := d.DecodeTypedField(field, wireType)
-		if err != nil {
-			return nil, wrapWithField(err, fieldName)
+		if wireType == WireStartGroup {
+			err := wrapWithField(fmt.Errorf("group encoding is not supported"), getFieldName(field))
+			if !d.skipBadField(&fieldErrs, err, valueStart, fieldNumber, wireType) {
+				return nil, err
+			}
+			continue
 		}
-
-		// Handle different field types
+		fieldName := getFieldName(field)
 		if field.Type.Kind == schema.KindMap {
-			// Handle maps specially
+			// Handle maps specially, collecting entries straight from the map decoder
+			key, value, err := NewMapDecoder(d).DecodeMapEntry(field.Type.MapKey, field.Type.MapValue)
+			if err != nil {
+				err = wrapWithField(err, fieldName)
+				if !d.skipBadField(&fieldErrs, err, valueStart, fieldNumber, wireType) {
+					return nil, err
+				}
+				continue
+			}
+			if mapCollector == nil {
+				mapCollector = make(map[string]map[interface{}]interface{})
+			}
 			if mapCollector[fieldName] == nil {
 				mapCollector[fieldName] = make(map[interface{}]interface{})
 			}
-			if entryMap, ok := value.(map[string]interface{}); ok {
-				mapCollector[fieldName][entryMap["key"]] = entryMap["value"]
+			// a key repeated on the wire keeps its last value, as protoc does
+			mapCollector[fieldName][key] = value
+			d.markPresent(field, fieldName)
+			continue
+		}
+		// Decode using appropriate decoder
+		value, isPackedType, err := d.DecodeTypedField(field, wireType)
+		if nested, ok := err.(*DecodeErrors); ok && value != nil {
+			// a nested message decoded partially; keep it and report its errors under this field
+			for _, nestedErr := range nested.Errors {
+				if field.Label == schema.LabelRepeated {
+					nestedErr = wrapWithIndex(nestedErr, len(repeatedCollector[fieldName]))
+				}
+				fieldErrs = append(fieldErrs, wrapWithField(nestedErr, fieldName))
+			}
+		} else if err != nil {
+			if field.Label == schema.LabelRepeated && !isPackedPayload(field, wireType) {
+				err = wrapWithIndex(err, len(repeatedCollector[fieldName]))
+			}
+			err = wrapWithField(err, fieldName)
+			if !d.skipBadField(&fieldErrs, err, valueStart, fieldNumber, wireType) {
+				return nil, err
+			}
+			continue
+		}
+
+		if elements, ok := value.([]interface{}); ok && isPackedType {
+			// the run's tag was counted above, each of its elements counts
+			// too, so even empty runs add up
+			fieldCount += len(elements)
+			if err := checkFieldCount(fieldCount, msg); err != nil {
+				return nil, err
 			}
-		} else if field.Label == schema.LabelRepeated && !isPackedType {
+		}
+
+		// Handle different field types
+		if field.Label == schema.LabelRepeated {
 			// Handle repeated fields
-			if repeatedCollector[fieldName] == nil {
-				repeatedCollector[fieldName] = make([]interface{}, 0)
+			if repeatedCollector == nil {
+				repeatedCollector = make(map[string][]interface{})
+			}
+			if elements, ok := value.([]interface{}); ok && isPackedType {
+				// packed runs and single elements concatenate, and an empty
+				// run still marks the field present as an empty list
+				if repeatedCollector[fieldName] == nil {
+					repeatedCollector[fieldName] = make([]interface{}, 0, len(elements))
+				}
+				repeatedCollector[fieldName] = append(repeatedCollector[fieldName], elements...)
+			} else {
+				repeatedCollector[fieldName] = append(repeatedCollector[fieldName], value)
 			}
-			repeatedCollector[fieldName] = append(repeatedCollector[fieldName], value)
 		} else {
 			// Handle regular fields
 			result[fieldName] = value
 		}
+		d.markPresent(field, fieldName)
 	}
 
 	// Add collected maps to result
@@ -200,6 +360,10 @@ func (d *Decoder) DecodeWithSchema(msg *schema.Message) (interface{}, error) {
 							return nil, fmt.Errorf("invalid null tracker field number type")
 						}
 						field := getFieldByNumber(msg, fieldNumber32)
+						if field == nil || !d.selected(field) {
+							// tracker written against a newer schema, nothing to null out
+							continue
+						}
 						result[getFieldName(field)] = nil
 					}
 				}
@@ -207,21 +371,25 @@ func (d *Decoder) DecodeWithSchema(msg *schema.Message) (interface{}, error) {
 				return nil, fmt.Errorf("invalid null tracker format")
 			}
 		}
-
-		delete(result, schema.NullTrackerFieldName)
 	} else if config.FillMissingScalarDefaultsOnDecode{
 		for _, field := range msg.Fields {
-			if field.Label == schema.LabelRepeated {
+			if field.Label == schema.LabelRepeated || !d.selected(field) {
 				continue
 			}
 
 			fieldName := getFieldName(field)
 			// add default values only when its not present in result
 			if _, ok := result[fieldName]; !ok {
-				if field.Type.Kind == schema.KindPrimitive { // add default for primitive types except bytes
+				if field.DefaultValue != "" { // proto2 [default = ...] declared on the field
+					value, err := d.declaredDefault(field)
+					if err != nil {
+						return nil, wrapWithField(err, fieldName)
+					}
+					result[fieldName] = value
+				} else if field.Type.Kind == schema.KindPrimitive { // add default for primitive types except bytes
 					result[fieldName] = getDefaultValue(field.Type.PrimitiveType)
 				} else if field.Type.Kind == schema.KindEnum { // add default value 0 for enum cases
-					enum, err := d.registry.GetEnum(field.Type.EnumType)
+					enum, err := d.getEnum(field)
 					if err != nil {
 						return nil, err
 					}
@@ -231,43 +399,214 @@ func (d *Decoder) DecodeWithSchema(msg *schema.Message) (interface{}, error) {
 					}
 					result[fieldName] = enumDefaultStringVal
 				}
+				if value, ok := result[fieldName]; ok && config.Trace != nil {
+					config.Trace(TraceEvent{Kind: TraceDefaultFilled, Message: msg.Name, Field: fieldName, Detail: fmt.Sprintf("%#v", value)})
+				}
 			}
 		}
 	}
 
+	if config.DecodeIntegersAsInt {
+		integersAsInt(result, msg)
+	}
+
+	// The null tracker is an internal helper consumed above, it must never
+	// surface in the decoded output.
+	if schema.IsNullTrackerField(getFieldByNumber(msg, schema.NullTrackerFieldNumber)) {
+		delete(result, schema.NullTrackerFieldName)
+	}
+
 	// when message is wrapper, empty message on wire means
 	// two different values based on wrapped item type. If
 	// wrapped item is of repeated type, it means empty list,
 	// otherwise null.
 	if msg.IsWrapper {
-		var field *schema.Field
-		if len(msg.Fields) > 0 {
-			field = msg.Fields[0]
-		}
-		if len(msg.OneofGroups) > 0 {
-			typeName := msg.OneofGroups[0].Fields[0].JsonName
-			for k := range result {
-				typeName = k
-				break
+		field := wrapperField(msg)
+		if unions := unionOneofs(msg); len(unions) > 0 {
+			field = decodedUnionMember(result, unions)
+			key := getFieldName(field)
+			if result[key] == nil {
+				result[key] = make(map[string]interface{})
 			}
-			if oneOfField := getOneOfField(msg, typeName); oneOfField != nil {
-				field = oneOfField
+			if member, ok := result[key].(map[string]interface{}); ok {
+				member[gqlTypeNameField] = unionTypeName(field)
 			}
-			if result[typeName] == nil {
-				result[typeName] = make(map[string]interface{})
-			}
-			result[typeName].(map[string]interface{})[gqlTypeNameField] = typeName
+		}
+		if field == nil {
+			return nil, fmt.Errorf("missing wrapped field in %s", msg.Name)
 		}
 		wrappedVal := result[getFieldName(field)]
 		if wrappedVal == nil {
-			if msg.Fields[0].Label == schema.LabelRepeated {
-				return []interface{}{}, nil
+			if field.Label == schema.LabelRepeated {
+				return []interface{}{}, decodeErrors(fieldErrs)
 			}
-			return nil, nil
+			return nil, decodeErrors(fieldErrs)
 		}
-		return wrappedVal, nil
+		return wrappedVal, decodeErrors(fieldErrs)
 	}
-	return result, nil
+	return result, decodeErrors(fieldErrs)
+}
+
+// integersAsInt replaces the decoded values of the integer fields of msg with
+// Go ints where they fit, see Config.DecodeIntegersAsInt
+func integersAsInt(result map[string]interface{}, msg *schema.Message) {
+	convert := func(field *schema.Field, t *schema.FieldType) {
+		if !isIntegerType(t) {
+			return
+		}
+		value, ok := result[getFieldName(field)]
+		if !ok {
+			return
+		}
+		if elements, ok := value.([]interface{}); ok {
+			for i := range elements {
+				elements[i] = asInt(elements[i])
+			}
+			return
+		}
+		if field.Type.Kind == schema.KindMap {
+			rv := reflect.ValueOf(value)
+			if rv.Kind() != reflect.Map || rv.Type().Elem().Kind() != reflect.Interface {
+				return
+			}
+			iter := rv.MapRange()
+			for iter.Next() {
+				rv.SetMapIndex(iter.Key(), reflect.ValueOf(asInt(iter.Value().Interface())))
+			}
+			return
+		}
+		result[getFieldName(field)] = asInt(value)
+	}
+	fields := append([]*schema.Field(nil), msg.Fields...)
+	for _, oneof := range msg.OneofGroups {
+		fields = append(fields, oneof.Fields...)
+	}
+	for _, field := range append(fields, msg.Extensions...) {
+		if field.Type.Kind == schema.KindMap {
+			if field.Type.MapValue != nil {
+				convert(field, field.Type.MapValue)
+			}
+			continue
+		}
+		convert(field, &field.Type)
+	}
+}
+
+// isIntegerType reports whether t is an integer scalar or integer wrapper
+func isIntegerType(t *schema.FieldType) bool {
+	switch t.Kind {
+	case schema.KindPrimitive:
+		switch t.PrimitiveType {
+		case schema.TypeInt32, schema.TypeInt64, schema.TypeUint32, schema.TypeUint64,
+			schema.TypeSint32, schema.TypeSint64, schema.TypeFixed32, schema.TypeFixed64,
+			schema.TypeSfixed32, schema.TypeSfixed64:
+			return true
+		}
+	case schema.KindWrapper:
+		switch t.WrapperType {
+		case schema.WrapperInt32Value, schema.WrapperInt64Value, schema.WrapperUInt32Value, schema.WrapperUInt64Value:
+			return true
+		}
+	}
+	return false
+}
+
+// asInt converts a decoded integer to int when it fits; anything else is returned as is
+func asInt(value interface{}) interface{} {
+	switch v := value.(type) {
+	case int32:
+		return int(v)
+	case uint32:
+		if uint64(v) <= math.MaxInt {
+			return int(v)
+		}
+	case int64:
+		if v >= math.MinInt && v <= math.MaxInt {
+			return int(v)
+		}
+	case uint64:
+		if v <= math.MaxInt {
+			return int(v)
+		}
+	case map[string]interface{}:
+		// an integer wrapper decoded with DecodeWrappersAsMessages
+		if inner, ok := v["value"]; ok {
+			v["value"] = asInt(inner)
+		}
+	}
+	return value
+}
+
+// markPresent records that field appeared on the wire when presence is tracked
+func (d *Decoder) markPresent(field *schema.Field, fieldName string) {
+	if d.presence != nil && !schema.IsNullTrackerField(field) {
+		d.presence[fieldName] = true
+	}
+}
+
+// checkFieldCount enforces config.MaxFields on the field values of msg
+func checkFieldCount(count int, msg *schema.Message) error {
+	if config.MaxFields > 0 && count > config.MaxFields {
+		return fmt.Errorf("message %s has more than %d field values, the MaxFields limit", msg.Name, config.MaxFields)
+	}
+	return nil
+}
+
+// checkFieldSize enforces config.MaxFieldSize on a length-delimited field whose
+// length prefix starts at the current position, without consuming it. A
+// malformed length is left for the field decode to report.
+func (d *Decoder) checkFieldSize(fieldNumber FieldNumber, wireType WireType) error {
+	if config.MaxFieldSize <= 0 || wireType != WireBytes {
+		return nil
+	}
+	start := d.pos
+	length, err := d.DecodeVarint()
+	d.pos = start
+	if err != nil {
+		return nil
+	}
+	if length > uint64(config.MaxFieldSize) {
+		return fmt.Errorf("field %d is %d bytes, more than the MaxFieldSize limit of %d", fieldNumber, length, config.MaxFieldSize)
+	}
+	return nil
+}
+
+// decodeUnknownField stores a field missing from the schema in result the way
+// Parse would, so the last occurrence of a field number wins
+func (d *Decoder) decodeUnknownField(result map[string]interface{}, fieldNumber FieldNumber, wireType WireType) error {
+	data, err := d.decodeRawValue(fieldNumber, wireType)
+	if err != nil {
+		return err
+	}
+	result[fmt.Sprintf("field_%d", fieldNumber)] = map[string]interface{}{
+		"type":  wireTypeName(wireType),
+		"value": data,
+	}
+	return nil
+}
+
+// skipBadField records err and moves past the field whose value failed to
+// decode when the CollectErrors option is set; otherwise it reports false and
+// the caller fails with err. A field that cannot be skipped, e.g. because its
+// length runs past the input, ends decoding with what was read so far.
+func (d *Decoder) skipBadField(errs *[]error, err error, valueStart int, fieldNumber FieldNumber, wireType WireType) bool {
+	if !d.opts.collectErrors {
+		return false
+	}
+	*errs = append(*errs, err)
+	d.pos = valueStart
+	if skipErr := d.skipField(fieldNumber, wireType); skipErr != nil {
+		d.pos = len(d.buf)
+	}
+	return true
+}
+
+// decodeErrors returns the collected field errors as a *DecodeErrors, or nil if there are none
+func decodeErrors(errs []error) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	return &DecodeErrors{Errors: errs}
 }
 
 func getFieldByNumber(msg *schema.Message, fieldNumber int32) *schema.Field {
@@ -283,6 +622,11 @@ func getFieldByNumber(msg *schema.Message, fieldNumber int32) *schema.Field {
 			}
 		}
 	}
+	for _, field := range msg.Extensions {
+		if field.Number == fieldNumber {
+			return field
+		}
+	}
 	return nil
 }
 
@@ -297,6 +641,10 @@ func initNull(result map[string]interface{}, msg *schema.Message) {
 
 // DecodeTypedField routes to the appropriate decoder based on field type
 func (d *Decoder) DecodeTypedField(field *schema.Field, wireType WireType) (interface{}, bool, error) {
+	if codec := field.Codec(); codec != nil {
+		value, err := d.decodeWithCodec(codec, wireType)
+		return value, false, err
+	}
 	fieldType := field.Type
 	switch fieldType.Kind {
 	case schema.KindPrimitive:
@@ -319,40 +667,33 @@ func (d *Decoder) DecodeTypedField(field *schema.Field, wireType WireType) (inte
 		value, err := md.DecodeMessage(fieldType.MessageType)
 		return value, false, err
 	case schema.KindEnum:
-		var err error
-		result := make([]interface{}, 0)
 		// first check if the enum is registered
-		enum, err := d.registry.GetEnum(fieldType.EnumType)
+		enum, err := d.getEnum(field)
 		if err != nil {
 			return nil, false, err
 		}
-	vd := NewVarintDecoder(d)
 	// Check if this is packed (length-delimited) or unpacked (single varint)
 	// Packed: WireBytes (type 2) - read length, then all values
 	// Unpacked: WireVarint (type 0) - read single value, main decoder accumulates
 	if field.Label == schema.LabelRepeated && wireType == WireBytes {
-		// Packed repeated enum: read length, then decode all values
-		length, err := vd.DecodeVarint()
-		if err != nil {
-			return nil, false, err
-		}
-		// one by one read the bytes and find the relevant field name for it.
-		end := d.pos + int(length)
-		for d.pos < end {
-			vd := NewVarintDecoder(d)
-			enumIntVal, err := vd.DecodeEnum()
+		// Packed repeated enum: one by one read the values and find the relevant name for each
+		result, err := d.decodePackedRun(func() (interface{}, error) {
+			enumIntVal, err := NewVarintDecoder(d).DecodeEnum()
 			if err != nil {
-				return nil, false, err
+				return nil, err
+			}
+			enumStringVal, err := d.findEnumValue(enum, enumIntVal)
+			if err != nil {
+				return fmt.Sprintf("%d", enumIntVal), nil
 			}
-		enumStringVal, err := d.findEnumValue(enum, enumIntVal)
+			return enumStringVal, nil
+		})
 		if err != nil {
-			result = append(result, fmt.Sprintf("%d", enumIntVal))
-			continue
-		}
-		result = append(result, enumStringVal)
+			return nil, false, err
 		}
 		return result, true, nil
 	}
+	vd := NewVarintDecoder(d)
 	// Single enum value (either non-repeated, or unpacked repeated)
 	enumIntVal, err := vd.DecodeEnum()
 	if err != nil {
@@ -377,13 +718,32 @@ func (d *Decoder) DecodeTypedField(field *schema.Field, wireType WireType) (inte
 		}, false, nil
 	case schema.KindWrapper:
 		value, err := d.decodeWrapper(fieldType.WrapperType, wireType, field.JSONString)
+		if err == nil && d.opts.wrappersAsMessages && !field.JSONString {
+			value = map[string]interface{}{"value": value}
+		}
 		return value, false, err
 	default:
-		value, err := d.decodeRawValue(wireType)
+		value, err := d.decodeRawValue(FieldNumber(field.Number), wireType)
 		return value, false, err
 	}
 }
 
+// decodeWithCodec hands the payload of a field to its registered codec
+func (d *Decoder) decodeWithCodec(codec schema.FieldCodec, wireType WireType) (interface{}, error) {
+	if wireType != WireBytes {
+		return nil, fmt.Errorf("field codec expects wire type %d, got %d", WireBytes, wireType)
+	}
+	payload, err := NewBytesDecoder(d).DecodeBytes()
+	if err != nil {
+		return nil, err
+	}
+	value, err := codec.Decode(payload)
+	if err != nil {
+		return nil, fmt.Errorf("field codec: %w", err)
+	}
+	return value, nil
+}
+
 // decodeJSONBytes interprets the raw bytes of a json_bytes field as a JSON
 // document and decodes it into a Go value. Numbers are preserved as
 // json.Number to match the rest of the library and avoid precision loss.
@@ -413,31 +773,36 @@ func (d *Decoder) decodePrimitive(field *schema.Field, wireType WireType) (inter
 			if field.Label != schema.LabelRepeated {
 				return nil, false, fmt.Errorf("wire type (2) for primitive scalars has to be repeated")
 			}
-			vd := NewVarintDecoder(d)
-			length, err := vd.DecodeVarint()
+			res, err := d.decodePackedRun(func() (interface{}, error) {
+				return d.decodePrimitiveHelper(primitiveType)
+			})
 			if err != nil {
 				return nil, false, err
 			}
-			res := make([]interface{}, 0)
-			end := d.pos + int(length)
-			for d.pos < end {
-				val, err := d.decodePrimitiveHelper(primitiveType)
-				if err != nil {
-					return nil, false, err
-				}
-				res = append(res, val)
-			}
 			return res, true, nil
 		} else {
 			// for string and bytes , its never packed even its repeated so decode and return
 			bd := NewBytesDecoder(d)
+			if primitiveType == schema.TypeString {
+				// string() already copies, so read the shared buffer directly
+				rawValue, err := bd.DecodeRawBytes()
+				if err != nil {
+					return nil, false, err
+				}
+				return string(rawValue), false, nil
+			}
+			if config.DecodeBytesAsBase64 && !field.JSONBytes {
+				// the encoded string is a copy, so read the shared buffer directly
+				rawValue, err := bd.DecodeRawBytes()
+				if err != nil {
+					return nil, false, err
+				}
+				return bytesValue(rawValue), false, nil
+			}
 			rawValue, err := bd.DecodeBytes()
 			if err != nil {
 				return nil, false, err
 			}
-			if primitiveType == schema.TypeString {
-				return string(rawValue), false, nil
-			}
 			return rawValue, false, nil
 		}
 	}
@@ -446,6 +811,33 @@ func (d *Decoder) decodePrimitive(field *schema.Field, wireType WireType) (inter
 	return value, false, err
 }
 
+// decodePackedRun reads the length of a packed run and then its elements with
+// decodeElement. Errors carry the index of the failing element, and a run whose
+// length overruns the input or whose last element crosses that length is
+// rejected instead of reading into the following fields.
+func (d *Decoder) decodePackedRun(decodeElement func() (interface{}, error)) ([]interface{}, error) {
+	length, err := NewVarintDecoder(d).DecodeVarint()
+	if err != nil {
+		return nil, fmt.Errorf("packed length: %w", err)
+	}
+	if remaining := uint64(len(d.buf) - d.pos); length > remaining {
+		return nil, fmt.Errorf("packed length %d exceeds the %d bytes left", length, remaining)
+	}
+	end := d.pos + int(length)
+	res := make([]interface{}, 0)
+	for d.pos < end {
+		val, err := decodeElement()
+		if err != nil {
+			return nil, wrapWithIndex(fmt.Errorf("packed element: %w", err), len(res))
+		}
+		if d.pos > end {
+			return nil, wrapWithIndex(fmt.Errorf("packed element runs %d byte(s) past the packed length %d", d.pos-end, length), len(res))
+		}
+		res = append(res, val)
+	}
+	return res, nil
+}
+
 func (d *Decoder) decodePrimitiveHelper(primitiveType schema.PrimitiveType) (any, error) {
 	switch primitiveType {
 	case schema.TypeInt32, schema.TypeInt64, schema.TypeUint32, schema.TypeUint64,
@@ -514,7 +906,9 @@ func (d *Decoder) decodeWrapper(wrapperType schema.WrapperType, wireType WireTyp
 	// Create a new decoder for the wrapper message content
 	wrapperDecoder := NewDecoder(wrapperBytes)
 
-	// Decode the wrapper value field (field number 1)
+	// Decode the wrapper value field (field number 1). A present but empty
+	// wrapper holds the typed zero value, which keeps it distinct from an
+	// unset wrapper field across a decode/encode round trip.
 	if wrapperDecoder.pos >= len(wrapperDecoder.buf) {
 		switch wrapperType {
 		case schema.WrapperDoubleValue:
@@ -534,7 +928,7 @@ func (d *Decoder) decodeWrapper(wrapperType schema.WrapperType, wireType WireTyp
 		case schema.WrapperStringValue:
 			return "", nil
 		case schema.WrapperBytesValue:
-			return []byte{}, nil
+			return bytesValue([]byte{}), nil
 		default:
 			// Empty wrapper message means nil value
 			return nil, nil
@@ -595,6 +989,10 @@ func (d *Decoder) decodeWrapper(wrapperType schema.WrapperType, wireType WireTyp
 		if err != nil {
 			return nil, err
 		}
+		// negative values are sign-extended to ten bytes on the wire
+		if config.RejectWrapperOverflowOnDecode && int64(rawValue) != int64(int32(rawValue)) {
+			return nil, fmt.Errorf("Int32Value %d overflows int32", int64(rawValue))
+		}
 		return int32(rawValue), nil
 
 	case schema.WrapperUInt32Value:
@@ -606,6 +1004,9 @@ func (d *Decoder) decodeWrapper(wrapperType schema.WrapperType, wireType WireTyp
 		if err != nil {
 			return nil, err
 		}
+		if config.RejectWrapperOverflowOnDecode && rawValue > math.MaxUint32 {
+			return nil, fmt.Errorf("UInt32Value %d overflows uint32", rawValue)
+		}
 		return uint32(rawValue), nil
 
 	case schema.WrapperBoolValue:
@@ -640,15 +1041,20 @@ func (d *Decoder) decodeWrapper(wrapperType schema.WrapperType, wireType WireTyp
 			return nil, fmt.Errorf("expected bytes wire type for BytesValue, got %d", valueWireType)
 		}
 		bd := NewBytesDecoder(wrapperDecoder)
-		return bd.DecodeBytes()
+		wrappedBytes, err := bd.DecodeBytes()
+		if err != nil {
+			return nil, err
+		}
+		return bytesValue(wrappedBytes), nil
 
 	default:
 		return nil, fmt.Errorf("unsupported wrapper type: %s", wrapperType)
 	}
 }
 
-// decodeRawValue decodes without type information
-func (d *Decoder) decodeRawValue(wireType WireType) (interface{}, error) {
+// decodeRawValue decodes without type information. A group yields the raw
+// bytes of its body, without the closing end-group tag.
+func (d *Decoder) decodeRawValue(fieldNumber FieldNumber, wireType WireType) (interface{}, error) {
 	switch wireType {
 	case WireVarint:
 		vd := NewVarintDecoder(d)
@@ -662,13 +1068,23 @@ func (d *Decoder) decodeRawValue(wireType WireType) (interface{}, error) {
 	case WireFixed32:
 		fd := NewFixedDecoder(d)
 		return fd.DecodeFixed32()
+	case WireStartGroup:
+		start := d.pos
+		bodyEnd, err := d.skipGroup(fieldNumber)
+		if err != nil {
+			return nil, err
+		}
+		// Copy the body to avoid sharing the underlying buffer
+		body := make([]byte, bodyEnd-start)
+		copy(body, d.buf[start:bodyEnd])
+		return body, nil
 	default:
 		return nil, fmt.Errorf("unknown wire type: %d", wireType)
 	}
 }
 
 // skipField skips a field based on wire type
-func (d *Decoder) skipField(wireType WireType) error {
+func (d *Decoder) skipField(fieldNumber FieldNumber, wireType WireType) error {
 	switch wireType {
 	case WireVarint:
 		vd := NewVarintDecoder(d)
@@ -688,17 +1104,47 @@ func (d *Decoder) skipField(wireType WireType) error {
 		}
 		d.pos += 4
 		return nil
+	case WireStartGroup:
+		_, err := d.skipGroup(fieldNumber)
+		return err
 	default:
 		return fmt.Errorf("unknown wire type: %d", wireType)
 	}
 }
 
+// skipGroup skips a group body, including nested groups, through the
+// end-group tag matching fieldNumber. It returns the position at which that
+// end-group tag starts.
+func (d *Decoder) skipGroup(fieldNumber FieldNumber) (int, error) {
+	for {
+		if d.pos >= len(d.buf) {
+			return 0, fmt.Errorf("unterminated group for field %d", fieldNumber)
+		}
+		tagStart := d.pos
+		tag, err := d.DecodeVarint()
+		if err != nil {
+			return 0, err
+		}
+		nestedNumber, wireType := ParseTag(Tag(tag))
+		if wireType == WireEndGroup {
+			if nestedNumber != fieldNumber {
+				return 0, fmt.Errorf("mismatched end group: expected field %d, got %d", fieldNumber, nestedNumber)
+			}
+			return tagStart, nil
+		}
+		if err := d.skipField(nestedNumber, wireType); err != nil {
+			return 0, err
+		}
+	}
+}
+
 // DecodeField decodes a single field from the current position (backward compatibility)
 func (d *Decoder) DecodeField() (*Value, error) {
 	if d.pos >= len(d.buf) {
 		return nil, nil
 	}
 
+	start := d.pos
 	tag, err := d.DecodeVarint()
 	if err != nil {
 		return nil, err
@@ -706,25 +1152,45 @@ func (d *Decoder) DecodeField() (*Value, error) {
 
 	fieldNumber, wireType := ParseTag(Tag(tag))
 
-	data, err := d.decodeRawValue(wireType)
+	data, err := d.decodeRawValue(fieldNumber, wireType)
 	if err != nil {
 		return nil, err
 	}
 
-	return &Value{
+	value := &Value{
 		FieldNumber: fieldNumber,
 		WireType:    wireType,
 		Data:        data,
-	}, nil
+	}
+	if d.keepRaw {
+		value.Raw = d.buf[start:d.pos:d.pos]
+	}
+	return value, nil
 }
 
+// getFieldName returns the key a field is decoded under, per config.DecodeFieldNames
 func getFieldName(field *schema.Field) string {
+	switch config.DecodeFieldNames {
+	case FieldNamesProto:
+		return field.Name
+	case FieldNamesCamel:
+		return toLowerCamel(field.Name)
+	}
 	if field.JsonName != "" {
 		return field.JsonName
 	}
 	return field.Name
 }
 
+// bytesValue returns b as decoded for a bytes field: the slice itself, or its
+// standard base64 encoding when config.DecodeBytesAsBase64 is set.
+func bytesValue(b []byte) interface{} {
+	if config.DecodeBytesAsBase64 {
+		return base64.StdEncoding.EncodeToString(b)
+	}
+	return b
+}
+
 func getDefaultValue(pt schema.PrimitiveType) interface{} {
 	switch pt {
 	case schema.TypeDouble:
@@ -748,6 +1214,63 @@ func getDefaultValue(pt schema.PrimitiveType) interface{} {
 	}
 }
 
+// getEnum looks up the enum type of field, failing with an error rather than
+// a nil dereference when the decoder was built without a registry
+func (d *Decoder) getEnum(field *schema.Field) (*schema.Enum, error) {
+	if d.registry == nil {
+		name := field.Name
+		if name == "" {
+			// map values are decoded through a field without a name
+			name = field.Type.EnumType
+		}
+		return nil, fmt.Errorf("registry required to decode enum field %s", name)
+	}
+	return d.registry.GetEnum(field.Type.EnumType)
+}
+
+// declaredDefault converts a proto2 `[default = ...]` constant into the
+// Go value the decoder would produce for the field.
+func (d *Decoder) declaredDefault(field *schema.Field) (interface{}, error) {
+	raw := field.DefaultValue
+	if field.Type.Kind == schema.KindEnum {
+		enum, err := d.getEnum(field)
+		if err != nil {
+			return nil, err
+		}
+		for _, en := range enum.Values {
+			if en.Name == raw {
+				return d.findEnumValue(enum, en.Number)
+			}
+		}
+		return nil, fmt.Errorf("default value %s is not a value of enum %s", raw, field.Type.EnumType)
+	}
+	switch field.Type.PrimitiveType {
+	case schema.TypeDouble:
+		return strconv.ParseFloat(raw, 64)
+	case schema.TypeFloat:
+		v, err := strconv.ParseFloat(raw, 32)
+		return float32(v), err
+	case schema.TypeInt64, schema.TypeSint64, schema.TypeSfixed64:
+		return strconv.ParseInt(raw, 0, 64)
+	case schema.TypeInt32, schema.TypeSint32, schema.TypeSfixed32:
+		v, err := strconv.ParseInt(raw, 0, 32)
+		return int32(v), err
+	case schema.TypeUint64, schema.TypeFixed64:
+		return strconv.ParseUint(raw, 0, 64)
+	case schema.TypeUint32, schema.TypeFixed32:
+		v, err := strconv.ParseUint(raw, 0, 32)
+		return uint32(v), err
+	case schema.TypeBool:
+		return strconv.ParseBool(raw)
+	case schema.TypeString:
+		return raw, nil
+	case schema.TypeBytes:
+		return bytesValue([]byte(raw)), nil
+	default:
+		return nil, fmt.Errorf("unsupported default value type: %s", field.Type.PrimitiveType)
+	}
+}
+
 func (d *Decoder) findEnumValue(enum *schema.Enum, enumIntVal int32) (string, error) {
 	for _, en := range enum.Values {
 		if en.Number == enumIntVal {
@@ -760,3 +1283,19 @@ func (d *Decoder) findEnumValue(enum *schema.Enum, enumIntVal int32) (string, er
 	return "", fmt.Errorf("unknown enum field value %d received for enum field %#v", enumIntVal, enum)
 
 }
+
+// isPackedPayload reports whether a repeated field occurrence carries a packed
+// run of scalars rather than a single element.
+func isPackedPayload(field *schema.Field, wireType WireType) bool {
+	if wireType != WireBytes {
+		return false
+	}
+	switch field.Type.Kind {
+	case schema.KindEnum:
+		return true
+	case schema.KindPrimitive:
+		return field.Type.PrimitiveType != schema.TypeString && field.Type.PrimitiveType != schema.TypeBytes
+	default:
+		return false
+	}
+}
@@ -1,8 +1,12 @@
 package wire
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
 	"math"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/anirudhraja/protolite/registry"
@@ -1040,3 +1044,1745 @@ func TestDecoder_JSONNames(t *testing.T) {
 		}
 	}
 }
+
+func TestDecoder_Proto2DeclaredDefaults(t *testing.T) {
+	reg := loadTestRegistry(t, `syntax = "proto2";
+package defaults;
+
+enum Mode {
+  MODE_OFF = 0;
+  MODE_FAST = 1;
+}
+
+message Config {
+  optional int32 retries = 1 [default = 3];
+  optional string region = 2 [default = "us-east"];
+  optional double ratio = 3 [default = 0.5];
+  optional bool enabled = 4 [default = true];
+  optional Mode mode = 5 [default = MODE_FAST];
+  optional uint32 mask = 6 [default = 0x10];
+  optional int64 plain = 7;
+}
+`)
+	msg, err := reg.GetMessage("defaults.Config")
+	if err != nil {
+		t.Fatalf("GetMessage: %v", err)
+	}
+
+	decodedI, err := DecodeMessage(nil, msg, reg)
+	if err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+	expected := map[string]interface{}{
+		"retries": int32(3),
+		"region":  "us-east",
+		"ratio":   0.5,
+		"enabled": true,
+		"mode":    "MODE_FAST",
+		"mask":    uint32(16),
+		"plain":   int64(0),
+	}
+	if !reflect.DeepEqual(decodedI, expected) {
+		t.Errorf("Expected %v, got %v", expected, decodedI)
+	}
+
+	// Present fields always win over the declared default.
+	encoded, err := EncodeMessage(map[string]interface{}{"retries": int32(0)}, msg, reg)
+	if err != nil {
+		t.Fatalf("Failed to encode: %v", err)
+	}
+	decodedI, err = DecodeMessage(encoded, msg, reg)
+	if err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+	if got := decodedI.(map[string]interface{})["retries"]; got != int32(0) {
+		t.Errorf("Expected explicit retries 0, got %v", got)
+	}
+}
+
+func TestDecoder_DecodeFieldKeepRawBytes(t *testing.T) {
+	encoder := NewEncoder()
+	encoder.EncodeVarint(uint64(MakeTag(1, WireVarint)))
+	encoder.EncodeVarint(300)
+	encoder.EncodeVarint(uint64(MakeTag(2, WireBytes)))
+	encoder.EncodeBytes([]byte("keep me"))
+	encoder.EncodeVarint(uint64(MakeTag(3, WireFixed32)))
+	encoder.EncodeFixed32(7)
+	input := encoder.Bytes()
+
+	t.Run("off_by_default", func(t *testing.T) {
+		field, err := NewDecoder(input).DecodeField()
+		if err != nil {
+			t.Fatalf("DecodeField failed: %v", err)
+		}
+		if field.Raw != nil {
+			t.Errorf("Expected no raw bytes, got %v", field.Raw)
+		}
+	})
+
+	t.Run("rewrite_single_field", func(t *testing.T) {
+		decoder := NewDecoder(input)
+		decoder.SetKeepRawBytes(true)
+
+		// Forward every field untouched except field 1, which gets a new value.
+		out := NewEncoder()
+		var rebuilt []byte
+		for {
+			field, err := decoder.DecodeField()
+			if err != nil {
+				t.Fatalf("DecodeField failed: %v", err)
+			}
+			if field == nil {
+				break
+			}
+			if field.FieldNumber == 1 {
+				out.EncodeVarint(uint64(MakeTag(1, WireVarint)))
+				out.EncodeVarint(5)
+				rebuilt = append(rebuilt, out.Bytes()...)
+				continue
+			}
+			rebuilt = append(rebuilt, field.Raw...)
+		}
+
+		expected := NewEncoder()
+		expected.EncodeVarint(uint64(MakeTag(1, WireVarint)))
+		expected.EncodeVarint(5)
+		expected.EncodeVarint(uint64(MakeTag(2, WireBytes)))
+		expected.EncodeBytes([]byte("keep me"))
+		expected.EncodeVarint(uint64(MakeTag(3, WireFixed32)))
+		expected.EncodeFixed32(7)
+		if !bytes.Equal(rebuilt, expected.Bytes()) {
+			t.Errorf("Expected %v, got %v", expected.Bytes(), rebuilt)
+		}
+	})
+}
+
+func TestDecoder_UnknownGroups(t *testing.T) {
+	// field 1 (varint) = 7, field 5 = group{ field 1 = 1, field 6 = group{ field 2 = "x" } }, field 2 = "after"
+	encoder := NewEncoder()
+	encoder.EncodeVarint(uint64(MakeTag(1, WireVarint)))
+	encoder.EncodeVarint(7)
+	groupStart := len(encoder.Bytes())
+	encoder.EncodeVarint(uint64(MakeTag(5, WireStartGroup)))
+	encoder.EncodeVarint(uint64(MakeTag(1, WireVarint)))
+	encoder.EncodeVarint(1)
+	encoder.EncodeVarint(uint64(MakeTag(6, WireStartGroup)))
+	encoder.EncodeVarint(uint64(MakeTag(2, WireBytes)))
+	encoder.EncodeBytes([]byte("x"))
+	encoder.EncodeVarint(uint64(MakeTag(6, WireEndGroup)))
+	encoder.EncodeVarint(uint64(MakeTag(5, WireEndGroup)))
+	groupEnd := len(encoder.Bytes())
+	encoder.EncodeVarint(uint64(MakeTag(2, WireBytes)))
+	encoder.EncodeBytes([]byte("after"))
+	input := encoder.Bytes()
+
+	t.Run("skipped_with_schema", func(t *testing.T) {
+		msg := &schema.Message{
+			Name: "Known",
+			Fields: []*schema.Field{
+				{Name: "id", Number: 1, Type: schema.FieldType{Kind: schema.KindPrimitive, PrimitiveType: schema.TypeInt32}},
+				{Name: "name", Number: 2, Type: schema.FieldType{Kind: schema.KindPrimitive, PrimitiveType: schema.TypeString}},
+			},
+		}
+		decodedI, err := DecodeMessage(input, msg, registry.NewRegistry([]string{""}))
+		if err != nil {
+			t.Fatalf("Failed to decode: %v", err)
+		}
+		decoded := decodedI.(map[string]interface{})
+		if decoded["id"] != int32(7) || decoded["name"] != "after" {
+			t.Errorf("Unexpected decode result: %v", decoded)
+		}
+	})
+
+	t.Run("raw_bytes_include_end_group", func(t *testing.T) {
+		decoder := NewDecoder(input)
+		decoder.SetKeepRawBytes(true)
+		var rebuilt []byte
+		for {
+			field, err := decoder.DecodeField()
+			if err != nil {
+				t.Fatalf("DecodeField failed: %v", err)
+			}
+			if field == nil {
+				break
+			}
+			if field.FieldNumber == 5 {
+				if field.WireType != WireStartGroup {
+					t.Errorf("Expected group wire type, got %d", field.WireType)
+				}
+				if !bytes.Equal(field.Raw, input[groupStart:groupEnd]) {
+					t.Errorf("Expected group raw bytes %v, got %v", input[groupStart:groupEnd], field.Raw)
+				}
+			}
+			rebuilt = append(rebuilt, field.Raw...)
+		}
+		if !bytes.Equal(rebuilt, input) {
+			t.Errorf("Round trip mismatch: expected %v, got %v", input, rebuilt)
+		}
+	})
+
+	t.Run("group_value_is_a_copy", func(t *testing.T) {
+		buf := append([]byte(nil), input...)
+		decoder := NewDecoder(buf)
+		var group []byte
+		for {
+			field, err := decoder.DecodeField()
+			if err != nil {
+				t.Fatalf("DecodeField failed: %v", err)
+			}
+			if field == nil {
+				break
+			}
+			if field.FieldNumber == 5 {
+				group = field.Data.([]byte)
+			}
+		}
+		expected := append([]byte(nil), group...)
+		for i := range buf {
+			buf[i] = 0xff
+		}
+		if !bytes.Equal(group, expected) {
+			t.Errorf("Expected the group body to survive changes to the input, got %v", group)
+		}
+	})
+
+	t.Run("mismatched_end_group", func(t *testing.T) {
+		bad := NewEncoder()
+		bad.EncodeVarint(uint64(MakeTag(5, WireStartGroup)))
+		bad.EncodeVarint(uint64(MakeTag(4, WireEndGroup)))
+		if _, err := NewDecoder(bad.Bytes()).DecodeField(); err == nil {
+			t.Error("Expected error for mismatched end group")
+		}
+	})
+}
+
+func TestDecoder_Proto2Extensions(t *testing.T) {
+	reg := loadTestRegistry(t, `syntax = "proto2";
+package exttest;
+
+message Event {
+  optional string name = 1;
+  extensions 100 to 199;
+}
+
+enum Priority {
+  PRIORITY_LOW = 0;
+  PRIORITY_HIGH = 1;
+}
+
+extend Event {
+  optional Priority priority = 100;
+  optional string source = 101;
+}
+`)
+	msg, err := reg.GetMessage("exttest.Event")
+	if err != nil {
+		t.Fatalf("GetMessage: %v", err)
+	}
+	if len(msg.Extensions) != 2 {
+		t.Fatalf("Expected 2 extension fields on Event, got %d", len(msg.Extensions))
+	}
+
+	// Extensions written by another producer: field 100 = 1, field 101 = "cron".
+	encoder := NewEncoder()
+	encoder.EncodeVarint(uint64(MakeTag(1, WireBytes)))
+	encoder.EncodeBytes([]byte("tick"))
+	encoder.EncodeVarint(uint64(MakeTag(100, WireVarint)))
+	encoder.EncodeVarint(1)
+	encoder.EncodeVarint(uint64(MakeTag(101, WireBytes)))
+	encoder.EncodeBytes([]byte("cron"))
+
+	decodedI, err := DecodeMessage(encoder.Bytes(), msg, reg)
+	if err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+	decoded := decodedI.(map[string]interface{})
+	if decoded["name"] != "tick" || decoded["priority"] != "PRIORITY_HIGH" || decoded["source"] != "cron" {
+		t.Errorf("Unexpected decode result: %v", decoded)
+	}
+
+	reencoded, err := EncodeMessage(decoded, msg, reg)
+	if err != nil {
+		t.Fatalf("Failed to encode: %v", err)
+	}
+	if !bytes.Equal(reencoded, encoder.Bytes()) {
+		t.Errorf("Round trip mismatch: expected %v, got %v", encoder.Bytes(), reencoded)
+	}
+}
+
+func TestDecoder_PrimitiveDecodeDoesNotAllocate(t *testing.T) {
+	// Small integers and bools box without allocating, so any allocation here
+	// would come from the per-call Varint/Fixed decoder wrappers.
+	tests := []struct {
+		name     string
+		field    *schema.Field
+		wireType WireType
+		encode   func(e *Encoder)
+	}{
+		{
+			name:     "int32",
+			field:    &schema.Field{Name: "n", Number: 1, Type: schema.FieldType{Kind: schema.KindPrimitive, PrimitiveType: schema.TypeInt32}},
+			wireType: WireVarint,
+			encode:   func(e *Encoder) { e.EncodeVarint(7) },
+		},
+		{
+			name:     "bool",
+			field:    &schema.Field{Name: "b", Number: 1, Type: schema.FieldType{Kind: schema.KindPrimitive, PrimitiveType: schema.TypeBool}},
+			wireType: WireVarint,
+			encode:   func(e *Encoder) { e.EncodeVarint(1) },
+		},
+		{
+			name:     "fixed32",
+			field:    &schema.Field{Name: "f", Number: 1, Type: schema.FieldType{Kind: schema.KindPrimitive, PrimitiveType: schema.TypeFixed32}},
+			wireType: WireFixed32,
+			encode:   func(e *Encoder) { e.EncodeFixed32(7) },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoder := NewEncoder()
+			tt.encode(encoder)
+			d := NewDecoder(encoder.Bytes())
+			allocs := testing.AllocsPerRun(100, func() {
+				d.pos = 0
+				if _, _, err := d.DecodeTypedField(tt.field, tt.wireType); err != nil {
+					t.Fatalf("DecodeTypedField failed: %v", err)
+				}
+			})
+			if allocs != 0 {
+				t.Errorf("Expected no allocations, got %v per decode", allocs)
+			}
+		})
+	}
+}
+
+func TestDecoder_DecodeFieldNames(t *testing.T) {
+	reg := loadTestRegistry(t, `syntax = "proto3";
+package names;
+
+message User {
+  string user_id = 1;
+  string display_name = 2 [json_name = "nick"];
+  int32 age = 3;
+}
+`)
+	msg, err := reg.GetMessage("names.User")
+	if err != nil {
+		t.Fatalf("GetMessage: %v", err)
+	}
+	encoded, err := EncodeMessage(map[string]interface{}{
+		"user_id":      "u1",
+		"display_name": "Al",
+		"age":          int32(3),
+	}, msg, reg)
+	if err != nil {
+		t.Fatalf("Failed to encode: %v", err)
+	}
+
+	tests := []struct {
+		style FieldNameStyle
+		keys  []string
+	}{
+		{FieldNamesDeclared, []string{"user_id", "nick", "age"}},
+		{FieldNamesProto, []string{"user_id", "display_name", "age"}},
+		{FieldNamesCamel, []string{"userId", "displayName", "age"}},
+	}
+	prev := config
+	defer SetConfig(prev)
+	for _, tt := range tests {
+		cfg := prev
+		cfg.DecodeFieldNames = tt.style
+		SetConfig(cfg)
+		decodedI, err := DecodeMessage(encoded, msg, reg)
+		if err != nil {
+			t.Fatalf("style %d: failed to decode: %v", tt.style, err)
+		}
+		decoded := decodedI.(map[string]interface{})
+		if len(decoded) != len(tt.keys) {
+			t.Errorf("style %d: expected keys %v, got %v", tt.style, tt.keys, decoded)
+		}
+		for _, key := range tt.keys {
+			if _, ok := decoded[key]; !ok {
+				t.Errorf("style %d: expected key %q, got %v", tt.style, key, decoded)
+			}
+		}
+
+		// decoded output must be accepted back by the encoder
+		reencoded, err := EncodeMessage(decoded, msg, reg)
+		if err != nil {
+			t.Fatalf("style %d: failed to re-encode: %v", tt.style, err)
+		}
+		if !bytes.Equal(reencoded, encoded) {
+			t.Errorf("style %d: re-encoded bytes differ", tt.style)
+		}
+	}
+}
+
+func TestDecoder_DecodeFieldNames_UnionWrapper(t *testing.T) {
+	reg := loadTestRegistry(t, `syntax = "proto3";
+package names;
+
+message Result {
+  option wrapper = true;
+  oneof item {
+    Number number_item = 1 [json_name = "Number"];
+    Name name_item = 2 [json_name = "Name"];
+  }
+  message Number {
+    int32 value = 1;
+  }
+  message Name {
+    string value = 1;
+  }
+}
+`)
+	msg, err := reg.GetMessage("names.Result")
+	if err != nil {
+		t.Fatalf("GetMessage: %v", err)
+	}
+	encoded, err := EncodeMessage(map[string]interface{}{
+		"__typename": "Name",
+		"value":      "al",
+	}, msg, reg)
+	if err != nil {
+		t.Fatalf("Failed to encode: %v", err)
+	}
+
+	prev := config
+	defer SetConfig(prev)
+	for _, style := range []FieldNameStyle{FieldNamesDeclared, FieldNamesProto, FieldNamesCamel} {
+		cfg := prev
+		cfg.DecodeFieldNames = style
+		SetConfig(cfg)
+		decoded, err := DecodeMessage(encoded, msg, reg)
+		if err != nil {
+			t.Fatalf("style %d: failed to decode: %v", style, err)
+		}
+		// the __typename is the json_name whatever the key style
+		expected := map[string]interface{}{"value": "al", "__typename": "Name"}
+		if !reflect.DeepEqual(decoded, expected) {
+			t.Errorf("style %d: expected %v, got %v", style, expected, decoded)
+		}
+
+		// an empty union still reports its first member
+		decoded, err = DecodeMessage(nil, msg, reg)
+		if err != nil {
+			t.Fatalf("style %d: failed to decode empty union: %v", style, err)
+		}
+		if m, ok := decoded.(map[string]interface{}); !ok || m["__typename"] != "Number" {
+			t.Errorf("style %d: expected the first member for an empty union, got %v", style, decoded)
+		}
+	}
+}
+
+func TestEncoder_MixedFieldNameConventions(t *testing.T) {
+	reg := loadTestRegistry(t, `syntax = "proto3";
+package mixed;
+
+message User {
+  string user_id = 1;
+  string display_name = 2 [json_name = "nick"];
+  int32 login_count = 3;
+  oneof contact {
+    string email_address = 4;
+  }
+}
+`)
+	msg, err := reg.GetMessage("mixed.User")
+	if err != nil {
+		t.Fatalf("GetMessage: %v", err)
+	}
+	expected, err := EncodeMessage(map[string]interface{}{
+		"user_id":       "u1",
+		"display_name":  "Al",
+		"login_count":   int32(7),
+		"email_address": "al@example.com",
+	}, msg, reg)
+	if err != nil {
+		t.Fatalf("Failed to encode: %v", err)
+	}
+
+	// snake_case, camelCase and json_name keys may be combined in one map, and the
+	// camelCase form stays valid when json_name overrides it
+	inputs := []map[string]interface{}{
+		{"userId": "u1", "display_name": "Al", "loginCount": int32(7), "email_address": "al@example.com"},
+		{"user_id": "u1", "nick": "Al", "loginCount": int32(7), "emailAddress": "al@example.com"},
+		{"userId": "u1", "displayName": "Al", "login_count": int32(7), "emailAddress": "al@example.com"},
+	}
+	for i, data := range inputs {
+		encoded, err := EncodeMessage(data, msg, reg)
+		if err != nil {
+			t.Fatalf("input %d: failed to encode: %v", i, err)
+		}
+		if !bytes.Equal(encoded, expected) {
+			t.Errorf("input %d: expected %x, got %x", i, expected, encoded)
+		}
+	}
+
+	// the same field under several conventions is encoded once, from the
+	// proto name, then json_name, then the camelCase form
+	duplicated := map[string]interface{}{
+		"user_id":     "u1",
+		"userId":      "u2",
+		"displayName": "Bo",
+		"nick":        "Al",
+		"loginCount":  int32(7),
+		"login_count": nil,
+	}
+	expected, err = EncodeMessage(map[string]interface{}{
+		"user_id":      "u1",
+		"display_name": "Al",
+	}, msg, reg)
+	if err != nil {
+		t.Fatalf("Failed to encode: %v", err)
+	}
+	for i := 0; i < 10; i++ {
+		encoded, err := EncodeMessage(duplicated, msg, reg)
+		if err != nil {
+			t.Fatalf("Failed to encode duplicated keys: %v", err)
+		}
+		if !bytes.Equal(encoded, expected) {
+			t.Fatalf("expected %x, got %x", expected, encoded)
+		}
+	}
+
+	// and rejected when RejectDuplicateFieldsOnEncode is set
+	prev := config
+	defer SetConfig(prev)
+	cfg := prev
+	cfg.RejectDuplicateFieldsOnEncode = true
+	SetConfig(cfg)
+	_, err = EncodeMessage(map[string]interface{}{
+		"display_name": "Al",
+		"nick":         "Bo",
+		"displayName":  "Cy",
+		"user_id":      "u1",
+	}, msg, reg)
+	if err == nil {
+		t.Fatal("expected an error for a field given more than once")
+	}
+	if !strings.Contains(err.Error(), "display_name (displayName, display_name, nick)") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestDecoder_BytesAsBase64(t *testing.T) {
+	reg := loadTestRegistry(t, `syntax = "proto3";
+package b64;
+
+import "google/protobuf/wrappers.proto";
+
+message Blob {
+  bytes data = 1;
+  repeated bytes chunks = 2;
+  map<string, bytes> named = 3;
+  google.protobuf.BytesValue maybe = 4;
+}
+`)
+	msg, err := reg.GetMessage("b64.Blob")
+	if err != nil {
+		t.Fatalf("GetMessage: %v", err)
+	}
+	encoded, err := EncodeMessage(map[string]interface{}{
+		"data":   []byte("hello"),
+		"chunks": []interface{}{[]byte{0xff}, []byte{}},
+		"named":  map[string]interface{}{"k": []byte("v")},
+		"maybe":  []byte("w"),
+	}, msg, reg)
+	if err != nil {
+		t.Fatalf("Failed to encode: %v", err)
+	}
+
+	prev := config
+	defer SetConfig(prev)
+	cfg := prev
+	cfg.DecodeBytesAsBase64 = true
+	SetConfig(cfg)
+
+	decodedI, err := DecodeMessage(encoded, msg, reg)
+	if err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+	decoded := decodedI.(map[string]interface{})
+	if decoded["data"] != "aGVsbG8=" {
+		t.Errorf("Expected base64 data, got %#v", decoded["data"])
+	}
+	if chunks := decoded["chunks"].([]interface{}); len(chunks) != 2 || chunks[0] != "/w==" || chunks[1] != "" {
+		t.Errorf("Expected base64 chunks, got %#v", chunks)
+	}
+	if named := decoded["named"].(map[string]interface{}); named["k"] != "dg==" {
+		t.Errorf("Expected base64 map value, got %#v", named)
+	}
+	if decoded["maybe"] != "dw==" {
+		t.Errorf("Expected base64 BytesValue, got %#v", decoded["maybe"])
+	}
+
+	// the encoder accepts the base64 strings back
+	reencoded, err := EncodeMessage(decoded, msg, reg)
+	if err != nil {
+		t.Fatalf("Failed to re-encode: %v", err)
+	}
+	if !bytes.Equal(reencoded, encoded) {
+		t.Errorf("Round trip through base64 changed the encoding")
+	}
+}
+
+func TestDecoder_EmptyNestedMessage(t *testing.T) {
+	reg := loadTestRegistry(t, `syntax = "proto3";
+package empty;
+
+message Address {
+  string city = 1;
+}
+
+message User {
+  string name = 1;
+  Address address = 2;
+  repeated Address history = 3;
+}
+`)
+	msg, err := reg.GetMessage("empty.User")
+	if err != nil {
+		t.Fatalf("GetMessage: %v", err)
+	}
+
+	prev := config
+	defer SetConfig(prev)
+	cfg := prev
+	cfg.FillMissingScalarDefaultsOnDecode = false
+	SetConfig(cfg)
+
+	// An empty sub-message is still written, as a zero-length field
+	encoded, err := EncodeMessage(map[string]interface{}{
+		"address": map[string]interface{}{},
+		"history": []interface{}{map[string]interface{}{}},
+	}, msg, reg)
+	if err != nil {
+		t.Fatalf("Failed to encode: %v", err)
+	}
+	if want := []byte{0x12, 0x00, 0x1a, 0x00}; !bytes.Equal(encoded, want) {
+		t.Fatalf("Expected zero-length fields %x, got %x", want, encoded)
+	}
+
+	decodedI, err := DecodeMessage(encoded, msg, reg)
+	if err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+	decoded := decodedI.(map[string]interface{})
+	address, ok := decoded["address"].(map[string]interface{})
+	if !ok || len(address) != 0 {
+		t.Errorf("Expected present-but-empty address to decode to an empty map, got %#v", decoded["address"])
+	}
+	history, ok := decoded["history"].([]interface{})
+	if !ok || len(history) != 1 {
+		t.Fatalf("Expected one history entry, got %#v", decoded["history"])
+	}
+	if entry, ok := history[0].(map[string]interface{}); !ok || len(entry) != 0 {
+		t.Errorf("Expected empty history entry to decode to an empty map, got %#v", history[0])
+	}
+
+	absentI, err := DecodeMessage(nil, msg, reg)
+	if err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+	if _, ok := absentI.(map[string]interface{})["address"]; ok {
+		t.Errorf("Expected absent address to have no key, got %#v", absentI)
+	}
+}
+
+func TestNegativeInt32Varint(t *testing.T) {
+	// Negative int32 and enum values are sign-extended to 64 bits on the wire,
+	// always taking 10 bytes, so other runtimes read them back unchanged.
+	tests := []struct {
+		name  string
+		value int32
+		want  []byte
+	}{
+		{"minus_one", -1, []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01}},
+		{"min_int32", math.MinInt32, []byte{0x80, 0x80, 0x80, 0x80, 0xf8, 0xff, 0xff, 0xff, 0xff, 0x01}},
+		{"max_int32", math.MaxInt32, []byte{0xff, 0xff, 0xff, 0xff, 0x07}},
+	}
+	reg := loadTestRegistry(t, `syntax = "proto3";
+package negative;
+
+enum Code {
+  CODE_ZERO = 0;
+}
+
+message Holder {
+  int32 value = 1;
+  repeated int32 values = 2;
+  Code code = 3;
+}
+`)
+	msg, err := reg.GetMessage("negative.Holder")
+	if err != nil {
+		t.Fatalf("GetMessage: %v", err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoder := NewEncoder()
+			NewVarintEncoder(encoder).EncodeInt32(tt.value)
+			if !bytes.Equal(encoder.Bytes(), tt.want) {
+				t.Errorf("EncodeInt32(%d) = %x, want %x", tt.value, encoder.Bytes(), tt.want)
+			}
+			encoder = NewEncoder()
+			NewVarintEncoder(encoder).EncodeEnum(tt.value)
+			if !bytes.Equal(encoder.Bytes(), tt.want) {
+				t.Errorf("EncodeEnum(%d) = %x, want %x", tt.value, encoder.Bytes(), tt.want)
+			}
+
+			encoded, err := EncodeMessage(map[string]interface{}{
+				"value":  tt.value,
+				"values": []interface{}{tt.value},
+			}, msg, reg)
+			if err != nil {
+				t.Fatalf("Failed to encode: %v", err)
+			}
+			want := append([]byte{0x08}, tt.want...)
+			want = append(want, 0x12, byte(len(tt.want)))
+			want = append(want, tt.want...)
+			if !bytes.Equal(encoded, want) {
+				t.Errorf("EncodeMessage = %x, want %x", encoded, want)
+			}
+
+			decodedI, err := DecodeMessage(encoded, msg, reg)
+			if err != nil {
+				t.Fatalf("Failed to decode: %v", err)
+			}
+			decoded := decodedI.(map[string]interface{})
+			if decoded["value"] != tt.value {
+				t.Errorf("Expected value %d, got %v", tt.value, decoded["value"])
+			}
+			if values := decoded["values"].([]interface{}); len(values) != 1 || values[0] != tt.value {
+				t.Errorf("Expected values [%d], got %v", tt.value, values)
+			}
+		})
+	}
+
+	t.Run("unknown_negative_enum", func(t *testing.T) {
+		encoder := NewEncoder()
+		encoder.EncodeVarint(uint64(MakeTag(3, WireVarint)))
+		NewVarintEncoder(encoder).EncodeEnum(-2)
+		decodedI, err := DecodeMessage(encoder.Bytes(), msg, reg)
+		if err != nil {
+			t.Fatalf("Failed to decode: %v", err)
+		}
+		// unknown enum numbers are kept as their decimal string
+		if code := decodedI.(map[string]interface{})["code"]; code != "-2" {
+			t.Errorf("Expected unknown enum value \"-2\", got %v (%T)", code, code)
+		}
+	})
+}
+
+func TestDecoder_OneofMessageMember(t *testing.T) {
+	reg := loadTestRegistry(t, `syntax = "proto3";
+package oneofmsg;
+
+message TextContent {
+  string body = 1;
+  repeated string mentions = 2;
+}
+
+message ImageContent {
+  string url = 1;
+  int32 width = 2;
+}
+
+message Post {
+  string id = 1;
+  oneof content {
+    TextContent text_content = 11;
+    ImageContent image_content = 12;
+    string link = 13;
+  }
+}
+`)
+	msg, err := reg.GetMessage("oneofmsg.Post")
+	if err != nil {
+		t.Fatalf("GetMessage: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		member string
+		value  interface{}
+	}{
+		{"text", "text_content", map[string]interface{}{"body": "hi", "mentions": []interface{}{"a", "b"}}},
+		{"image", "image_content", map[string]interface{}{"url": "x.png", "width": int32(640)}},
+		{"empty_message", "text_content", map[string]interface{}{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoded, err := EncodeMessage(map[string]interface{}{"id": "p1", tt.member: tt.value}, msg, reg)
+			if err != nil {
+				t.Fatalf("Failed to encode: %v", err)
+			}
+			decodedI, err := DecodeMessage(encoded, msg, reg)
+			if err != nil {
+				t.Fatalf("Failed to decode: %v", err)
+			}
+			decoded := decodedI.(map[string]interface{})
+			member, ok := decoded[tt.member].(map[string]interface{})
+			if !ok {
+				t.Fatalf("Expected %s to decode to a nested map, got %T", tt.member, decoded[tt.member])
+			}
+			for k, v := range tt.value.(map[string]interface{}) {
+				if !reflect.DeepEqual(member[k], v) {
+					t.Errorf("Expected %s.%s = %v, got %v", tt.member, k, v, member[k])
+				}
+			}
+			for _, other := range []string{"text_content", "image_content", "link"} {
+				if _, ok := decoded[other]; ok && other != tt.member {
+					t.Errorf("Unselected oneof member %s should be absent, got %v", other, decoded[other])
+				}
+			}
+		})
+	}
+}
+
+func TestDecoder_BuiltinNullValue(t *testing.T) {
+	// google.protobuf.NullValue resolves without importing struct.proto
+	reg := loadTestRegistry(t, `syntax = "proto3";
+package nulltest;
+
+message Slot {
+  google.protobuf.NullValue single = 1;
+  repeated google.protobuf.NullValue many = 2;
+}
+`)
+	msg, err := reg.GetMessage("nulltest.Slot")
+	if err != nil {
+		t.Fatalf("GetMessage: %v", err)
+	}
+	if kind := msg.Fields[0].Type.Kind; kind != schema.KindEnum {
+		t.Fatalf("Expected NullValue field to be an enum, got %v", kind)
+	}
+
+	encoded, err := EncodeMessage(map[string]interface{}{
+		"single": "NULL_VALUE",
+		"many":   []interface{}{"NULL_VALUE", int32(0)},
+	}, msg, reg)
+	if err != nil {
+		t.Fatalf("Failed to encode: %v", err)
+	}
+	decodedI, err := DecodeMessage(encoded, msg, reg)
+	if err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+	decoded := decodedI.(map[string]interface{})
+	if decoded["single"] != "NULL_VALUE" {
+		t.Errorf("Expected single to be NULL_VALUE, got %v", decoded["single"])
+	}
+	if !reflect.DeepEqual(decoded["many"], []interface{}{"NULL_VALUE", "NULL_VALUE"}) {
+		t.Errorf("Expected many to be two NULL_VALUEs, got %#v", decoded["many"])
+	}
+}
+
+func TestDecoder_StructuralLimits(t *testing.T) {
+	reg := loadTestRegistry(t, `syntax = "proto3";
+package limits;
+
+message Part {
+  string name = 1;
+}
+
+message Upload {
+  string title = 1;
+  repeated int32 sizes = 2;
+  repeated string tags = 3;
+  Part part = 4;
+}
+`)
+	msg, err := reg.GetMessage("limits.Upload")
+	if err != nil {
+		t.Fatalf("GetMessage: %v", err)
+	}
+	encoded, err := EncodeMessage(map[string]interface{}{
+		"title": "report",
+		"sizes": []interface{}{int32(1), int32(2), int32(3)},
+		"tags":  []interface{}{"a", "b"},
+		"part":  map[string]interface{}{"name": "first-part"},
+	}, msg, reg)
+	if err != nil {
+		t.Fatalf("Failed to encode: %v", err)
+	}
+
+	tests := []struct {
+		name         string
+		maxFields    int
+		maxFieldSize int
+		wantErr      string
+	}{
+		// title + the sizes run and its 3 elements + 2 tags + part
+		{name: "within_limits", maxFields: 8, maxFieldSize: 12},
+		{name: "too_many_packed_elements", maxFields: 3, wantErr: "more than 3 field values"},
+		{name: "too_many_fields", maxFields: 5, wantErr: "more than 5 field values"},
+		{name: "field_too_large", maxFieldSize: 11, wantErr: "field 4 is 12 bytes, more than the MaxFieldSize limit of 11"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prev := config
+			defer SetConfig(prev)
+			cfg := prev
+			cfg.MaxFields = tt.maxFields
+			cfg.MaxFieldSize = tt.maxFieldSize
+			SetConfig(cfg)
+
+			// limits apply even when decode errors are being collected
+			_, err := DecodeMessage(encoded, msg, reg, CollectErrors())
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Expected no error, got %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestDecoder_MaxFieldsEmptyPackedRuns(t *testing.T) {
+	reg := loadTestRegistry(t, `syntax = "proto3";
+package limits;
+
+message Upload {
+  repeated int32 sizes = 2;
+}
+`)
+	msg, err := reg.GetMessage("limits.Upload")
+	if err != nil {
+		t.Fatalf("GetMessage: %v", err)
+	}
+	// ten empty packed runs of field 2
+	var encoded []byte
+	for i := 0; i < 10; i++ {
+		encoded = append(encoded, 0x12, 0x00)
+	}
+
+	prev := config
+	defer SetConfig(prev)
+	cfg := prev
+	cfg.MaxFields = 5
+	SetConfig(cfg)
+
+	_, err = DecodeMessage(encoded, msg, reg)
+	if err == nil || !strings.Contains(err.Error(), "more than 5 field values") {
+		t.Errorf("Expected the empty runs to exceed MaxFields, got %v", err)
+	}
+}
+
+func TestPackedFixedWidthRepeated(t *testing.T) {
+	reg := loadTestRegistry(t, `syntax = "proto3";
+package sensors;
+
+message Readings {
+  repeated double values = 1;
+  repeated fixed32 ids = 2;
+  repeated float ratios = 3;
+  repeated sfixed64 offsets = 4;
+}
+`)
+	msg, err := reg.GetMessage("sensors.Readings")
+	if err != nil {
+		t.Fatalf("GetMessage: %v", err)
+	}
+
+	data := map[string]interface{}{
+		"values":  []float64{1.5, -2},
+		"ids":     []uint32{1, math.MaxUint32},
+		"ratios":  []float32{0.5},
+		"offsets": []int64{-1},
+	}
+	encoded, err := EncodeMessage(data, msg, reg)
+	if err != nil {
+		t.Fatalf("Failed to encode: %v", err)
+	}
+	// one length-delimited record per field, length = count × element width,
+	// as protoc writes it
+	expected := []byte{
+		0x0a, 0x10, 0, 0, 0, 0, 0, 0, 0xf8, 0x3f, 0, 0, 0, 0, 0, 0, 0, 0xc0,
+		0x12, 0x08, 0x01, 0, 0, 0, 0xff, 0xff, 0xff, 0xff,
+		0x1a, 0x04, 0, 0, 0, 0x3f,
+		0x22, 0x08, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+	}
+	if !bytes.Equal(encoded, expected) {
+		t.Fatalf("Unexpected packed encoding:\nexpected % x\ngot      % x", expected, encoded)
+	}
+
+	decodedI, err := DecodeMessage(encoded, msg, reg)
+	if err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+	decoded := decodedI.(map[string]interface{})
+	want := map[string]interface{}{
+		"values":  []interface{}{1.5, float64(-2)},
+		"ids":     []interface{}{uint32(1), uint32(math.MaxUint32)},
+		"ratios":  []interface{}{float32(0.5)},
+		"offsets": []interface{}{int64(-1)},
+	}
+	for name, value := range want {
+		if !reflect.DeepEqual(decoded[name], value) {
+			t.Errorf("field %s: expected %#v, got %#v", name, value, decoded[name])
+		}
+	}
+}
+
+func TestPackedFixedWidthRepeated_LengthIsBytes(t *testing.T) {
+	reg := loadTestRegistry(t, `syntax = "proto3";
+package sensors;
+
+message Samples {
+  repeated fixed32 ids = 1;
+  repeated sfixed32 deltas = 2;
+  repeated fixed64 stamps = 3;
+  string name = 4;
+}
+`)
+	msg, err := reg.GetMessage("sensors.Samples")
+	if err != nil {
+		t.Fatalf("GetMessage: %v", err)
+	}
+
+	// the varint before each run is its length in bytes: 12 bytes hold three
+	// fixed32 values, 8 bytes one fixed64
+	data := []byte{
+		0x0a, 0x0c, 0x01, 0, 0, 0, 0x02, 0, 0, 0, 0x03, 0, 0, 0,
+		0x12, 0x08, 0xff, 0xff, 0xff, 0xff, 0x05, 0, 0, 0,
+		0x1a, 0x08, 0x07, 0, 0, 0, 0, 0, 0, 0,
+		0x22, 0x01, 'x',
+	}
+	decodedI, err := DecodeMessage(data, msg, reg)
+	if err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+	decoded := decodedI.(map[string]interface{})
+	want := map[string]interface{}{
+		"ids":    []interface{}{uint32(1), uint32(2), uint32(3)},
+		"deltas": []interface{}{int32(-1), int32(5)},
+		"stamps": []interface{}{uint64(7)},
+		"name":   "x",
+	}
+	for name, value := range want {
+		if !reflect.DeepEqual(decoded[name], value) {
+			t.Errorf("field %s: expected %#v, got %#v", name, value, decoded[name])
+		}
+	}
+
+	// a length that is not a multiple of the element width is rejected instead
+	// of reading into the next field
+	misaligned := []byte{0x0a, 0x06, 0x01, 0, 0, 0, 0x02, 0, 0x22, 0x01, 'x'}
+	if _, err := DecodeMessage(misaligned, msg, reg); err == nil || !strings.Contains(err.Error(), "past the packed length 6") {
+		t.Errorf("expected a packed length error, got %v", err)
+	}
+}
+
+func TestPackedVarintRepeated_LengthIsBytes(t *testing.T) {
+	reg := loadTestRegistry(t, `syntax = "proto3";
+package sensors;
+
+message Counters {
+  repeated int32 ids = 1;
+  repeated sint64 deltas = 2;
+  repeated uint64 totals = 3;
+  string name = 4;
+}
+`)
+	msg, err := reg.GetMessage("sensors.Counters")
+	if err != nil {
+		t.Fatalf("GetMessage: %v", err)
+	}
+
+	// multi-byte varints make the byte length of each run larger than its
+	// element count: 13 bytes hold three int32 values, one of them -1
+	data := []byte{
+		0x0a, 0x0d, 0xac, 0x02, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01, 0x01,
+		0x12, 0x03, 0x01, 0xac, 0x02,
+		0x1a, 0x0a, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01,
+		0x22, 0x01, 'x',
+	}
+	decodedI, err := DecodeMessage(data, msg, reg)
+	if err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+	decoded := decodedI.(map[string]interface{})
+	want := map[string]interface{}{
+		"ids":    []interface{}{int32(300), int32(-1), int32(1)},
+		"deltas": []interface{}{int64(-1), int64(150)},
+		"totals": []interface{}{uint64(math.MaxUint64)},
+		"name":   "x",
+	}
+	for name, value := range want {
+		if !reflect.DeepEqual(decoded[name], value) {
+			t.Errorf("field %s: expected %#v, got %#v", name, value, decoded[name])
+		}
+	}
+
+	// the encoder writes the same runs
+	encoded, err := EncodeMessage(map[string]interface{}{
+		"ids":    []int32{300, -1, 1},
+		"deltas": []int64{-1, 150},
+		"totals": []uint64{math.MaxUint64},
+		"name":   "x",
+	}, msg, reg)
+	if err != nil {
+		t.Fatalf("Failed to encode: %v", err)
+	}
+	if !bytes.Equal(encoded, data) {
+		t.Errorf("Unexpected packed encoding:\nexpected % x\ngot      % x", data, encoded)
+	}
+}
+
+func TestDecoder_DefaultFillKeepsMessagePresence(t *testing.T) {
+	reg := loadTestRegistry(t, `syntax = "proto3";
+package presence;
+
+import "google/protobuf/wrappers.proto";
+
+enum Tier {
+  TIER_FREE = 0;
+  TIER_PRO = 1;
+}
+
+message Address {
+  string city = 1;
+}
+
+message User {
+  string name = 1;
+  int64 id = 2;
+  bool active = 3;
+  Tier tier = 4;
+  Address address = 5;
+  google.protobuf.StringValue nickname = 6;
+  map<string, string> labels = 7;
+  repeated string tags = 8;
+  oneof contact {
+    string email = 9;
+    Address office = 10;
+  }
+}
+`)
+	msg, err := reg.GetMessage("presence.User")
+	if err != nil {
+		t.Fatalf("GetMessage: %v", err)
+	}
+
+	prev := config
+	defer SetConfig(prev)
+	cfg := prev
+	cfg.FillMissingScalarDefaultsOnDecode = true
+	SetConfig(cfg)
+
+	decodedI, err := DecodeMessage(nil, msg, reg)
+	if err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+	// scalars and enums get their zero value; fields with presence stay absent
+	expected := map[string]interface{}{
+		"name":   "",
+		"id":     int64(0),
+		"active": false,
+		"tier":   "TIER_FREE",
+	}
+	if !reflect.DeepEqual(decodedI, expected) {
+		t.Errorf("Unexpected result:\nexpected %#v\ngot      %#v", expected, decodedI)
+	}
+
+	// a present empty address is still told apart from an absent one
+	encoded, err := EncodeMessage(map[string]interface{}{"address": map[string]interface{}{}}, msg, reg)
+	if err != nil {
+		t.Fatalf("Failed to encode: %v", err)
+	}
+	decodedI, err = DecodeMessage(encoded, msg, reg)
+	if err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+	address, ok := decodedI.(map[string]interface{})["address"].(map[string]interface{})
+	if !ok || !reflect.DeepEqual(address, map[string]interface{}{"city": ""}) {
+		t.Errorf("Expected present address with defaults filled, got %#v", decodedI)
+	}
+}
+
+type testStatus int32
+
+const (
+	testStatusUnknown testStatus = iota
+	testStatusActive
+)
+
+func TestEncodeEnum_NamedIntegerTypes(t *testing.T) {
+	reg := loadTestRegistry(t, `syntax = "proto3";
+package named;
+
+enum Status {
+  STATUS_UNKNOWN = 0;
+  STATUS_ACTIVE = 1;
+}
+
+message Account {
+  Status status = 1;
+  repeated Status history = 2;
+  map<string, Status> by_region = 3;
+}
+`)
+	msg, err := reg.GetMessage("named.Account")
+	if err != nil {
+		t.Fatalf("GetMessage: %v", err)
+	}
+
+	encoded, err := EncodeMessage(map[string]interface{}{
+		"status":    testStatusActive,
+		"history":   []testStatus{testStatusUnknown, testStatusActive},
+		"by_region": map[string]interface{}{"eu": testStatusActive},
+	}, msg, reg)
+	if err != nil {
+		t.Fatalf("Failed to encode: %v", err)
+	}
+	expected, err := EncodeMessage(map[string]interface{}{
+		"status":    int32(1),
+		"history":   []int32{0, 1},
+		"by_region": map[string]interface{}{"eu": int32(1)},
+	}, msg, reg)
+	if err != nil {
+		t.Fatalf("Failed to encode: %v", err)
+	}
+	if !bytes.Equal(encoded, expected) {
+		t.Errorf("expected % x, got % x", expected, encoded)
+	}
+
+	if _, err := EncodeMessage(map[string]interface{}{"status": 1.5}, msg, reg); err == nil ||
+		!strings.Contains(err.Error(), "enum value must be string or number") {
+		t.Errorf("Expected non-integer values to be rejected, got %v", err)
+	}
+}
+
+func TestJSONNumber_64BitPrecision(t *testing.T) {
+	reg := loadTestRegistry(t, `syntax = "proto3";
+package snowflake;
+
+import "google/protobuf/wrappers.proto";
+
+message Ids {
+  uint64 id = 1;
+  fixed64 fid = 2;
+  int64 signed = 3;
+  sint64 zigzag = 4;
+  repeated uint64 ids = 5;
+  map<uint64, string> names = 6;
+  google.protobuf.UInt64Value wrapped = 7;
+}
+`)
+	msg, err := reg.GetMessage("snowflake.Ids")
+	if err != nil {
+		t.Fatalf("GetMessage: %v", err)
+	}
+
+	// none of these survive a round trip through float64
+	data := map[string]interface{}{
+		"id":      json.Number("18446744073709551615"),
+		"fid":     json.Number("18446744073709551615"),
+		"signed":  json.Number("9007199254740993"),
+		"zigzag":  json.Number("-9223372036854775807"),
+		"ids":     []interface{}{json.Number("18446744073709551615"), json.Number("9007199254740993")},
+		"names":   map[interface{}]interface{}{json.Number("18446744073709551615"): "max"},
+		"wrapped": json.Number("18446744073709551615"),
+	}
+	encoded, err := EncodeMessage(data, msg, reg)
+	if err != nil {
+		t.Fatalf("Failed to encode: %v", err)
+	}
+	decodedI, err := DecodeMessage(encoded, msg, reg)
+	if err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+	decoded := decodedI.(map[string]interface{})
+
+	want := map[string]interface{}{
+		"id":      uint64(math.MaxUint64),
+		"fid":     uint64(math.MaxUint64),
+		"signed":  int64(9007199254740993),
+		"zigzag":  int64(-9223372036854775807),
+		"ids":     []interface{}{uint64(math.MaxUint64), uint64(9007199254740993)},
+		"names":   map[uint64]interface{}{math.MaxUint64: "max"},
+		"wrapped": uint64(math.MaxUint64),
+	}
+	for name, value := range want {
+		if !reflect.DeepEqual(decoded[name], value) {
+			t.Errorf("field %s: expected %#v, got %#v", name, value, decoded[name])
+		}
+	}
+}
+
+func TestDecoder_EmptyRepeatedPresence(t *testing.T) {
+	reg := loadTestRegistry(t, `syntax = "proto3";
+package telemetry;
+
+message Sample {
+  int32 value = 1;
+}
+
+message Report {
+  repeated int32 samples = 1;
+  Sample last = 2;
+  repeated Sample history = 3;
+}
+`)
+	msg, err := reg.GetMessage("telemetry.Report")
+	if err != nil {
+		t.Fatalf("GetMessage: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		input    []byte
+		expected map[string]interface{}
+	}{
+		{
+			name:     "absent fields stay missing",
+			input:    []byte{},
+			expected: map[string]interface{}{},
+		},
+		{
+			name:     "empty packed run is an empty list",
+			input:    []byte{0x0a, 0x00},
+			expected: map[string]interface{}{"samples": []interface{}{}},
+		},
+		{
+			name:     "empty message is present",
+			input:    []byte{0x12, 0x00},
+			expected: map[string]interface{}{"last": map[string]interface{}{"value": int32(0)}},
+		},
+		{
+			name:     "empty run does not drop earlier elements",
+			input:    []byte{0x0a, 0x02, 0x01, 0x02, 0x0a, 0x00},
+			expected: map[string]interface{}{"samples": []interface{}{int32(1), int32(2)}},
+		},
+		{
+			name:     "packed and unpacked elements concatenate",
+			input:    []byte{0x08, 0x07, 0x0a, 0x02, 0x01, 0x02, 0x08, 0x03},
+			expected: map[string]interface{}{"samples": []interface{}{int32(7), int32(1), int32(2), int32(3)}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decoded, err := DecodeMessage(tt.input, msg, reg)
+			if err != nil {
+				t.Fatalf("Failed to decode: %v", err)
+			}
+			if !reflect.DeepEqual(decoded, tt.expected) {
+				t.Errorf("expected %#v, got %#v", tt.expected, decoded)
+			}
+		})
+	}
+
+	// an empty list encodes as an empty packed run, so it survives a round trip
+	encoded, err := EncodeMessage(map[string]interface{}{"samples": []int32{}}, msg, reg)
+	if err != nil {
+		t.Fatalf("Failed to encode: %v", err)
+	}
+	decoded, err := DecodeMessage(encoded, msg, reg)
+	if err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+	if samples, ok := decoded.(map[string]interface{})["samples"]; !ok || !reflect.DeepEqual(samples, []interface{}{}) {
+		t.Errorf("Expected samples to be present and empty, got %#v", decoded)
+	}
+}
+
+func TestDecoder_SyntheticOneofIsNotUnion(t *testing.T) {
+	reg := loadTestRegistry(t, `syntax = "proto3";
+package profile;
+
+message Profile {
+  string name = 1;
+  optional string nickname = 2;
+}
+`)
+	profile, err := reg.GetMessage("profile.Profile")
+	if err != nil {
+		t.Fatalf("GetMessage: %v", err)
+	}
+	if len(profile.OneofGroups) != 0 {
+		t.Errorf("Expected proto3 optional to load as a plain field, got oneofs %+v", profile.OneofGroups)
+	}
+
+	// a wrapper built from a descriptor keeps the optional field in its
+	// synthetic oneof, which must not be mistaken for a union
+	wrapper := &schema.Message{
+		Name:      "NicknameWrapper",
+		IsWrapper: true,
+		OneofGroups: []*schema.Oneof{{
+			Name: "_nickname",
+			Fields: []*schema.Field{{
+				Name:   "nickname",
+				Number: 1,
+				Label:  schema.LabelOptional,
+				Type:   schema.FieldType{Kind: schema.KindPrimitive, PrimitiveType: schema.TypeString},
+			}},
+		}},
+	}
+	encoder := NewEncoderWithRegistry(reg)
+	if err := NewMessageEncoder(encoder).EncodeMessage("ann", wrapper); err != nil {
+		t.Fatalf("Failed to encode: %v", err)
+	}
+	encoded := encoder.Bytes()
+	if expected := []byte{0x0a, 0x03, 'a', 'n', 'n'}; !bytes.Equal(encoded, expected) {
+		t.Errorf("expected % x, got % x", expected, encoded)
+	}
+	decoded, err := DecodeMessage(encoded, wrapper, reg)
+	if err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+	if decoded != "ann" {
+		t.Errorf("Expected the bare wrapped value without %s, got %#v", gqlTypeNameField, decoded)
+	}
+	decoded, err = DecodeMessage(nil, wrapper, reg)
+	if err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+	if decoded != nil {
+		t.Errorf("Expected an empty wrapper to decode to nil, got %#v", decoded)
+	}
+}
+
+func TestDecoder_NilRegistryEnumFields(t *testing.T) {
+	enumType := schema.FieldType{Kind: schema.KindEnum, EnumType: "test.Status"}
+	stringType := schema.FieldType{Kind: schema.KindPrimitive, PrimitiveType: schema.TypeString}
+	msg := &schema.Message{
+		Name: "Task",
+		Fields: []*schema.Field{
+			{Name: "status", Number: 1, Label: schema.LabelOptional, Type: enumType},
+			{Name: "labels", Number: 2, Label: schema.LabelOptional, Type: schema.FieldType{
+				Kind: schema.KindMap, MapKey: &stringType, MapValue: &stringType,
+			}},
+			{Name: "states", Number: 3, Label: schema.LabelOptional, Type: schema.FieldType{
+				Kind: schema.KindMap, MapKey: &stringType, MapValue: &enumType,
+			}},
+		},
+	}
+
+	tests := []struct {
+		name  string
+		input []byte
+		want  string
+	}{
+		{"enum value", []byte{0x08, 0x01}, "registry required to decode enum field status"},
+		{"absent enum default", []byte{}, "registry required to decode enum field status"},
+		{"enum map value", []byte{0x1a, 0x05, 0x0a, 0x01, 'a', 0x10, 0x01}, "registry required to decode enum field test.Status"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := DecodeMessage(tt.input, msg, nil)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Expected an error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+
+	t.Run("scalar map without enums", func(t *testing.T) {
+		prev := config
+		defer SetConfig(prev)
+		cfg := prev
+		cfg.FillMissingScalarDefaultsOnDecode = false
+		SetConfig(cfg)
+
+		decoded, err := DecodeMessage([]byte{0x12, 0x06, 0x0a, 0x01, 'a', 0x12, 0x01, 'b'}, msg, nil)
+		if err != nil {
+			t.Fatalf("Failed to decode: %v", err)
+		}
+		expected := map[string]interface{}{"labels": map[string]interface{}{"a": "b"}}
+		if !reflect.DeepEqual(decoded, expected) {
+			t.Errorf("expected %#v, got %#v", expected, decoded)
+		}
+	})
+}
+
+func TestDecoder_MessageSetItems(t *testing.T) {
+	const source = `syntax = "proto2";
+package msgset;
+
+message MessageSet {
+  %s
+  extensions 4 to max;
+}
+
+message LogEntry {
+  optional string text = 1;
+}
+
+extend MessageSet {
+  optional LogEntry log_entry = 100;
+}
+`
+	item := func(typeID uint64, payload []byte) []byte {
+		encoder := NewEncoder()
+		encoder.EncodeVarint(uint64(MakeTag(1, WireStartGroup)))
+		encoder.EncodeVarint(uint64(MakeTag(2, WireVarint)))
+		encoder.EncodeVarint(typeID)
+		encoder.EncodeVarint(uint64(MakeTag(3, WireBytes)))
+		encoder.EncodeBytes(payload)
+		encoder.EncodeVarint(uint64(MakeTag(1, WireEndGroup)))
+		return encoder.Bytes()
+	}
+	entry := NewEncoder()
+	entry.EncodeVarint(uint64(MakeTag(1, WireBytes)))
+	entry.EncodeBytes([]byte("disk full"))
+
+	decode := func(t *testing.T, option string, data []byte) map[string]interface{} {
+		t.Helper()
+		reg := loadTestRegistry(t, fmt.Sprintf(source, option))
+		msg, err := reg.GetMessage("msgset.MessageSet")
+		if err != nil {
+			t.Fatalf("GetMessage: %v", err)
+		}
+		decoded, err := DecodeMessage(data, msg, reg)
+		if err != nil {
+			t.Fatalf("Failed to decode: %v", err)
+		}
+		return decoded.(map[string]interface{})
+	}
+
+	t.Run("known type id", func(t *testing.T) {
+		decoded := decode(t, "option message_set_wire_format = true;", item(100, entry.Bytes()))
+		expected := map[string]interface{}{"log_entry": map[string]interface{}{"text": "disk full"}}
+		if !reflect.DeepEqual(decoded, expected) {
+			t.Errorf("expected %v, got %v", expected, decoded)
+		}
+	})
+
+	t.Run("unknown type id is skipped", func(t *testing.T) {
+		data := append(item(200, entry.Bytes()), item(100, entry.Bytes())...)
+		decoded := decode(t, "option message_set_wire_format = true;", data)
+		if len(decoded) != 1 || decoded["log_entry"] == nil {
+			t.Errorf("expected only log_entry, got %v", decoded)
+		}
+	})
+
+	t.Run("without the option items are unknown fields", func(t *testing.T) {
+		decoded := decode(t, "", item(100, entry.Bytes()))
+		if len(decoded) != 0 {
+			t.Errorf("expected no fields, got %v", decoded)
+		}
+	})
+
+	t.Run("truncated item", func(t *testing.T) {
+		reg := loadTestRegistry(t, fmt.Sprintf(source, "option message_set_wire_format = true;"))
+		msg, _ := reg.GetMessage("msgset.MessageSet")
+		data := item(100, entry.Bytes())
+		if _, err := DecodeMessage(data[:len(data)-1], msg, reg); err == nil {
+			t.Error("expected an error for an unterminated item")
+		}
+	})
+}
+
+func TestEncodeMessage_ErrorLeavesNoPartialOutput(t *testing.T) {
+	msg := &schema.Message{
+		Name: "Pair",
+		Fields: []*schema.Field{
+			{Name: "name", Number: 1, Type: schema.FieldType{Kind: schema.KindPrimitive, PrimitiveType: schema.TypeString}},
+			{Name: "count", Number: 2, Type: schema.FieldType{Kind: schema.KindPrimitive, PrimitiveType: schema.TypeInt32}},
+		},
+	}
+	// name is written before count fails
+	bad := map[string]interface{}{"name": "written first", "count": "not a number"}
+
+	encoder := NewEncoder()
+	encoder.EncodeVarint(uint64(MakeTag(9, WireVarint)))
+	encoder.EncodeVarint(1)
+	prefix := append([]byte(nil), encoder.Bytes()...)
+
+	if err := NewMessageEncoder(encoder).EncodeMessage(bad, msg); err == nil {
+		t.Fatal("expected an encode error")
+	}
+	if !bytes.Equal(encoder.Bytes(), prefix) {
+		t.Errorf("expected the buffer to be cut back to %x, got %x", prefix, encoder.Bytes())
+	}
+
+	// the encoder stays usable
+	if err := NewMessageEncoder(encoder).EncodeMessage(map[string]interface{}{"count": int32(3)}, msg); err != nil {
+		t.Fatalf("Failed to encode: %v", err)
+	}
+	if expected := append(prefix, 0x10, 0x03); !bytes.Equal(encoder.Bytes(), expected) {
+		t.Errorf("expected %x, got %x", expected, encoder.Bytes())
+	}
+
+	if out, err := EncodeMessage(bad, msg, nil); err == nil || out != nil {
+		t.Errorf("expected no output and an error, got %x, %v", out, err)
+	}
+}
+
+func TestDecoder_IntegersAsInt(t *testing.T) {
+	reg := loadTestRegistry(t, `syntax = "proto3";
+package ints;
+
+message Counters {
+  int32 small = 1;
+  int64 big = 2;
+  uint64 huge = 3;
+  repeated sint32 deltas = 4;
+  map<string, fixed64> totals = 5;
+  map<int32, string> names = 6;
+  google.protobuf.Int64Value limit = 7;
+  int32 unset = 8;
+  double ratio = 9;
+}
+`)
+	msg, err := reg.GetMessage("ints.Counters")
+	if err != nil {
+		t.Fatalf("GetMessage: %v", err)
+	}
+	encoded, err := EncodeMessage(map[string]interface{}{
+		"small":  int32(-3),
+		"big":    int64(1) << 40,
+		"huge":   uint64(math.MaxUint64),
+		"deltas": []interface{}{int32(1), int32(-2)},
+		"totals": map[string]interface{}{"a": uint64(7)},
+		"names":  map[int32]interface{}{int32(1): "one"},
+		"limit":  int64(9),
+		"ratio":  1.5,
+	}, msg, reg)
+	if err != nil {
+		t.Fatalf("Failed to encode: %v", err)
+	}
+
+	prev := config
+	defer SetConfig(prev)
+	cfg := prev
+	cfg.DecodeIntegersAsInt = true
+	SetConfig(cfg)
+
+	decoded, err := DecodeMessage(encoded, msg, reg)
+	if err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+	expected := map[string]interface{}{
+		"small": -3,
+		"big":   1 << 40,
+		// does not fit an int
+		"huge":   uint64(math.MaxUint64),
+		"deltas": []interface{}{1, -2},
+		"totals": map[string]interface{}{"a": 7},
+		// keys keep their width
+		"names": map[int32]interface{}{int32(1): "one"},
+		"limit": 9,
+		"unset": 0,
+		"ratio": 1.5,
+	}
+	if !reflect.DeepEqual(decoded, expected) {
+		t.Errorf("expected %#v, got %#v", expected, decoded)
+	}
+
+	// off by default
+	SetConfig(prev)
+	decoded, err = DecodeMessage(encoded, msg, reg)
+	if err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+	if small := decoded.(map[string]interface{})["small"]; small != int32(-3) {
+		t.Errorf("expected int32(-3) without the option, got %#v", small)
+	}
+}
+
+func TestListWrapper_ListOfLists(t *testing.T) {
+	reg := loadTestRegistry(t, `syntax = "proto3";
+package lists;
+
+message Board {
+  repeated Row rows = 1;
+  repeated Group groups = 2;
+  repeated Plane planes = 3;
+
+  message Row {
+    option wrapper = true;
+    repeated int32 items = 1;
+  }
+  message Group {
+    option wrapper = true;
+    repeated Cell items = 1;
+  }
+  message Plane {
+    option wrapper = true;
+    repeated Row rows = 1;
+  }
+  message Cell {
+    string name = 1;
+  }
+}
+`)
+	msg, err := reg.GetMessage("lists.Board")
+	if err != nil {
+		t.Fatalf("GetMessage: %v", err)
+	}
+	cell := func(name string) map[string]interface{} {
+		return map[string]interface{}{"name": name}
+	}
+	tests := []struct {
+		name     string
+		data     map[string]interface{}
+		expected map[string]interface{}
+	}{
+		{
+			name: "scalar lists",
+			data: map[string]interface{}{"rows": []interface{}{
+				[]interface{}{int32(1), int32(2)}, []interface{}{}, []interface{}{int32(3)},
+			}},
+			expected: map[string]interface{}{"rows": []interface{}{
+				[]interface{}{int32(1), int32(2)}, []interface{}{}, []interface{}{int32(3)},
+			}},
+		},
+		{
+			name: "typed nested slices",
+			data: map[string]interface{}{"rows": [][]int32{{1, 2}, nil, {3}}},
+			expected: map[string]interface{}{"rows": []interface{}{
+				[]interface{}{int32(1), int32(2)}, []interface{}{}, []interface{}{int32(3)},
+			}},
+		},
+		{
+			name: "message lists",
+			data: map[string]interface{}{"groups": []interface{}{
+				[]interface{}{cell("a"), cell("b")}, []map[string]interface{}{cell("c")},
+			}},
+			expected: map[string]interface{}{"groups": []interface{}{
+				[]interface{}{cell("a"), cell("b")}, []interface{}{cell("c")},
+			}},
+		},
+		{
+			name: "three levels",
+			data: map[string]interface{}{"planes": [][][]int32{{{1}, {}}, {}}},
+			expected: map[string]interface{}{"planes": []interface{}{
+				[]interface{}{[]interface{}{int32(1)}, []interface{}{}}, []interface{}{},
+			}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoded, err := EncodeMessage(tt.data, msg, reg)
+			if err != nil {
+				t.Fatalf("Failed to encode: %v", err)
+			}
+			decoded, err := DecodeMessage(encoded, msg, reg)
+			if err != nil {
+				t.Fatalf("Failed to decode: %v", err)
+			}
+			if !reflect.DeepEqual(decoded, tt.expected) {
+				t.Fatalf("expected %#v, got %#v", tt.expected, decoded)
+			}
+
+			// the decoded lists are accepted back by the encoder
+			reencoded, err := EncodeMessage(decoded.(map[string]interface{}), msg, reg)
+			if err != nil {
+				t.Fatalf("Failed to re-encode: %v", err)
+			}
+			redecoded, err := DecodeMessage(reencoded, msg, reg)
+			if err != nil {
+				t.Fatalf("Failed to decode re-encoded: %v", err)
+			}
+			if !reflect.DeepEqual(redecoded, tt.expected) {
+				t.Errorf("round trip changed the value: %#v", redecoded)
+			}
+		})
+	}
+}
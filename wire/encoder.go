@@ -31,14 +31,29 @@ func (e *Encoder) Bytes() []byte {
 	return e.buf
 }
 
+// grow ensures the buffer has room for at least n more bytes
+func (e *Encoder) grow(n int) {
+	if cap(e.buf)-len(e.buf) >= n {
+		return
+	}
+	buf := make([]byte, len(e.buf), 2*cap(e.buf)+n)
+	copy(buf, e.buf)
+	e.buf = buf
+}
+
 // Reset clears the encoder buffer
 func (e *Encoder) Reset() {
 	e.buf = e.buf[:0]
 }
 
-// EncodeMessage encodes a message using schema - main entry point
+// EncodeMessage encodes a message using schema - main entry point. A nil data
+// map is an explicitly empty message and encodes to zero bytes, whatever the
+// message options.
 func EncodeMessage(data map[string]interface{}, msg *schema.Message, registry *registry.Registry) ([]byte, error) {
 	encoder := NewEncoderWithRegistry(registry)
+	if data == nil {
+		return encoder.Bytes(), nil
+	}
 	me := NewMessageEncoder(encoder)
 	err := me.EncodeMessage(data, msg)
 	if err != nil {
@@ -17,7 +17,21 @@ func (e *FieldError) Error() string {
 		return e.Err.Error()
 	}
 
-	return fmt.Sprintf("error at proto path %s: %v", strings.Join(e.FieldPath, "."), e.Err)
+	return fmt.Sprintf("error at proto path %s: %v", formatFieldPath(e.FieldPath), e.Err)
+}
+
+// formatFieldPath joins path segments like a JSON path: field names are
+// separated by dots while index segments ("[1]") attach to the preceding field,
+// e.g. posts[1].comments[0].text_comment.format.
+func formatFieldPath(path []string) string {
+	var sb strings.Builder
+	for i, segment := range path {
+		if i > 0 && !strings.HasPrefix(segment, "[") {
+			sb.WriteByte('.')
+		}
+		sb.WriteString(segment)
+	}
+	return sb.String()
 }
 
 // Unwrap returns the underlying error.
@@ -31,21 +45,56 @@ func (e *FieldError) Is(target error) bool {
 	return ok
 }
 
+// DecodeErrors is returned by DecodeMessage alongside the partially decoded
+// message when the CollectErrors option is given and some fields were skipped.
+type DecodeErrors struct {
+	Errors []error // one per skipped field, usually a *FieldError
+}
+
+// Error implements the error interface.
+func (e *DecodeErrors) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("%d field(s) failed to decode: %s", len(e.Errors), strings.Join(msgs, "; "))
+}
+
+// Unwrap returns the collected errors for errors.Is and errors.As.
+func (e *DecodeErrors) Unwrap() []error {
+	return e.Errors
+}
+
 // wrapWithField wraps an error with a field name
 func wrapWithField(err error, fieldName string) error {
+	return wrapWithPathSegment(err, fieldName)
+}
+
+// wrapWithIndex wraps an error with the index of a repeated field element
+func wrapWithIndex(err error, index int) error {
+	return wrapWithPathSegment(err, fmt.Sprintf("[%d]", index))
+}
+
+// wrapWithKey wraps an error with the key of a map field entry
+func wrapWithKey(err error, key interface{}) error {
+	return wrapWithPathSegment(err, fmt.Sprintf("[%v]", key))
+}
+
+// wrapWithPathSegment prepends a segment to the path of a FieldError, creating one if needed
+func wrapWithPathSegment(err error, segment string) error {
 	if err == nil {
 		return nil
 	}
 
 	if fe, ok := err.(*FieldError); ok {
 		return &FieldError{
-			FieldPath:  append([]string{fieldName}, fe.FieldPath...),
+			FieldPath:  append([]string{segment}, fe.FieldPath...),
 			Err:        fe.Err,
 		}
 	}
 
 	return &FieldError{
-		FieldPath:  []string{fieldName},
+		FieldPath:  []string{segment},
 		Err:        err,
 	}
 }
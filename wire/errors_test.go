@@ -3,6 +3,7 @@ package wire
 import (
 	"errors"
 	"fmt"
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -241,3 +242,373 @@ func TestFieldErrorWrapping(t *testing.T) {
 
 	t.Logf("Wrapped error: %s", wrappedErr.Error())
 }
+
+func TestFieldErrorPathThroughRepeatedMessages(t *testing.T) {
+	reg := loadTestRegistry(t, `syntax = "proto3";
+package errtest;
+
+message TextComment {
+  int32 format = 1;
+}
+
+message Comment {
+  oneof body {
+    TextComment text_comment = 1;
+    string emoji = 2;
+  }
+}
+
+message Post {
+  repeated Comment comments = 1;
+}
+
+message User {
+  repeated Post posts = 1;
+  map<string, Post> pinned = 2;
+}
+`)
+	user, err := reg.GetMessage("errtest.User")
+	if err != nil {
+		t.Fatalf("GetMessage: %v", err)
+	}
+	badComment := map[string]interface{}{
+		"text_comment": map[string]interface{}{"format": "markdown"},
+	}
+
+	t.Run("repeated", func(t *testing.T) {
+		data := map[string]interface{}{
+			"posts": []interface{}{
+				map[string]interface{}{},
+				map[string]interface{}{"comments": []interface{}{badComment}},
+			},
+		}
+		_, err := EncodeMessage(data, user, reg)
+		if err == nil {
+			t.Fatal("expected encode error")
+		}
+		want := "posts[1].comments[0].text_comment.format"
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("expected error to contain %q, got: %s", want, err)
+		}
+	})
+
+	t.Run("map", func(t *testing.T) {
+		data := map[string]interface{}{
+			"pinned": map[string]interface{}{
+				"top": map[string]interface{}{"comments": []interface{}{badComment}},
+			},
+		}
+		_, err := EncodeMessage(data, user, reg)
+		if err == nil {
+			t.Fatal("expected encode error")
+		}
+		want := "pinned[top].comments[0].text_comment.format"
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("expected error to contain %q, got: %s", want, err)
+		}
+	})
+
+	t.Run("decode", func(t *testing.T) {
+		// posts[0].comments[0] holds a text_comment whose payload is truncated.
+		comment := NewEncoder()
+		comment.EncodeVarint(uint64(MakeTag(1, WireBytes)))
+		comment.EncodeVarint(5)
+		post := NewEncoder()
+		post.EncodeVarint(uint64(MakeTag(1, WireBytes)))
+		post.EncodeBytes(comment.Bytes())
+		encoder := NewEncoder()
+		encoder.EncodeVarint(uint64(MakeTag(1, WireBytes)))
+		encoder.EncodeBytes(post.Bytes())
+
+		_, err := DecodeMessage(encoder.Bytes(), user, reg)
+		if err == nil {
+			t.Fatal("expected decode error")
+		}
+		want := "posts[0].comments[0].text_comment"
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("expected error to contain %q, got: %s", want, err)
+		}
+	})
+}
+
+func TestRepeatedFieldScalarValue(t *testing.T) {
+	reg := loadTestRegistry(t, `syntax = "proto3";
+package reptest;
+
+message Tag {
+  string name = 1;
+}
+
+message Holder {
+  repeated string labels = 1;
+  repeated Tag tags = 2;
+}
+`)
+	msg, err := reg.GetMessage("reptest.Holder")
+	if err != nil {
+		t.Fatalf("GetMessage: %v", err)
+	}
+
+	t.Run("clear_error_by_default", func(t *testing.T) {
+		_, err := EncodeMessage(map[string]interface{}{"labels": "solo"}, msg, reg)
+		if err == nil {
+			t.Fatal("expected error for single value on repeated field")
+		}
+		if !strings.Contains(err.Error(), "field labels is repeated; got single string value") {
+			t.Errorf("unexpected error: %s", err)
+		}
+	})
+
+	t.Run("lenient_wraps_single_element", func(t *testing.T) {
+		prev := config
+		lenient := prev
+		lenient.WrapSingleRepeatedElementOnEncode = true
+		SetConfig(lenient)
+		defer SetConfig(prev)
+
+		got, err := EncodeMessage(map[string]interface{}{
+			"labels": "solo",
+			"tags":   map[string]interface{}{"name": "one"},
+		}, msg, reg)
+		if err != nil {
+			t.Fatalf("Failed to encode: %v", err)
+		}
+		expected, err := EncodeMessage(map[string]interface{}{
+			"labels": []interface{}{"solo"},
+			"tags":   []interface{}{map[string]interface{}{"name": "one"}},
+		}, msg, reg)
+		if err != nil {
+			t.Fatalf("Failed to encode: %v", err)
+		}
+		if string(got) != string(expected) {
+			t.Errorf("expected %v, got %v", expected, got)
+		}
+	})
+}
+
+func TestDecodeMessage_CollectErrors(t *testing.T) {
+	reg := loadTestRegistry(t, `syntax = "proto3";
+package collect;
+
+import "google/protobuf/wrappers.proto";
+
+message Address {
+  string city = 1;
+  google.protobuf.DoubleValue lat = 2;
+}
+
+message User {
+  string name = 1;
+  google.protobuf.DoubleValue score = 2;
+  Address address = 3;
+  int32 age = 4;
+}
+`)
+	msg, err := reg.GetMessage("collect.User")
+	if err != nil {
+		t.Fatalf("GetMessage: %v", err)
+	}
+
+	// a DoubleValue whose inner value is sent as a varint
+	badDouble := NewEncoder()
+	badDouble.EncodeVarint(uint64(MakeTag(1, WireVarint)))
+	badDouble.EncodeVarint(5)
+
+	address := NewEncoder()
+	address.EncodeVarint(uint64(MakeTag(1, WireBytes)))
+	address.EncodeString("Paris")
+	address.EncodeVarint(uint64(MakeTag(2, WireBytes)))
+	address.EncodeBytes(badDouble.Bytes())
+
+	encoder := NewEncoder()
+	encoder.EncodeVarint(uint64(MakeTag(1, WireBytes)))
+	encoder.EncodeString("ok")
+	encoder.EncodeVarint(uint64(MakeTag(2, WireBytes)))
+	encoder.EncodeBytes(badDouble.Bytes())
+	encoder.EncodeVarint(uint64(MakeTag(3, WireBytes)))
+	encoder.EncodeBytes(address.Bytes())
+	encoder.EncodeVarint(uint64(MakeTag(4, WireVarint)))
+	encoder.EncodeVarint(7)
+	// a trailing field whose length runs past the input
+	encoder.EncodeVarint(uint64(MakeTag(1, WireBytes)))
+	encoder.EncodeVarint(50)
+	data := encoder.Bytes()
+
+	if _, err := DecodeMessage(data, msg, reg); err == nil {
+		t.Fatal("Expected the first bad field to fail decoding by default")
+	}
+
+	prev := config
+	defer SetConfig(prev)
+	cfg := prev
+	cfg.FillMissingScalarDefaultsOnDecode = false
+	SetConfig(cfg)
+
+	decodedI, err := DecodeMessage(data, msg, reg, CollectErrors())
+	var decodeErrs *DecodeErrors
+	if !errors.As(err, &decodeErrs) {
+		t.Fatalf("Expected *DecodeErrors, got %v", err)
+	}
+	expectedPaths := []string{"score", "address.lat", "name"}
+	if len(decodeErrs.Errors) != len(expectedPaths) {
+		t.Fatalf("Expected %d errors, got %v", len(expectedPaths), decodeErrs.Errors)
+	}
+	for i, path := range expectedPaths {
+		var fe *FieldError
+		if !errors.As(decodeErrs.Errors[i], &fe) || formatFieldPath(fe.FieldPath) != path {
+			t.Errorf("Expected error %d at %s, got %v", i, path, decodeErrs.Errors[i])
+		}
+	}
+
+	decoded, ok := decodedI.(map[string]interface{})
+	if !ok {
+		t.Fatalf("Expected a partial message, got %T", decodedI)
+	}
+	expected := map[string]interface{}{
+		"name":    "ok",
+		"address": map[string]interface{}{"city": "Paris"},
+		"age":     int32(7),
+	}
+	if !reflect.DeepEqual(decoded, expected) {
+		t.Errorf("Partial message mismatch\ngot:  %#v\nwant: %#v", decoded, expected)
+	}
+
+	// the option applies to its own call only
+	if _, err := DecodeMessage(data, msg, reg); err == nil || errors.As(err, &decodeErrs) {
+		t.Errorf("Expected a plain decode to stop at the first bad field, got %v", err)
+	}
+}
+
+func TestPackedDecodeErrorContext(t *testing.T) {
+	reg := loadTestRegistry(t, `syntax = "proto3";
+package errtest;
+
+enum Color {
+  COLOR_UNKNOWN = 0;
+  COLOR_RED = 1;
+}
+
+message Packed {
+  repeated int32 ids = 1;
+  repeated Color colors = 2;
+}
+`)
+	msg, err := reg.GetMessage("errtest.Packed")
+	if err != nil {
+		t.Fatalf("GetMessage: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		want []string
+	}{
+		{
+			name: "truncated_varint",
+			data: []byte{0x0a, 0x03, 0x01, 0x02, 0x80},
+			want: []string{"ids[2]", "packed element"},
+		},
+		{
+			name: "length_overruns_input",
+			data: []byte{0x0a, 0x05, 0x01},
+			want: []string{"ids", "packed length 5 exceeds the 1 bytes left"},
+		},
+		{
+			name: "element_crosses_length",
+			data: []byte{0x0a, 0x02, 0x01, 0x80, 0x01},
+			want: []string{"ids[1]", "past the packed length 2"},
+		},
+		{
+			name: "truncated_enum",
+			data: []byte{0x12, 0x02, 0x01, 0xff},
+			want: []string{"colors[1]", "packed element"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := DecodeMessage(tt.data, msg, reg)
+			if err == nil {
+				t.Fatal("expected decode error")
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("expected error to contain %q, got: %s", want, err)
+				}
+			}
+		})
+	}
+}
+
+func TestRejectUnknownFieldsOnEncode(t *testing.T) {
+	reg := loadTestRegistry(t, `syntax = "proto3";
+package stricttest;
+
+message Author {
+  string name = 1;
+}
+
+message Post {
+  string title = 1;
+  int64 view_count = 2;
+  Author author = 3;
+}
+`)
+	msg, err := reg.GetMessage("stricttest.Post")
+	if err != nil {
+		t.Fatalf("GetMessage: %v", err)
+	}
+	data := map[string]interface{}{
+		"title":     "hello",
+		"view_cont": int64(3),
+		"author":    map[string]interface{}{"name": "ann", "nmae": "typo"},
+	}
+
+	t.Run("skipped_by_default", func(t *testing.T) {
+		if _, err := EncodeMessage(data, msg, reg); err != nil {
+			t.Fatalf("expected unknown keys to be skipped, got %v", err)
+		}
+	})
+
+	prev := config
+	defer SetConfig(prev)
+	cfg := prev
+	cfg.RejectUnknownFieldsOnEncode = true
+	SetConfig(cfg)
+
+	t.Run("top_level_keys_listed", func(t *testing.T) {
+		_, err := EncodeMessage(map[string]interface{}{
+			"title":     "hello",
+			"view_cont": int64(3),
+			"titel":     "typo",
+		}, msg, reg)
+		if err == nil {
+			t.Fatal("expected error for unknown keys")
+		}
+		if !strings.Contains(err.Error(), "unknown fields for message Post: titel, view_cont") {
+			t.Errorf("unexpected error: %s", err)
+		}
+	})
+
+	t.Run("nested_keys_carry_path", func(t *testing.T) {
+		_, err := EncodeMessage(map[string]interface{}{
+			"author": map[string]interface{}{"name": "ann", "nmae": "typo"},
+		}, msg, reg)
+		if err == nil {
+			t.Fatal("expected error for unknown nested key")
+		}
+		if !strings.Contains(err.Error(), "author") || !strings.Contains(err.Error(), "unknown fields for message Author: nmae") {
+			t.Errorf("unexpected error: %s", err)
+		}
+	})
+
+	t.Run("known_keys_and_typename_accepted", func(t *testing.T) {
+		_, err := EncodeMessage(map[string]interface{}{
+			"title":      "hello",
+			"viewCount":  int64(3),
+			"__typename": "Post",
+		}, msg, reg)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
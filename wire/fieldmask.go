@@ -0,0 +1,116 @@
+package wire
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/anirudhraja/protolite/registry"
+	"github.com/anirudhraja/protolite/schema"
+)
+
+// EncodeMessageFields encodes only the fields of data selected by the given
+// FieldMask paths. Each path is a dot-separated list of snake_case field
+// names relative to msg, e.g. "address.city".
+func EncodeMessageFields(data map[string]interface{}, msg *schema.Message, registry *registry.Registry, paths []string) ([]byte, error) {
+	masked, err := applyFieldMask(data, msg, registry, paths)
+	if err != nil {
+		return nil, err
+	}
+	return EncodeMessage(masked, msg, registry)
+}
+
+// applyFieldMask builds a copy of data holding only the values selected by paths
+func applyFieldMask(data map[string]interface{}, msg *schema.Message, registry *registry.Registry, paths []string) (map[string]interface{}, error) {
+	split := make([][]string, 0, len(paths))
+	for _, path := range paths {
+		segments := strings.Split(path, ".")
+		for _, segment := range segments {
+			if segment == "" {
+				return nil, fmt.Errorf("invalid field mask path %q", path)
+			}
+		}
+		split = append(split, segments)
+	}
+	// Deeper paths go first so that a shallower path selecting the whole
+	// message ("address") replaces the partial copy built for "address.city"
+	// instead of writing into the caller's map.
+	sort.SliceStable(split, func(i, j int) bool { return len(split[i]) > len(split[j]) })
+
+	result := make(map[string]interface{})
+	for _, segments := range split {
+		if err := maskPath(result, data, msg, registry, segments); err != nil {
+			return nil, fmt.Errorf("field mask path %s: %w", strings.Join(segments, "."), err)
+		}
+	}
+	return result, nil
+}
+
+// maskPath copies the value addressed by segments from src into dst
+func maskPath(dst, src map[string]interface{}, msg *schema.Message, registry *registry.Registry, segments []string) error {
+	field := findFieldBySnakeName(msg, segments[0])
+	if field == nil {
+		return fmt.Errorf("unknown field %s in %s", segments[0], msg.Name)
+	}
+	key, value, ok := lookupFieldValue(src, field)
+	if !ok {
+		// not set in data, nothing to encode
+		return nil
+	}
+	if len(segments) == 1 {
+		dst[key] = value
+		return nil
+	}
+
+	if field.Type.Kind != schema.KindMessage || field.Label == schema.LabelRepeated {
+		return fmt.Errorf("field %s is not a singular message", field.Name)
+	}
+	if value == nil {
+		return nil
+	}
+	nestedSrc, ok := value.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("message value for field %s must be map[string]interface{}, got %T", field.Name, value)
+	}
+	nestedMsg, err := registry.GetMessage(field.Type.MessageType)
+	if err != nil {
+		return err
+	}
+	nestedDst, ok := dst[key].(map[string]interface{})
+	if !ok {
+		nestedDst = make(map[string]interface{})
+		dst[key] = nestedDst
+	}
+	return maskPath(nestedDst, nestedSrc, nestedMsg, registry, segments[1:])
+}
+
+// findFieldBySnakeName finds a field, including oneof members, by its declared proto name
+func findFieldBySnakeName(msg *schema.Message, name string) *schema.Field {
+	for _, field := range msg.Fields {
+		if field.Name == name {
+			return field
+		}
+	}
+	for _, oneOf := range msg.OneofGroups {
+		for _, field := range oneOf.Fields {
+			if field.Name == name {
+				return field
+			}
+		}
+	}
+	return nil
+}
+
+// lookupFieldValue returns the key and value under which data holds field,
+// accepting the same spellings the encoder does
+func lookupFieldValue(data map[string]interface{}, field *schema.Field) (string, interface{}, bool) {
+	for _, key := range []string{field.Name, field.JsonName, toLowerCamel(field.Name)} {
+		if key == "" {
+			continue
+		}
+		if value, ok := data[key]; ok {
+			return key, value, true
+		}
+	}
+	return "", nil, false
+}
@@ -33,7 +33,7 @@ func NewMapEncoder(e *Encoder) *MapEncoder {
 func (md *MapDecoder) DecodeMapEntry(keyType, valueType *schema.FieldType) (interface{}, interface{}, error) {
 	// Read the length-delimited map entry
 	bd := NewBytesDecoder(md.decoder)
-	entryBytes, err := bd.DecodeBytes()
+	entryBytes, err := bd.DecodeRawBytes()
 	if err != nil {
 		return nil, nil, err
 	}
@@ -41,6 +41,8 @@ func (md *MapDecoder) DecodeMapEntry(keyType, valueType *schema.FieldType) (inte
 	// Create a new decoder for the entry data
 	entryDecoder := NewDecoder(entryBytes)
 	entryDecoder.registry = md.decoder.registry
+	entryDecoder.keepUnknown = md.decoder.keepUnknown
+	entryDecoder.opts = md.decoder.opts
 
 	var key, value interface{}
 
@@ -65,7 +67,7 @@ func (md *MapDecoder) DecodeMapEntry(keyType, valueType *schema.FieldType) (inte
 			}
 		default:
 			// Skip unknown fields
-			if err := entryDecoder.skipField(wireType); err != nil {
+			if err := entryDecoder.skipField(fieldNumber, wireType); err != nil {
 				return nil, nil, err
 			}
 		}
@@ -76,11 +78,30 @@ func (md *MapDecoder) DecodeMapEntry(keyType, valueType *schema.FieldType) (inte
         key = defaultValueForType(keyType)
     }
     if value == nil {
-        value = defaultValueForType(valueType)
+        value, err = md.defaultMapValue(valueType)
+        if err != nil {
+            return nil, nil, fmt.Errorf("failed to decode map value: %v", err)
+        }
     }
 	return key, value, nil
 }
 
+// defaultMapValue returns the value used for a map entry whose value is absent
+// on the wire. Enum values resolve to the name of their zero value so they match
+// what DecodeTypedField produces for a present value.
+func (md *MapDecoder) defaultMapValue(valueType *schema.FieldType) (interface{}, error) {
+	if valueType.Kind == schema.KindEnum && md.decoder.registry != nil {
+		enum, err := md.decoder.registry.GetEnum(valueType.EnumType)
+		if err != nil {
+			return nil, err
+		}
+		if name, err := md.decoder.findEnumValue(enum, 0); err == nil {
+			return name, nil
+		}
+	}
+	return defaultValueForType(valueType), nil
+}
+
 // ENCODER METHODS
 
 // EncodeMapEntry encodes a map entry (key-value pair)
@@ -98,7 +119,13 @@ func (me *MapEncoder) EncodeMapEntry(key, value interface{}, keyType, valueType
 		return err
 	}
 
-	// Encode value (field number 2)
+	// Encode value (field number 2). A nil message value is written as an
+	// empty message; any other nil value is left out, so the entry decodes to
+	// the value type's zero value.
+	if value == nil && valueType.Kind != schema.KindMessage {
+		NewBytesEncoder(me.encoder).EncodeBytes(entryEncoder.buf)
+		return nil
+	}
 	valueTag := MakeTag(FieldNumber(2), me.getWireType(valueType))
 	ve.EncodeVarint(uint64(valueTag))
 	if err := entMsg.encodeFieldValue(value, &schema.Field{Type: *valueType}); err != nil {
@@ -127,7 +154,7 @@ func (me *MapEncoder) EncodeMap(mapData interface{}, keyType, valueType *schema.
 
 		// Encode map entry
 		if err := me.EncodeMapEntry(iter.Key().Interface(), iter.Value().Interface(), keyType, valueType); err != nil {
-			return err
+			return wrapWithKey(err, iter.Key().Interface())
 		}
 	}
 	return nil
@@ -147,7 +174,7 @@ func (me *MapEncoder) getWireType(fieldType *schema.FieldType) WireType {
 		default:
 			return WireVarint
 		}
-	case schema.KindMessage:
+	case schema.KindMessage, schema.KindWrapper:
 		return WireBytes
 	case schema.KindEnum:
 		return WireVarint
@@ -156,13 +183,66 @@ func (me *MapEncoder) getWireType(fieldType *schema.FieldType) WireType {
 	}
 }
 
+// entriesToMap converts a map given as a slice of entries, either
+// map[string]interface{} with "key" and "value" or structs with Key and Value
+// fields, into a map[interface{}]interface{}. Any other value is returned as
+// is. As on the wire, a repeated key keeps its last value.
+func entriesToMap(value interface{}) (interface{}, error) {
+	rv := reflect.ValueOf(value)
+	if !rv.IsValid() || rv.Kind() != reflect.Slice || rv.Type().Elem().Kind() == reflect.Uint8 {
+		return value, nil
+	}
+	out := make(map[interface{}]interface{}, rv.Len())
+	for i := 0; i < rv.Len(); i++ {
+		key, val, err := mapEntryKeyValue(rv.Index(i))
+		if err != nil {
+			return nil, wrapWithIndex(err, i)
+		}
+		out[key] = val
+	}
+	return out, nil
+}
+
+// mapEntryKeyValue extracts the key and value of a single map entry element
+func mapEntryKeyValue(entry reflect.Value) (interface{}, interface{}, error) {
+	for entry.Kind() == reflect.Interface || entry.Kind() == reflect.Ptr {
+		if entry.IsNil() {
+			return nil, nil, fmt.Errorf("map entry is nil")
+		}
+		entry = entry.Elem()
+	}
+	switch entry.Kind() {
+	case reflect.Map:
+		m, ok := entry.Interface().(map[string]interface{})
+		if !ok {
+			return nil, nil, fmt.Errorf("map entry must be map[string]interface{}, got %s", entry.Type())
+		}
+		key, ok := m["key"]
+		if !ok {
+			return nil, nil, fmt.Errorf("map entry has no \"key\"")
+		}
+		return key, m["value"], nil
+	case reflect.Struct:
+		key, val := entry.FieldByName("Key"), entry.FieldByName("Value")
+		if !key.IsValid() || !val.IsValid() {
+			return nil, nil, fmt.Errorf("map entry struct %s must have Key and Value fields", entry.Type())
+		}
+		if !key.CanInterface() || !val.CanInterface() {
+			return nil, nil, fmt.Errorf("map entry struct %s must export Key and Value", entry.Type())
+		}
+		return key.Interface(), val.Interface(), nil
+	default:
+		return nil, nil, fmt.Errorf("map entry must be a map or struct, got %s", entry.Type())
+	}
+}
+
 // defaultValueForType returns the protobuf default for a given field type.
 func defaultValueForType(t *schema.FieldType) interface{} {
     switch t.Kind {
     case schema.KindPrimitive:
         switch t.PrimitiveType {
         case schema.TypeBytes:
-            return []byte{}
+            return bytesValue([]byte{})
         default:
             return getDefaultValue(t.PrimitiveType)
         }
@@ -0,0 +1,573 @@
+package wire
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/anirudhraja/protolite/registry"
+	"github.com/anirudhraja/protolite/schema"
+)
+
+// loadTestRegistry loads a single self-contained proto definition into a fresh registry.
+func loadTestRegistry(t *testing.T, content string) *registry.Registry {
+	t.Helper()
+	reg := registry.NewRegistry([]string{""})
+	if err := reg.LoadSchema(strings.NewReader(content), "test.proto"); err != nil {
+		t.Fatalf("Failed to load schema: %v", err)
+	}
+	return reg
+}
+
+func TestMap_EnumValues(t *testing.T) {
+	reg := loadTestRegistry(t, `syntax = "proto3";
+package maptest;
+
+enum Status {
+  STATUS_UNKNOWN = 0;
+  STATUS_ACTIVE = 1;
+  STATUS_BLOCKED = 2;
+}
+
+message Holder {
+  map<int32, Status> statuses = 1;
+}
+`)
+	msg, err := reg.GetMessage("maptest.Holder")
+	if err != nil {
+		t.Fatalf("GetMessage: %v", err)
+	}
+
+	t.Run("round_trip", func(t *testing.T) {
+		data := map[string]interface{}{
+			"statuses": map[int32]interface{}{
+				int32(1): "STATUS_ACTIVE",
+				int32(2): "STATUS_BLOCKED",
+			},
+		}
+		encoded, err := EncodeMessage(data, msg, reg)
+		if err != nil {
+			t.Fatalf("Failed to encode: %v", err)
+		}
+		decodedI, err := DecodeMessage(encoded, msg, reg)
+		if err != nil {
+			t.Fatalf("Failed to decode: %v", err)
+		}
+		statuses, ok := decodedI.(map[string]interface{})["statuses"].(map[int32]interface{})
+		if !ok {
+			t.Fatalf("Expected statuses to be map[int32]interface{}, got %T", decodedI.(map[string]interface{})["statuses"])
+		}
+		if statuses[1] != "STATUS_ACTIVE" || statuses[2] != "STATUS_BLOCKED" {
+			t.Errorf("Expected enum names as map values, got %v", statuses)
+		}
+	})
+
+	t.Run("absent_value_defaults_to_zero_name", func(t *testing.T) {
+		// Entry carrying only the key (field 1 = 7); the value is the enum zero value.
+		entry := NewEncoder()
+		entry.EncodeVarint(uint64(MakeTag(1, WireVarint)))
+		entry.EncodeVarint(7)
+
+		encoder := NewEncoder()
+		encoder.EncodeVarint(uint64(MakeTag(1, WireBytes)))
+		encoder.EncodeBytes(entry.Bytes())
+
+		decodedI, err := DecodeMessage(encoder.Bytes(), msg, reg)
+		if err != nil {
+			t.Fatalf("Failed to decode: %v", err)
+		}
+		statuses := decodedI.(map[string]interface{})["statuses"].(map[int32]interface{})
+		if statuses[7] != "STATUS_UNKNOWN" {
+			t.Errorf("Expected STATUS_UNKNOWN for absent value, got %v (%T)", statuses[7], statuses[7])
+		}
+	})
+
+	t.Run("map_entry_decoder", func(t *testing.T) {
+		encoder := NewEncoderWithRegistry(reg)
+		keyType := &schema.FieldType{Kind: schema.KindPrimitive, PrimitiveType: schema.TypeInt32}
+		valueType := &schema.FieldType{Kind: schema.KindEnum, EnumType: "maptest.Status"}
+		if err := NewMapEncoder(encoder).EncodeMapEntry(int32(3), int32(2), keyType, valueType); err != nil {
+			t.Fatalf("Failed to encode map entry: %v", err)
+		}
+		key, value, err := NewMapDecoder(NewDecoderWithRegistry(encoder.Bytes(), reg)).DecodeMapEntry(keyType, valueType)
+		if err != nil {
+			t.Fatalf("Failed to decode map entry: %v", err)
+		}
+		if key != int32(3) || value != "STATUS_BLOCKED" {
+			t.Errorf("Expected (3, STATUS_BLOCKED), got (%v, %v)", key, value)
+		}
+	})
+}
+
+func TestMap_PreEncodedMessageValues(t *testing.T) {
+	reg := loadTestRegistry(t, `syntax = "proto3";
+package maptest;
+
+message Profile {
+  string display_name = 1;
+  int32 followers = 2;
+}
+
+message Holder {
+  map<string, Profile> profiles = 1;
+}
+`)
+	holder, err := reg.GetMessage("maptest.Holder")
+	if err != nil {
+		t.Fatalf("GetMessage: %v", err)
+	}
+	profile, err := reg.GetMessage("maptest.Profile")
+	if err != nil {
+		t.Fatalf("GetMessage: %v", err)
+	}
+
+	cached, err := EncodeMessage(map[string]interface{}{
+		"display_name": "cached",
+		"followers":    int32(7),
+	}, profile, reg)
+	if err != nil {
+		t.Fatalf("Failed to encode profile: %v", err)
+	}
+
+	data := map[string]interface{}{
+		"profiles": map[string]interface{}{
+			"cached": cached,
+			"fresh": map[string]interface{}{
+				"display_name": "fresh",
+				"followers":    int32(1),
+			},
+		},
+	}
+	encoded, err := EncodeMessage(data, holder, reg)
+	if err != nil {
+		t.Fatalf("Failed to encode: %v", err)
+	}
+	decodedI, err := DecodeMessage(encoded, holder, reg)
+	if err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+	profiles := decodedI.(map[string]interface{})["profiles"].(map[string]interface{})
+	cachedProfile, ok := profiles["cached"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("Expected cached profile to decode to a map, got %T", profiles["cached"])
+	}
+	if cachedProfile["display_name"] != "cached" || cachedProfile["followers"] != int32(7) {
+		t.Errorf("Pre-encoded map value was not emitted verbatim: %v", cachedProfile)
+	}
+	freshProfile := profiles["fresh"].(map[string]interface{})
+	if freshProfile["display_name"] != "fresh" || freshProfile["followers"] != int32(1) {
+		t.Errorf("Unexpected fresh profile: %v", freshProfile)
+	}
+}
+
+func TestMap_BoolKeys(t *testing.T) {
+	reg := loadTestRegistry(t, `syntax = "proto3";
+package maptest;
+
+message Config {
+  string name = 1;
+}
+
+message Holder {
+  map<bool, string> labels = 1;
+  map<bool, Config> configs = 2;
+}
+`)
+	msg, err := reg.GetMessage("maptest.Holder")
+	if err != nil {
+		t.Fatalf("GetMessage: %v", err)
+	}
+
+	data := map[string]interface{}{
+		"labels": map[bool]interface{}{
+			true:  "yes",
+			false: "no",
+		},
+		"configs": map[bool]interface{}{
+			true: map[string]interface{}{"name": "enabled"},
+		},
+	}
+	encoded, err := EncodeMessage(data, msg, reg)
+	if err != nil {
+		t.Fatalf("Failed to encode: %v", err)
+	}
+	decodedI, err := DecodeMessage(encoded, msg, reg)
+	if err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+	decoded := decodedI.(map[string]interface{})
+
+	labels, ok := decoded["labels"].(map[bool]interface{})
+	if !ok {
+		t.Fatalf("Expected labels to be map[bool]interface{}, got %T", decoded["labels"])
+	}
+	if labels[true] != "yes" || labels[false] != "no" {
+		t.Errorf("Unexpected labels: %v", labels)
+	}
+
+	configs, ok := decoded["configs"].(map[bool]interface{})
+	if !ok {
+		t.Fatalf("Expected configs to be map[bool]interface{}, got %T", decoded["configs"])
+	}
+	if cfg, ok := configs[true].(map[string]interface{}); !ok || cfg["name"] != "enabled" {
+		t.Errorf("Unexpected configs: %v", configs)
+	}
+}
+
+func TestMap_FixedWidthValues(t *testing.T) {
+	reg := loadTestRegistry(t, `syntax = "proto3";
+package maptest;
+
+message Holder {
+  map<string, sfixed64> timestamps = 1;
+  map<string, sfixed32> offsets = 2;
+  map<string, fixed64> counters = 3;
+  map<string, fixed32> flags = 4;
+}
+`)
+	msg, err := reg.GetMessage("maptest.Holder")
+	if err != nil {
+		t.Fatalf("GetMessage: %v", err)
+	}
+
+	data := map[string]interface{}{
+		"timestamps": map[string]interface{}{"created": int64(-1700000000123), "updated": int64(1700000000456)},
+		"offsets":    map[string]interface{}{"utc": int32(-18000)},
+		"counters":   map[string]interface{}{"hits": uint64(1) << 63},
+		"flags":      map[string]interface{}{"mask": uint32(0xdeadbeef)},
+	}
+	encoded, err := EncodeMessage(data, msg, reg)
+	if err != nil {
+		t.Fatalf("Failed to encode: %v", err)
+	}
+	decodedI, err := DecodeMessage(encoded, msg, reg)
+	if err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+	decoded := decodedI.(map[string]interface{})
+
+	timestamps := decoded["timestamps"].(map[string]interface{})
+	if timestamps["created"] != int64(-1700000000123) || timestamps["updated"] != int64(1700000000456) {
+		t.Errorf("Unexpected sfixed64 values: %v", timestamps)
+	}
+	if offsets := decoded["offsets"].(map[string]interface{}); offsets["utc"] != int32(-18000) {
+		t.Errorf("Unexpected sfixed32 values: %v", offsets)
+	}
+	if counters := decoded["counters"].(map[string]interface{}); counters["hits"] != uint64(1)<<63 {
+		t.Errorf("Unexpected fixed64 values: %v", counters)
+	}
+	if flags := decoded["flags"].(map[string]interface{}); flags["mask"] != uint32(0xdeadbeef) {
+		t.Errorf("Unexpected fixed32 values: %v", flags)
+	}
+}
+
+func TestMap_EntrySlices(t *testing.T) {
+	reg := loadTestRegistry(t, `syntax = "proto3";
+package maptest;
+
+message Profile {
+  string display_name = 1;
+}
+
+message Holder {
+  map<string, int32> scores = 1;
+  map<int64, Profile> profiles = 2;
+}
+`)
+	msg, err := reg.GetMessage("maptest.Holder")
+	if err != nil {
+		t.Fatalf("GetMessage: %v", err)
+	}
+	type scoreEntry struct {
+		Key   string
+		Value int32
+	}
+
+	data := map[string]interface{}{
+		"scores": []scoreEntry{{"a", 1}, {"b", 2}, {"a", 3}},
+		"profiles": []map[string]interface{}{
+			{"key": int64(7), "value": map[string]interface{}{"display_name": "seven"}},
+		},
+	}
+	encoded, err := EncodeMessage(data, msg, reg)
+	if err != nil {
+		t.Fatalf("Failed to encode: %v", err)
+	}
+	decodedI, err := DecodeMessage(encoded, msg, reg)
+	if err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+	decoded := decodedI.(map[string]interface{})
+	scores := decoded["scores"].(map[string]interface{})
+	if len(scores) != 2 || scores["a"] != int32(3) || scores["b"] != int32(2) {
+		t.Errorf("Expected last value to win for repeated keys, got %v", scores)
+	}
+	profiles := decoded["profiles"].(map[int64]interface{})
+	if p, ok := profiles[7].(map[string]interface{}); !ok || p["display_name"] != "seven" {
+		t.Errorf("Unexpected profiles: %v", profiles)
+	}
+
+	t.Run("invalid_entry", func(t *testing.T) {
+		_, err := EncodeMessage(map[string]interface{}{
+			"scores": []interface{}{map[string]interface{}{"key": "a", "value": int32(1)}, map[string]interface{}{"value": int32(2)}},
+		}, msg, reg)
+		if err == nil || !strings.Contains(err.Error(), "scores[1]") || !strings.Contains(err.Error(), `no "key"`) {
+			t.Errorf("Expected error naming the entry without a key, got %v", err)
+		}
+	})
+}
+
+func TestMap_SintKeysAndValues(t *testing.T) {
+	reg := loadTestRegistry(t, `syntax = "proto3";
+package maptest;
+
+message Holder {
+  map<sint64, string> names = 1;
+  map<sint32, sint64> deltas = 2;
+}
+`)
+	msg, err := reg.GetMessage("maptest.Holder")
+	if err != nil {
+		t.Fatalf("GetMessage: %v", err)
+	}
+
+	t.Run("zigzag_on_the_wire", func(t *testing.T) {
+		encoded, err := EncodeMessage(map[string]interface{}{
+			"names":  map[int64]interface{}{int64(-5): "x"},
+			"deltas": map[int32]interface{}{int32(-3): int64(-9)},
+		}, msg, reg)
+		if err != nil {
+			t.Fatalf("Failed to encode: %v", err)
+		}
+		// zigzag(-5) = 9, zigzag(-3) = 5, zigzag(-9) = 17
+		want := []byte{
+			0x0a, 0x05, 0x08, 0x09, 0x12, 0x01, 'x',
+			0x12, 0x04, 0x08, 0x05, 0x10, 0x11,
+		}
+		if !bytes.Equal(encoded, want) {
+			t.Errorf("Expected %x, got %x", want, encoded)
+		}
+	})
+
+	t.Run("round_trip", func(t *testing.T) {
+		data := map[string]interface{}{
+			"names":  map[json.Number]interface{}{"-9223372036854775808": "min", "-1": "neg", "1": "pos"},
+			"deltas": map[int32]interface{}{int32(math.MinInt32): int64(math.MinInt64), int32(-1): int64(-1)},
+		}
+		encoded, err := EncodeMessage(data, msg, reg)
+		if err != nil {
+			t.Fatalf("Failed to encode: %v", err)
+		}
+		decodedI, err := DecodeMessage(encoded, msg, reg)
+		if err != nil {
+			t.Fatalf("Failed to decode: %v", err)
+		}
+		decoded := decodedI.(map[string]interface{})
+		names := decoded["names"].(map[int64]interface{})
+		if len(names) != 3 || names[math.MinInt64] != "min" || names[-1] != "neg" || names[1] != "pos" {
+			t.Errorf("Unexpected names: %v", names)
+		}
+		deltas := decoded["deltas"].(map[int32]interface{})
+		if len(deltas) != 2 || deltas[math.MinInt32] != int64(math.MinInt64) || deltas[-1] != int64(-1) {
+			t.Errorf("Unexpected deltas: %v", deltas)
+		}
+	})
+}
+
+func TestMap_DuplicateKeysLastWins(t *testing.T) {
+	reg := loadTestRegistry(t, `syntax = "proto3";
+package maptest;
+
+message Counters {
+  map<string, int32> counts = 1;
+  string name = 2;
+}
+`)
+	msg, err := reg.GetMessage("maptest.Counters")
+	if err != nil {
+		t.Fatalf("GetMessage: %v", err)
+	}
+	keyType := &schema.FieldType{Kind: schema.KindPrimitive, PrimitiveType: schema.TypeString}
+	valueType := &schema.FieldType{Kind: schema.KindPrimitive, PrimitiveType: schema.TypeInt32}
+	writeEntry := func(encoder *Encoder, key string, value int32) {
+		encoder.EncodeVarint(uint64(MakeTag(1, WireBytes)))
+		if err := NewMapEncoder(encoder).EncodeMapEntry(key, value, keyType, valueType); err != nil {
+			t.Fatalf("Failed to encode map entry: %v", err)
+		}
+	}
+
+	// "a" appears twice, with an unrelated field between the occurrences
+	encoder := NewEncoder()
+	writeEntry(encoder, "a", 1)
+	writeEntry(encoder, "b", 5)
+	encoder.EncodeVarint(uint64(MakeTag(2, WireBytes)))
+	encoder.EncodeString("x")
+	writeEntry(encoder, "a", 2)
+
+	decodedI, err := DecodeMessage(encoder.Bytes(), msg, reg)
+	if err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+	counts := decodedI.(map[string]interface{})["counts"]
+	expected := map[string]interface{}{"a": int32(2), "b": int32(5)}
+	if !reflect.DeepEqual(counts, expected) {
+		t.Errorf("Expected the last value to win, want %v, got %v", expected, counts)
+	}
+}
+
+func TestMap_NilValues(t *testing.T) {
+	reg := loadTestRegistry(t, `syntax = "proto3";
+package nilmap;
+
+message Profile {
+  string name = 1;
+}
+
+message Holder {
+  map<string, Profile> profiles = 1;
+  map<string, string> labels = 2;
+  map<int32, int64> counts = 3;
+}
+`)
+	msg, err := reg.GetMessage("nilmap.Holder")
+	if err != nil {
+		t.Fatalf("GetMessage: %v", err)
+	}
+
+	encoded, err := EncodeMessage(map[string]interface{}{
+		"profiles": map[string]interface{}{"ann": map[string]interface{}{"name": "Ann"}, "bob": nil},
+		"labels":   map[string]interface{}{"team": nil},
+		"counts":   map[int32]interface{}{7: nil},
+	}, msg, reg)
+	if err != nil {
+		t.Fatalf("Failed to encode: %v", err)
+	}
+
+	// a nil scalar value leaves the value out of the entry, keeping only the key
+	expectedEntry := NewEncoder()
+	NewVarintEncoder(expectedEntry).EncodeVarint(uint64(MakeTag(2, WireBytes)))
+	NewBytesEncoder(expectedEntry).EncodeBytes([]byte{0x0a, 0x04, 't', 'e', 'a', 'm'})
+	if !bytes.Contains(encoded, expectedEntry.Bytes()) {
+		t.Errorf("Expected key-only entry % x in % x", expectedEntry.Bytes(), encoded)
+	}
+
+	decodedI, err := DecodeMessage(encoded, msg, reg)
+	if err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+	decoded := decodedI.(map[string]interface{})
+	expected := map[string]interface{}{
+		"profiles": map[string]interface{}{
+			"ann": map[string]interface{}{"name": "Ann"},
+			"bob": map[string]interface{}{"name": ""},
+		},
+		"labels": map[string]interface{}{"team": ""},
+		"counts": map[int32]interface{}{7: int64(0)},
+	}
+	if !reflect.DeepEqual(decoded, expected) {
+		t.Errorf("Unexpected result:\nexpected %#v\ngot      %#v", expected, decoded)
+	}
+}
+
+func TestMap_MessageValuesWithNestedMaps(t *testing.T) {
+	reg := loadTestRegistry(t, `syntax = "proto3";
+package blog;
+
+message Post {
+  map<string, PostMetric> metrics = 1;
+  map<string, CategoryInfo> categories = 2;
+}
+
+message PostMetric {
+  double value = 1;
+  repeated DataPoint history = 2;
+  map<string, Series> breakdown = 3;
+}
+
+message Series {
+  repeated DataPoint points = 1;
+  map<int32, string> labels = 2;
+}
+
+message DataPoint {
+  int64 timestamp = 1;
+  double value = 2;
+}
+
+message CategoryInfo {
+  string name = 1;
+  repeated string subcategories = 2;
+  map<string, string> attributes = 3;
+}
+`)
+	msg, err := reg.GetMessage("blog.Post")
+	if err != nil {
+		t.Fatalf("GetMessage: %v", err)
+	}
+	point := func(ts int64, v float64) map[string]interface{} {
+		return map[string]interface{}{"timestamp": ts, "value": v}
+	}
+	data := map[string]interface{}{
+		"metrics": map[string]interface{}{
+			"views": map[string]interface{}{
+				"value":   12.5,
+				"history": []interface{}{point(1, 1.5), point(2, 2.5)},
+				"breakdown": map[string]interface{}{
+					"mobile": map[string]interface{}{
+						"points": []interface{}{point(3, 3.5)},
+						"labels": map[int32]string{1: "ios", 2: "android"},
+					},
+					"desktop": map[string]interface{}{
+						"points": []interface{}{},
+						"labels": map[int32]string{},
+					},
+				},
+			},
+		},
+		"categories": map[string]interface{}{
+			"tech": map[string]interface{}{
+				"name":          "Tech",
+				"subcategories": []interface{}{"go", "protobuf"},
+				"attributes":    map[string]string{"color": "blue"},
+			},
+		},
+	}
+	encoded, err := EncodeMessage(data, msg, reg)
+	if err != nil {
+		t.Fatalf("Failed to encode: %v", err)
+	}
+	decodedI, err := DecodeMessage(encoded, msg, reg)
+	if err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+
+	expected := map[string]interface{}{
+		"metrics": map[string]interface{}{
+			"views": map[string]interface{}{
+				"value":   12.5,
+				"history": []interface{}{point(1, 1.5), point(2, 2.5)},
+				"breakdown": map[string]interface{}{
+					"mobile": map[string]interface{}{
+						"points": []interface{}{point(3, 3.5)},
+						"labels": map[int32]interface{}{int32(1): "ios", int32(2): "android"},
+					},
+					// empty lists and maps are not written, only scalars get defaults
+					"desktop": map[string]interface{}{},
+				},
+			},
+		},
+		"categories": map[string]interface{}{
+			"tech": map[string]interface{}{
+				"name":          "Tech",
+				"subcategories": []interface{}{"go", "protobuf"},
+				"attributes":    map[string]interface{}{"color": "blue"},
+			},
+		},
+	}
+	if !reflect.DeepEqual(decodedI, expected) {
+		t.Errorf("expected %#v, got %#v", expected, decodedI)
+	}
+}
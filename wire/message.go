@@ -4,8 +4,12 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"io"
+	"math"
+	"reflect"
 	"sort"
 	"strconv"
+	"strings"
 
 	"github.com/anirudhraja/protolite/schema"
 )
@@ -34,33 +38,69 @@ func NewMessageEncoder(e *Encoder) *MessageEncoder {
 
 // DecodeMessage decodes a nested message
 func (md *MessageDecoder) DecodeMessage(messageType string) (interface{}, error) {
-	// Messages are encoded as length-delimited bytes
+	// Messages are encoded as length-delimited bytes. The nested decoder only
+	// reads them, so share the input buffer and copy only when handing the
+	// bytes back to the caller.
 	bd := NewBytesDecoder(md.decoder)
-	messageBytes, err := bd.DecodeBytes()
+	messageBytes, err := bd.DecodeRawBytes()
 	if err != nil {
 		// Return error directly to avoid repetitive wrapping in recursive calls
 		return nil, err
 	}
+	return md.decodeMessageBytes(messageBytes, messageType)
+}
 
+// decodeMessageBytes decodes the payload of a message of the given type, which
+// may share the input buffer
+func (md *MessageDecoder) decodeMessageBytes(messageBytes []byte, messageType string) (interface{}, error) {
 	if md.decoder.registry == nil {
 		// No registry available, return raw bytes
-		return messageBytes, nil
+		return unknownMessageValue(append([]byte(nil), messageBytes...)), nil
 	}
 
 	// Look up the message schema
 	msg, err := md.decoder.registry.GetMessage(messageType)
 	if err != nil {
 		// Schema not found, return raw bytes
-		return messageBytes, nil
+		return unknownMessageValue(append([]byte(nil), messageBytes...)), nil
 	}
 
 	// Recursively decode the nested message
 	nestedDecoder := NewDecoderWithRegistry(messageBytes, md.decoder.registry)
+	nestedDecoder.keepUnknown = md.decoder.keepUnknown
+	nestedDecoder.opts = md.decoder.opts
 	return nestedDecoder.DecodeWithSchema(msg)
 }
 
+// unknownMessageValue returns the decoded form of a message whose schema is
+// not available: its raw bytes, or the schema-less Parse result when
+// DecodeUnknownToParse is enabled and the bytes parse cleanly.
+func unknownMessageValue(messageBytes []byte) interface{} {
+	if !config.DecodeUnknownToParse {
+		return messageBytes
+	}
+	parsed, err := Parse(messageBytes)
+	if err != nil {
+		return messageBytes
+	}
+	return parsed
+}
+
 // ENCODER METHODS
+
+// EncodeMessage appends data encoded as msg to the encoder's buffer. On error
+// the buffer is cut back to its length before the call, so no partially
+// written message is left behind for a reused encoder to emit.
 func (me *MessageEncoder) EncodeMessage(data interface{}, msg *schema.Message) error {
+	start := len(me.encoder.buf)
+	if err := me.encodeMessageValue(data, msg); err != nil {
+		me.encoder.buf = me.encoder.buf[:start]
+		return err
+	}
+	return nil
+}
+
+func (me *MessageEncoder) encodeMessageValue(data interface{}, msg *schema.Message) error {
 	var (
 		messageData map[string]interface{}
 		ok          bool
@@ -68,12 +108,16 @@ func (me *MessageEncoder) EncodeMessage(data interface{}, msg *schema.Message) e
 	if data == nil {
 		return nil
 	}
+	if m, ok := data.(schema.ProtoliteMarshaler); ok {
+		converted, err := m.MarshalProtolite()
+		if err != nil {
+			return fmt.Errorf("marshaling %T: %w", data, err)
+		}
+		data = converted
+	}
 	if msg.IsWrapper {
 		// mostly a wrapper has single field, except the wrapper of an union.
-		var field *schema.Field
-		if len(msg.Fields) > 0 {
-			field = msg.Fields[0]
-		}
+		field := wrapperField(msg)
 		if dataMap, ok := data.(map[string]interface{}); ok {
 			if iTypeName, ok := dataMap[gqlTypeNameField]; ok {
 				if oneOfField := getOneOfField(msg, iTypeName.(string)); oneOfField != nil {
@@ -86,17 +130,208 @@ func (me *MessageEncoder) EncodeMessage(data interface{}, msg *schema.Message) e
 		}
 		messageData = map[string]interface{}{getFieldName(field): data}
 	} else {
-		// If it's a map, we need to encode it as a message
+		// If it's a map, we need to encode it as a message; a Go struct is
+		// converted into one first
 		messageData, ok = data.(map[string]interface{})
 		if !ok {
-			return fmt.Errorf("message value for field %s must be map[string]interface{}, got %T", msg.Name, data)
+			var err error
+			if messageData, ok, err = structToMessageMap(data, msg); err != nil {
+				return err
+			} else if !ok {
+				return fmt.Errorf("message value for field %s must be map[string]interface{} or a struct, got %T", msg.Name, data)
+			}
 		}
 	}
 	return me.encodeMessage(messageData, msg)
 }
 
-func getOneOfField(msg *schema.Message, typeName string) *schema.Field {
+// unsetWrapper reports whether value, given for a singular wrapper field, is a
+// wrapper map with a nil or missing "value", which like nil leaves the field
+// unset. The map given for a json_string field is its JSON value instead.
+func unsetWrapper(field *schema.Field, value interface{}) bool {
+	if field.Type.Kind != schema.KindWrapper || field.Label == schema.LabelRepeated || field.JSONString {
+		return false
+	}
+	wrapper, ok := value.(map[string]interface{})
+	return ok && wrapper["value"] == nil
+}
+
+// structToMessageMap converts a Go struct, or a pointer to one, into a message
+// map for msg. A struct field fills the message field named by its protobuf
+// struct tag (name=...), else by its json tag, else the one whose name matches
+// ignoring case and underscores, so UserName fills user_name. A struct field
+// matching two message fields that way, or two struct fields filling the same
+// message field, is an error. Unexported, json:"-" and unmatched struct fields
+// are skipped, as are nil pointers, maps and slices; a nil struct pointer is an
+// empty message. Field values implementing schema.ProtoliteMarshaler are kept
+// as is for EncodeMessage to call. It reports false for non-structs.
+func structToMessageMap(data interface{}, msg *schema.Message) (map[string]interface{}, bool, error) {
+	rv := reflect.ValueOf(data)
+	for rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return map[string]interface{}{}, rv.Type().Elem().Kind() == reflect.Struct, nil
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return nil, false, nil
+	}
+
+	fields := make([]*schema.Field, 0, len(msg.Fields)+len(msg.Extensions))
+	fields = append(fields, msg.Fields...)
 	for _, oneOf := range msg.OneofGroups {
+		fields = append(fields, oneOf.Fields...)
+	}
+	fields = append(fields, msg.Extensions...)
+	byNormalized := make(map[string][]*schema.Field, len(fields))
+	for _, field := range fields {
+		key := normalizeFieldName(field.Name)
+		byNormalized[key] = append(byNormalized[key], field)
+	}
+
+	result := make(map[string]interface{})
+	// message field name -> the struct field filling it
+	filledBy := make(map[string]string)
+	rt := rv.Type()
+	for i := 0; i < rv.NumField(); i++ {
+		structField := rt.Field(i)
+		if !structField.IsExported() {
+			continue
+		}
+		field, err := structFieldTarget(structField, fields, byNormalized)
+		if err != nil {
+			return nil, true, fmt.Errorf("struct %s for message %s: %w", rt, msg.Name, err)
+		}
+		if field == nil {
+			continue
+		}
+		if other, ok := filledBy[field.Name]; ok {
+			return nil, true, fmt.Errorf("struct %s for message %s: fields %s and %s both fill %s", rt, msg.Name, other, structField.Name, field.Name)
+		}
+		filledBy[field.Name] = structField.Name
+		value := rv.Field(i)
+		switch value.Kind() {
+		case reflect.Ptr, reflect.Map, reflect.Interface, reflect.Slice:
+			if value.IsNil() {
+				continue
+			}
+		}
+		if value.CanAddr() {
+			// MarshalProtolite may have a pointer receiver
+			if m, ok := value.Addr().Interface().(schema.ProtoliteMarshaler); ok {
+				result[field.Name] = m
+				continue
+			}
+		}
+		result[field.Name] = value.Interface()
+	}
+	return result, true, nil
+}
+
+// structFieldTarget returns the message field among fields that structField
+// fills, see structToMessageMap, or nil when it fills none
+func structFieldTarget(structField reflect.StructField, fields []*schema.Field, byNormalized map[string][]*schema.Field) (*schema.Field, error) {
+	if tag, ok := structField.Tag.Lookup("protobuf"); ok {
+		for _, part := range strings.Split(tag, ",") {
+			if name, ok := strings.CutPrefix(part, "name="); ok {
+				return fieldNamed(fields, name), nil
+			}
+		}
+	}
+	if tag, ok := structField.Tag.Lookup("json"); ok {
+		name, _, _ := strings.Cut(tag, ",")
+		if name == "-" {
+			return nil, nil
+		}
+		if name != "" {
+			return fieldNamed(fields, name), nil
+		}
+	}
+	candidates := byNormalized[normalizeFieldName(structField.Name)]
+	if len(candidates) > 1 {
+		return nil, fmt.Errorf("field %s matches both %s and %s, tag it to pick one", structField.Name, candidates[0].Name, candidates[1].Name)
+	}
+	if len(candidates) == 1 {
+		return candidates[0], nil
+	}
+	return nil, nil
+}
+
+// fieldNamed returns the field of fields that name refers to, see fieldNameMatches
+func fieldNamed(fields []*schema.Field, name string) *schema.Field {
+	for _, field := range fields {
+		if fieldNameMatches(field, name) {
+			return field
+		}
+	}
+	return nil
+}
+
+// normalizeFieldName lowercases name and drops its underscores
+func normalizeFieldName(name string) string {
+	return strings.ToLower(strings.ReplaceAll(name, "_", ""))
+}
+
+// structSlice converts a slice of structs or struct pointers, e.g. []Address,
+// to []interface{} so a repeated message field can encode it element by element
+func structSlice(value interface{}) ([]interface{}, bool) {
+	rv := reflect.ValueOf(value)
+	if rv.Kind() != reflect.Slice {
+		return nil, false
+	}
+	elem := rv.Type().Elem()
+	if elem.Kind() == reflect.Ptr {
+		elem = elem.Elem()
+	}
+	if elem.Kind() != reflect.Struct {
+		return nil, false
+	}
+	elements := make([]interface{}, rv.Len())
+	for i := range elements {
+		elements[i] = rv.Index(i).Interface()
+	}
+	return elements, true
+}
+
+// enumSlice converts a slice of a named integer type, such as generated enum
+// constants, given for a repeated enum field into []interface{}
+func enumSlice(value interface{}, field *schema.Field) ([]interface{}, bool) {
+	rv := reflect.ValueOf(value)
+	if field.Type.Kind != schema.KindEnum || rv.Kind() != reflect.Slice {
+		return nil, false
+	}
+	switch rv.Type().Elem().Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+	default:
+		return nil, false
+	}
+	elements := make([]interface{}, rv.Len())
+	for i := range elements {
+		elements[i] = rv.Index(i).Interface()
+	}
+	return elements, true
+}
+
+// nestedSlice converts a slice of slices, such as [][]int32, given for a
+// repeated message field into []interface{}. Each inner slice is the list held
+// by one element, which must be a wrapper message around a repeated field.
+func nestedSlice(value interface{}, field *schema.Field) ([]interface{}, bool) {
+	rv := reflect.ValueOf(value)
+	if field.Type.Kind != schema.KindMessage || rv.Kind() != reflect.Slice || rv.Type().Elem().Kind() != reflect.Slice {
+		return nil, false
+	}
+	elements := make([]interface{}, rv.Len())
+	for i := range elements {
+		if inner := rv.Index(i); !inner.IsNil() {
+			elements[i] = inner.Interface()
+		}
+	}
+	return elements, true
+}
+
+func getOneOfField(msg *schema.Message, typeName string) *schema.Field {
+	for _, oneOf := range unionOneofs(msg) {
 		for _, field := range oneOf.Fields {
 			// json_name is overloaded in union wrapper to store __typename as it was unused.
 			if field.JsonName == typeName {
@@ -107,6 +342,56 @@ func getOneOfField(msg *schema.Message, typeName string) *schema.Field {
 	return nil
 }
 
+// decodedUnionMember returns the union member present in a decoded wrapper,
+// or the first member when none is. Members are looked up by their decoded
+// key, so other keys such as the field_N entries of unknown fields are never
+// taken for a member.
+func decodedUnionMember(result map[string]interface{}, unions []*schema.Oneof) *schema.Field {
+	for _, oneOf := range unions {
+		for _, field := range oneOf.Fields {
+			if _, ok := result[getFieldName(field)]; ok {
+				return field
+			}
+		}
+	}
+	return unions[0].Fields[0]
+}
+
+// unionTypeName returns the GraphQL __typename of a union member, which the
+// json_name holds whatever DecodeFieldNames selects for the decoded keys
+func unionTypeName(field *schema.Field) string {
+	if field.JsonName != "" {
+		return field.JsonName
+	}
+	return field.Name
+}
+
+// unionOneofs returns the oneofs of msg that form a union, leaving out the
+// synthetic oneofs of proto3 optional fields
+func unionOneofs(msg *schema.Message) []*schema.Oneof {
+	var unions []*schema.Oneof
+	for _, oneOf := range msg.OneofGroups {
+		if !oneOf.IsSynthetic() {
+			unions = append(unions, oneOf)
+		}
+	}
+	return unions
+}
+
+// wrapperField returns the field a non-union wrapper carries, which for a
+// proto3 optional field sits in its synthetic oneof rather than msg.Fields
+func wrapperField(msg *schema.Message) *schema.Field {
+	if len(msg.Fields) > 0 {
+		return msg.Fields[0]
+	}
+	for _, oneOf := range msg.OneofGroups {
+		if oneOf.IsSynthetic() {
+			return oneOf.Fields[0]
+		}
+	}
+	return nil
+}
+
 // EncodeMessage encodes a message with the given data
 func (me *MessageEncoder) encodeMessage(data map[string]interface{}, msg *schema.Message) error {
 	// Encode each field
@@ -119,23 +404,53 @@ func (me *MessageEncoder) encodeMessage(data map[string]interface{}, msg *schema
 	}
 	var entries []fieldEntry
 	nullFields := make([]int32, 0)
+	var unknown []string
+	// the key each field is taken from, see namePrecedence
+	chosen := make(map[int32]fieldEntry, len(data))
+	var duplicated []int32
 	for fieldName, fieldValue := range data {
 		field := me.findFieldByName(msg, fieldName)
 		if field == nil {
+			if config.RejectUnknownFieldsOnEncode && fieldName != gqlTypeNameField {
+				unknown = append(unknown, fieldName)
+			} else if config.Trace != nil && fieldName != gqlTypeNameField {
+				config.Trace(TraceEvent{Kind: TraceUnknownKeyDropped, Message: msg.Name, Field: fieldName})
+			}
 			continue // Skip unknown fields
 		}
-		// if there is no value , no need to iterate over the key
-		if fieldValue == nil {
-			nullFields = append(nullFields, field.Number)
+		// the null tracker is derived from nil values below, never taken from input
+		if schema.IsNullTrackerField(field) {
 			continue
 		}
-
-		entries = append(entries, fieldEntry{
+		// the same field given under two naming conventions keeps the key
+		// with the highest precedence
+		if other, ok := chosen[field.Number]; ok {
+			duplicated = append(duplicated, field.Number)
+			if namePrecedence(field, other.name) <= namePrecedence(field, fieldName) {
+				continue
+			}
+		}
+		chosen[field.Number] = fieldEntry{
 			name:   fieldName,
 			value:  fieldValue,
 			number: field.Number,
 			field:  field,
-		})
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("unknown fields for message %s: %s", msg.Name, strings.Join(unknown, ", "))
+	}
+	if len(duplicated) > 0 && config.RejectDuplicateFieldsOnEncode {
+		return me.duplicateFieldsError(data, msg, duplicated)
+	}
+	for _, entry := range chosen {
+		// if there is no value , no need to iterate over the key
+		if entry.value == nil || unsetWrapper(entry.field, entry.value) {
+			nullFields = append(nullFields, entry.number)
+			continue
+		}
+		entries = append(entries, entry)
 	}
 	if msg.TrackNull {
 		nullTrackerField := me.findFieldByName(msg, schema.NullTrackerFieldName)
@@ -200,6 +515,9 @@ func (me *MessageEncoder) encodeFieldValue(value interface{}, field *schema.Fiel
 	if field.Label == schema.LabelRepeated {
 		return me.encodeRepeatedField(value, field)
 	}
+	if codec := field.Codec(); codec != nil {
+		return me.encodeWithCodec(value, codec)
+	}
 	if field.JSONString {
 		b, _ := json.Marshal(value)
 		value = string(b)
@@ -213,6 +531,9 @@ func (me *MessageEncoder) encodeFieldValue(value interface{}, field *schema.Fiel
 	}
 	switch field.Type.Kind {
 	case schema.KindPrimitive:
+		if config.Trace != nil {
+			tracePrimitive(value, field)
+		}
 		return me.encodePrimitiveField(value, field.Type.PrimitiveType)
 	case schema.KindMessage:
 		return me.encodeMessageField(value, field.Type.MessageType)
@@ -285,8 +606,35 @@ func (me *MessageEncoder) encodeRepeatedField(value interface{}, field *schema.F
 			for i, val := range v {
 				slice[i] = val
 			}
+		case [][]byte:
+			// repeated bytes: each element is its own length-delimited field
+			slice = make([]interface{}, len(v))
+			for i, val := range v {
+				slice[i] = val
+			}
 		default:
-			return fmt.Errorf("repeated field value must be a slice, got %T", value)
+			if elements, ok := structSlice(value); ok {
+				slice = elements
+				break
+			}
+			if elements, ok := enumSlice(value, field); ok {
+				slice = elements
+				break
+			}
+			if elements, ok := nestedSlice(value, field); ok {
+				slice = elements
+				break
+			}
+			if !isSingleRepeatedElement(value, field) {
+				return fmt.Errorf("repeated field value must be a slice, got %T", value)
+			}
+			if !config.WrapSingleRepeatedElementOnEncode {
+				return fmt.Errorf("field %s is repeated; got single %T value, wrap in []interface{}", field.Name, value)
+			}
+			if config.Trace != nil {
+				config.Trace(TraceEvent{Kind: TraceValueCoerced, Field: field.Name, Detail: fmt.Sprintf("single %T wrapped into a list", value)})
+			}
+			slice = []interface{}{value}
 		}
 	}
 	if field.JSONString {
@@ -307,6 +655,9 @@ func (me *MessageEncoder) encodeRepeatedField(value interface{}, field *schema.F
 		}
 		slice = marshaled
 	}
+	if field.Set {
+		slice = me.dedupeSetElements(slice, field)
+	}
 
 	var packed bool
 	if field.Type.Kind == schema.KindPrimitive {
@@ -321,15 +672,18 @@ func (me *MessageEncoder) encodeRepeatedField(value interface{}, field *schema.F
 		b := NewMessageEncoder(NewEncoderWithRegistry(me.encoder.registry))
 		switch field.Type.Kind {
 		case schema.KindPrimitive:
-			for _, v := range slice {
+			for i, v := range slice {
+				if config.Trace != nil {
+					tracePrimitive(v, field)
+				}
 				if err := b.encodePrimitiveField(v, field.Type.PrimitiveType); err != nil {
-					return err
+					return wrapWithIndex(err, i)
 				}
 			}
 		case schema.KindEnum:
-			for _, v := range slice {
+			for i, v := range slice {
 				if err := b.encodeEnumField(v, field.Type); err != nil {
-					return err
+					return wrapWithIndex(err, i)
 				}
 			}
 		default:
@@ -340,36 +694,102 @@ func (me *MessageEncoder) encodeRepeatedField(value interface{}, field *schema.F
 	}
 
 	// For each element in the slice, encode field tag + value
-	for _, element := range slice {
-		ve := NewVarintEncoder(me.encoder)
-		// Encode field tag for each element
-		wireType := me.getWireType(&field.Type)
-		tag := MakeTag(FieldNumber(field.Number), wireType)
-		ve.EncodeVarint(uint64(tag))
+	for i, element := range slice {
+		if err := me.encodeRepeatedElement(element, field); err != nil {
+			return wrapWithIndex(err, i)
+		}
+	}
 
-		// Encode the element value
-		switch field.Type.Kind {
-		case schema.KindPrimitive:
-			if err := me.encodePrimitiveField(element, field.Type.PrimitiveType); err != nil {
-				return err
-			}
-		case schema.KindMessage:
-			if err := me.encodeMessageField(element, field.Type.MessageType); err != nil {
-				return err
-			}
-		case schema.KindEnum:
-			if err := me.encodeEnumField(element, field.Type); err != nil {
-				return err
-			}
-		case schema.KindWrapper:
-			if err := me.encodeWrapperField(element, field.Type.WrapperType); err != nil {
-				return err
+	return nil
+}
+
+// isSingleRepeatedElement reports whether value looks like one element of a
+// repeated field rather than a collection: a non-slice scalar, []byte for
+// bytes fields, or a map for message fields.
+func isSingleRepeatedElement(value interface{}, field *schema.Field) bool {
+	if _, ok := value.([]byte); ok {
+		return field.Type.Kind == schema.KindPrimitive && field.Type.PrimitiveType == schema.TypeBytes
+	}
+	switch reflect.ValueOf(value).Kind() {
+	case reflect.Slice, reflect.Array:
+		return false
+	case reflect.Map:
+		return field.Type.Kind == schema.KindMessage
+	default:
+		return true
+	}
+}
+
+// dedupeSetElements drops elements of a set field equal to an earlier one,
+// keeping first-occurrence order. The caller's slice is left untouched.
+func (me *MessageEncoder) dedupeSetElements(slice []interface{}, field *schema.Field) []interface{} {
+	seen := make(map[string]struct{}, len(slice))
+	unique := make([]interface{}, 0, len(slice))
+	for _, v := range slice {
+		key := me.setElementKey(v, field)
+		if _, dup := seen[key]; dup {
+			continue
+		}
+		seen[key] = struct{}{}
+		unique = append(unique, v)
+	}
+	return unique
+}
+
+// setElementKey returns the identity of a set element. Enum names are
+// resolved to their numbers so that a name and its number count as equal.
+func (me *MessageEncoder) setElementKey(v interface{}, field *schema.Field) string {
+	if b, ok := v.([]byte); ok {
+		return string(b)
+	}
+	if name, ok := v.(string); ok && field.Type.Kind == schema.KindEnum && me.encoder.registry != nil {
+		if enum, err := me.encoder.registry.GetEnum(field.Type.EnumType); err == nil {
+			for _, en := range enum.Values {
+				if en.Name == name || en.JsonName == name {
+					return fmt.Sprint(en.Number)
+				}
 			}
-		default:
-			return fmt.Errorf("unsupported repeated field type: %s", field.Type.Kind)
 		}
 	}
+	return fmt.Sprint(v)
+}
+
+// encodeRepeatedElement encodes the field tag and value of a single element of an unpacked repeated field
+func (me *MessageEncoder) encodeRepeatedElement(element interface{}, field *schema.Field) error {
+	ve := NewVarintEncoder(me.encoder)
+	// Encode field tag for each element
+	wireType := me.getWireType(&field.Type)
+	tag := MakeTag(FieldNumber(field.Number), wireType)
+	ve.EncodeVarint(uint64(tag))
+
+	// Encode the element value
+	if codec := field.Codec(); codec != nil {
+		return me.encodeWithCodec(element, codec)
+	}
+	switch field.Type.Kind {
+	case schema.KindPrimitive:
+		if config.Trace != nil {
+			tracePrimitive(element, field)
+		}
+		return me.encodePrimitiveField(element, field.Type.PrimitiveType)
+	case schema.KindMessage:
+		return me.encodeMessageField(element, field.Type.MessageType)
+	case schema.KindEnum:
+		return me.encodeEnumField(element, field.Type)
+	case schema.KindWrapper:
+		return me.encodeWrapperField(element, field.Type.WrapperType)
+	default:
+		return fmt.Errorf("unsupported repeated field type: %s", field.Type.Kind)
+	}
+}
 
+// encodeWithCodec writes the payload produced by the field's registered codec
+func (me *MessageEncoder) encodeWithCodec(value interface{}, codec schema.FieldCodec) error {
+	payload, err := codec.Encode(value)
+	if err != nil {
+		return fmt.Errorf("field codec: %w", err)
+	}
+	NewBytesEncoder(me.encoder).EncodeBytes(payload)
 	return nil
 }
 
@@ -385,34 +805,18 @@ func (me *MessageEncoder) encodePrimitiveField(value interface{}, primitiveType
 		NewBytesEncoder(encoder).EncodeString(v)
 		return nil
 	case schema.TypeBytes:
+		// stream large payloads into the encoder instead of buffering them; a
+		// nested message still copies its encoder into the parent, see EncodeReader
+		if r, ok := value.(io.Reader); ok {
+			return NewBytesEncoder(encoder).EncodeReader(r)
+		}
 		v, ok := value.([]byte)
 		if !ok {
-			if w, ok := value.([]interface{}); ok {
-				for i := 0; i < len(w); i++ {
-					switch val := w[i].(type) {
-					case int32:
-						if val > 0xFF {
-							return fmt.Errorf("out of range value for byte")
-						}
-						v = append(v, byte(val))
-					case int64:
-						if val > 0xFF {
-							return fmt.Errorf("out of range value for byte")
-						}
-						v = append(v, byte(val))
-					case json.Number:
-						num, err := val.Int64()
-						if err != nil {
-							return fmt.Errorf("invalid value %s for byte", val)
-						}
-						if num > 0xFF {
-							return fmt.Errorf("out of range value for byte")
-						}
-						v = append(v, byte(num))
-					default:
-						return fmt.Errorf("invalid value type %T for byte array", val)
-					}
+			if list, isList, err := byteList(value); isList {
+				if err != nil {
+					return err
 				}
+				v = list
 			} else if w, ok := value.(string); ok {
 				var err error
 				v, err = base64.StdEncoding.DecodeString(w)
@@ -432,7 +836,7 @@ func (me *MessageEncoder) encodePrimitiveField(value interface{}, primitiveType
 			if !ok {
 				return fmt.Errorf("expected int32, got %T", value)
 			}
-			val, err := strconv.Atoi(jsonVal.String())
+			val, err := strconv.ParseInt(jsonVal.String(), 10, 32)
 			if err != nil {
 				return err
 			}
@@ -555,7 +959,7 @@ func (me *MessageEncoder) encodePrimitiveField(value interface{}, primitiveType
 			if !ok {
 				return fmt.Errorf("expected int32, got %T", value)
 			}
-			val, err := strconv.Atoi(jsonVal.String())
+			val, err := strconv.ParseInt(jsonVal.String(), 10, 32)
 			if err != nil {
 				return err
 			}
@@ -583,7 +987,7 @@ func (me *MessageEncoder) encodePrimitiveField(value interface{}, primitiveType
 			if !ok {
 				return fmt.Errorf("expected int32, got %T", value)
 			}
-			val, err := strconv.Atoi(jsonVal.String())
+			val, err := strconv.ParseInt(jsonVal.String(), 10, 32)
 			if err != nil {
 				return err
 			}
@@ -698,10 +1102,27 @@ func (me *MessageEncoder) encodeEnumField(value interface{}, fieldType schema.Fi
 		NewVarintEncoder(me.encoder).EncodeEnum(int32(v))
 		return nil
 	default:
+		// named integer types, such as generated enum constants
+		if n, ok := integerEnumValue(value); ok {
+			NewVarintEncoder(me.encoder).EncodeEnum(n)
+			return nil
+		}
 		return fmt.Errorf("enum value must be string or number for %s field, got %T", fieldType.EnumType, value)
 	}
 }
 
+// integerEnumValue returns the number held by a value of any integer kind
+func integerEnumValue(value interface{}) (int32, bool) {
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return int32(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int32(rv.Uint()), true
+	}
+	return 0, false
+}
+
 // encodeWrapperField encodes a wrapper field
 func (me *MessageEncoder) encodeWrapperField(value interface{}, wrapperType schema.WrapperType) error {
 	// If wrapper value is nil, don't encode anything (optional semantics)
@@ -718,16 +1139,22 @@ func (me *MessageEncoder) encodeWrapperField(value interface{}, wrapperType sche
 
 	// Helper function to extract actual value from wrapper structure or primitive
 	extractWrapperValue := func(v interface{}) (interface{}, error) {
-		// If it's already a map with "value" key, extract the value
+		// If it's already a wrapper message map, take its "value" and ignore
+		// any other keys such as __typename
 		if mapVal, ok := v.(map[string]interface{}); ok {
-			if actualValue, exists := mapVal["value"]; exists {
-				return actualValue, nil
-			}
-			return nil, fmt.Errorf("wrapper map must contain 'value' field")
+			return mapVal["value"], nil
 		}
 		return v, nil
 	}
 
+	// A wrapper map without a value, or with a nil one, leaves a singular field
+	// unset (see unsetWrapper); a list element or map value has to be present,
+	// so it holds the zero value, which encodes as an empty message
+	if actualValue, _ := extractWrapperValue(value); actualValue == nil {
+		NewBytesEncoder(me.encoder).EncodeBytes(nil)
+		return nil
+	}
+
 	// Determine the wire type and encode the value based on wrapper type
 	switch wrapperType {
 	case schema.WrapperDoubleValue:
@@ -832,7 +1259,7 @@ func (me *MessageEncoder) encodeWrapperField(value interface{}, wrapperType sche
 			val = v
 		case json.Number:
 			var i64 int64
-			i64, err = v.Int64()
+			i64, err = strconv.ParseInt(v.String(), 10, 32)
 			if err != nil {
 				return fmt.Errorf("invalid int32: %v", err)
 			}
@@ -915,7 +1342,14 @@ func (me *MessageEncoder) encodeWrapperField(value interface{}, wrapperType sche
 				}
 			}
 		default:
-			return fmt.Errorf("unexpected type for bytes: %T", actualValue)
+			list, isList, err := byteList(actualValue)
+			if !isList {
+				return fmt.Errorf("unexpected type for bytes: %T", actualValue)
+			}
+			if err != nil {
+				return err
+			}
+			val = list
 		}
 		tag := MakeTag(FieldNumber(1), WireBytes)
 		ve.EncodeVarint(uint64(tag))
@@ -932,8 +1366,83 @@ func (me *MessageEncoder) encodeWrapperField(value interface{}, wrapperType sche
 	return nil
 }
 
-// encodeMapField encodes a map field - passes typed maps directly to encoder
+// byteList converts a list of byte values, e.g. from a JSON number array, to
+// []byte. Besides []interface{} it takes slices of any Go integer type such as
+// []int, and reports false for any other value.
+func byteList(value interface{}) ([]byte, bool, error) {
+	if list, ok := value.([]interface{}); ok {
+		b, err := byteSlice(list)
+		return b, true, err
+	}
+	rv := reflect.ValueOf(value)
+	if rv.Kind() != reflect.Slice {
+		return nil, false, nil
+	}
+	switch rv.Type().Elem().Kind() {
+	case reflect.Uint8:
+		// named byte slices such as json.RawMessage
+		return rv.Bytes(), true, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+	default:
+		return nil, false, nil
+	}
+	list := make([]interface{}, rv.Len())
+	for i := range list {
+		list[i] = rv.Index(i).Interface()
+	}
+	b, err := byteSlice(list)
+	return b, true, err
+}
+
+// byteSlice converts the elements of a byte list: Go integers, json.Number or
+// whole float64 values as encoding/json produces them
+func byteSlice(list []interface{}) ([]byte, error) {
+	b := make([]byte, 0, len(list))
+	for _, element := range list {
+		var n int64
+		switch val := element.(type) {
+		case json.Number:
+			var err error
+			if n, err = val.Int64(); err != nil {
+				return nil, fmt.Errorf("invalid value %s for byte", val)
+			}
+		case float64:
+			if val != math.Trunc(val) || val < 0 || val > 0xFF {
+				return nil, fmt.Errorf("invalid value %v for byte", val)
+			}
+			n = int64(val)
+		default:
+			rv := reflect.ValueOf(element)
+			switch rv.Kind() {
+			case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+				n = rv.Int()
+			case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+				if rv.Uint() > 0xFF {
+					return nil, fmt.Errorf("out of range value for byte")
+				}
+				n = int64(rv.Uint())
+			default:
+				return nil, fmt.Errorf("invalid value type %T for byte array", val)
+			}
+		}
+		if n < 0 || n > 0xFF {
+			return nil, fmt.Errorf("out of range value for byte")
+		}
+		b = append(b, byte(n))
+	}
+	return b, nil
+}
+
+// encodeMapField encodes a map field - passes typed maps directly to encoder.
+// Message-typed values follow encodeMessageField, so a pre-encoded []byte value
+// is emitted verbatim instead of being re-encoded. Maps given as a slice of
+// key/value entries are accepted too, see entriesToMap.
 func (me *MessageEncoder) encodeMapField(value interface{}, field *schema.Field) error {
+	value, err := entriesToMap(value)
+	if err != nil {
+		return err
+	}
 	// Use the map encoder to encode the entire map with field tags
 	mapEncoder := NewMapEncoder(me.encoder)
 	return mapEncoder.EncodeMap(value, field.Type.MapKey, field.Type.MapValue, field.Number)
@@ -943,44 +1452,79 @@ func (me *MessageEncoder) encodeMapField(value interface{}, field *schema.Field)
 
 // getWireType returns the wire type for a field type
 func (me *MessageEncoder) getWireType(fieldType *schema.FieldType) WireType {
-	switch fieldType.Kind {
-	case schema.KindPrimitive:
-		switch fieldType.PrimitiveType {
-		case schema.TypeString, schema.TypeBytes:
-			return WireBytes
-		case schema.TypeFloat, schema.TypeFixed32, schema.TypeSfixed32:
-			return WireFixed32
-		case schema.TypeDouble, schema.TypeFixed64, schema.TypeSfixed64:
-			return WireFixed64
-		default:
-			return WireVarint
-		}
-	case schema.KindMessage:
-		return WireBytes
-	case schema.KindEnum:
-		return WireVarint
-	case schema.KindMap:
-		return WireBytes
-	case schema.KindWrapper:
-		return WireBytes // Wrapper types are encoded as length-delimited messages
-	default:
-		return WireVarint
-	}
+	return WireType(fieldType.WireType())
 }
 
-// findFieldByName finds a field by name in a message
+// findFieldByName finds a field by name in a message. Proto names, json_name
+// and the lowerCamel form of the proto name are all accepted, so a single data
+// map may mix conventions.
 func (me *MessageEncoder) findFieldByName(msg *schema.Message, fieldName string) *schema.Field {
 	for _, field := range msg.Fields {
-		if field.Name == fieldName || field.JsonName == fieldName || toLowerCamel(field.Name) == fieldName {
+		if fieldNameMatches(field, fieldName) {
 			return field
 		}
 	}
 	for _, oneOf := range msg.OneofGroups {
 		for _, field := range oneOf.Fields {
-			if field.Name == fieldName || field.JsonName == fieldName || toLowerCamel(field.Name) == fieldName {
+			if fieldNameMatches(field, fieldName) {
 				return field
 			}
 		}
 	}
+	for _, field := range msg.Extensions {
+		if fieldNameMatches(field, fieldName) {
+			return field
+		}
+	}
 	return nil
 }
+
+// namePrecedence ranks the keys that can name field when several are given for
+// it in one map: the proto name first, then json_name, then the lowerCamel form
+func namePrecedence(field *schema.Field, name string) int {
+	switch {
+	case field.Name == name:
+		return 0
+	case field.JsonName != "" && field.JsonName == name:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// duplicateFieldsError lists every key of data naming one of the fields given
+// more than once
+func (me *MessageEncoder) duplicateFieldsError(data map[string]interface{}, msg *schema.Message, numbers []int32) error {
+	keys := make(map[int32][]string, len(numbers))
+	names := make(map[int32]string, len(numbers))
+	for _, number := range numbers {
+		keys[number] = nil
+	}
+	for fieldName := range data {
+		field := me.findFieldByName(msg, fieldName)
+		if field == nil {
+			continue
+		}
+		if _, ok := keys[field.Number]; ok {
+			keys[field.Number] = append(keys[field.Number], fieldName)
+			names[field.Number] = field.Name
+		}
+	}
+	duplicates := make([]string, 0, len(keys))
+	for number, fieldKeys := range keys {
+		sort.Strings(fieldKeys)
+		duplicates = append(duplicates, fmt.Sprintf("%s (%s)", names[number], strings.Join(fieldKeys, ", ")))
+	}
+	sort.Strings(duplicates)
+	return fmt.Errorf("fields for message %s given more than once: %s", msg.Name, strings.Join(duplicates, "; "))
+}
+
+// fieldNameMatches reports whether name refers to field by its proto name, its
+// json_name, or the lowerCamel form of its proto name. The camel form is checked
+// even when json_name is set to something else.
+func fieldNameMatches(field *schema.Field, name string) bool {
+	if field.Name == name || (field.JsonName != "" && field.JsonName == name) {
+		return true
+	}
+	return toLowerCamel(field.Name) == name
+}
@@ -0,0 +1,97 @@
+package wire
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/anirudhraja/protolite/schema"
+)
+
+// A message with the proto2 message_set_wire_format option carries each of its
+// extensions in an item group instead of a regular field:
+//
+//	repeated group Item = 1 {
+//	  required int32 type_id = 2; // extension field number
+//	  required bytes message = 3; // encoded extension message
+//	}
+const (
+	messageSetItemNumber    FieldNumber = 1
+	messageSetTypeIDNumber  FieldNumber = 2
+	messageSetMessageNumber FieldNumber = 3
+)
+
+// isMessageSetItem reports whether a field read from msg is a MessageSet item
+func isMessageSetItem(msg *schema.Message, fieldNumber FieldNumber, wireType WireType) bool {
+	return msg.MessageSetWireFormat && fieldNumber == messageSetItemNumber && wireType == WireStartGroup
+}
+
+// decodeMessageSetItem reads one MessageSet item, whose start tag has been
+// consumed, and stores the decoded message under the extension field of msg
+// numbered by its type_id. Items whose type_id is not a message extension of
+// msg are kept like unknown fields when keepUnknown is set and dropped
+// otherwise.
+func (d *Decoder) decodeMessageSetItem(result map[string]interface{}, msg *schema.Message) error {
+	var (
+		typeID              uint64
+		payload             []byte
+		hasType, hasPayload bool
+	)
+item:
+	for {
+		if d.pos >= len(d.buf) {
+			return fmt.Errorf("unterminated MessageSet item")
+		}
+		tag, err := d.DecodeVarint()
+		if err != nil {
+			return err
+		}
+		fieldNumber, wireType := ParseTag(Tag(tag))
+		switch {
+		case wireType == WireEndGroup:
+			if fieldNumber != messageSetItemNumber {
+				return fmt.Errorf("mismatched end group: expected field %d, got %d", messageSetItemNumber, fieldNumber)
+			}
+			break item
+		case fieldNumber == messageSetTypeIDNumber && wireType == WireVarint:
+			typeID, err = d.DecodeVarint()
+			hasType = true
+		case fieldNumber == messageSetMessageNumber && wireType == WireBytes:
+			payload, err = NewBytesDecoder(d).DecodeRawBytes()
+			hasPayload = true
+		default:
+			err = d.skipField(fieldNumber, wireType)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if !hasType || !hasPayload {
+		return fmt.Errorf("MessageSet item without type_id or message")
+	}
+	if typeID == 0 || typeID > math.MaxInt32 {
+		return fmt.Errorf("invalid MessageSet type_id %d", typeID)
+	}
+
+	field := getFieldByNumber(msg, int32(typeID))
+	if field == nil || field.Type.Kind != schema.KindMessage {
+		if d.keepUnknown {
+			result[fmt.Sprintf("field_%d", typeID)] = map[string]interface{}{
+				"type":  wireTypeName(WireBytes),
+				"value": append([]byte(nil), payload...),
+			}
+		} else if config.Trace != nil {
+			config.Trace(TraceEvent{Kind: TraceUnknownFieldSkipped, Message: msg.Name, Field: fmt.Sprintf("field_%d", typeID), Detail: "MessageSet item"})
+		}
+		return nil
+	}
+	if !d.selected(field) {
+		return nil
+	}
+	value, err := NewMessageDecoder(d).decodeMessageBytes(payload, field.Type.MessageType)
+	if err != nil {
+		return wrapWithField(err, getFieldName(field))
+	}
+	result[getFieldName(field)] = value
+	d.markPresent(field, getFieldName(field))
+	return nil
+}
@@ -0,0 +1,82 @@
+package wire
+
+import (
+	"testing"
+
+	"github.com/anirudhraja/protolite/schema"
+)
+
+const nullTrackerTestProto = `syntax = "proto3";
+package nulltest;
+
+message Settings {
+  option track_null = true;
+  google.protobuf.StringValue nickname = 1;
+  int32 retries = 2;
+}
+`
+
+func TestNullTracker_NotInDecodedOutput(t *testing.T) {
+	reg := loadTestRegistry(t, nullTrackerTestProto)
+	msg, err := reg.GetMessage("nulltest.Settings")
+	if err != nil {
+		t.Fatalf("GetMessage: %v", err)
+	}
+
+	t.Run("round_trip", func(t *testing.T) {
+		data := map[string]interface{}{
+			"nickname": nil,
+			"retries":  int32(3),
+			// caller supplied tracker values are ignored, the tracker is derived from nils
+			schema.NullTrackerFieldName: map[string]interface{}{"bogus": true},
+		}
+		encoded, err := EncodeMessage(data, msg, reg)
+		if err != nil {
+			t.Fatalf("Failed to encode: %v", err)
+		}
+		decodedI, err := DecodeMessage(encoded, msg, reg)
+		if err != nil {
+			t.Fatalf("Failed to decode: %v", err)
+		}
+		decoded := decodedI.(map[string]interface{})
+		if _, ok := decoded[schema.NullTrackerFieldName]; ok {
+			t.Errorf("Internal %s key leaked into output: %v", schema.NullTrackerFieldName, decoded)
+		}
+		if nickname, ok := decoded["nickname"]; !ok || nickname != nil {
+			t.Errorf("Expected nickname to be present and nil, got %v (present=%v)", nickname, ok)
+		}
+		if decoded["retries"] != int32(3) {
+			t.Errorf("Expected retries=3, got %v", decoded["retries"])
+		}
+	})
+
+	t.Run("tracker_with_unknown_field_number", func(t *testing.T) {
+		trackerMsg, err := reg.GetMessage("nulltest." + schema.NullTrackerWrapperMessageName)
+		if err != nil {
+			t.Fatalf("GetMessage: %v", err)
+		}
+		tracker, err := EncodeMessage(map[string]interface{}{
+			schema.NullTrackerWrapperInternalFieldName: map[string]interface{}{
+				schema.NullTrackerNullFieldsFieldName: []int32{1, 99},
+			},
+		}, trackerMsg, reg)
+		if err != nil {
+			t.Fatalf("Failed to encode tracker: %v", err)
+		}
+		encoder := NewEncoder()
+		encoder.EncodeVarint(uint64(MakeTag(FieldNumber(schema.NullTrackerFieldNumber), WireBytes)))
+		encoder.EncodeBytes(tracker)
+
+		decodedI, err := DecodeMessage(encoder.Bytes(), msg, reg)
+		if err != nil {
+			t.Fatalf("Failed to decode: %v", err)
+		}
+		decoded := decodedI.(map[string]interface{})
+		if _, ok := decoded[schema.NullTrackerFieldName]; ok {
+			t.Errorf("Internal %s key leaked into output: %v", schema.NullTrackerFieldName, decoded)
+		}
+		if nickname, ok := decoded["nickname"]; !ok || nickname != nil {
+			t.Errorf("Expected nickname to be present and nil, got %v (present=%v)", nickname, ok)
+		}
+	})
+}
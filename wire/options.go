@@ -0,0 +1,44 @@
+package wire
+
+// DecodeOption adjusts a single DecodeMessage call. Unlike Config, which
+// applies to every decode in the process, options only affect the call they
+// are passed to, so concurrent callers can decode with different settings.
+type DecodeOption func(*decodeOptions)
+
+// decodeOptions holds the per-call settings of a decode, shared by the
+// decoders of its nested messages and map entries
+type decodeOptions struct {
+	collectErrors      bool
+	wrappersAsMessages bool
+}
+
+// CollectErrors makes DecodeMessage skip fields whose value fails to decode
+// and keep going. It returns the partial message together with a
+// *DecodeErrors listing every skipped field, whose Unwrap exposes each of them
+// to errors.Is and errors.As. Decoding still stops where the input can no
+// longer be framed, e.g. a truncated length.
+func CollectErrors() DecodeOption {
+	return func(o *decodeOptions) {
+		o.collectErrors = true
+	}
+}
+
+// UnwrapWrappers selects how wrapper fields, their repeated elements and map
+// values decode for this call: to the bare scalar when unwrap is true, or to a
+// {"value": scalar} map when it is false. It overrides
+// Config.DecodeWrappersAsMessages. A field typed as google.protobuf.XValue
+// decodes the same way however the type is spelled in the schema.
+func UnwrapWrappers(unwrap bool) DecodeOption {
+	return func(o *decodeOptions) {
+		o.wrappersAsMessages = !unwrap
+	}
+}
+
+// newDecodeOptions applies opts to the defaults taken from the global Config
+func newDecodeOptions(opts []DecodeOption) decodeOptions {
+	o := decodeOptions{wrappersAsMessages: config.DecodeWrappersAsMessages}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
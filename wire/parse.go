@@ -0,0 +1,52 @@
+package wire
+
+import "fmt"
+
+// Parse decodes protobuf data without schema knowledge. Each field is keyed
+// by "field_<number>" and described by its wire type and raw decoded value.
+func Parse(data []byte) (map[string]interface{}, error) {
+	result := make(map[string]interface{})
+	if len(data) == 0 {
+		return result, nil
+	}
+
+	decoder := NewDecoder(data)
+	for {
+		field, err := decoder.DecodeField()
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode field: %v", err)
+		}
+
+		if field == nil {
+			// End of data
+			break
+		}
+
+		// Use field number as key since we don't have schema
+		fieldKey := fmt.Sprintf("field_%d", field.FieldNumber)
+		result[fieldKey] = map[string]interface{}{
+			"type":  wireTypeName(field.WireType),
+			"value": field.Data,
+		}
+	}
+
+	return result, nil
+}
+
+// wireTypeName returns the readable name Parse reports for a wire type
+func wireTypeName(wireType WireType) string {
+	switch wireType {
+	case WireVarint:
+		return "varint"
+	case WireFixed64:
+		return "fixed64"
+	case WireBytes:
+		return "bytes"
+	case WireFixed32:
+		return "fixed32"
+	case WireStartGroup:
+		return "group"
+	default:
+		return "unknown"
+	}
+}
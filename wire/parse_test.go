@@ -0,0 +1,110 @@
+package wire
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/anirudhraja/protolite/registry"
+	"github.com/anirudhraja/protolite/schema"
+)
+
+func TestDecodeUnknownToParse(t *testing.T) {
+	// Only the outer message is known, the nested type is not registered.
+	reg := registry.NewRegistry([]string{""})
+	outer := &schema.Message{
+		Name: "Outer",
+		Fields: []*schema.Field{
+			{Name: "inner", Number: 1, Label: schema.LabelOptional, Type: schema.FieldType{Kind: schema.KindMessage, MessageType: "missing.Inner"}},
+		},
+	}
+
+	inner := NewEncoder()
+	inner.EncodeVarint(uint64(MakeTag(1, WireVarint)))
+	inner.EncodeVarint(42)
+	inner.EncodeVarint(uint64(MakeTag(2, WireBytes)))
+	inner.EncodeBytes([]byte("hi"))
+
+	encoder := NewEncoder()
+	encoder.EncodeVarint(uint64(MakeTag(1, WireBytes)))
+	encoder.EncodeBytes(inner.Bytes())
+
+	t.Run("raw_bytes_by_default", func(t *testing.T) {
+		decodedI, err := DecodeMessage(encoder.Bytes(), outer, reg)
+		if err != nil {
+			t.Fatalf("Failed to decode: %v", err)
+		}
+		raw, ok := decodedI.(map[string]interface{})["inner"].([]byte)
+		if !ok || !bytes.Equal(raw, inner.Bytes()) {
+			t.Errorf("Expected raw inner bytes, got %#v", decodedI.(map[string]interface{})["inner"])
+		}
+	})
+
+	t.Run("parsed_when_enabled", func(t *testing.T) {
+		prev := config
+		SetConfig(Config{FillMissingScalarDefaultsOnDecode: prev.FillMissingScalarDefaultsOnDecode, DecodeUnknownToParse: true})
+		defer SetConfig(prev)
+
+		decodedI, err := DecodeMessage(encoder.Bytes(), outer, reg)
+		if err != nil {
+			t.Fatalf("Failed to decode: %v", err)
+		}
+		parsed, ok := decodedI.(map[string]interface{})["inner"].(map[string]interface{})
+		if !ok {
+			t.Fatalf("Expected parsed inner message, got %T", decodedI.(map[string]interface{})["inner"])
+		}
+		field1 := parsed["field_1"].(map[string]interface{})
+		if field1["type"] != "varint" || field1["value"] != uint64(42) {
+			t.Errorf("Unexpected field_1: %v", field1)
+		}
+		field2 := parsed["field_2"].(map[string]interface{})
+		if field2["type"] != "bytes" || !bytes.Equal(field2["value"].([]byte), []byte("hi")) {
+			t.Errorf("Unexpected field_2: %v", field2)
+		}
+	})
+}
+
+func TestParseWithSchema_UnionWrapperWithUnknownField(t *testing.T) {
+	reg := loadTestRegistry(t, `syntax = "proto3";
+package parsetest;
+
+message Result {
+  option wrapper = true;
+  oneof item {
+    Number number = 1 [json_name = "Number"];
+    Name name = 2 [json_name = "Name"];
+  }
+  message Number {
+    int32 value = 1;
+  }
+  message Name {
+    string value = 1;
+  }
+}
+`)
+	msg, err := reg.GetMessage("parsetest.Result")
+	if err != nil {
+		t.Fatalf("GetMessage: %v", err)
+	}
+	encoded, err := EncodeMessage(map[string]interface{}{
+		"__typename": "Name",
+		"value":      "al",
+	}, msg, reg)
+	if err != nil {
+		t.Fatalf("Failed to encode: %v", err)
+	}
+	// unknown varint field 9 next to the member, kept as field_9
+	data := append(encoded, 0x48, 0x01)
+
+	// the member used to be picked from the first map key, which was
+	// sometimes field_9, so decode several times
+	for i := 0; i < 50; i++ {
+		decoded, err := ParseWithSchema(data, msg, reg)
+		if err != nil {
+			t.Fatalf("ParseWithSchema failed: %v", err)
+		}
+		member, ok := decoded.(map[string]interface{})
+		if !ok || member["__typename"] != "Name" || member["value"] != "al" {
+			t.Fatalf("run %d: expected the Name member, got %v", i, decoded)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package wire
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSetField_DedupesOnEncode(t *testing.T) {
+	reg := loadTestRegistry(t, `syntax = "proto3";
+package settest;
+
+enum Color {
+  COLOR_UNSPECIFIED = 0;
+  COLOR_RED = 1;
+  COLOR_BLUE = 2;
+}
+
+message Holder {
+  repeated string tags = 1 [set = true];
+  repeated int32 ids = 2 [set = true];
+  repeated Color colors = 3 [set = true];
+  repeated string history = 4;
+}
+`)
+	msg, err := reg.GetMessage("settest.Holder")
+	if err != nil {
+		t.Fatalf("GetMessage: %v", err)
+	}
+
+	tags := []interface{}{"b", "a", "b", "c", "a"}
+	data := map[string]interface{}{
+		"tags":    tags,
+		"ids":     []int32{3, 1, 3, 3, 2},
+		"colors":  []interface{}{"COLOR_BLUE", int32(2), "COLOR_RED", "COLOR_BLUE"},
+		"history": []interface{}{"x", "x", "y"},
+	}
+	encoded, err := EncodeMessage(data, msg, reg)
+	if err != nil {
+		t.Fatalf("Failed to encode: %v", err)
+	}
+	decodedI, err := DecodeMessage(encoded, msg, reg)
+	if err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+	decoded := decodedI.(map[string]interface{})
+
+	expected := map[string]interface{}{
+		"tags":    []interface{}{"b", "a", "c"},
+		"ids":     []interface{}{int32(3), int32(1), int32(2)},
+		"colors":  []interface{}{"COLOR_BLUE", "COLOR_RED"},
+		"history": []interface{}{"x", "x", "y"},
+	}
+	for name, want := range expected {
+		if !reflect.DeepEqual(decoded[name], want) {
+			t.Errorf("%s: expected %v, got %v", name, want, decoded[name])
+		}
+	}
+	if len(tags) != 5 {
+		t.Errorf("Input slice must not be modified, got %v", tags)
+	}
+}
+
+func TestSetField_FalseKeepsDuplicates(t *testing.T) {
+	reg := loadTestRegistry(t, `syntax = "proto3";
+package settest;
+
+message Holder {
+  repeated string tags = 1 [set = false];
+  string label = 2 [set = false];
+}
+`)
+	msg, err := reg.GetMessage("settest.Holder")
+	if err != nil {
+		t.Fatalf("GetMessage: %v", err)
+	}
+	for _, field := range msg.Fields {
+		if field.Set {
+			t.Errorf("%s: expected set = false to leave the field a plain list", field.Name)
+		}
+	}
+
+	encoded, err := EncodeMessage(map[string]interface{}{"tags": []interface{}{"a", "a", "b"}}, msg, reg)
+	if err != nil {
+		t.Fatalf("Failed to encode: %v", err)
+	}
+	decoded, err := DecodeMessage(encoded, msg, reg)
+	if err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+	want := []interface{}{"a", "a", "b"}
+	if got := decoded.(map[string]interface{})["tags"]; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
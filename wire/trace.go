@@ -0,0 +1,86 @@
+package wire
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/anirudhraja/protolite/schema"
+)
+
+// TraceKind identifies the decision a TraceEvent reports
+type TraceKind int
+
+const (
+	// TraceUnknownFieldSkipped: a field number on the wire matched no field of
+	// the message and was skipped while decoding.
+	TraceUnknownFieldSkipped TraceKind = iota
+	// TraceDefaultFilled: a field absent from the wire was set to its default,
+	// see Config.FillMissingScalarDefaultsOnDecode.
+	TraceDefaultFilled
+	// TraceUnknownKeyDropped: a key of the input map matched no field of the
+	// message and was left out of the encoding.
+	TraceUnknownKeyDropped
+	// TraceValueCoerced: an input value was converted to the field's type
+	// while encoding, e.g. a json.Number for an int64 field, or a single
+	// value wrapped into a list for a repeated field.
+	TraceValueCoerced
+	// TraceReservedFieldSeen: a field number on the wire is reserved in the
+	// message, i.e. a removed field is still being sent. It is reported
+	// instead of TraceUnknownFieldSkipped, also when unknown fields are kept.
+	TraceReservedFieldSeen
+)
+
+func (k TraceKind) String() string {
+	switch k {
+	case TraceUnknownFieldSkipped:
+		return "unknown field skipped"
+	case TraceDefaultFilled:
+		return "default filled"
+	case TraceUnknownKeyDropped:
+		return "unknown key dropped"
+	case TraceValueCoerced:
+		return "value coerced"
+	case TraceReservedFieldSeen:
+		return "reserved field seen"
+	}
+	return fmt.Sprintf("TraceKind(%d)", int(k))
+}
+
+// TraceEvent describes one decision taken while encoding or decoding, see Config.Trace
+type TraceEvent struct {
+	Kind    TraceKind
+	Message string // name of the message being processed, empty where the encoder does not track it
+	Field   string // field name or input key, "field_<number>" for unknown field numbers
+	Detail  string // human readable specifics, e.g. the value type that was coerced
+}
+
+func (e TraceEvent) String() string {
+	s := e.Kind.String()
+	if e.Message != "" {
+		s += " in " + e.Message
+	}
+	if e.Field != "" {
+		s += ": " + e.Field
+	}
+	if e.Detail != "" {
+		s += " (" + e.Detail + ")"
+	}
+	return s
+}
+
+// tracePrimitive reports a primitive value whose Go type differs from the one
+// the decoder produces for field. Callers check config.Trace first, so tracing
+// costs nothing while it is off.
+func tracePrimitive(value interface{}, field *schema.Field) {
+	native := reflect.TypeOf(getDefaultValue(field.Type.PrimitiveType))
+	if field.Type.PrimitiveType == schema.TypeBytes {
+		native = reflect.TypeOf([]byte(nil))
+	}
+	if reflect.TypeOf(value) != native {
+		config.Trace(TraceEvent{
+			Kind:   TraceValueCoerced,
+			Field:  field.Name,
+			Detail: fmt.Sprintf("%T to %s", value, field.Type.PrimitiveType),
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package wire
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/anirudhraja/protolite/schema"
+)
+
+func TestTrace(t *testing.T) {
+	reg := loadTestRegistry(t, `syntax = "proto3";
+package tracing;
+
+enum Kind {
+  KIND_NONE = 0;
+}
+
+message Event {
+  string name = 1;
+  int64 at = 2;
+  Kind kind = 3;
+  repeated int32 codes = 4;
+}
+`)
+	msg, err := reg.GetMessage("tracing.Event")
+	if err != nil {
+		t.Fatalf("GetMessage: %v", err)
+	}
+
+	var events []TraceEvent
+	prev := config
+	defer SetConfig(prev)
+	cfg := prev
+	cfg.WrapSingleRepeatedElementOnEncode = true
+	cfg.Trace = func(e TraceEvent) { events = append(events, e) }
+	SetConfig(cfg)
+
+	encoded, err := EncodeMessage(map[string]interface{}{
+		"name":       "boot",
+		"at":         json.Number("17"),
+		"codes":      int32(3),
+		"nmae":       "typo",
+		"__typename": "Event",
+	}, msg, reg)
+	if err != nil {
+		t.Fatalf("Failed to encode: %v", err)
+	}
+	expected := []TraceEvent{
+		{Kind: TraceUnknownKeyDropped, Message: "Event", Field: "nmae"},
+		{Kind: TraceValueCoerced, Field: "at", Detail: "json.Number to int64"},
+		{Kind: TraceValueCoerced, Field: "codes", Detail: "single int32 wrapped into a list"},
+	}
+	if !reflect.DeepEqual(events, expected) {
+		t.Errorf("Unexpected encode events:\nexpected %v\ngot      %v", expected, events)
+	}
+
+	events = nil
+	// field 9 is unknown to the schema
+	if _, err := DecodeMessage(append(encoded, 0x48, 0x01), msg, reg); err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+	expected = []TraceEvent{
+		{Kind: TraceUnknownFieldSkipped, Message: "Event", Field: "field_9", Detail: "wire type 0"},
+		{Kind: TraceDefaultFilled, Message: "Event", Field: "kind", Detail: `"KIND_NONE"`},
+	}
+	if !reflect.DeepEqual(events, expected) {
+		t.Errorf("Unexpected decode events:\nexpected %v\ngot      %v", expected, events)
+	}
+	if got := expected[0].String(); got != "unknown field skipped in Event: field_9 (wire type 0)" {
+		t.Errorf("Unexpected event text %q", got)
+	}
+}
+
+func TestTrace_ReservedFieldSeen(t *testing.T) {
+	reg := loadTestRegistry(t, `syntax = "proto3";
+package tracing;
+
+message Account {
+  reserved 2, 5 to 7, 100 to max;
+  reserved "legacy_id";
+  string name = 1;
+}
+`)
+	msg, err := reg.GetMessage("tracing.Account")
+	if err != nil {
+		t.Fatalf("GetMessage: %v", err)
+	}
+	expectedRanges := []schema.ReservedRange{{Start: 2, End: 2}, {Start: 5, End: 7}, {Start: 100, End: 1<<29 - 1}}
+	if !reflect.DeepEqual(msg.ReservedRanges, expectedRanges) || !reflect.DeepEqual(msg.ReservedNames, []string{"legacy_id"}) {
+		t.Fatalf("Unexpected reserved declarations: %v %v", msg.ReservedRanges, msg.ReservedNames)
+	}
+
+	var events []TraceEvent
+	prev := config
+	defer SetConfig(prev)
+	cfg := prev
+	cfg.Trace = func(e TraceEvent) { events = append(events, e) }
+	SetConfig(cfg)
+
+	// name = "a", reserved field 6 = 1, unknown field 9 = 1
+	data := []byte{0x0a, 0x01, 'a', 0x30, 0x01, 0x48, 0x01}
+	decoded, err := DecodeMessage(data, msg, reg)
+	if err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+	if !reflect.DeepEqual(decoded, map[string]interface{}{"name": "a"}) {
+		t.Errorf("Unexpected decode result: %v", decoded)
+	}
+	expected := []TraceEvent{
+		{Kind: TraceReservedFieldSeen, Message: "Account", Field: "field_6", Detail: "wire type 0"},
+		{Kind: TraceUnknownFieldSkipped, Message: "Account", Field: "field_9", Detail: "wire type 0"},
+	}
+	if !reflect.DeepEqual(events, expected) {
+		t.Errorf("Unexpected decode events:\nexpected %v\ngot      %v", expected, events)
+	}
+
+	// reported while unknown fields are kept too
+	events = nil
+	if _, err := ParseWithSchema(data, msg, reg); err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+	if len(events) != 1 || events[0].Kind != TraceReservedFieldSeen {
+		t.Errorf("Expected a single reserved field event, got %v", events)
+	}
+}
@@ -7,6 +7,9 @@ const (
 	WireFixed64 WireType = 1 // fixed64, sfixed64, double
 	WireBytes   WireType = 2 // string, bytes, embedded messages, packed repeated fields
 	WireFixed32 WireType = 5 // fixed32, sfixed32, float
+
+	WireStartGroup WireType = 3 // proto2 group start (deprecated)
+	WireEndGroup   WireType = 4 // proto2 group end (deprecated)
 )
 
 // FieldNumber represents a protobuf field number
@@ -37,6 +40,7 @@ type Value struct {
 	FieldNumber FieldNumber
 	WireType    WireType
 	Data        interface{} // Actual value
+	Raw         []byte      // verbatim field encoding, set when the decoder keeps raw bytes; aliases the input
 }
 
 // RawValue represents a raw (undecoded) protobuf value
@@ -1,6 +1,10 @@
 package wire
 
 import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"reflect"
 	"testing"
 
 	"github.com/anirudhraja/protolite/schema"
@@ -355,6 +359,67 @@ func TestWrapperTypes_EdgeCases(t *testing.T) {
 			t.Errorf("Expected float64(0.0), got %v (%T)", field, field)
 		}
 	})
+
+	t.Run("wrapper_maps_with_extra_keys", func(t *testing.T) {
+		message := &schema.Message{
+			Name: "TestMessage",
+			Fields: []*schema.Field{
+				{
+					Name:   "name",
+					Number: 1,
+					Type: schema.FieldType{
+						Kind:        schema.KindWrapper,
+						WrapperType: schema.WrapperStringValue,
+					},
+				},
+				{
+					Name:   "count",
+					Number: 2,
+					Type: schema.FieldType{
+						Kind:        schema.KindWrapper,
+						WrapperType: schema.WrapperInt32Value,
+					},
+				},
+				{
+					Name:   "enabled",
+					Number: 3,
+					Type: schema.FieldType{
+						Kind:        schema.KindWrapper,
+						WrapperType: schema.WrapperBoolValue,
+					},
+				},
+			},
+		}
+
+		testData := map[string]interface{}{
+			"name":    map[string]interface{}{"__typename": "StringValue", "value": "alice"},
+			"count":   map[string]interface{}{"__typename": "Int32Value", "value": nil},
+			"enabled": map[string]interface{}{"__typename": "BoolValue"},
+		}
+
+		encodedData, err := EncodeMessage(testData, message, nil)
+		if err != nil {
+			t.Fatalf("Failed to encode: %v", err)
+		}
+
+		decodedDataI, err := DecodeMessage(encodedData, message, nil)
+		if err != nil {
+			t.Fatalf("Failed to decode: %v", err)
+		}
+		decodedData := decodedDataI.(map[string]interface{})
+		if decodedData["name"] != "alice" {
+			t.Errorf("Expected name alice, got %v (%T)", decodedData["name"], decodedData["name"])
+		}
+		// a wrapper map with a nil or missing value leaves the field unset
+		for _, name := range []string{"count", "enabled"} {
+			if value, ok := decodedData[name]; ok {
+				t.Errorf("Expected %s to be unset, got %v (%T)", name, value, value)
+			}
+		}
+		if !bytes.Equal(encodedData, []byte{0x0a, 0x07, 0x0a, 0x05, 'a', 'l', 'i', 'c', 'e'}) {
+			t.Errorf("Expected only the name wrapper on the wire, got %x", encodedData)
+		}
+	})
 }
 
 // Helper function to compare values (handles byte slices specially)
@@ -377,3 +442,395 @@ func compareValues(a, b interface{}) bool {
 		return a == b
 	}
 }
+
+func TestWrapperTypes_UnsetVsZeroRoundTrip(t *testing.T) {
+	message := &schema.Message{
+		Name: "Settings",
+		Fields: []*schema.Field{
+			{
+				Name:   "timeout",
+				Number: 1,
+				Type: schema.FieldType{
+					Kind:        schema.KindWrapper,
+					WrapperType: schema.WrapperInt32Value,
+				},
+			},
+			{
+				Name:   "label",
+				Number: 2,
+				Type: schema.FieldType{
+					Kind:        schema.KindWrapper,
+					WrapperType: schema.WrapperStringValue,
+				},
+			},
+			{
+				Name:   "retries",
+				Number: 3,
+				Type: schema.FieldType{
+					Kind:        schema.KindWrapper,
+					WrapperType: schema.WrapperUInt32Value,
+				},
+			},
+		},
+	}
+
+	// timeout and label are set to their zero values, retries is unset
+	original, err := EncodeMessage(map[string]interface{}{
+		"timeout": int32(0),
+		"label":   "",
+	}, message, nil)
+	if err != nil {
+		t.Fatalf("Failed to encode: %v", err)
+	}
+
+	data := original
+	for cycle := 0; cycle < 2; cycle++ {
+		decodedI, err := DecodeMessage(data, message, nil)
+		if err != nil {
+			t.Fatalf("cycle %d: failed to decode: %v", cycle, err)
+		}
+		decoded := decodedI.(map[string]interface{})
+		if v, ok := decoded["timeout"]; !ok || v != int32(0) {
+			t.Errorf("cycle %d: expected timeout present as int32(0), got %v (%T, present=%v)", cycle, v, v, ok)
+		}
+		if v, ok := decoded["label"]; !ok || v != "" {
+			t.Errorf("cycle %d: expected label present as empty string, got %v (%T, present=%v)", cycle, v, v, ok)
+		}
+		if v, ok := decoded["retries"]; ok {
+			t.Errorf("cycle %d: expected retries to stay unset, got %v (%T)", cycle, v, v)
+		}
+
+		data, err = EncodeMessage(decoded, message, nil)
+		if err != nil {
+			t.Fatalf("cycle %d: failed to re-encode: %v", cycle, err)
+		}
+		if string(data) != string(original) {
+			t.Errorf("cycle %d: re-encoded bytes %x differ from original %x", cycle, data, original)
+		}
+	}
+}
+
+func TestWrapperTypes_QualifiedReferences(t *testing.T) {
+	reg := loadTestRegistry(t, `syntax = "proto3";
+package google.protobuf.ext;
+
+import "google/protobuf/wrappers.proto";
+
+message Holder {
+  google.protobuf.StringValue plain = 1;
+  .google.protobuf.StringValue dotted = 2;
+  protobuf.Int32Value relative = 3;
+  map<string, .google.protobuf.BoolValue> flags = 4;
+}
+`)
+	msg, err := reg.GetMessage("google.protobuf.ext.Holder")
+	if err != nil {
+		t.Fatalf("GetMessage: %v", err)
+	}
+
+	encoded, err := EncodeMessage(map[string]interface{}{
+		"plain":    "a",
+		"dotted":   "b",
+		"relative": int32(3),
+		"flags":    map[string]interface{}{"beta": true},
+	}, msg, reg)
+	if err != nil {
+		t.Fatalf("Failed to encode: %v", err)
+	}
+	decodedI, err := DecodeMessage(encoded, msg, reg)
+	if err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+	// every spelling of a wrapper type unwraps to its scalar
+	expected := map[string]interface{}{
+		"plain":    "a",
+		"dotted":   "b",
+		"relative": int32(3),
+		"flags":    map[string]interface{}{"beta": true},
+	}
+	if !reflect.DeepEqual(decodedI, expected) {
+		t.Errorf("Unexpected result:\nexpected %#v\ngot      %#v", expected, decodedI)
+	}
+}
+
+func TestWrapperTypes_BareScalarInput(t *testing.T) {
+	reg := loadTestRegistry(t, `syntax = "proto3";
+package bare;
+
+import "google/protobuf/wrappers.proto";
+
+message Values {
+  google.protobuf.DoubleValue d = 1;
+  google.protobuf.FloatValue f = 2;
+  google.protobuf.Int64Value i64 = 3;
+  google.protobuf.UInt64Value u64 = 4;
+  google.protobuf.Int32Value i32 = 5;
+  google.protobuf.UInt32Value u32 = 6;
+  google.protobuf.BoolValue b = 7;
+  google.protobuf.StringValue s = 8;
+  google.protobuf.BytesValue raw = 9;
+}
+`)
+	msg, err := reg.GetMessage("bare.Values")
+	if err != nil {
+		t.Fatalf("GetMessage: %v", err)
+	}
+
+	bare := map[string]interface{}{
+		"d":   2.5,
+		"f":   float32(1.5),
+		"i64": int64(-7),
+		"u64": uint64(18446744073709551615),
+		"i32": int32(-3),
+		"u32": uint32(4),
+		"b":   true,
+		"s":   "hi",
+		"raw": []byte{1, 2, 255},
+	}
+	wrapped := make(map[string]interface{}, len(bare))
+	for k, v := range bare {
+		wrapped[k] = map[string]interface{}{"value": v}
+	}
+	encoded, err := EncodeMessage(bare, msg, reg)
+	if err != nil {
+		t.Fatalf("Failed to encode bare scalars: %v", err)
+	}
+	expected, err := EncodeMessage(wrapped, msg, reg)
+	if err != nil {
+		t.Fatalf("Failed to encode wrapper maps: %v", err)
+	}
+	if !bytes.Equal(encoded, expected) {
+		t.Fatalf("bare scalars encoded differently from {value: ...} maps:\nexpected % x\ngot      % x", expected, encoded)
+	}
+
+	// decoded wrappers are bare scalars, so they encode back unchanged
+	decoded, err := DecodeMessage(encoded, msg, reg)
+	if err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+	if !reflect.DeepEqual(decoded, bare) {
+		t.Errorf("expected %#v, got %#v", bare, decoded)
+	}
+	reencoded, err := EncodeMessage(decoded.(map[string]interface{}), msg, reg)
+	if err != nil {
+		t.Fatalf("Failed to re-encode: %v", err)
+	}
+	if !bytes.Equal(reencoded, encoded) {
+		t.Errorf("re-encoding changed the bytes:\nexpected % x\ngot      % x", encoded, reencoded)
+	}
+
+	// BytesValue also takes base64 text and lists of byte numbers
+	for _, raw := range []interface{}{
+		"AQL/",
+		[]interface{}{int64(1), int32(2), json.Number("255")},
+	} {
+		got, err := EncodeMessage(map[string]interface{}{"raw": raw}, msg, reg)
+		if err != nil {
+			t.Fatalf("Failed to encode %#v: %v", raw, err)
+		}
+		want, _ := EncodeMessage(map[string]interface{}{"raw": []byte{1, 2, 255}}, msg, reg)
+		if !bytes.Equal(got, want) {
+			t.Errorf("%#v: expected % x, got % x", raw, want, got)
+		}
+	}
+	if _, err := EncodeMessage(map[string]interface{}{"raw": []interface{}{int64(256)}}, msg, reg); err == nil {
+		t.Errorf("Expected out of range byte to be rejected")
+	}
+}
+
+func TestWrapperTypes_RejectOverflowOnDecode(t *testing.T) {
+	message := &schema.Message{
+		Name: "Limits",
+		Fields: []*schema.Field{
+			{Name: "signed", Number: 1, Type: schema.FieldType{Kind: schema.KindWrapper, WrapperType: schema.WrapperInt32Value}},
+			{Name: "unsigned", Number: 2, Type: schema.FieldType{Kind: schema.KindWrapper, WrapperType: schema.WrapperUInt32Value}},
+		},
+	}
+	// wrapper encodes field number as a wrapper message holding v
+	wrapper := func(number FieldNumber, v uint64) []byte {
+		inner := NewEncoder()
+		inner.EncodeVarint(uint64(MakeTag(1, WireVarint)))
+		inner.EncodeVarint(v)
+		outer := NewEncoder()
+		outer.EncodeVarint(uint64(MakeTag(number, WireBytes)))
+		outer.EncodeBytes(inner.Bytes())
+		return outer.Bytes()
+	}
+
+	prev := config
+	defer SetConfig(prev)
+	cfg := prev
+	cfg.RejectWrapperOverflowOnDecode = true
+	SetConfig(cfg)
+
+	valid := append(wrapper(1, uint64(0xFFFFFFFFFFFFFFFF)), wrapper(2, math.MaxUint32)...)
+	decodedI, err := DecodeMessage(valid, message, nil)
+	if err != nil {
+		t.Fatalf("Failed to decode in-range wrappers: %v", err)
+	}
+	decoded := decodedI.(map[string]interface{})
+	if decoded["signed"] != int32(-1) || decoded["unsigned"] != uint32(math.MaxUint32) {
+		t.Errorf("Unexpected decode result: %v", decoded)
+	}
+
+	for name, data := range map[string][]byte{
+		"Int32Value above range":  wrapper(1, math.MaxInt32+1),
+		"Int32Value below range":  wrapper(1, uint64(math.MaxUint64-math.MaxUint32)),
+		"UInt32Value above range": wrapper(2, math.MaxUint32+1),
+	} {
+		if _, err := DecodeMessage(data, message, nil); err == nil {
+			t.Errorf("%s: expected an overflow error", name)
+		}
+	}
+
+	// without the option the value is truncated as before
+	SetConfig(prev)
+	decodedI, err = DecodeMessage(wrapper(2, math.MaxUint32+1), message, nil)
+	if err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+	if v := decodedI.(map[string]interface{})["unsigned"]; v != uint32(0) {
+		t.Errorf("expected truncated uint32(0), got %v (%T)", v, v)
+	}
+}
+
+func TestWrapperTypes_DecodeAsMessages(t *testing.T) {
+	message := &schema.Message{
+		Name: "Profile",
+		Fields: []*schema.Field{
+			{Name: "age", Number: 1, Type: schema.FieldType{Kind: schema.KindWrapper, WrapperType: schema.WrapperInt32Value}},
+			{Name: "nick", Number: 2, Type: schema.FieldType{Kind: schema.KindWrapper, WrapperType: schema.WrapperStringValue}},
+			{Name: "score", Number: 3, Type: schema.FieldType{Kind: schema.KindPrimitive, PrimitiveType: schema.TypeInt32}},
+			{Name: "visits", Number: 4, Label: schema.LabelRepeated, Type: schema.FieldType{Kind: schema.KindWrapper, WrapperType: schema.WrapperInt64Value}},
+			{Name: "flags", Number: 5, Type: schema.FieldType{
+				Kind:     schema.KindMap,
+				MapKey:   &schema.FieldType{Kind: schema.KindPrimitive, PrimitiveType: schema.TypeString},
+				MapValue: &schema.FieldType{Kind: schema.KindWrapper, WrapperType: schema.WrapperBoolValue},
+			}},
+		},
+	}
+	encoded, err := EncodeMessage(map[string]interface{}{
+		"age":    int32(30),
+		"nick":   "",
+		"score":  int32(7),
+		"visits": []interface{}{int64(1), int64(2)},
+		"flags":  map[string]interface{}{"admin": true},
+	}, message, nil)
+	if err != nil {
+		t.Fatalf("Failed to encode: %v", err)
+	}
+
+	prev := config
+	defer SetConfig(prev)
+	cfg := prev
+	cfg.DecodeWrappersAsMessages = true
+	SetConfig(cfg)
+
+	decoded, err := DecodeMessage(encoded, message, nil)
+	if err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+	expected := map[string]interface{}{
+		"age":   map[string]interface{}{"value": int32(30)},
+		"nick":  map[string]interface{}{"value": ""},
+		"score": int32(7),
+		"visits": []interface{}{
+			map[string]interface{}{"value": int64(1)},
+			map[string]interface{}{"value": int64(2)},
+		},
+		"flags": map[string]interface{}{"admin": map[string]interface{}{"value": true}},
+	}
+	if !reflect.DeepEqual(decoded, expected) {
+		t.Errorf("expected %#v, got %#v", expected, decoded)
+	}
+
+	// the encoder takes the map shape back
+	reencoded, err := EncodeMessage(decoded.(map[string]interface{}), message, nil)
+	if err != nil {
+		t.Fatalf("Failed to re-encode: %v", err)
+	}
+	redecoded, err := DecodeMessage(reencoded, message, nil)
+	if err != nil {
+		t.Fatalf("Failed to decode re-encoded: %v", err)
+	}
+	if !reflect.DeepEqual(redecoded, expected) {
+		t.Errorf("round trip changed the value: %#v", redecoded)
+	}
+
+	// integer wrappers still honor DecodeIntegersAsInt
+	cfg.DecodeIntegersAsInt = true
+	SetConfig(cfg)
+	decoded, err = DecodeMessage(encoded, message, nil)
+	if err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+	if age := decoded.(map[string]interface{})["age"]; !reflect.DeepEqual(age, map[string]interface{}{"value": 30}) {
+		t.Errorf("expected age as int inside the wrapper map, got %#v", age)
+	}
+}
+
+func TestDecodeMessage_UnwrapWrappersOption(t *testing.T) {
+	reg := loadTestRegistry(t, `syntax = "proto3";
+package unwrap;
+
+import "google/protobuf/wrappers.proto";
+
+message Holder {
+  google.protobuf.StringValue plain = 1;
+  .google.protobuf.Int32Value dotted = 2;
+  repeated google.protobuf.BoolValue flags = 3;
+}
+`)
+	msg, err := reg.GetMessage("unwrap.Holder")
+	if err != nil {
+		t.Fatalf("GetMessage: %v", err)
+	}
+	encoded, err := EncodeMessage(map[string]interface{}{
+		"plain":  "a",
+		"dotted": int32(3),
+		"flags":  []interface{}{true},
+	}, msg, reg)
+	if err != nil {
+		t.Fatalf("Failed to encode: %v", err)
+	}
+
+	unwrapped := map[string]interface{}{
+		"plain":  "a",
+		"dotted": int32(3),
+		"flags":  []interface{}{true},
+	}
+	wrapped := map[string]interface{}{
+		"plain":  map[string]interface{}{"value": "a"},
+		"dotted": map[string]interface{}{"value": int32(3)},
+		"flags":  []interface{}{map[string]interface{}{"value": true}},
+	}
+	decode := func(opts ...DecodeOption) interface{} {
+		t.Helper()
+		decoded, err := DecodeMessage(encoded, msg, reg, opts...)
+		if err != nil {
+			t.Fatalf("Failed to decode: %v", err)
+		}
+		return decoded
+	}
+
+	if got := decode(UnwrapWrappers(false)); !reflect.DeepEqual(got, wrapped) {
+		t.Errorf("UnwrapWrappers(false): expected %v, got %v", wrapped, got)
+	}
+	// the option does not outlive its call
+	if got := decode(); !reflect.DeepEqual(got, unwrapped) {
+		t.Errorf("default: expected %v, got %v", unwrapped, got)
+	}
+
+	prev := config
+	defer SetConfig(prev)
+	cfg := prev
+	cfg.DecodeWrappersAsMessages = true
+	SetConfig(cfg)
+	// a per-call option overrides the global default
+	if got := decode(UnwrapWrappers(true)); !reflect.DeepEqual(got, unwrapped) {
+		t.Errorf("UnwrapWrappers(true): expected %v, got %v", unwrapped, got)
+	}
+	if got := decode(); !reflect.DeepEqual(got, wrapped) {
+		t.Errorf("global DecodeWrappersAsMessages: expected %v, got %v", wrapped, got)
+	}
+}